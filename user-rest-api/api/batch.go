@@ -0,0 +1,139 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/userservice"
+)
+
+// batchRequest is the body of POST /users:batch.
+type batchRequest struct {
+	Operations []batchOperation `json:"operations"`
+}
+
+// batchOperation creates, updates or deletes a user. User is the body of POST
+// /users for creates and of PATCH /users/{id} for updates. IfMatch holds the
+// ETag of the user to update or delete, or "*", as the If-Match header does.
+type batchOperation struct {
+	Op      string          `json:"op"`
+	UserID  string          `json:"user_id"`
+	IfMatch string          `json:"if_match"`
+	User    json.RawMessage `json:"user"`
+}
+
+type batchResponse struct {
+	Committed bool          `json:"committed"`
+	Results   []batchResult `json:"results"`
+}
+
+// batchResult is the outcome of an operation; Error is set if it failed.
+type batchResult struct {
+	Op     string            `json:"op"`
+	Status string            `json:"status"`
+	User   *userservice.User `json:"user,omitempty"`
+	Error  *Problem          `json:"error,omitempty"`
+}
+
+// Batch runs a list of operations in one transaction, so that all of them are
+// applied or none. The result reports the outcome of every operation; it is
+// sent with 422 when nothing was applied because an operation failed.
+func (h *Handler) Batch(w http.ResponseWriter, r *http.Request) {
+	var req batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "The body is not valid JSON.")
+		return
+	}
+	ops := make([]userservice.BatchOperation, len(req.Operations))
+	for i, op := range req.Operations {
+		arg, err := batchOp(op)
+		arg.Err = err
+		ops[i] = arg
+	}
+
+	result, err := h.Service.Batch(r.Context(), ops)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	resp := batchResponse{Committed: result.Committed, Results: make([]batchResult, len(result.Results))}
+	for i, res := range result.Results {
+		resp.Results[i] = batchResult{Op: res.Op, Status: res.Status, User: res.User}
+		if res.Err != nil {
+			p := errorProblem(r, res.Err)
+			resp.Results[i].Error = &p
+		}
+	}
+	status := http.StatusOK
+	if !result.Committed {
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, r, status, resp)
+}
+
+// batchOp decodes and checks an operation as the endpoint of its kind does.
+// Unknown operations are left to the service to reject.
+func batchOp(op batchOperation) (userservice.BatchOperation, error) {
+	arg := userservice.BatchOperation{Op: op.Op}
+	if op.Op == userservice.OpCreate {
+		var req userRequest
+		if err := json.Unmarshal(op.User, &req); err != nil {
+			return arg, invalidUserField()
+		}
+		if err := internal.Validate.Struct(req); err != nil {
+			return arg, err
+		}
+		arg.Create = userservice.CreateUserParams{
+			FirstName: req.FirstName,
+			LastName:  req.LastName,
+			Email:     req.Email,
+			Phone:     &req.Phone,
+			Age:       req.Age,
+			Status:    &req.Status,
+		}
+		return arg, nil
+	}
+	if op.Op != userservice.OpUpdate && op.Op != userservice.OpDelete {
+		return arg, nil
+	}
+
+	userID, err := uuid.Parse(op.UserID)
+	if err != nil {
+		return arg, &userservice.ValidationError{Fields: []userservice.FieldError{{Field: "user_id", Message: "must be a UUID"}}}
+	}
+	version, err := parseIfMatch(op.IfMatch)
+	if err != nil {
+		return arg, err
+	}
+	if op.Op == userservice.OpDelete {
+		arg.Delete = userservice.DeleteUserParams{UserID: userID, ExpectedVersion: version}
+		return arg, nil
+	}
+
+	var patch userPatch
+	if err := json.Unmarshal(op.User, &patch); err != nil {
+		return arg, invalidUserField()
+	}
+	if err := patch.validate(); err != nil {
+		return arg, err
+	}
+	arg.Update = userservice.UpdateUserParams{
+		UserID:    userID,
+		FirstName: patch.FirstName,
+		LastName:  patch.LastName,
+		Email:     patch.Email,
+		Phone:     patch.Phone,
+		Age:       patch.Age,
+		Status:    patch.Status,
+
+		ExpectedVersion: version,
+	}
+	return arg, nil
+}
+
+func invalidUserField() error {
+	return &userservice.ValidationError{Fields: []userservice.FieldError{{Field: "user", Message: "must be a JSON object with the fields of a user"}}}
+}
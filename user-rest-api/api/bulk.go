@@ -0,0 +1,330 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/userservice"
+)
+
+const (
+	csvType    = "text/csv"
+	ndjsonType = "application/x-ndjson"
+)
+
+// maxImportBytes is the size of the largest file that can be imported.
+const maxImportBytes = 32 << 20
+
+// exportColumns are the CSV columns of an export.
+var exportColumns = []string{"user_id", "first_name", "last_name", "email", "phone", "age", "status", "created_at", "updated_at", "version", "deleted_at"}
+
+// importColumns are the CSV columns of an import. The other columns of an
+// export are ignored, so that exports can be imported.
+var importColumns = []string{"first_name", "last_name", "email", "phone", "age", "status"}
+
+// ImportUsers creates users from a CSV file with a header row, or from NDJSON
+// with a user object on each line. The mode and dry_run query parameters are
+// those of userservice.ImportUsersParams. The result reports the outcome of
+// every row; it is sent with 422 when an atomic import created nothing
+// because rows failed.
+func (h *Handler) ImportUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	arg := userservice.ImportUsersParams{Mode: q.Get("mode")}
+	if v := q.Get("dry_run"); v != "" {
+		dryRun, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, r, &userservice.ValidationError{Fields: []userservice.FieldError{{Field: "dry_run", Message: "must be true or false"}}})
+			return
+		}
+		arg.DryRun = dryRun
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var err error
+	switch mediaType {
+	case csvType:
+		arg.Rows, err = decodeCSV(body)
+	case ndjsonType, "application/ndjson":
+		arg.Rows, err = decodeNDJSON(body)
+	default:
+		writeProblem(w, r, http.StatusUnsupportedMediaType, "The body must be "+csvType+" or "+ndjsonType+".")
+		return
+	}
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("The file is larger than %d bytes.", tooLarge.Limit))
+		return
+	case err != nil:
+		writeProblem(w, r, http.StatusBadRequest, "The file cannot be read: "+err.Error()+".")
+		return
+	}
+
+	result, err := h.Service.ImportUsers(r.Context(), arg)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	status := http.StatusOK
+	if result.Mode == userservice.ImportAtomic && result.Failed > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, r, status, result)
+}
+
+// decodeCSV reads the rows of a CSV import. It stops after one row more than
+// the service accepts.
+func decodeCSV(body io.Reader) ([]userservice.ImportRow, error) {
+	cr := csv.NewReader(body)
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("the header row is missing")
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		switch {
+		case slices.Contains(importColumns, name):
+			columns[name] = i
+		case !slices.Contains(exportColumns, name):
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+	}
+
+	var rows []userservice.ImportRow
+	for len(rows) <= userservice.MaxImportRows {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		get := func(column string) string {
+			if i, ok := columns[column]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		req := userRequest{
+			FirstName: get("first_name"),
+			LastName:  get("last_name"),
+			Email:     get("email"),
+			Phone:     get("phone"),
+			Status:    get("status"),
+		}
+		var errs []userservice.FieldError
+		if v := get("age"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 32)
+			if err != nil {
+				errs = append(errs, userservice.FieldError{Field: "age", Message: "must be an integer"})
+			} else {
+				age := int32(n)
+				req.Age = &age
+			}
+		}
+		line, _ := cr.FieldPos(0)
+		rows = append(rows, importRow(line, req, errs))
+	}
+	return rows, nil
+}
+
+// decodeNDJSON reads the rows of an NDJSON import, skipping blank lines. It
+// stops after one row more than the service accepts.
+func decodeNDJSON(body io.Reader) ([]userservice.ImportRow, error) {
+	sc := bufio.NewScanner(body)
+	line := 0
+	var rows []userservice.ImportRow
+	for len(rows) <= userservice.MaxImportRows && sc.Scan() {
+		line++
+		b := bytes.TrimSpace(sc.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		var req userRequest
+		if err := json.Unmarshal(b, &req); err != nil {
+			rows = append(rows, userservice.ImportRow{
+				Line:   line,
+				Errors: []userservice.FieldError{{Field: "line", Message: "must be a JSON object with the fields of a user"}},
+			})
+			continue
+		}
+		rows = append(rows, importRow(line, req, nil))
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("line %d: %w", line+1, err)
+	}
+	return rows, nil
+}
+
+// importRow checks req as CreateUser does and adds its problems to errs.
+func importRow(line int, req userRequest, errs []userservice.FieldError) userservice.ImportRow {
+	var invalid validator.ValidationErrors
+	if err := internal.Validate.Struct(req); errors.As(err, &invalid) {
+		errs = append(errs, fieldErrors(invalid)...)
+	}
+	return userservice.ImportRow{
+		Line: line,
+		User: userservice.CreateUserParams{
+			FirstName: req.FirstName,
+			LastName:  req.LastName,
+			Email:     req.Email,
+			Phone:     &req.Phone,
+			Age:       req.Age,
+			Status:    &req.Status,
+		},
+		Errors: errs,
+	}
+}
+
+// ExportUsers streams every user matching the filters of ListUsers as NDJSON
+// or, with format=csv or an Accept header asking for text/csv, as CSV; limit
+// and cursor are ignored. The response is sent while the users are read; if
+// reading fails after it has started, it is cut short.
+func (h *Handler) ExportUsers(w http.ResponseWriter, r *http.Request) {
+	arg, err := listUsersParams(r.URL.Query())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ndjson"
+		if strings.Contains(r.Header.Get("Accept"), csvType) {
+			format = "csv"
+		}
+	}
+	var enc userEncoder
+	switch format {
+	case "csv":
+		enc = &csvUserEncoder{w: csv.NewWriter(w)}
+	case "ndjson":
+		buf := bufio.NewWriter(w)
+		enc = &ndjsonUserEncoder{buf: buf, enc: json.NewEncoder(buf)}
+	default:
+		writeError(w, r, &userservice.ValidationError{Fields: []userservice.FieldError{{Field: "format", Message: "must be one of csv ndjson"}}})
+		return
+	}
+
+	// The response starts with the first user, so that errors of the first
+	// page can still be reported as problems.
+	started := false
+	start := func() error {
+		started = true
+		w.Header().Set("Content-Type", enc.contentType())
+		w.Header().Set("Content-Disposition", `attachment; filename="users.`+format+`"`)
+		w.WriteHeader(http.StatusOK)
+		return enc.header()
+	}
+	rc := http.NewResponseController(w)
+	n := 0
+	err = h.Service.ExportUsers(r.Context(), arg, func(user userservice.User) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		if err := enc.encode(user); err != nil {
+			return err
+		}
+		if n++; n%userservice.MaxPageSize == 0 {
+			if err := enc.flush(); err != nil {
+				return err
+			}
+			_ = rc.Flush()
+		}
+		return nil
+	})
+	if err == nil && !started {
+		err = start()
+	}
+	if err == nil {
+		err = enc.flush()
+	}
+	switch {
+	case err == nil:
+	case !started:
+		writeError(w, r, err)
+	default:
+		log.Printf("%s %s failed after %d users: %v\n", r.Method, r.URL.Path, n, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// userEncoder writes users in an export format.
+type userEncoder interface {
+	contentType() string
+	header() error
+	encode(user userservice.User) error
+	flush() error
+}
+
+type csvUserEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvUserEncoder) contentType() string { return csvType + "; charset=utf-8" }
+
+func (e *csvUserEncoder) header() error { return e.w.Write(exportColumns) }
+
+func (e *csvUserEncoder) encode(user userservice.User) error {
+	record := []string{
+		user.UserID.String(),
+		user.FirstName,
+		user.LastName,
+		user.Email,
+		"",
+		"",
+		user.Status,
+		user.CreatedAt.Format(time.RFC3339Nano),
+		user.UpdatedAt.Format(time.RFC3339Nano),
+		strconv.FormatInt(int64(user.Version), 10),
+		"",
+	}
+	if user.Phone != nil {
+		record[4] = *user.Phone
+	}
+	if user.Age != nil {
+		record[5] = strconv.FormatInt(int64(*user.Age), 10)
+	}
+	if user.DeletedAt != nil {
+		record[10] = user.DeletedAt.Format(time.RFC3339Nano)
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvUserEncoder) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type ndjsonUserEncoder struct {
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func (e *ndjsonUserEncoder) contentType() string { return ndjsonType }
+
+func (e *ndjsonUserEncoder) header() error { return nil }
+
+func (e *ndjsonUserEncoder) encode(user userservice.User) error { return e.enc.Encode(user) }
+
+func (e *ndjsonUserEncoder) flush() error { return e.buf.Flush() }
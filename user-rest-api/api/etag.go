@@ -0,0 +1,51 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	errPreconditionRequired = errors.New("If-Match header is required")
+	errPreconditionFailed   = errors.New("If-Match does not match the current ETag")
+)
+
+// etag is the entity tag of a user at version.
+func etag(version int32) string {
+	return `"` + strconv.FormatInt(int64(version), 10) + `"`
+}
+
+// ifMatch returns the version required by the If-Match header, or nil for
+// "*". It fails with errPreconditionRequired if the header is missing and
+// with errPreconditionFailed if it cannot match any version, e.g. because it
+// is a weak tag, which never matches.
+func ifMatch(r *http.Request) (*int32, error) {
+	return parseIfMatch(r.Header.Get("If-Match"))
+}
+
+// parseIfMatch parses an If-Match value as ifMatch does.
+func parseIfMatch(h string) (*int32, error) {
+	h = strings.TrimSpace(h)
+	if h == "" {
+		return nil, errPreconditionRequired
+	}
+	if h == "*" {
+		return nil, nil
+	}
+	tag, ok := strings.CutPrefix(h, `"`)
+	if !ok {
+		return nil, errPreconditionFailed
+	}
+	tag, ok = strings.CutSuffix(tag, `"`)
+	if !ok {
+		return nil, errPreconditionFailed
+	}
+	v, err := strconv.ParseInt(tag, 10, 32)
+	if err != nil {
+		return nil, errPreconditionFailed
+	}
+	version := int32(v)
+	return &version, nil
+}
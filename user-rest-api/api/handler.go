@@ -2,41 +2,96 @@ package api
 
 import (
 	"encoding/json"
-	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/nats"
+	"errors"
 	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/userservice"
+	"mime"
 	"net/http"
+	"net/url"
+	"path"
+	"strconv"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
 	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/idempotency"
 )
 
 type Handler struct {
 	Service userservice.UserService
+	// Idempotency, if set, stores the responses to POST requests with an
+	// Idempotency-Key header.
+	Idempotency *idempotency.Store
+	// Streams, if set, reads orders and trades for personal data exports and
+	// deletes the events of erased users.
+	Streams StreamData
 }
 
-var req struct {
+// userRequest is the body of POST and PUT.
+type userRequest struct {
 	FirstName string `json:"first_name" validate:"required,min=2,max=50"`
 	LastName  string `json:"last_name" validate:"required,min=2,max=50"`
 	Email     string `json:"email" validate:"required,email"`
 	Phone     string `json:"phone"`
 	Age       *int32 `json:"age"`
-	Status    string `json:"status"`
+	Status    string `json:"status" validate:"omitempty,oneof=pending active suspended closed"`
 }
 
 func NewHandler(service userservice.UserService) *Handler {
 	return &Handler{Service: service}
 }
 
-func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
+// userPatch is the body of PATCH, a JSON Merge Patch. Set fields are checked
+// against the rules of userRequest.
+type userPatch struct {
+	FirstName userservice.Optional[string] `json:"first_name"`
+	LastName  userservice.Optional[string] `json:"last_name"`
+	Email     userservice.Optional[string] `json:"email"`
+	Phone     userservice.Optional[string] `json:"phone"`
+	Age       userservice.Optional[int32]  `json:"age"`
+	Status    userservice.Optional[string] `json:"status"`
+}
 
+func (p userPatch) validate() error {
+	var invalid userservice.ValidationError
+	for _, f := range []struct {
+		name  string
+		value userservice.Optional[string]
+		rule  string
+	}{
+		{"first_name", p.FirstName, "min=2,max=50"},
+		{"last_name", p.LastName, "min=2,max=50"},
+		{"email", p.Email, "email"},
+	} {
+		if f.value.Null {
+			invalid.Fields = append(invalid.Fields, userservice.FieldError{Field: f.name, Message: "cannot be null"})
+			continue
+		}
+		if !f.value.Set {
+			continue
+		}
+		var errs validator.ValidationErrors
+		if err := internal.Validate.Var(f.value.Value, f.rule); errors.As(err, &errs) {
+			invalid.Fields = append(invalid.Fields, userservice.FieldError{Field: f.name, Message: fieldMessage(errs[0])})
+		}
+	}
+	if len(invalid.Fields) > 0 {
+		return &invalid
+	}
+	return nil
+}
+
+const mergePatchType = "application/merge-patch+json"
+
+func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var req userRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "The body is not valid JSON.")
 		return
 	}
 
 	if err := internal.Validate.Struct(req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
@@ -51,103 +106,357 @@ func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	})
 
 	if err != nil {
-		http.Error(w, "Could not create user: "+err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	// Publish to NATS
-	event, _ := json.Marshal(user)
-	nats.Publish("users.updated", event)
-
 	// Respond with the created user
-	if err := json.NewEncoder(w).Encode(user); err != nil {
-		http.Error(w, "Error encoding response: "+err.Error(), http.StatusInternalServerError)
-	}
+	w.Header().Set("ETag", etag(user.Version))
+	w.Header().Set("Location", path.Join(r.URL.Path, user.UserID.String()))
+	writeJSON(w, r, http.StatusCreated, user)
 }
 
 func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	userID, err := uuid.Parse(idStr)
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "The user ID is not a UUID.")
 		return
 	}
 
 	user, err := h.Service.GetUser(r.Context(), userID)
 	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		writeError(w, r, err)
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(user); err != nil {
-		http.Error(w, "Error encoding response: "+err.Error(), http.StatusInternalServerError)
-	}
+	w.Header().Set("ETag", etag(user.Version))
+	writeJSON(w, r, http.StatusOK, user)
 }
 
+// ListUsers returns one page of users as a JSON array. The cursor of the next
+// page is returned in the X-Next-Cursor header and a Link header, and the
+// total in X-Total-Count when count=true is given.
 func (h *Handler) ListUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := h.Service.GetAllUsers(r.Context())
+	arg, err := listUsersParams(r.URL.Query())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	page, err := h.Service.ListUsers(r.Context(), arg)
 	if err != nil {
-		http.Error(w, "Failed to list users", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(users); err != nil {
-		http.Error(w, "Error encoding response: "+err.Error(), http.StatusInternalServerError)
+	setNextPage(w, r, page.NextCursor)
+	if page.Total != nil {
+		w.Header().Set("X-Total-Count", strconv.FormatInt(*page.Total, 10))
 	}
+	writeJSON(w, r, http.StatusOK, page.Users)
 }
 
-func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+// setNextPage links to the page after the current one, if there is one.
+func setNextPage(w http.ResponseWriter, r *http.Request, cursor string) {
+	if cursor == "" {
+		return
+	}
+	next := *r.URL
+	q := next.Query()
+	q.Set("cursor", cursor)
+	next.RawQuery = q.Encode()
+	w.Header().Set("X-Next-Cursor", cursor)
+	w.Header().Set("Link", "<"+next.RequestURI()+`>; rel="next"`)
+}
+
+// ListUserAudit returns one page of the changes made to a user, newest first,
+// as a JSON array. Paging works as for ListUsers.
+func (h *Handler) ListUserAudit(w http.ResponseWriter, r *http.Request) {
 	userID, err := uuid.Parse(chi.URLParam(r, "id"))
 	if err != nil {
-		http.Error(w, "Invalid UUID", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "The user ID is not a UUID.")
 		return
 	}
+	arg := userservice.ListAuditParams{UserID: userID, Cursor: r.URL.Query().Get("cursor")}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			writeError(w, r, &userservice.ValidationError{Fields: []userservice.FieldError{{Field: "limit", Message: "must be an integer"}}})
+			return
+		}
+		arg.Limit = int32(n)
+	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	page, err := h.Service.ListUserAudit(r.Context(), arg)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	if err := internal.Validate.Struct(req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	setNextPage(w, r, page.NextCursor)
+	writeJSON(w, r, http.StatusOK, page.Entries)
+}
+
+// SearchUsers finds users by partial name, email or phone number and returns
+// them best match first.
+func (h *Handler) SearchUsers(w http.ResponseWriter, r *http.Request) {
+	var limit int32
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			writeError(w, r, &userservice.ValidationError{Fields: []userservice.FieldError{{Field: "limit", Message: "must be an integer"}}})
+			return
+		}
+		limit = int32(n)
+	}
+
+	results, err := h.Service.SearchUsers(r.Context(), r.URL.Query().Get("q"), limit)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
+	writeJSON(w, r, http.StatusOK, results)
+}
+
+func listUsersParams(q url.Values) (userservice.ListUsersParams, error) {
+	arg := userservice.ListUsersParams{
+		Cursor:      q.Get("cursor"),
+		Sort:        q.Get("sort"),
+		Status:      q.Get("status"),
+		EmailPrefix: q.Get("email_prefix"),
+		NamePrefix:  q.Get("name_prefix"),
+		WithTotal:   q.Get("count") == "true",
 
-	user, err := h.Service.UpdateUser(r.Context(), userservice.UpdateUserParams{
+		IncludeDeleted: q.Get("include_deleted") == "true",
+	}
+	parseInt := func(name string) (*int32, error) {
+		v := q.Get(name)
+		if v == "" {
+			return nil, nil
+		}
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return nil, &userservice.ValidationError{Fields: []userservice.FieldError{{Field: name, Message: "must be an integer"}}}
+		}
+		i := int32(n)
+		return &i, nil
+	}
+	limit, err := parseInt("limit")
+	if err != nil {
+		return arg, err
+	}
+	if limit != nil {
+		arg.Limit = *limit
+	}
+	if arg.MinAge, err = parseInt("min_age"); err != nil {
+		return arg, err
+	}
+	if arg.MaxAge, err = parseInt("max_age"); err != nil {
+		return arg, err
+	}
+	return arg, nil
+}
+
+// UpdateUser applies a JSON Merge Patch (RFC 7396): fields absent from the
+// body are left unchanged and null clears a field. If-Match must hold the
+// ETag of the user being patched, or "*".
+func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "The user ID is not a UUID.")
+		return
+	}
+	version, err := ifMatch(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, err := mime.ParseMediaType(ct)
+		if err != nil || (mediaType != mergePatchType && mediaType != "application/json") {
+			writeProblem(w, r, http.StatusUnsupportedMediaType, "Content-Type must be "+mergePatchType+".")
+			return
+		}
+	}
+
+	var patch userPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "The body is not valid JSON.")
+		return
+	}
+	if err := patch.validate(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	h.update(w, r, userservice.UpdateUserParams{
 		UserID:    userID,
-		FirstName: req.FirstName,
-		LastName:  req.LastName,
-		Email:     req.Email,
-		Phone:     &req.Phone,
-		Age:       req.Age,
-		Status:    &req.Status,
+		FirstName: patch.FirstName,
+		LastName:  patch.LastName,
+		Email:     patch.Email,
+		Phone:     patch.Phone,
+		Age:       patch.Age,
+		Status:    patch.Status,
+
+		ExpectedVersion: version,
 	})
+}
 
+// ReplaceUser replaces every field of a user; optional fields absent from the
+// body are cleared, except status, which is left unchanged. If-Match is
+// required as for UpdateUser.
+func (h *Handler) ReplaceUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
 	if err != nil {
-		http.Error(w, "Could not update user: "+err.Error(), http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusBadRequest, "The user ID is not a UUID.")
+		return
+	}
+	version, err := ifMatch(r)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	event, _ := json.Marshal(user)
-	nats.Publish("users.updated", event)
+	var req userRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "The body is not valid JSON.")
+		return
+	}
+	if err := internal.Validate.Struct(req); err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-	if err := json.NewEncoder(w).Encode(user); err != nil {
-		http.Error(w, "Error encoding response: "+err.Error(), http.StatusInternalServerError)
+	arg := userservice.UpdateUserParams{
+		UserID:    userID,
+		FirstName: userservice.Value(req.FirstName),
+		LastName:  userservice.Value(req.LastName),
+		Email:     userservice.Value(req.Email),
+		Phone:     userservice.Value(req.Phone),
+		Age:       userservice.FromPtr(req.Age),
+
+		ExpectedVersion: version,
 	}
+	// Status cannot be cleared, and only changes by transitions.
+	if req.Status != "" {
+		arg.Status = userservice.Value(req.Status)
+	}
+	h.update(w, r, arg)
 }
 
+func (h *Handler) update(w http.ResponseWriter, r *http.Request, arg userservice.UpdateUserParams) {
+	user, err := h.Service.UpdateUser(r.Context(), arg)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(user.Version))
+	writeJSON(w, r, http.StatusOK, user)
+}
+
+// DeleteUser deletes a user, which can be restored until it is purged.
+// If-Match is required as for UpdateUser.
 func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	userID, err := uuid.Parse(idStr)
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "The user ID is not a UUID.")
+		return
+	}
+	version, err := ifMatch(r)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	if err := h.Service.DeleteUser(r.Context(), userID); err != nil {
-		http.Error(w, "Failed to delete user: "+err.Error(), http.StatusInternalServerError)
+	err = h.Service.DeleteUser(r.Context(), userservice.DeleteUserParams{UserID: userID, ExpectedVersion: version})
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// RestoreUser restores a deleted user. If-Match is optional, since deleted
+// users are only seen in listings.
+func (h *Handler) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "The user ID is not a UUID.")
+		return
+	}
+	version, err := ifMatch(r)
+	if err != nil && !errors.Is(err, errPreconditionRequired) {
+		writeError(w, r, err)
+		return
+	}
+
+	user, err := h.Service.RestoreUser(r.Context(), userservice.RestoreUserParams{UserID: userID, ExpectedVersion: version})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(user.Version))
+	writeJSON(w, r, http.StatusOK, user)
+}
+
+// statusChange is the body of the status transition endpoints.
+type statusChange struct {
+	Reason string `json:"reason" validate:"required,max=500"`
+}
+
+// ActivateUser, SuspendUser and CloseUser move a user along its lifecycle.
+// If-Match is required as for UpdateUser.
+func (h *Handler) ActivateUser(w http.ResponseWriter, r *http.Request) {
+	h.changeStatus(w, r, userservice.StatusActive)
+}
+
+func (h *Handler) SuspendUser(w http.ResponseWriter, r *http.Request) {
+	h.changeStatus(w, r, userservice.StatusSuspended)
+}
+
+func (h *Handler) CloseUser(w http.ResponseWriter, r *http.Request) {
+	h.changeStatus(w, r, userservice.StatusClosed)
+}
+
+func (h *Handler) changeStatus(w http.ResponseWriter, r *http.Request, status string) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "The user ID is not a UUID.")
+		return
+	}
+	version, err := ifMatch(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var req statusChange
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "The body is not valid JSON.")
+		return
+	}
+	if err := internal.Validate.Struct(req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	user, err := h.Service.ChangeStatus(r.Context(), userservice.ChangeStatusParams{
+		UserID:          userID,
+		Status:          status,
+		Reason:          req.Reason,
+		ExpectedVersion: version,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(user.Version))
+	writeJSON(w, r, http.StatusOK, user)
+}
@@ -1,8 +1,12 @@
 package api_test
 
 import (
+	"archive/zip"
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/laki88/yaalalabs-user-api/user-rest-api/api"
@@ -12,7 +16,9 @@ import (
 	"github.com/stretchr/testify/mock"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"testing"
+	"time"
 )
 
 type mockUserService struct {
@@ -29,9 +35,14 @@ func (m *mockUserService) GetUser(ctx context.Context, id uuid.UUID) (userservic
 	return args.Get(0).(userservice.User), args.Error(1)
 }
 
-func (m *mockUserService) GetAllUsers(ctx context.Context) ([]userservice.User, error) {
-	args := m.Called(ctx)
-	return args.Get(0).([]userservice.User), args.Error(1)
+func (m *mockUserService) ListUsers(ctx context.Context, arg userservice.ListUsersParams) (userservice.UserPage, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(userservice.UserPage), args.Error(1)
+}
+
+func (m *mockUserService) SearchUsers(ctx context.Context, query string, limit int32) ([]userservice.SearchResult, error) {
+	args := m.Called(ctx, query, limit)
+	return args.Get(0).([]userservice.SearchResult), args.Error(1)
 }
 
 func (m *mockUserService) UpdateUser(ctx context.Context, arg userservice.UpdateUserParams) (userservice.User, error) {
@@ -39,30 +50,110 @@ func (m *mockUserService) UpdateUser(ctx context.Context, arg userservice.Update
 	return args.Get(0).(userservice.User), args.Error(1)
 }
 
-func (m *mockUserService) DeleteUser(ctx context.Context, id uuid.UUID) error {
-	args := m.Called(ctx, id)
+func (m *mockUserService) DeleteUser(ctx context.Context, arg userservice.DeleteUserParams) error {
+	args := m.Called(ctx, arg)
+	return args.Error(0)
+}
+
+func (m *mockUserService) RestoreUser(ctx context.Context, arg userservice.RestoreUserParams) (userservice.User, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(userservice.User), args.Error(1)
+}
+
+func (m *mockUserService) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) ([]uuid.UUID, error) {
+	args := m.Called(ctx, deletedBefore)
+	return args.Get(0).([]uuid.UUID), args.Error(1)
+}
+
+func (m *mockUserService) ListUserAudit(ctx context.Context, arg userservice.ListAuditParams) (userservice.AuditPage, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(userservice.AuditPage), args.Error(1)
+}
+
+func (m *mockUserService) ChangeStatus(ctx context.Context, arg userservice.ChangeStatusParams) (userservice.User, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(userservice.User), args.Error(1)
+}
+
+func (m *mockUserService) Batch(ctx context.Context, ops []userservice.BatchOperation) (userservice.BatchResult, error) {
+	args := m.Called(ctx, ops)
+	return args.Get(0).(userservice.BatchResult), args.Error(1)
+}
+
+func (m *mockUserService) ImportUsers(ctx context.Context, arg userservice.ImportUsersParams) (userservice.ImportResult, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(userservice.ImportResult), args.Error(1)
+}
+
+func (m *mockUserService) EraseUser(ctx context.Context, arg userservice.EraseUserParams) (userservice.User, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(userservice.User), args.Error(1)
+}
+
+func (m *mockUserService) UsersWithEventsNotErased(ctx context.Context, limit int32) ([]uuid.UUID, error) {
+	args := m.Called(ctx, limit)
+	return args.Get(0).([]uuid.UUID), args.Error(1)
+}
+
+func (m *mockUserService) MarkEventsErased(ctx context.Context, userID uuid.UUID) error {
+	args := m.Called(ctx, userID)
 	return args.Error(0)
 }
 
+func (m *mockUserService) ExportPersonalData(ctx context.Context, userID uuid.UUID) (userservice.PersonalData, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(userservice.PersonalData), args.Error(1)
+}
+
+type mockStreamData struct {
+	mock.Mock
+}
+
+func (m *mockStreamData) Orders(ctx context.Context, userID uuid.UUID) ([]json.RawMessage, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]json.RawMessage), args.Error(1)
+}
+
+func (m *mockStreamData) Trades(ctx context.Context, userID uuid.UUID) ([]json.RawMessage, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]json.RawMessage), args.Error(1)
+}
+
+func (m *mockStreamData) EraseEvents(ctx context.Context, userID uuid.UUID) (int, error) {
+	args := m.Called(ctx, userID)
+	return args.Int(0), args.Error(1)
+}
+
+// ExportUsers calls fn with the users given to Return, then returns the
+// error given to it.
+func (m *mockUserService) ExportUsers(ctx context.Context, arg userservice.ListUsersParams, fn func(userservice.User) error) error {
+	args := m.Called(ctx, arg)
+	for _, user := range args.Get(0).([]userservice.User) {
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
+
 func TestCreateUser_Success(t *testing.T) {
 	mockService := new(mockUserService)
 	handler := api.NewHandler(mockService)
 	internal.InitValidator()
 
-	reqBody := `{"first_name":"Alice","last_name":"Smith","email":"alice@example.com", "phone": "1234567890", "age": 30, "status": "Active"}`
+	reqBody := `{"first_name":"Alice","last_name":"Smith","email":"alice@example.com", "phone": "1234567890", "age": 30, "status": "active"}`
 	r := httptest.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(reqBody))
 	r.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 
 	phoneNumber := "1234567890"
 	age := int32(30)
-	status := "Active"
-	expected := userservice.User{FirstName: "Alice", LastName: "Smith", Email: "alice@example.com", Phone: &phoneNumber, Age: &age, Status: &status}
+	expected := userservice.User{FirstName: "Alice", LastName: "Smith", Email: "alice@example.com", Phone: &phoneNumber, Age: &age, Status: userservice.StatusActive}
 	mockService.On("CreateUser", mock.Anything, mock.Anything).Return(expected, nil)
 
 	handler.CreateUser(w, r)
 
-	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusCreated, w.Code)
 	mockService.AssertExpectations(t)
 }
 
@@ -98,14 +189,18 @@ func TestUpdateUser_Success(t *testing.T) {
 	ctx.URLParams.Add("id", userID.String())
 	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, ctx))
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", `"3"`)
 	w := httptest.NewRecorder()
 
-	expected := userservice.User{UserID: userID, Email: "updated@example.com"}
-	mockService.On("UpdateUser", mock.Anything, mock.Anything).Return(expected, nil)
+	expected := userservice.User{UserID: userID, Email: "updated@example.com", Version: 4}
+	mockService.On("UpdateUser", mock.Anything, mock.MatchedBy(func(arg userservice.UpdateUserParams) bool {
+		return *arg.ExpectedVersion == 3
+	})).Return(expected, nil)
 
 	handler.UpdateUser(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"4"`, w.Header().Get("ETag"))
 	mockService.AssertExpectations(t)
 }
 
@@ -118,13 +213,704 @@ func TestDeleteUser_Success(t *testing.T) {
 	ctx := chi.NewRouteContext()
 	ctx.URLParams.Add("id", userID.String())
 	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, ctx))
+	req.Header.Set("If-Match", "*")
 
 	w := httptest.NewRecorder()
 
-	mockService.On("DeleteUser", mock.Anything, userID).Return(nil)
+	mockService.On("DeleteUser", mock.Anything, userservice.DeleteUserParams{UserID: userID}).Return(nil)
 
 	handler.DeleteUser(w, req)
 
 	assert.Equal(t, http.StatusNoContent, w.Code)
 	mockService.AssertExpectations(t)
 }
+
+func TestRestoreUser(t *testing.T) {
+	mockService := new(mockUserService)
+	router := api.Routes(api.NewHandler(mockService))
+
+	userID := uuid.New()
+	mockService.On("RestoreUser", mock.Anything, userservice.RestoreUserParams{UserID: userID}).
+		Return(userservice.User{UserID: userID, Version: 3}, nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/"+userID.String()+"/restore", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+
+	notDeleted := uuid.New()
+	mockService.On("RestoreUser", mock.Anything, mock.MatchedBy(func(arg userservice.RestoreUserParams) bool {
+		return arg.UserID == notDeleted
+	})).Return(userservice.User{}, userservice.ErrUserNotDeleted)
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/"+notDeleted.String()+"/restore", nil)
+	req.Header.Set("If-Match", `"2"`)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestStatusTransitions(t *testing.T) {
+	internal.InitValidator()
+	mockService := new(mockUserService)
+	router := api.Routes(api.NewHandler(mockService))
+
+	userID := uuid.New()
+	version := int32(4)
+	mockService.On("ChangeStatus", mock.Anything, userservice.ChangeStatusParams{
+		UserID: userID, Status: userservice.StatusSuspended, Reason: "chargeback", ExpectedVersion: &version,
+	}).Return(userservice.User{UserID: userID, Status: userservice.StatusSuspended, Version: 5}, nil)
+	mockService.On("ChangeStatus", mock.Anything, mock.MatchedBy(func(arg userservice.ChangeStatusParams) bool {
+		return arg.Status == userservice.StatusActive
+	})).Return(userservice.User{}, userservice.ErrInvalidTransition)
+
+	post := func(action, body, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/"+userID.String()+"/"+action, bytes.NewBufferString(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("suspend", `{"reason":"chargeback"}`, `"4"`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"5"`, w.Header().Get("ETag"))
+	assert.Contains(t, w.Body.String(), `"status":"suspended"`)
+
+	assert.Equal(t, http.StatusConflict, post("activate", `{"reason":"appeal"}`, "*").Code)
+	assert.Equal(t, http.StatusBadRequest, post("close", `{}`, "*").Code)
+	assert.Equal(t, http.StatusPreconditionRequired, post("close", `{"reason":"request"}`, "").Code)
+	mockService.AssertNumberOfCalls(t, "ChangeStatus", 2)
+}
+
+func TestCreateUser_RejectsUnknownStatus(t *testing.T) {
+	internal.InitValidator()
+	mockService := new(mockUserService)
+	handler := api.NewHandler(mockService)
+
+	reqBody := `{"first_name":"Alice","last_name":"Smith","email":"alice@example.com","status":"Active"}`
+	w := httptest.NewRecorder()
+	handler.CreateUser(w, httptest.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(reqBody)))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"field":"status"`)
+	mockService.AssertNotCalled(t, "CreateUser", mock.Anything, mock.Anything)
+}
+
+func TestListUserAudit(t *testing.T) {
+	mockService := new(mockUserService)
+	router := api.Routes(api.NewHandler(mockService))
+
+	userID := uuid.New()
+	mockService.On("ListUserAudit", mock.Anything, userservice.ListAuditParams{UserID: userID, Limit: 1, Cursor: "Mg"}).
+		Return(userservice.AuditPage{
+			Entries:    []userservice.AuditEntry{{ID: 2, UserID: userID, Action: userservice.AuditUpdated, Actor: "admin", Changed: []string{"age"}}},
+			NextCursor: "MQ",
+		}, nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+userID.String()+"/audit?limit=1&cursor=Mg", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "MQ", w.Header().Get("X-Next-Cursor"))
+	assert.Contains(t, w.Header().Get("Link"), "cursor=MQ")
+	assert.Contains(t, w.Body.String(), `"action":"updated"`)
+	assert.Contains(t, w.Body.String(), `"actor":"admin"`)
+	mockService.AssertExpectations(t)
+}
+
+func TestListUsers_IncludeDeleted(t *testing.T) {
+	mockService := new(mockUserService)
+	handler := api.NewHandler(mockService)
+
+	mockService.On("ListUsers", mock.Anything, userservice.ListUsersParams{IncludeDeleted: true}).
+		Return(userservice.UserPage{}, nil)
+
+	w := httptest.NewRecorder()
+	handler.ListUsers(w, httptest.NewRequest(http.MethodGet, "/users?include_deleted=true", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestListUsers_PassesFiltersAndSetsPagingHeaders(t *testing.T) {
+	mockService := new(mockUserService)
+	handler := api.NewHandler(mockService)
+
+	minAge := int32(18)
+	total := int64(42)
+	mockService.On("ListUsers", mock.Anything, userservice.ListUsersParams{
+		Limit:      10,
+		Sort:       "-age",
+		Status:     "active",
+		MinAge:     &minAge,
+		NamePrefix: "al",
+		WithTotal:  true,
+	}).Return(userservice.UserPage{
+		Users:      []userservice.User{{FirstName: "Alice"}},
+		NextCursor: "abc",
+		Total:      &total,
+	}, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/users?limit=10&sort=-age&status=active&min_age=18&name_prefix=al&count=true", nil)
+	w := httptest.NewRecorder()
+	handler.ListUsers(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "abc", w.Header().Get("X-Next-Cursor"))
+	assert.Contains(t, w.Header().Get("Link"), "cursor=abc")
+	assert.Equal(t, "42", w.Header().Get("X-Total-Count"))
+	assert.Contains(t, w.Body.String(), `"first_name":"Alice"`)
+	mockService.AssertExpectations(t)
+}
+
+func TestListUsers_InvalidParams(t *testing.T) {
+	mockService := new(mockUserService)
+	handler := api.NewHandler(mockService)
+
+	w := httptest.NewRecorder()
+	handler.ListUsers(w, httptest.NewRequest(http.MethodGet, "/users?min_age=old", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mockService.On("ListUsers", mock.Anything, mock.Anything).
+		Return(userservice.UserPage{}, fmt.Errorf("%w: cannot sort by \"phone\"", userservice.ErrInvalidListParams))
+	w = httptest.NewRecorder()
+	handler.ListUsers(w, httptest.NewRequest(http.MethodGet, "/users?sort=phone", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestSearchUsers(t *testing.T) {
+	mockService := new(mockUserService)
+	router := api.Routes(api.NewHandler(mockService))
+
+	mockService.On("SearchUsers", mock.Anything, "ali smi", int32(5)).Return([]userservice.SearchResult{{
+		User:       userservice.User{FirstName: "Alice"},
+		Rank:       0.9,
+		Highlights: map[string]string{"first_name": "<mark>Ali</mark>ce"},
+	}}, nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/search?q=ali+smi&limit=5", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"rank":0.9`)
+	mockService.AssertExpectations(t)
+}
+
+func TestUpdateUser_MergePatch(t *testing.T) {
+	mockService := new(mockUserService)
+	router := api.Routes(api.NewHandler(mockService))
+	internal.InitValidator()
+
+	userID := uuid.New()
+	mockService.On("UpdateUser", mock.Anything, userservice.UpdateUserParams{
+		UserID: userID,
+		Phone:  userservice.Null[string](),
+		Age:    userservice.Value(int32(40)),
+	}).Return(userservice.User{UserID: userID}, nil)
+
+	req := httptest.NewRequest(http.MethodPatch, "/"+userID.String(), bytes.NewBufferString(`{"phone":null,"age":40}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", "*")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestUpdateUser_RejectsInvalidPatches(t *testing.T) {
+	mockService := new(mockUserService)
+	router := api.Routes(api.NewHandler(mockService))
+	internal.InitValidator()
+	userID := uuid.New()
+
+	for body, contentType := range map[string]string{
+		`{"email":null}`:         "application/merge-patch+json",
+		`{"first_name":"A"}`:     "application/merge-patch+json",
+		`{"email":"not-email"}`:  "application/json",
+		`{"first_name":"Alice"}`: "text/plain",
+	} {
+		req := httptest.NewRequest(http.MethodPatch, "/"+userID.String(), bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("If-Match", "*")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Contains(t, []int{http.StatusBadRequest, http.StatusUnsupportedMediaType}, w.Code, body)
+	}
+	mockService.AssertNotCalled(t, "UpdateUser", mock.Anything, mock.Anything)
+}
+
+func TestReplaceUser_ClearsAbsentOptionalFields(t *testing.T) {
+	mockService := new(mockUserService)
+	router := api.Routes(api.NewHandler(mockService))
+	internal.InitValidator()
+
+	userID := uuid.New()
+	mockService.On("UpdateUser", mock.Anything, userservice.UpdateUserParams{
+		UserID:    userID,
+		FirstName: userservice.Value("Alice"),
+		LastName:  userservice.Value("Smith"),
+		Email:     userservice.Value("alice@example.com"),
+		Phone:     userservice.Value(""),
+		Age:       userservice.Null[int32](),
+	}).Return(userservice.User{UserID: userID}, nil)
+
+	body := `{"first_name":"Alice","last_name":"Smith","email":"alice@example.com"}`
+	req := httptest.NewRequest(http.MethodPut, "/"+userID.String(), bytes.NewBufferString(body))
+	req.Header.Set("If-Match", "*")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestGetUser_SetsETag(t *testing.T) {
+	mockService := new(mockUserService)
+	router := api.Routes(api.NewHandler(mockService))
+
+	userID := uuid.New()
+	mockService.On("GetUser", mock.Anything, userID).Return(userservice.User{UserID: userID, Version: 7}, nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+userID.String(), nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"7"`, w.Header().Get("ETag"))
+}
+
+func TestWrites_RequireIfMatch(t *testing.T) {
+	mockService := new(mockUserService)
+	router := api.Routes(api.NewHandler(mockService))
+	internal.InitValidator()
+	userID := uuid.New()
+	body := `{"first_name":"Alice","last_name":"Smith","email":"alice@example.com"}`
+
+	for _, method := range []string{http.MethodPatch, http.MethodPut, http.MethodDelete} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, "/"+userID.String(), bytes.NewBufferString(body)))
+		assert.Equal(t, http.StatusPreconditionRequired, w.Code, method)
+
+		// A weak ETag never matches.
+		req := httptest.NewRequest(method, "/"+userID.String(), bytes.NewBufferString(body))
+		req.Header.Set("If-Match", `W/"1"`)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code, method)
+	}
+	mockService.AssertNotCalled(t, "UpdateUser", mock.Anything, mock.Anything)
+	mockService.AssertNotCalled(t, "DeleteUser", mock.Anything, mock.Anything)
+}
+
+func TestWrites_VersionMismatch(t *testing.T) {
+	mockService := new(mockUserService)
+	router := api.Routes(api.NewHandler(mockService))
+	internal.InitValidator()
+	userID := uuid.New()
+	version := int32(2)
+
+	mockService.On("UpdateUser", mock.Anything, userservice.UpdateUserParams{UserID: userID, Age: userservice.Value(int32(40)), ExpectedVersion: &version}).
+		Return(userservice.User{}, userservice.ErrVersionMismatch)
+	mockService.On("DeleteUser", mock.Anything, userservice.DeleteUserParams{UserID: userID, ExpectedVersion: &version}).
+		Return(userservice.ErrVersionMismatch)
+
+	for _, method := range []string{http.MethodPatch, http.MethodDelete} {
+		req := httptest.NewRequest(method, "/"+userID.String(), bytes.NewBufferString(`{"age":40}`))
+		req.Header.Set("If-Match", `"2"`)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code, method)
+	}
+	mockService.AssertExpectations(t)
+}
+
+func TestErrors_AreProblemDetails(t *testing.T) {
+	mockService := new(mockUserService)
+	router := api.Routes(api.NewHandler(mockService))
+	internal.InitValidator()
+
+	missing, broken := uuid.New(), uuid.New()
+	mockService.On("GetUser", mock.Anything, missing).Return(userservice.User{}, userservice.ErrUserNotFound)
+	mockService.On("GetUser", mock.Anything, broken).Return(userservice.User{}, errors.New("pq: connection refused"))
+	mockService.On("CreateUser", mock.Anything, mock.Anything).Return(userservice.User{}, userservice.ErrEmailTaken)
+
+	for name, tc := range map[string]struct {
+		req    *http.Request
+		status int
+		typ    string
+	}{
+		"not found":      {httptest.NewRequest(http.MethodGet, "/"+missing.String(), nil), http.StatusNotFound, "not-found"},
+		"internal error": {httptest.NewRequest(http.MethodGet, "/"+broken.String(), nil), http.StatusInternalServerError, "internal-error"},
+		"bad id":         {httptest.NewRequest(http.MethodGet, "/nope", nil), http.StatusBadRequest, "invalid-request"},
+		"email taken": {
+			httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"first_name":"Alice","last_name":"Smith","email":"alice@example.com"}`)),
+			http.StatusConflict, "conflict",
+		},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, tc.req)
+
+		assert.Equal(t, tc.status, w.Code, name)
+		assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"), name)
+		var problem api.Problem
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem), name)
+		assert.Equal(t, "https://schemas.yaalalabs.com/problems/"+tc.typ, problem.Type, name)
+		assert.Equal(t, tc.status, problem.Status, name)
+		assert.NotContains(t, problem.Detail, "pq:", name)
+	}
+}
+
+func TestErrors_ListInvalidFields(t *testing.T) {
+	router := api.Routes(api.NewHandler(new(mockUserService)))
+	internal.InitValidator()
+
+	body := `{"first_name":"A","email":"not-an-email"}`
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body)))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var problem api.Problem
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	assert.Equal(t, []userservice.FieldError{
+		{Field: "first_name", Message: "must have at least 2 characters"},
+		{Field: "last_name", Message: "is required"},
+		{Field: "email", Message: "must be a valid email address"},
+	}, problem.Errors)
+
+	req := httptest.NewRequest(http.MethodPatch, "/"+uuid.NewString(), bytes.NewBufferString(`{"email":null,"last_name":"B"}`))
+	req.Header.Set("If-Match", "*")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	problem = api.Problem{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	assert.Equal(t, []userservice.FieldError{
+		{Field: "last_name", Message: "must have at least 2 characters"},
+		{Field: "email", Message: "cannot be null"},
+	}, problem.Errors)
+}
+
+// bulkRouter routes requests as main does.
+func bulkRouter(service userservice.UserService) http.Handler {
+	internal.InitValidator()
+	handler := api.NewHandler(service)
+	r := chi.NewRouter()
+	r.Mount("/users", api.Routes(handler))
+	api.BulkRoutes(r, handler)
+	return r
+}
+
+func TestImportUsers_CSV(t *testing.T) {
+	mockService := new(mockUserService)
+	body := "user_id,first_name,last_name,email,phone,age,status\n" +
+		",John,Doe,john@example.com,555,30,pending\n" +
+		",J,Doe,not-an-email,,old,\n"
+	r := httptest.NewRequest(http.MethodPost, "/users:import?mode=best_effort&dry_run=true", bytes.NewBufferString(body))
+	r.Header.Set("Content-Type", "text/csv; charset=utf-8")
+	w := httptest.NewRecorder()
+
+	mockService.On("ImportUsers", mock.Anything, mock.MatchedBy(func(arg userservice.ImportUsersParams) bool {
+		if arg.Mode != userservice.ImportBestEffort || !arg.DryRun || len(arg.Rows) != 2 {
+			return false
+		}
+		john, invalid := arg.Rows[0], arg.Rows[1]
+		var fields []string
+		for _, e := range invalid.Errors {
+			fields = append(fields, e.Field)
+		}
+		return john.Line == 2 && john.Errors == nil && *john.User.Age == 30 && *john.User.Status == userservice.StatusPending &&
+			invalid.Line == 3 && slices.Equal([]string{"age", "first_name", "email"}, fields)
+	})).Return(userservice.ImportResult{Mode: userservice.ImportBestEffort, DryRun: true, Created: 1, Failed: 1}, nil)
+
+	bulkRouter(mockService).ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestImportUsers_NDJSON(t *testing.T) {
+	mockService := new(mockUserService)
+	body := `{"first_name":"John","last_name":"Doe","email":"john@example.com"}` + "\n\n" + `{"first_name":` + "\n"
+	r := httptest.NewRequest(http.MethodPost, "/users:import", bytes.NewBufferString(body))
+	r.Header.Set("Content-Type", "application/x-ndjson")
+	w := httptest.NewRecorder()
+
+	mockService.On("ImportUsers", mock.Anything, mock.MatchedBy(func(arg userservice.ImportUsersParams) bool {
+		return len(arg.Rows) == 2 && arg.Rows[0].Line == 1 && arg.Rows[0].Errors == nil &&
+			arg.Rows[1].Line == 3 && arg.Rows[1].Errors[0].Field == "line"
+	})).Return(userservice.ImportResult{Mode: userservice.ImportAtomic, Failed: 1}, nil)
+
+	bulkRouter(mockService).ServeHTTP(w, r)
+
+	// Nothing was created by the atomic import.
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	mockService.AssertExpectations(t)
+}
+
+func TestImportUsers_RejectsUnreadableFiles(t *testing.T) {
+	mockService := new(mockUserService)
+	router := bulkRouter(mockService)
+
+	for name, tc := range map[string]struct {
+		target, contentType, body string
+		status                    int
+	}{
+		"unsupported type": {"/users:import", "application/json", `[]`, http.StatusUnsupportedMediaType},
+		"unknown column":   {"/users:import", "text/csv", "first_name,nickname\n", http.StatusBadRequest},
+		"ragged csv":       {"/users:import", "text/csv", "first_name,email\nJo\n", http.StatusBadRequest},
+		"bad dry_run":      {"/users:import?dry_run=maybe", "text/csv", "email\n", http.StatusBadRequest},
+	} {
+		r := httptest.NewRequest(http.MethodPost, tc.target, bytes.NewBufferString(tc.body))
+		r.Header.Set("Content-Type", tc.contentType)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, tc.status, w.Code, name)
+		assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"), name)
+	}
+	mockService.AssertNotCalled(t, "ImportUsers", mock.Anything, mock.Anything)
+}
+
+func TestExportUsers_CSV(t *testing.T) {
+	mockService := new(mockUserService)
+	r := httptest.NewRequest(http.MethodGet, "/users:export?status=active&cursor=ignored", nil)
+	r.Header.Set("Accept", "text/csv")
+	w := httptest.NewRecorder()
+
+	phone, age := "555", int32(30)
+	createdAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	users := []userservice.User{
+		{UserID: uuid.New(), FirstName: "John", LastName: "Doe", Email: "john@example.com", Phone: &phone, Age: &age, Status: "active", CreatedAt: createdAt, UpdatedAt: createdAt, Version: 2},
+		{UserID: uuid.New(), FirstName: "Jane", LastName: "Doe, Jr.", Email: "jane@example.com", Status: "active", CreatedAt: createdAt, UpdatedAt: createdAt, Version: 1},
+	}
+	mockService.On("ExportUsers", mock.Anything, mock.MatchedBy(func(arg userservice.ListUsersParams) bool {
+		return arg.Status == userservice.StatusActive
+	})).Return(users, nil)
+
+	bulkRouter(mockService).ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "user_id,first_name,last_name,email,phone,age,status,created_at,updated_at,version,deleted_at\n"+
+		users[0].UserID.String()+",John,Doe,john@example.com,555,30,active,2026-01-02T03:04:05Z,2026-01-02T03:04:05Z,2,\n"+
+		users[1].UserID.String()+`,Jane,"Doe, Jr.",jane@example.com,,,active,2026-01-02T03:04:05Z,2026-01-02T03:04:05Z,1,`+"\n", w.Body.String())
+}
+
+func TestExportUsers_NDJSON(t *testing.T) {
+	mockService := new(mockUserService)
+	r := httptest.NewRequest(http.MethodGet, "/users:export", nil)
+	w := httptest.NewRecorder()
+
+	users := []userservice.User{{UserID: uuid.New(), Email: "a@example.com"}, {UserID: uuid.New(), Email: "b@example.com"}}
+	mockService.On("ExportUsers", mock.Anything, mock.Anything).Return(users, nil)
+
+	bulkRouter(mockService).ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	dec := json.NewDecoder(w.Body)
+	for _, want := range users {
+		var got userservice.User
+		assert.NoError(t, dec.Decode(&got))
+		assert.Equal(t, want.UserID, got.UserID)
+	}
+	assert.False(t, dec.More())
+}
+
+func TestExportUsers_ErrorBeforeFirstUserIsProblem(t *testing.T) {
+	mockService := new(mockUserService)
+	r := httptest.NewRequest(http.MethodGet, "/users:export?sort=phone", nil)
+	w := httptest.NewRecorder()
+
+	mockService.On("ExportUsers", mock.Anything, mock.Anything).Return([]userservice.User(nil), userservice.ErrInvalidListParams)
+
+	bulkRouter(mockService).ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+}
+
+func TestBatch_DecodesOperations(t *testing.T) {
+	mockService := new(mockUserService)
+	updated, deleted := uuid.New(), uuid.New()
+	body := `{"operations":[
+		{"op":"create","user":{"first_name":"New","last_name":"User","email":"new@example.com"}},
+		{"op":"update","user_id":"` + updated.String() + `","if_match":"\"3\"","user":{"phone":null}},
+		{"op":"delete","user_id":"` + deleted.String() + `","if_match":"*"}
+	]}`
+	r := httptest.NewRequest(http.MethodPost, "/users:batch", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+
+	mockService.On("Batch", mock.Anything, mock.MatchedBy(func(ops []userservice.BatchOperation) bool {
+		return len(ops) == 3 &&
+			ops[0].Err == nil && ops[0].Create.Email == "new@example.com" &&
+			ops[1].Err == nil && ops[1].Update.UserID == updated && *ops[1].Update.ExpectedVersion == 3 && ops[1].Update.Phone.Null &&
+			ops[2].Err == nil && ops[2].Delete.UserID == deleted && ops[2].Delete.ExpectedVersion == nil
+	})).Return(userservice.BatchResult{Committed: true, Results: []userservice.BatchOperationResult{
+		{Op: userservice.OpCreate, Status: userservice.OpSucceeded, User: &userservice.User{Email: "new@example.com"}},
+		{Op: userservice.OpUpdate, Status: userservice.OpSucceeded, User: &userservice.User{UserID: updated}},
+		{Op: userservice.OpDelete, Status: userservice.OpSucceeded},
+	}}, nil)
+
+	bulkRouter(mockService).ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Committed bool `json:"committed"`
+		Results   []struct {
+			Status string            `json:"status"`
+			User   *userservice.User `json:"user"`
+		} `json:"results"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.True(t, resp.Committed)
+	assert.Equal(t, "new@example.com", resp.Results[0].User.Email)
+	assert.Nil(t, resp.Results[2].User)
+	mockService.AssertExpectations(t)
+}
+
+func TestBatch_ReportsFailedOperations(t *testing.T) {
+	mockService := new(mockUserService)
+	body := `{"operations":[
+		{"op":"update","user_id":"` + uuid.NewString() + `","if_match":"\"1\"","user":{"first_name":"Renamed"}},
+		{"op":"delete","user_id":"` + uuid.NewString() + `"},
+		{"op":"create","user":{"first_name":"N"}}
+	]}`
+	r := httptest.NewRequest(http.MethodPost, "/users:batch", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+
+	// The service reports the decoding errors it is given.
+	mockService.On("Batch", mock.Anything, mock.MatchedBy(func(ops []userservice.BatchOperation) bool {
+		return len(ops) == 3 && ops[0].Err == nil && ops[1].Err != nil && ops[2].Err != nil
+	})).Return(userservice.BatchResult{Results: []userservice.BatchOperationResult{
+		{Op: userservice.OpUpdate, Status: userservice.OpFailed, Err: userservice.ErrVersionMismatch},
+		{Op: userservice.OpDelete, Status: userservice.OpSkipped},
+		{Op: userservice.OpCreate, Status: userservice.OpFailed, Err: &userservice.ValidationError{Fields: []userservice.FieldError{{Field: "email", Message: "is required"}}}},
+	}}, nil)
+
+	bulkRouter(mockService).ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	var resp struct {
+		Committed bool `json:"committed"`
+		Results   []struct {
+			Status string       `json:"status"`
+			Error  *api.Problem `json:"error"`
+		} `json:"results"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.False(t, resp.Committed)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.Results[0].Error.Status)
+	assert.Nil(t, resp.Results[1].Error)
+	assert.Equal(t, "email", resp.Results[2].Error.Errors[0].Field)
+	mockService.AssertExpectations(t)
+}
+
+func TestExportPersonalData(t *testing.T) {
+	mockService := new(mockUserService)
+	streams := new(mockStreamData)
+	handler := api.NewHandler(mockService)
+	handler.Streams = streams
+	router := api.Routes(handler)
+	userID := uuid.New()
+
+	mockService.On("ExportPersonalData", mock.Anything, userID).Return(userservice.PersonalData{
+		User:  userservice.User{UserID: userID, Email: "john@example.com"},
+		Audit: []userservice.AuditEntry{{Action: userservice.AuditCreated}},
+	}, nil)
+	streams.On("Orders", mock.Anything, userID).Return([]json.RawMessage{json.RawMessage(`{"order_id":"o1"}`)}, nil)
+	streams.On("Trades", mock.Anything, userID).Return([]json.RawMessage(nil), nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+userID.String()+"/personal-data", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "user-"+userID.String()+".zip")
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	assert.NoError(t, err)
+	files := map[string]string{}
+	for _, f := range archive.File {
+		rc, err := f.Open()
+		assert.NoError(t, err)
+		var buf bytes.Buffer
+		_, _ = buf.ReadFrom(rc)
+		_ = rc.Close()
+		files[f.Name] = buf.String()
+	}
+	assert.Contains(t, files["user.json"], "john@example.com")
+	assert.Contains(t, files["audit.json"], `"action": "created"`)
+	assert.Contains(t, files["orders.json"], `"order_id": "o1"`)
+	assert.JSONEq(t, `[]`, files["trades.json"])
+	mockService.AssertExpectations(t)
+	streams.AssertExpectations(t)
+}
+
+func TestExportPersonalData_Errors(t *testing.T) {
+	mockService := new(mockUserService)
+	userID := uuid.New()
+
+	// Without JetStream the orders and trades cannot be read.
+	w := httptest.NewRecorder()
+	api.Routes(api.NewHandler(mockService)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+userID.String()+"/personal-data", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	streams := new(mockStreamData)
+	handler := api.NewHandler(mockService)
+	handler.Streams = streams
+	mockService.On("ExportPersonalData", mock.Anything, userID).Return(userservice.PersonalData{}, userservice.ErrUserNotFound)
+
+	w = httptest.NewRecorder()
+	api.Routes(handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+userID.String()+"/personal-data", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	streams.AssertNotCalled(t, "Orders", mock.Anything, mock.Anything)
+}
+
+func TestEraseUser(t *testing.T) {
+	internal.InitValidator()
+	mockService := new(mockUserService)
+	streams := new(mockStreamData)
+	handler := api.NewHandler(mockService)
+	handler.Streams = streams
+	router := api.Routes(handler)
+
+	userID := uuid.New()
+	version := int32(2)
+	mockService.On("EraseUser", mock.Anything, userservice.EraseUserParams{UserID: userID, Reason: "GDPR request", ExpectedVersion: &version}).
+		Return(userservice.User{UserID: userID, Status: userservice.StatusClosed, Version: 3}, nil)
+	streams.On("EraseEvents", mock.Anything, userID).Return(0, errors.New("nats: timeout")).Once()
+	streams.On("EraseEvents", mock.Anything, userID).Return(4, nil).Once()
+	mockService.On("MarkEventsErased", mock.Anything, userID).Return(nil).Once()
+
+	post := func(body, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/"+userID.String()+"/erase", bytes.NewBufferString(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusPreconditionRequired, post(`{"reason":"GDPR request"}`, "").Code)
+	assert.Equal(t, http.StatusBadRequest, post(`{}`, `"2"`).Code)
+
+	// The events could not be deleted, so the erasure is retried.
+	assert.Equal(t, http.StatusServiceUnavailable, post(`{"reason":"GDPR request"}`, `"2"`).Code)
+	w := post(`{"reason":"GDPR request"}`, `"2"`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+	mockService.AssertNumberOfCalls(t, "EraseUser", 2)
+	mockService.AssertNumberOfCalls(t, "MarkEventsErased", 1)
+	streams.AssertExpectations(t)
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/idempotency"
+)
+
+const (
+	// IdempotencyKeyHeader makes a retried request return the response to
+	// the first one instead of being handled again.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// ReplayedHeader is set on responses returned from the idempotency store.
+	ReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLen = 255
+)
+
+// Idempotent stores the responses to requests with an Idempotency-Key
+// header and returns them again for retries with the same key, query and
+// body. Keys are scoped to the caller named by ActorHeader, so callers that
+// pick the same key do not see each other's responses. Requests without the
+// header, and all requests if store is nil, are passed through. Server errors
+// are not stored, so they can be retried.
+func Idempotent(store *idempotency.Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if store == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLen {
+				writeProblem(w, r, http.StatusBadRequest, "Idempotency-Key is too long.")
+				return
+			}
+			key = scopedKey(r, key)
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeProblem(w, r, http.StatusBadRequest, "The body could not be read.")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			hash := sha256.New()
+			hash.Write([]byte(r.Method + " " + r.URL.Path + "?" + r.URL.RawQuery + "\n"))
+			hash.Write(body)
+
+			stored, err := store.Begin(r.Context(), key, hex.EncodeToString(hash.Sum(nil)))
+			switch {
+			case errors.Is(err, idempotency.ErrInFlight), errors.Is(err, idempotency.ErrKeyReused):
+				writeError(w, r, err)
+				return
+			case err != nil:
+				log.Printf("Idempotency store unavailable: %v\n", err)
+				writeProblem(w, r, http.StatusServiceUnavailable, "Idempotent requests cannot be handled right now.")
+				return
+			case stored != nil:
+				for name, values := range stored.Header {
+					w.Header()[name] = values
+				}
+				w.Header().Set(ReplayedHeader, "true")
+				w.WriteHeader(stored.StatusCode)
+				_, _ = w.Write(stored.Body)
+				return
+			}
+
+			rec := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			// The response is sent already, so failures here only mean a
+			// retry is handled again.
+			if rec.status >= http.StatusInternalServerError {
+				if err := store.Release(r.Context(), key); err != nil {
+					log.Printf("Failed to release idempotency key: %v\n", err)
+				}
+				return
+			}
+			resp := idempotency.Response{StatusCode: rec.status, Header: w.Header().Clone(), Body: rec.body.Bytes()}
+			if err := store.Complete(r.Context(), key, resp); err != nil {
+				log.Printf("Failed to store idempotent response: %v\n", err)
+			}
+		})
+	}
+}
+
+// scopedKey prefixes key with the caller of r. The caller is escaped so that
+// it cannot contain the ":" separating it from the key.
+func scopedKey(r *http.Request, key string) string {
+	return url.QueryEscape(r.Header.Get(ActorHeader)) + ":" + key
+}
+
+// responseRecorder copies a response while it is written.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,137 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/api"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/idempotency"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/repository/mocks"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/userservice"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCreateUser_IdempotencyKeyReplaysResponse(t *testing.T) {
+	internal.InitValidator()
+	mockService := new(mockUserService)
+	created := userservice.User{UserID: uuid.New(), FirstName: "Alice", Version: 1}
+	mockService.On("CreateUser", mock.Anything, mock.Anything).Return(created, nil).Once()
+
+	// The repository behaves like the table: the first claim succeeds, the
+	// retry finds the saved response.
+	var saved db.IdempotencyKey
+	repo := new(mocks.MockIdempotencyRepository)
+	repo.On("ClaimIdempotencyKey", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			arg := args.Get(1).(db.ClaimIdempotencyKeyParams)
+			saved = db.IdempotencyKey{Key: arg.Key, RequestHash: arg.RequestHash}
+		}).
+		Return(db.IdempotencyKey{}, nil).Once()
+	repo.On("ClaimIdempotencyKey", mock.Anything, mock.Anything).Return(db.IdempotencyKey{}, sql.ErrNoRows)
+	repo.On("SaveIdempotentResponse", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			arg := args.Get(1).(db.SaveIdempotentResponseParams)
+			saved.StatusCode, saved.Headers, saved.Body = arg.StatusCode, arg.Headers, arg.Body
+		}).
+		Return(nil).Once()
+	repo.On("GetIdempotencyKey", mock.Anything, ":retry-1").Return(func(context.Context, string) (db.IdempotencyKey, error) {
+		return saved, nil
+	})
+
+	handler := api.NewHandler(mockService)
+	handler.Idempotency = idempotency.NewStore(repo)
+	router := api.Routes(handler)
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		req.Header.Set(api.IdempotencyKeyHeader, "retry-1")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	body := `{"first_name":"Alice","last_name":"Smith","email":"alice@example.com"}`
+
+	first := post(body)
+	retry := post(body)
+
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Equal(t, first.Code, retry.Code)
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, `"1"`, retry.Header().Get("ETag"))
+	assert.Equal(t, "true", retry.Header().Get(api.ReplayedHeader))
+
+	// The same key with another body is a client error.
+	other := post(`{"first_name":"Bob","last_name":"Smith","email":"bob@example.com"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, other.Code)
+
+	mockService.AssertExpectations(t)
+	repo.AssertExpectations(t)
+}
+
+func TestIdempotent_ScopesKeysToCallerAndQuery(t *testing.T) {
+	// The repository behaves like the table.
+	keys := make(map[string]db.IdempotencyKey)
+	repo := new(mocks.MockIdempotencyRepository)
+	repo.On("ClaimIdempotencyKey", mock.Anything, mock.Anything).Return(func(_ context.Context, arg db.ClaimIdempotencyKeyParams) (db.IdempotencyKey, error) {
+		if _, ok := keys[arg.Key]; ok {
+			return db.IdempotencyKey{}, sql.ErrNoRows
+		}
+		keys[arg.Key] = db.IdempotencyKey{Key: arg.Key, RequestHash: arg.RequestHash}
+		return keys[arg.Key], nil
+	})
+	repo.On("GetIdempotencyKey", mock.Anything, mock.Anything).Return(func(_ context.Context, key string) (db.IdempotencyKey, error) {
+		return keys[key], nil
+	})
+	repo.On("SaveIdempotentResponse", mock.Anything, mock.Anything).Return(func(_ context.Context, arg db.SaveIdempotentResponseParams) error {
+		k := keys[arg.Key]
+		k.StatusCode, k.Headers, k.Body = arg.StatusCode, arg.Headers, arg.Body
+		keys[arg.Key] = k
+		return nil
+	})
+
+	var handled int
+	handler := api.Idempotent(idempotency.NewStore(repo))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled++
+		w.WriteHeader(http.StatusCreated)
+	}))
+	post := func(actor, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, bytes.NewBufferString(`{}`))
+		req.Header.Set(api.IdempotencyKeyHeader, "key-1")
+		req.Header.Set(api.ActorHeader, actor)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusCreated, post("alice", "/users?notify=true").Code)
+	// Another caller with the same key is handled on its own.
+	bob := post("bob", "/users?notify=true")
+	assert.Equal(t, http.StatusCreated, bob.Code)
+	assert.Empty(t, bob.Header().Get(api.ReplayedHeader))
+	assert.Equal(t, 2, handled)
+
+	retry := post("alice", "/users?notify=true")
+	assert.Equal(t, "true", retry.Header().Get(api.ReplayedHeader))
+	// The query is part of the request.
+	assert.Equal(t, http.StatusUnprocessableEntity, post("alice", "/users?notify=false").Code)
+	assert.Equal(t, 2, handled)
+}
+
+func TestCreateUser_EmailTaken(t *testing.T) {
+	internal.InitValidator()
+	mockService := new(mockUserService)
+	mockService.On("CreateUser", mock.Anything, mock.Anything).Return(userservice.User{}, userservice.ErrEmailTaken)
+
+	body := `{"first_name":"Alice","last_name":"Smith","email":"alice@example.com"}`
+	w := httptest.NewRecorder()
+	api.Routes(api.NewHandler(mockService)).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body)))
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
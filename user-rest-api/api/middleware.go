@@ -0,0 +1,17 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/events"
+)
+
+// ActorHeader names the caller in the actor attribute of the events it causes.
+const ActorHeader = "X-Actor-ID"
+
+func Actor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := events.WithActor(r.Context(), r.Header.Get(ActorHeader))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
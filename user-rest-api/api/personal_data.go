@@ -0,0 +1,161 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/userservice"
+)
+
+// StreamData reads and erases the data about users kept in JetStream. It is
+// implemented by streams.UserData.
+type StreamData interface {
+	Orders(ctx context.Context, userID uuid.UUID) ([]json.RawMessage, error)
+	Trades(ctx context.Context, userID uuid.UUID) ([]json.RawMessage, error)
+	EraseEvents(ctx context.Context, userID uuid.UUID) (int, error)
+}
+
+// ExportPersonalData sends everything held about a user, for data subject
+// access requests, as a zip archive of JSON files: the user, its audit log,
+// and the orders and trades of the matching engine. It fails with 503 while
+// JetStream is unavailable rather than send an incomplete archive.
+func (h *Handler) ExportPersonalData(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "The user ID is not a UUID.")
+		return
+	}
+	if h.Streams == nil {
+		writeProblem(w, r, http.StatusServiceUnavailable, "Orders and trades cannot be read right now.")
+		return
+	}
+
+	data, err := h.Service.ExportPersonalData(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	orders, err := h.Streams.Orders(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error reading orders of user %s: %v\n", userID, err)
+		writeProblem(w, r, http.StatusServiceUnavailable, "Orders cannot be read right now.")
+		return
+	}
+	trades, err := h.Streams.Trades(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error reading trades of user %s: %v\n", userID, err)
+		writeProblem(w, r, http.StatusServiceUnavailable, "Trades cannot be read right now.")
+		return
+	}
+
+	// The archive is built in memory so that errors are still sent as
+	// problems.
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, f := range []struct {
+		name string
+		v    any
+	}{
+		{"user.json", data.User},
+		{"audit.json", data.Audit},
+		{"orders.json", nonNil(orders)},
+		{"trades.json", nonNil(trades)},
+	} {
+		if err := writeZipJSON(archive, f.name, f.v); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="user-`+userID.String()+`.zip"`)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
+
+func writeZipJSON(archive *zip.Writer, name string, v any) error {
+	f, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// nonNil makes empty lists encode as [] rather than null.
+func nonNil(msgs []json.RawMessage) []json.RawMessage {
+	if msgs == nil {
+		return []json.RawMessage{}
+	}
+	return msgs
+}
+
+// EraseUser erases the personal data of a user, for right to erasure
+// requests. If-Match is required as for UpdateUser, but is not checked for
+// users that are already erased, including purged users. The user is erased
+// in the database first, then its events are deleted from JetStream; if that
+// fails the response is 503 and the request can be retried, since erasing an
+// erased user only deletes its events again. Orders and trades are financial
+// records and are kept.
+func (h *Handler) EraseUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "The user ID is not a UUID.")
+		return
+	}
+	version, err := ifMatch(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var req statusChange
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "The body is not valid JSON.")
+		return
+	}
+	if err := internal.Validate.Struct(req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	user, err := h.Service.EraseUser(r.Context(), userservice.EraseUserParams{
+		UserID:          userID,
+		Reason:          req.Reason,
+		ExpectedVersion: version,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if h.Streams == nil {
+		writeProblem(w, r, http.StatusServiceUnavailable, "The user is erased, but its events cannot be deleted right now. Retry the request.")
+		return
+	}
+	if _, err := h.Streams.EraseEvents(r.Context(), userID); err != nil {
+		log.Printf("Error erasing events of user %s: %v\n", userID, err)
+		writeProblem(w, r, http.StatusServiceUnavailable, "The user is erased, but its events cannot be deleted right now. Retry the request.")
+		return
+	}
+	// Otherwise the purge job erases the events again, which is harmless.
+	if err := h.Service.MarkEventsErased(r.Context(), userID); err != nil {
+		log.Printf("Error recording erased events of user %s: %v\n", userID, err)
+	}
+
+	w.Header().Set("ETag", etag(user.Version))
+	writeJSON(w, r, http.StatusOK, user)
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/idempotency"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/webhook"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/userservice"
+)
+
+const problemContentType = "application/problem+json"
+
+// problemTypeBase prefixes the type URIs of problems.
+const problemTypeBase = "https://schemas.yaalalabs.com/problems/"
+
+// Problem is an RFC 7807 problem details response. Errors lists the invalid
+// fields of a validation problem.
+type Problem struct {
+	Type     string                   `json:"type"`
+	Title    string                   `json:"title"`
+	Status   int                      `json:"status"`
+	Detail   string                   `json:"detail,omitempty"`
+	Instance string                   `json:"instance,omitempty"`
+	Errors   []userservice.FieldError `json:"errors,omitempty"`
+}
+
+// problemTypes names the problem type of each status the API responds with.
+var problemTypes = map[int]string{
+	http.StatusBadRequest:            "invalid-request",
+	http.StatusNotFound:              "not-found",
+	http.StatusConflict:              "conflict",
+	http.StatusPreconditionFailed:    "precondition-failed",
+	http.StatusPreconditionRequired:  "precondition-required",
+	http.StatusRequestEntityTooLarge: "payload-too-large",
+	http.StatusUnsupportedMediaType:  "unsupported-media-type",
+	http.StatusUnprocessableEntity:   "unprocessable-entity",
+	http.StatusServiceUnavailable:    "service-unavailable",
+	http.StatusInternalServerError:   "internal-error",
+}
+
+// writeProblem responds with a problem of the given status.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string, fields ...userservice.FieldError) {
+	sendProblem(w, newProblem(r, status, detail, fields...))
+}
+
+// writeError responds with the problem matching err.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	sendProblem(w, errorProblem(r, err))
+}
+
+func sendProblem(w http.ResponseWriter, p Problem) {
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+func newProblem(r *http.Request, status int, detail string, fields ...userservice.FieldError) Problem {
+	p := Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.RequestURI(),
+		Errors:   fields,
+	}
+	if t, ok := problemTypes[status]; ok {
+		p.Type = problemTypeBase + t
+	}
+	return p
+}
+
+// errorProblem returns the problem matching err. Errors of unknown kinds are
+// logged and reported without their message, which may come from the
+// database driver.
+func errorProblem(r *http.Request, err error) Problem {
+	var invalid *userservice.ValidationError
+	var invalidFields validator.ValidationErrors
+	switch {
+	case errors.As(err, &invalid):
+		return newProblem(r, http.StatusBadRequest, "The request has invalid fields.", invalid.Fields...)
+	case errors.As(err, &invalidFields):
+		return newProblem(r, http.StatusBadRequest, "The request has invalid fields.", fieldErrors(invalidFields)...)
+	case errors.Is(err, userservice.ErrValidation):
+		return newProblem(r, http.StatusBadRequest, err.Error())
+	case errors.Is(err, userservice.ErrNotFound), errors.Is(err, webhook.ErrNotFound):
+		return newProblem(r, http.StatusNotFound, err.Error())
+	case errors.Is(err, userservice.ErrVersionMismatch), errors.Is(err, errPreconditionFailed):
+		return newProblem(r, http.StatusPreconditionFailed, err.Error())
+	case errors.Is(err, errPreconditionRequired):
+		return newProblem(r, http.StatusPreconditionRequired, err.Error())
+	case errors.Is(err, userservice.ErrConflict), errors.Is(err, idempotency.ErrInFlight):
+		return newProblem(r, http.StatusConflict, err.Error())
+	case errors.Is(err, idempotency.ErrKeyReused):
+		return newProblem(r, http.StatusUnprocessableEntity, err.Error())
+	default:
+		log.Printf("%s %s failed: %v\n", r.Method, r.URL.Path, err)
+		return newProblem(r, http.StatusInternalServerError, "The server could not handle the request.")
+	}
+}
+
+// writeJSON encodes v as the response body.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(append(body, '\n'))
+}
+
+// fieldErrors turns validator errors into messages a client can show.
+func fieldErrors(errs validator.ValidationErrors) []userservice.FieldError {
+	fields := make([]userservice.FieldError, len(errs))
+	for i, e := range errs {
+		fields[i] = userservice.FieldError{Field: e.Field(), Message: fieldMessage(e)}
+	}
+	return fields
+}
+
+func fieldMessage(e validator.FieldError) string {
+	switch e.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "url":
+		return "must be a valid URL"
+	case "min":
+		return fmt.Sprintf("must %s at least %s", boundVerb(e.Kind()), e.Param()+boundUnit(e.Kind()))
+	case "max":
+		return fmt.Sprintf("must %s at most %s", boundVerb(e.Kind()), e.Param()+boundUnit(e.Kind()))
+	case "startswith":
+		return fmt.Sprintf("must start with %q", e.Param())
+	case "oneof":
+		return "must be one of " + e.Param()
+	default:
+		return fmt.Sprintf("failed the %s check", e.Tag())
+	}
+}
+
+// boundVerb and boundUnit phrase min and max for strings, collections and
+// numbers.
+func boundVerb(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return "have"
+	default:
+		return "be"
+	}
+}
+
+func boundUnit(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return " items"
+	default:
+		return ""
+	}
+}
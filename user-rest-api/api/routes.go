@@ -4,12 +4,31 @@ import "github.com/go-chi/chi/v5"
 
 func Routes(handler *Handler) *chi.Mux {
 	r := chi.NewRouter()
+	r.Use(Actor)
 
-	r.Post("/", handler.CreateUser)
+	r.With(Idempotent(handler.Idempotency)).Post("/", handler.CreateUser)
 	r.Get("/", handler.ListUsers)
+	r.Get("/search", handler.SearchUsers)
 	r.Get("/{id}", handler.GetUser)
+	r.Put("/{id}", handler.ReplaceUser)
 	r.Patch("/{id}", handler.UpdateUser)
 	r.Delete("/{id}", handler.DeleteUser)
+	r.Post("/{id}/restore", handler.RestoreUser)
+	r.Post("/{id}/activate", handler.ActivateUser)
+	r.Post("/{id}/suspend", handler.SuspendUser)
+	r.Post("/{id}/close", handler.CloseUser)
+	r.Get("/{id}/audit", handler.ListUserAudit)
+	r.Get("/{id}/personal-data", handler.ExportPersonalData)
+	r.Post("/{id}/erase", handler.EraseUser)
 
 	return r
 }
+
+// BulkRoutes registers the bulk operations on users on r, which mounts Routes
+// at /users. They are custom methods of the collection, at /users:batch,
+// /users:import and /users:export.
+func BulkRoutes(r chi.Router, handler *Handler) {
+	r.With(Actor, Idempotent(handler.Idempotency)).Post("/users:batch", handler.Batch)
+	r.With(Actor).Post("/users:import", handler.ImportUsers)
+	r.With(Actor).Get("/users:export", handler.ExportUsers)
+}
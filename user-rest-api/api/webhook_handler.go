@@ -0,0 +1,155 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/webhook"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/userservice"
+)
+
+type WebhookHandler struct {
+	Service webhook.Service
+}
+
+func NewWebhookHandler(service webhook.Service) *WebhookHandler {
+	return &WebhookHandler{Service: service}
+}
+
+func WebhookRoutes(handler *WebhookHandler) *chi.Mux {
+	r := chi.NewRouter()
+
+	r.Post("/", handler.CreateSubscription)
+	r.Get("/", handler.ListSubscriptions)
+	r.Get("/deliveries/dead", handler.ListDeadDeliveries)
+	r.Post("/deliveries/{id}/retry", handler.RetryDelivery)
+	r.Get("/{id}", handler.GetSubscription)
+	r.Delete("/{id}", handler.DeleteSubscription)
+
+	return r
+}
+
+func (h *WebhookHandler) CreateSubscription(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL        string   `json:"url" validate:"required,url,startswith=http"`
+		EventTypes []string `json:"event_types" validate:"required,min=1,dive,required"`
+		Secret     string   `json:"secret" validate:"omitempty,min=16"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "The body is not valid JSON.")
+		return
+	}
+	if err := internal.Validate.Struct(req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	for _, t := range req.EventTypes {
+		if !slices.Contains(webhook.EventTypes, t) {
+			writeError(w, r, &userservice.ValidationError{Fields: []userservice.FieldError{{
+				Field:   "event_types",
+				Message: "has unknown event type " + t + ", expected one of " + strings.Join(webhook.EventTypes, ", "),
+			}}})
+			return
+		}
+	}
+
+	sub, err := h.Service.CreateSubscription(r.Context(), webhook.CreateSubscriptionParams{
+		URL:        req.URL,
+		EventTypes: req.EventTypes,
+		Secret:     req.Secret,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusCreated, sub)
+}
+
+func (h *WebhookHandler) GetSubscription(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "The webhook ID is not a UUID.")
+		return
+	}
+	sub, err := h.Service.GetSubscription(r.Context(), id)
+	if errors.Is(err, webhook.ErrNotFound) {
+		writeProblem(w, r, http.StatusNotFound, "Webhook not found.")
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, sub)
+}
+
+func (h *WebhookHandler) ListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	subs, err := h.Service.ListSubscriptions(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, subs)
+}
+
+func (h *WebhookHandler) DeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "The webhook ID is not a UUID.")
+		return
+	}
+	err = h.Service.DeleteSubscription(r.Context(), id)
+	if errors.Is(err, webhook.ErrNotFound) {
+		writeProblem(w, r, http.StatusNotFound, "Webhook not found.")
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListDeadDeliveries lists deliveries that ran out of attempts, newest first.
+func (h *WebhookHandler) ListDeadDeliveries(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 1000 {
+			writeError(w, r, &userservice.ValidationError{Fields: []userservice.FieldError{{Field: "limit", Message: "must be between 1 and 1000"}}})
+			return
+		}
+		limit = n
+	}
+	deliveries, err := h.Service.ListDeadDeliveries(r.Context(), int32(limit))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, deliveries)
+}
+
+func (h *WebhookHandler) RetryDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "The delivery ID is not a UUID.")
+		return
+	}
+	err = h.Service.RetryDelivery(r.Context(), id)
+	if errors.Is(err, webhook.ErrNotFound) {
+		writeProblem(w, r, http.StatusNotFound, "Dead delivery not found.")
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,108 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/api"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/webhook"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockWebhookService struct {
+	mock.Mock
+}
+
+func (m *mockWebhookService) CreateSubscription(ctx context.Context, arg webhook.CreateSubscriptionParams) (webhook.Subscription, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(webhook.Subscription), args.Error(1)
+}
+
+func (m *mockWebhookService) GetSubscription(ctx context.Context, id uuid.UUID) (webhook.Subscription, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(webhook.Subscription), args.Error(1)
+}
+
+func (m *mockWebhookService) ListSubscriptions(ctx context.Context) ([]webhook.Subscription, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]webhook.Subscription), args.Error(1)
+}
+
+func (m *mockWebhookService) DeleteSubscription(ctx context.Context, id uuid.UUID) error {
+	return m.Called(ctx, id).Error(0)
+}
+
+func (m *mockWebhookService) ListDeadDeliveries(ctx context.Context, limit int32) ([]webhook.Delivery, error) {
+	args := m.Called(ctx, limit)
+	return args.Get(0).([]webhook.Delivery), args.Error(1)
+}
+
+func (m *mockWebhookService) RetryDelivery(ctx context.Context, id uuid.UUID) error {
+	return m.Called(ctx, id).Error(0)
+}
+
+func TestCreateWebhook_Success(t *testing.T) {
+	mockService := new(mockWebhookService)
+	router := api.WebhookRoutes(api.NewWebhookHandler(mockService))
+	internal.InitValidator()
+
+	body := `{"url":"https://partner.example.com/hook","event_types":["` + events.TypeUserCreated + `","` + events.TypeTradeExecuted + `"]}`
+	mockService.On("CreateSubscription", mock.Anything, webhook.CreateSubscriptionParams{
+		URL:        "https://partner.example.com/hook",
+		EventTypes: []string{events.TypeUserCreated, events.TypeTradeExecuted},
+	}).Return(webhook.Subscription{ID: uuid.New(), Secret: "generated"}, nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body)))
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"secret":"generated"`)
+	mockService.AssertExpectations(t)
+}
+
+func TestCreateWebhook_RejectsUnknownEventType(t *testing.T) {
+	mockService := new(mockWebhookService)
+	router := api.WebhookRoutes(api.NewWebhookHandler(mockService))
+	internal.InitValidator()
+
+	body := `{"url":"https://partner.example.com/hook","event_types":["user.created"]}`
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body)))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockService.AssertNotCalled(t, "CreateSubscription", mock.Anything, mock.Anything)
+}
+
+func TestListDeadDeliveries(t *testing.T) {
+	mockService := new(mockWebhookService)
+	router := api.WebhookRoutes(api.NewWebhookHandler(mockService))
+
+	mockService.On("ListDeadDeliveries", mock.Anything, int32(5)).Return([]webhook.Delivery{{ID: uuid.New(), Status: "dead"}}, nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/deliveries/dead?limit=5", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"dead"`)
+	mockService.AssertExpectations(t)
+}
+
+func TestRetryDelivery_NotFound(t *testing.T) {
+	mockService := new(mockWebhookService)
+	router := api.WebhookRoutes(api.NewWebhookHandler(mockService))
+
+	id := uuid.New()
+	mockService.On("RetryDelivery", mock.Anything, id).Return(webhook.ErrNotFound)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/deliveries/"+id.String()+"/retry", nil))
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -4,16 +4,435 @@ VALUES ($1, $2, $3, $4, $5, $6)
     RETURNING *;
 
 -- name: GetUser :one
+SELECT * FROM users WHERE user_id = $1 AND deleted_at IS NULL;
+
+-- name: GetUserIncludingDeleted :one
 SELECT * FROM users WHERE user_id = $1;
 
--- name: ListUsers :many
-SELECT * FROM users;
+-- name: GetUserForUpdate :one
+-- Locks the user until the end of the transaction. Deleted users are
+-- returned too, so that they can be restored.
+SELECT * FROM users WHERE user_id = $1 FOR UPDATE;
+
+-- The ListUsersBy queries return the page of matching users after
+-- (after_key, after_id) in the order of one column. There is one query per
+-- column and direction, so that the (column, user_id) index of the column
+-- serves both the keyset condition and the order. after_key is the value of
+-- the column for the last user of the previous page.
+
+-- name: ListUsersByCreatedAt :many
+SELECT * FROM users
+WHERE (@include_deleted::bool OR deleted_at IS NULL)
+  AND (sqlc.narg(status)::text IS NULL OR status = sqlc.narg(status))
+  AND (sqlc.narg(min_age)::int IS NULL OR age >= sqlc.narg(min_age))
+  AND (sqlc.narg(max_age)::int IS NULL OR age <= sqlc.narg(max_age))
+  AND (sqlc.narg(email_prefix)::text IS NULL OR lower(email) LIKE sqlc.narg(email_prefix) || '%')
+  AND (sqlc.narg(name_prefix)::text IS NULL
+       OR lower(first_name) LIKE sqlc.narg(name_prefix) || '%'
+       OR lower(last_name) LIKE sqlc.narg(name_prefix) || '%')
+  AND (sqlc.narg(after_id)::uuid IS NULL
+       OR (created_at, user_id) > (sqlc.narg(after_key)::timestamptz, sqlc.narg(after_id)::uuid))
+ORDER BY created_at, user_id
+LIMIT @page_size;
+
+-- name: ListUsersByCreatedAtDesc :many
+SELECT * FROM users
+WHERE (@include_deleted::bool OR deleted_at IS NULL)
+  AND (sqlc.narg(status)::text IS NULL OR status = sqlc.narg(status))
+  AND (sqlc.narg(min_age)::int IS NULL OR age >= sqlc.narg(min_age))
+  AND (sqlc.narg(max_age)::int IS NULL OR age <= sqlc.narg(max_age))
+  AND (sqlc.narg(email_prefix)::text IS NULL OR lower(email) LIKE sqlc.narg(email_prefix) || '%')
+  AND (sqlc.narg(name_prefix)::text IS NULL
+       OR lower(first_name) LIKE sqlc.narg(name_prefix) || '%'
+       OR lower(last_name) LIKE sqlc.narg(name_prefix) || '%')
+  AND (sqlc.narg(after_id)::uuid IS NULL
+       OR (created_at, user_id) < (sqlc.narg(after_key)::timestamptz, sqlc.narg(after_id)::uuid))
+ORDER BY created_at DESC, user_id DESC
+LIMIT @page_size;
+
+-- name: ListUsersByEmail :many
+SELECT * FROM users
+WHERE (@include_deleted::bool OR deleted_at IS NULL)
+  AND (sqlc.narg(status)::text IS NULL OR status = sqlc.narg(status))
+  AND (sqlc.narg(min_age)::int IS NULL OR age >= sqlc.narg(min_age))
+  AND (sqlc.narg(max_age)::int IS NULL OR age <= sqlc.narg(max_age))
+  AND (sqlc.narg(email_prefix)::text IS NULL OR lower(email) LIKE sqlc.narg(email_prefix) || '%')
+  AND (sqlc.narg(name_prefix)::text IS NULL
+       OR lower(first_name) LIKE sqlc.narg(name_prefix) || '%'
+       OR lower(last_name) LIKE sqlc.narg(name_prefix) || '%')
+  AND (sqlc.narg(after_id)::uuid IS NULL
+       OR (lower(email), user_id) > (lower(sqlc.narg(after_key)::text), sqlc.narg(after_id)::uuid))
+ORDER BY lower(email), user_id
+LIMIT @page_size;
+
+-- name: ListUsersByEmailDesc :many
+SELECT * FROM users
+WHERE (@include_deleted::bool OR deleted_at IS NULL)
+  AND (sqlc.narg(status)::text IS NULL OR status = sqlc.narg(status))
+  AND (sqlc.narg(min_age)::int IS NULL OR age >= sqlc.narg(min_age))
+  AND (sqlc.narg(max_age)::int IS NULL OR age <= sqlc.narg(max_age))
+  AND (sqlc.narg(email_prefix)::text IS NULL OR lower(email) LIKE sqlc.narg(email_prefix) || '%')
+  AND (sqlc.narg(name_prefix)::text IS NULL
+       OR lower(first_name) LIKE sqlc.narg(name_prefix) || '%'
+       OR lower(last_name) LIKE sqlc.narg(name_prefix) || '%')
+  AND (sqlc.narg(after_id)::uuid IS NULL
+       OR (lower(email), user_id) < (lower(sqlc.narg(after_key)::text), sqlc.narg(after_id)::uuid))
+ORDER BY lower(email) DESC, user_id DESC
+LIMIT @page_size;
+
+-- name: ListUsersByFirstName :many
+SELECT * FROM users
+WHERE (@include_deleted::bool OR deleted_at IS NULL)
+  AND (sqlc.narg(status)::text IS NULL OR status = sqlc.narg(status))
+  AND (sqlc.narg(min_age)::int IS NULL OR age >= sqlc.narg(min_age))
+  AND (sqlc.narg(max_age)::int IS NULL OR age <= sqlc.narg(max_age))
+  AND (sqlc.narg(email_prefix)::text IS NULL OR lower(email) LIKE sqlc.narg(email_prefix) || '%')
+  AND (sqlc.narg(name_prefix)::text IS NULL
+       OR lower(first_name) LIKE sqlc.narg(name_prefix) || '%'
+       OR lower(last_name) LIKE sqlc.narg(name_prefix) || '%')
+  AND (sqlc.narg(after_id)::uuid IS NULL
+       OR (lower(first_name), user_id) > (lower(sqlc.narg(after_key)::text), sqlc.narg(after_id)::uuid))
+ORDER BY lower(first_name), user_id
+LIMIT @page_size;
+
+-- name: ListUsersByFirstNameDesc :many
+SELECT * FROM users
+WHERE (@include_deleted::bool OR deleted_at IS NULL)
+  AND (sqlc.narg(status)::text IS NULL OR status = sqlc.narg(status))
+  AND (sqlc.narg(min_age)::int IS NULL OR age >= sqlc.narg(min_age))
+  AND (sqlc.narg(max_age)::int IS NULL OR age <= sqlc.narg(max_age))
+  AND (sqlc.narg(email_prefix)::text IS NULL OR lower(email) LIKE sqlc.narg(email_prefix) || '%')
+  AND (sqlc.narg(name_prefix)::text IS NULL
+       OR lower(first_name) LIKE sqlc.narg(name_prefix) || '%'
+       OR lower(last_name) LIKE sqlc.narg(name_prefix) || '%')
+  AND (sqlc.narg(after_id)::uuid IS NULL
+       OR (lower(first_name), user_id) < (lower(sqlc.narg(after_key)::text), sqlc.narg(after_id)::uuid))
+ORDER BY lower(first_name) DESC, user_id DESC
+LIMIT @page_size;
+
+-- name: ListUsersByLastName :many
+SELECT * FROM users
+WHERE (@include_deleted::bool OR deleted_at IS NULL)
+  AND (sqlc.narg(status)::text IS NULL OR status = sqlc.narg(status))
+  AND (sqlc.narg(min_age)::int IS NULL OR age >= sqlc.narg(min_age))
+  AND (sqlc.narg(max_age)::int IS NULL OR age <= sqlc.narg(max_age))
+  AND (sqlc.narg(email_prefix)::text IS NULL OR lower(email) LIKE sqlc.narg(email_prefix) || '%')
+  AND (sqlc.narg(name_prefix)::text IS NULL
+       OR lower(first_name) LIKE sqlc.narg(name_prefix) || '%'
+       OR lower(last_name) LIKE sqlc.narg(name_prefix) || '%')
+  AND (sqlc.narg(after_id)::uuid IS NULL
+       OR (lower(last_name), user_id) > (lower(sqlc.narg(after_key)::text), sqlc.narg(after_id)::uuid))
+ORDER BY lower(last_name), user_id
+LIMIT @page_size;
+
+-- name: ListUsersByLastNameDesc :many
+SELECT * FROM users
+WHERE (@include_deleted::bool OR deleted_at IS NULL)
+  AND (sqlc.narg(status)::text IS NULL OR status = sqlc.narg(status))
+  AND (sqlc.narg(min_age)::int IS NULL OR age >= sqlc.narg(min_age))
+  AND (sqlc.narg(max_age)::int IS NULL OR age <= sqlc.narg(max_age))
+  AND (sqlc.narg(email_prefix)::text IS NULL OR lower(email) LIKE sqlc.narg(email_prefix) || '%')
+  AND (sqlc.narg(name_prefix)::text IS NULL
+       OR lower(first_name) LIKE sqlc.narg(name_prefix) || '%'
+       OR lower(last_name) LIKE sqlc.narg(name_prefix) || '%')
+  AND (sqlc.narg(after_id)::uuid IS NULL
+       OR (lower(last_name), user_id) < (lower(sqlc.narg(after_key)::text), sqlc.narg(after_id)::uuid))
+ORDER BY lower(last_name) DESC, user_id DESC
+LIMIT @page_size;
+
+-- name: ListUsersByAge :many
+SELECT * FROM users
+WHERE (@include_deleted::bool OR deleted_at IS NULL)
+  AND (sqlc.narg(status)::text IS NULL OR status = sqlc.narg(status))
+  AND (sqlc.narg(min_age)::int IS NULL OR age >= sqlc.narg(min_age))
+  AND (sqlc.narg(max_age)::int IS NULL OR age <= sqlc.narg(max_age))
+  AND (sqlc.narg(email_prefix)::text IS NULL OR lower(email) LIKE sqlc.narg(email_prefix) || '%')
+  AND (sqlc.narg(name_prefix)::text IS NULL
+       OR lower(first_name) LIKE sqlc.narg(name_prefix) || '%'
+       OR lower(last_name) LIKE sqlc.narg(name_prefix) || '%')
+  AND (sqlc.narg(after_id)::uuid IS NULL
+       OR (COALESCE(age, 0), user_id) > (sqlc.narg(after_key)::int, sqlc.narg(after_id)::uuid))
+ORDER BY COALESCE(age, 0), user_id
+LIMIT @page_size;
+
+-- name: ListUsersByAgeDesc :many
+SELECT * FROM users
+WHERE (@include_deleted::bool OR deleted_at IS NULL)
+  AND (sqlc.narg(status)::text IS NULL OR status = sqlc.narg(status))
+  AND (sqlc.narg(min_age)::int IS NULL OR age >= sqlc.narg(min_age))
+  AND (sqlc.narg(max_age)::int IS NULL OR age <= sqlc.narg(max_age))
+  AND (sqlc.narg(email_prefix)::text IS NULL OR lower(email) LIKE sqlc.narg(email_prefix) || '%')
+  AND (sqlc.narg(name_prefix)::text IS NULL
+       OR lower(first_name) LIKE sqlc.narg(name_prefix) || '%'
+       OR lower(last_name) LIKE sqlc.narg(name_prefix) || '%')
+  AND (sqlc.narg(after_id)::uuid IS NULL
+       OR (COALESCE(age, 0), user_id) < (sqlc.narg(after_key)::int, sqlc.narg(after_id)::uuid))
+ORDER BY COALESCE(age, 0) DESC, user_id DESC
+LIMIT @page_size;
+
+-- name: SearchUsers :many
+-- Matches words by prefix with full-text search, names and emails by trigram
+-- similarity, which tolerates typos, and phone numbers by substring.
+SELECT sqlc.embed(users),
+    (ts_rank(to_tsvector('simple', users.first_name || ' ' || users.last_name || ' ' || users.email || ' ' || COALESCE(users.phone, '')),
+             to_tsquery('simple', @tsquery::text))
+     + similarity(users.first_name || ' ' || users.last_name, @term::text)
+     + similarity(users.email, @term::text))::float8 AS rank
+FROM users
+WHERE users.deleted_at IS NULL
+  AND (to_tsvector('simple', users.first_name || ' ' || users.last_name || ' ' || users.email || ' ' || COALESCE(users.phone, ''))
+        @@ to_tsquery('simple', @tsquery::text)
+   OR (users.first_name || ' ' || users.last_name) % @term::text
+   OR users.email % @term::text
+   OR (sqlc.narg(phone)::text IS NOT NULL AND users.phone LIKE '%' || sqlc.narg(phone) || '%'))
+ORDER BY rank DESC, users.user_id
+LIMIT @result_limit;
+
+-- name: CountUsers :one
+-- Counts the users matching the filters of the ListUsersBy queries.
+SELECT count(*) FROM users
+WHERE (@include_deleted::bool OR deleted_at IS NULL)
+  AND (sqlc.narg(status)::text IS NULL OR status = sqlc.narg(status))
+  AND (sqlc.narg(min_age)::int IS NULL OR age >= sqlc.narg(min_age))
+  AND (sqlc.narg(max_age)::int IS NULL OR age <= sqlc.narg(max_age))
+  AND (sqlc.narg(email_prefix)::text IS NULL OR lower(email) LIKE sqlc.narg(email_prefix) || '%')
+  AND (sqlc.narg(name_prefix)::text IS NULL
+       OR lower(first_name) LIKE sqlc.narg(name_prefix) || '%'
+       OR lower(last_name) LIKE sqlc.narg(name_prefix) || '%');
 
 -- name: UpdateUser :one
+-- Applies a partial update: NULL leaves a required column unchanged, and the
+-- set_ flags say whether a nullable column is written, possibly with NULL.
+UPDATE users
+SET first_name = COALESCE(sqlc.narg(first_name), first_name),
+    last_name = COALESCE(sqlc.narg(last_name), last_name),
+    email = COALESCE(sqlc.narg(email), email),
+    phone = CASE WHEN @set_phone::bool THEN sqlc.narg(phone) ELSE phone END,
+    age = CASE WHEN @set_age::bool THEN sqlc.narg(age) ELSE age END,
+    version = version + 1,
+    updated_at = now()
+WHERE user_id = @user_id
+    RETURNING *;
+
+-- name: UpdateUserStatus :one
+UPDATE users
+SET status = $2, version = version + 1, updated_at = now()
+WHERE user_id = $1
+    RETURNING *;
+
+-- name: SoftDeleteUser :one
+UPDATE users
+SET deleted_at = now(), version = version + 1, updated_at = now()
+WHERE user_id = $1
+    RETURNING *;
+
+-- name: RestoreUser :one
+UPDATE users
+SET deleted_at = NULL, version = version + 1, updated_at = now()
+WHERE user_id = $1
+    RETURNING *;
+
+-- name: PurgeDeletedUsers :many
+-- Anonymises the users deleted before deleted_before, audits it and returns
+-- them, so that their personal data can be removed from the rest of the
+-- database too. Purged users count as erased; users purged before they were
+-- also marked erased are returned once more, without a second audit entry.
+-- The rows are kept so that references to them stay valid.
+WITH due AS (
+    SELECT user_id, purged_at IS NULL AS fresh
+    FROM users
+    WHERE deleted_at < @deleted_before::timestamptz AND erased_at IS NULL
+    FOR UPDATE
+), purged AS (
+    UPDATE users
+    SET first_name = '',
+        last_name = '',
+        email = users.user_id::text || '@deleted.invalid',
+        phone = NULL,
+        age = NULL,
+        purged_at = COALESCE(users.purged_at, now()),
+        erased_at = now()
+    FROM due
+    WHERE users.user_id = due.user_id
+    RETURNING users.user_id, due.fresh
+), audited AS (
+    INSERT INTO user_audit (user_id, action, source, changed)
+    SELECT user_id, 'purged', @source::text, '{first_name,last_name,email,phone,age}'
+    FROM purged
+    WHERE fresh
+)
+SELECT user_id FROM purged;
+
+-- name: EraseUser :one
+-- Pseudonymises a user and closes it. The row is kept so that references to
+-- it stay valid.
 UPDATE users
-SET first_name = $2, last_name = $3, email = $4, phone = $5, age = $6, status = $7
+SET first_name = '',
+    last_name = '',
+    email = user_id::text || '@erased.invalid',
+    phone = NULL,
+    age = NULL,
+    status = 'closed',
+    erased_at = now(),
+    version = version + 1,
+    updated_at = now()
 WHERE user_id = $1
     RETURNING *;
 
--- name: DeleteUser :exec
-DELETE FROM users WHERE user_id = $1;
+-- name: ListUsersWithEventsNotErased :many
+-- Returns the erased users whose events are not erased outside the database
+-- yet, oldest erasure first.
+SELECT user_id FROM users
+WHERE erased_at IS NOT NULL AND events_erased_at IS NULL
+ORDER BY erased_at
+LIMIT $1;
+
+-- name: MarkEventsErased :exec
+UPDATE users SET events_erased_at = now() WHERE user_id = $1;
+
+-- name: ScrubUserData :exec
+-- Removes the personal data of a user from its audit entries and from the
+-- events about it in the outbox and in webhook deliveries. Events are found
+-- by their subject, without an index, which is fine for the rare erasures.
+-- The stored responses of idempotent requests that mention the user are
+-- deleted, so a retry after the erasure is handled as a new request.
+WITH audit AS (
+    UPDATE user_audit
+    SET before = strip_user_pii(before), after = strip_user_pii(after)
+    WHERE user_id = @user_id
+), events AS (
+    UPDATE outbox
+    SET payload = strip_event_pii(payload)
+    WHERE payload->>'subject' = (@user_id::uuid)::text
+), responses AS (
+    DELETE FROM idempotency_keys
+    WHERE position(convert_to((@user_id::uuid)::text, 'UTF8') IN body) > 0
+)
+UPDATE webhook_deliveries
+SET payload = strip_event_pii(payload)
+WHERE payload->>'subject' = (@user_id::uuid)::text;
+
+-- name: InsertUserAudit :exec
+INSERT INTO user_audit (user_id, action, actor, reason, source, changed, before, after)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8);
+
+-- name: ListUserAudit :many
+-- Returns the audit entries of a user newest first, starting before
+-- before_id if it is set.
+SELECT * FROM user_audit
+WHERE user_id = @user_id
+  AND (sqlc.narg(before_id)::bigint IS NULL OR id < sqlc.narg(before_id))
+ORDER BY id DESC
+LIMIT @page_size;
+
+-- name: InsertOutboxEvent :one
+INSERT INTO outbox (subject, payload)
+VALUES ($1, $2)
+    RETURNING *;
+
+-- name: ClaimOutboxEvents :many
+-- Locks the oldest unpublished events; concurrent relays skip locked rows.
+SELECT * FROM outbox
+WHERE published_at IS NULL
+ORDER BY created_at, id
+LIMIT $1
+FOR UPDATE SKIP LOCKED;
+
+-- name: MarkOutboxPublished :exec
+UPDATE outbox SET published_at = now(), attempts = attempts + 1, last_error = NULL
+WHERE id = $1;
+
+-- name: MarkOutboxFailed :exec
+UPDATE outbox SET attempts = attempts + 1, last_error = $2
+WHERE id = $1;
+
+-- name: CreateWebhookSubscription :one
+INSERT INTO webhook_subscriptions (url, event_types, secret)
+VALUES ($1, $2, $3)
+    RETURNING *;
+
+-- name: GetWebhookSubscription :one
+SELECT * FROM webhook_subscriptions WHERE id = $1;
+
+-- name: ListWebhookSubscriptions :many
+SELECT * FROM webhook_subscriptions ORDER BY created_at, id;
+
+-- name: DeleteWebhookSubscription :execrows
+DELETE FROM webhook_subscriptions WHERE id = $1;
+
+-- name: EnqueueWebhookDeliveries :execrows
+-- Queues an event for every subscription to its type. Redelivered events are
+-- ignored.
+INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload)
+SELECT s.id, @event_id::text, @event_type::text, @payload::jsonb
+FROM webhook_subscriptions s
+WHERE @event_type::text = ANY (s.event_types)
+ON CONFLICT (subscription_id, event_id) DO NOTHING;
+
+-- name: ClaimWebhookDeliveries :many
+-- Leases due deliveries, along with their subscription, until lease_until by
+-- moving their next attempt to then, so that they are delivered outside any
+-- transaction without other workers taking them. Concurrent workers skip
+-- locked rows. A delivery whose worker dies is due again once its lease ends.
+UPDATE webhook_deliveries d
+SET next_attempt_at = @lease_until
+FROM webhook_subscriptions s
+WHERE s.id = d.subscription_id AND d.id IN (
+    SELECT id FROM webhook_deliveries
+    WHERE status = 'pending' AND next_attempt_at <= now()
+    ORDER BY next_attempt_at, id
+    LIMIT @batch_size
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING d.*, s.url, s.secret;
+
+-- name: MarkWebhookDelivered :exec
+UPDATE webhook_deliveries
+SET status = 'delivered', attempts = attempts + 1, delivered_at = now(), last_error = NULL
+WHERE id = $1;
+
+-- name: MarkWebhookFailed :exec
+-- Schedules a retry, or moves the delivery to the dead letters when
+-- next_attempt_at is NULL.
+UPDATE webhook_deliveries
+SET attempts = attempts + 1,
+    last_error = @last_error,
+    status = CASE WHEN sqlc.narg(next_attempt_at)::timestamptz IS NULL THEN 'dead' ELSE 'pending' END,
+    next_attempt_at = COALESCE(sqlc.narg(next_attempt_at)::timestamptz, next_attempt_at)
+WHERE id = @id;
+
+-- name: ListDeadWebhookDeliveries :many
+SELECT * FROM webhook_deliveries
+WHERE status = 'dead'
+ORDER BY created_at DESC, id
+LIMIT $1;
+
+-- name: RetryWebhookDelivery :execrows
+-- Requeues a dead delivery for immediate delivery.
+UPDATE webhook_deliveries
+SET status = 'pending', attempts = 0, next_attempt_at = now()
+WHERE id = $1 AND status = 'dead';
+
+-- name: ClaimIdempotencyKey :one
+-- Records a new key, or takes over one that expired before expired_before.
+-- Returns no row if the key is in use.
+INSERT INTO idempotency_keys (key, request_hash)
+VALUES (@key, @request_hash)
+ON CONFLICT (key) DO UPDATE
+SET request_hash = EXCLUDED.request_hash, status_code = NULL, headers = '{}', body = NULL, created_at = now()
+WHERE idempotency_keys.created_at < @expired_before
+    RETURNING *;
+
+-- name: GetIdempotencyKey :one
+SELECT * FROM idempotency_keys WHERE key = $1;
+
+-- name: SaveIdempotentResponse :exec
+UPDATE idempotency_keys
+SET status_code = $2, headers = $3, body = $4
+WHERE key = $1;
+
+-- name: DeleteIdempotencyKey :exec
+DELETE FROM idempotency_keys WHERE key = $1;
+
+-- name: DeleteExpiredIdempotencyKeys :execrows
+DELETE FROM idempotency_keys WHERE created_at < $1;
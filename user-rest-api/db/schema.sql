@@ -1,9 +1,145 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
 CREATE TABLE users (
     user_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     first_name VARCHAR(50) NOT NULL,
     last_name VARCHAR(50) NOT NULL,
-    email VARCHAR(100) NOT NULL UNIQUE,
+    email VARCHAR(100) NOT NULL,
     phone VARCHAR(15),
     age INT CHECK (age > 0),
-    status VARCHAR(10) DEFAULT 'Active'
-);
\ No newline at end of file
+    -- status changes only along the transitions allowed by userservice.
+    status VARCHAR(10) NOT NULL DEFAULT 'active' CHECK (status IN ('pending', 'active', 'suspended', 'closed')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    -- version is incremented by every update and is the ETag of the user.
+    version INT NOT NULL DEFAULT 1,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    -- deleted_at is set when the user is deleted. Deleted users are hidden
+    -- and can be restored until they are purged, which anonymises them and
+    -- sets purged_at.
+    deleted_at TIMESTAMPTZ,
+    purged_at TIMESTAMPTZ,
+    -- erased_at is set when the personal data of the user is erased, on
+    -- request or when it is purged. The row is kept, pseudonymised, for the
+    -- records that refer to it.
+    erased_at TIMESTAMPTZ,
+    -- events_erased_at is set once the events about an erased user are also
+    -- erased outside the database, which is retried until it succeeds.
+    events_erased_at TIMESTAMPTZ
+);
+
+-- Emails are unique among users that are not deleted.
+CREATE UNIQUE INDEX users_email_key ON users (email) WHERE deleted_at IS NULL;
+CREATE INDEX users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL AND erased_at IS NULL;
+CREATE INDEX users_events_not_erased_idx ON users (erased_at) WHERE erased_at IS NOT NULL AND events_erased_at IS NULL;
+
+-- Sort indexes. The expressions must match the ListUsersBy queries exactly.
+CREATE INDEX users_created_at_idx ON users (created_at, user_id);
+CREATE INDEX users_email_sort_idx ON users (lower(email), user_id);
+CREATE INDEX users_first_name_sort_idx ON users (lower(first_name), user_id);
+CREATE INDEX users_last_name_sort_idx ON users (lower(last_name), user_id);
+CREATE INDEX users_age_sort_idx ON users (COALESCE(age, 0), user_id);
+CREATE INDEX users_email_prefix_idx ON users (lower(email) text_pattern_ops);
+
+-- Search indexes. The expressions must match SearchUsers exactly.
+CREATE INDEX users_search_idx ON users USING GIN (
+    to_tsvector('simple', first_name || ' ' || last_name || ' ' || email || ' ' || COALESCE(phone, ''))
+);
+CREATE INDEX users_name_trgm_idx ON users USING GIN ((first_name || ' ' || last_name) gin_trgm_ops);
+CREATE INDEX users_email_trgm_idx ON users USING GIN (email gin_trgm_ops);
+CREATE INDEX users_phone_trgm_idx ON users USING GIN (phone gin_trgm_ops);
+
+-- user_audit records every change to a user: who made it, through which
+-- service, and the user before and after. It is append-only and outlives the
+-- user. source is the CloudEvents source of the service, actor is NULL when
+-- unknown and id orders the entries of a user.
+CREATE TABLE user_audit (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id UUID NOT NULL,
+    action VARCHAR(10) NOT NULL CHECK (action IN ('created', 'updated', 'deleted', 'restored', 'purged', 'activated', 'suspended', 'closed', 'erased')),
+    actor TEXT,
+    -- reason is given with status changes.
+    reason TEXT,
+    source TEXT NOT NULL,
+    changed TEXT[] NOT NULL DEFAULT '{}',
+    before JSONB,
+    after JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX user_audit_user_idx ON user_audit (user_id, id);
+
+-- strip_user_pii removes the fields that identify a person from a copy of a
+-- user, as kept in audit entries.
+CREATE FUNCTION strip_user_pii(doc JSONB) RETURNS JSONB
+    LANGUAGE SQL IMMUTABLE
+    AS $$
+    SELECT CASE WHEN jsonb_typeof(doc) = 'object'
+        THEN doc - '{first_name,last_name,email,phone,age}'::text[]
+        ELSE doc END
+    $$;
+
+-- strip_event_pii removes them from the copies of a user in a user change
+-- event. Events without them are returned unchanged.
+CREATE FUNCTION strip_event_pii(event JSONB) RETURNS JSONB
+    LANGUAGE SQL IMMUTABLE
+    AS $$
+    SELECT jsonb_set(
+        jsonb_set(event, '{data,before}', COALESCE(strip_user_pii(event #> '{data,before}'), 'null'), false),
+        '{data,after}', COALESCE(strip_user_pii(event #> '{data,after}'), 'null'), false)
+    $$;
+
+-- outbox holds user change events written in the same transaction as the
+-- change itself; the relay publishes them to NATS and marks them published.
+CREATE TABLE outbox (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    subject VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    published_at TIMESTAMPTZ,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT
+);
+
+CREATE INDEX outbox_unpublished_idx ON outbox (created_at) WHERE published_at IS NULL;
+
+-- webhook_subscriptions are partner callbacks for user and trade events.
+CREATE TABLE webhook_subscriptions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    url TEXT NOT NULL,
+    event_types TEXT[] NOT NULL,
+    secret TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- webhook_deliveries holds one row per event and subscription. Rows are
+-- retried until delivered or, after too many attempts, dead.
+CREATE TABLE webhook_deliveries (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    subscription_id UUID NOT NULL REFERENCES webhook_subscriptions (id) ON DELETE CASCADE,
+    event_id TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(10) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'dead')),
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    delivered_at TIMESTAMPTZ,
+    UNIQUE (subscription_id, event_id)
+);
+
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+
+-- idempotency_keys holds the response to a request made with an
+-- Idempotency-Key header, returned again when the request is retried.
+-- status_code is NULL while the first request is in flight.
+CREATE TABLE idempotency_keys (
+    key TEXT PRIMARY KEY,
+    request_hash TEXT NOT NULL,
+    status_code INT,
+    headers JSONB NOT NULL DEFAULT '{}',
+    body BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idempotency_keys_created_at_idx ON idempotency_keys (created_at);
@@ -3,6 +3,7 @@ package config
 import (
 	"log"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -20,6 +21,31 @@ type Config struct {
 	NATS struct {
 		URL string `yaml:"url"`
 	} `yaml:"nats"`
+
+	Outbox struct {
+		PollInterval time.Duration `yaml:"poll_interval"`
+		BatchSize    int           `yaml:"batch_size"`
+	} `yaml:"outbox"`
+
+	Webhooks struct {
+		PollInterval time.Duration `yaml:"poll_interval"`
+		BatchSize    int           `yaml:"batch_size"`
+		MaxAttempts  int           `yaml:"max_attempts"`
+		Backoff      time.Duration `yaml:"backoff"`
+		Timeout      time.Duration `yaml:"timeout"`
+	} `yaml:"webhooks"`
+
+	Idempotency struct {
+		Retention     time.Duration `yaml:"retention"`
+		PurgeInterval time.Duration `yaml:"purge_interval"`
+	} `yaml:"idempotency"`
+
+	Users struct {
+		// DeletedRetention is how long deleted users can be restored before
+		// they are purged. Zero keeps them forever.
+		DeletedRetention time.Duration `yaml:"deleted_retention"`
+		PurgeInterval    time.Duration `yaml:"purge_interval"`
+	} `yaml:"users"`
 }
 
 var AppConfig Config
@@ -6,16 +6,80 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/sqlc-dev/pqtype"
 )
 
+type IdempotencyKey struct {
+	Key         string
+	RequestHash string
+	StatusCode  sql.NullInt32
+	Headers     json.RawMessage
+	Body        []byte
+	CreatedAt   time.Time
+}
+
+type Outbox struct {
+	ID          uuid.UUID
+	Subject     string
+	Payload     json.RawMessage
+	CreatedAt   time.Time
+	PublishedAt sql.NullTime
+	Attempts    int32
+	LastError   sql.NullString
+}
+
 type User struct {
+	UserID         uuid.UUID
+	FirstName      string
+	LastName       string
+	Email          string
+	Phone          sql.NullString
+	Age            sql.NullInt32
+	Status         string
+	CreatedAt      time.Time
+	Version        int32
+	UpdatedAt      time.Time
+	DeletedAt      sql.NullTime
+	PurgedAt       sql.NullTime
+	ErasedAt       sql.NullTime
+	EventsErasedAt sql.NullTime
+}
+
+type UserAudit struct {
+	ID        int64
 	UserID    uuid.UUID
-	FirstName string
-	LastName  string
-	Email     string
-	Phone     sql.NullString
-	Age       sql.NullInt32
-	Status    sql.NullString
+	Action    string
+	Actor     sql.NullString
+	Reason    sql.NullString
+	Source    string
+	Changed   []string
+	Before    pqtype.NullRawMessage
+	After     pqtype.NullRawMessage
+	CreatedAt time.Time
+}
+
+type WebhookDelivery struct {
+	ID             uuid.UUID
+	SubscriptionID uuid.UUID
+	EventID        string
+	EventType      string
+	Payload        json.RawMessage
+	Status         string
+	Attempts       int32
+	NextAttemptAt  time.Time
+	LastError      sql.NullString
+	CreatedAt      time.Time
+	DeliveredAt    sql.NullTime
+}
+
+type WebhookSubscription struct {
+	ID         uuid.UUID
+	Url        string
+	EventTypes []string
+	Secret     string
+	CreatedAt  time.Time
 }
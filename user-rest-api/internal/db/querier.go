@@ -6,16 +6,98 @@ package db
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 type Querier interface {
+	// Records a new key, or takes over one that expired before expired_before.
+	// Returns no row if the key is in use.
+	ClaimIdempotencyKey(ctx context.Context, arg ClaimIdempotencyKeyParams) (IdempotencyKey, error)
+	// Locks the oldest unpublished events; concurrent relays skip locked rows.
+	ClaimOutboxEvents(ctx context.Context, limit int32) ([]Outbox, error)
+	// Leases due deliveries, along with their subscription, until lease_until by
+	// moving their next attempt to then, so that they are delivered outside any
+	// transaction without other workers taking them. Concurrent workers skip
+	// locked rows. A delivery whose worker dies is due again once its lease ends.
+	ClaimWebhookDeliveries(ctx context.Context, arg ClaimWebhookDeliveriesParams) ([]ClaimWebhookDeliveriesRow, error)
+	// Counts the users matching the filters of the ListUsersBy queries.
+	CountUsers(ctx context.Context, arg CountUsersParams) (int64, error)
 	CreateUser(ctx context.Context, arg CreateUserParams) (User, error)
-	DeleteUser(ctx context.Context, userID uuid.UUID) error
+	CreateWebhookSubscription(ctx context.Context, arg CreateWebhookSubscriptionParams) (WebhookSubscription, error)
+	DeleteExpiredIdempotencyKeys(ctx context.Context, createdAt time.Time) (int64, error)
+	DeleteIdempotencyKey(ctx context.Context, key string) error
+	DeleteWebhookSubscription(ctx context.Context, id uuid.UUID) (int64, error)
+	// Queues an event for every subscription to its type. Redelivered events are
+	// ignored.
+	EnqueueWebhookDeliveries(ctx context.Context, arg EnqueueWebhookDeliveriesParams) (int64, error)
+	// Pseudonymises a user and closes it. The row is kept so that references to
+	// it stay valid.
+	EraseUser(ctx context.Context, userID uuid.UUID) (User, error)
+	GetIdempotencyKey(ctx context.Context, key string) (IdempotencyKey, error)
 	GetUser(ctx context.Context, userID uuid.UUID) (User, error)
-	ListUsers(ctx context.Context) ([]User, error)
+	// Locks the user until the end of the transaction. Deleted users are
+	// returned too, so that they can be restored.
+	GetUserForUpdate(ctx context.Context, userID uuid.UUID) (User, error)
+	GetUserIncludingDeleted(ctx context.Context, userID uuid.UUID) (User, error)
+	GetWebhookSubscription(ctx context.Context, id uuid.UUID) (WebhookSubscription, error)
+	InsertOutboxEvent(ctx context.Context, arg InsertOutboxEventParams) (Outbox, error)
+	InsertUserAudit(ctx context.Context, arg InsertUserAuditParams) error
+	ListDeadWebhookDeliveries(ctx context.Context, limit int32) ([]WebhookDelivery, error)
+	// Returns the audit entries of a user newest first, starting before
+	// before_id if it is set.
+	ListUserAudit(ctx context.Context, arg ListUserAuditParams) ([]UserAudit, error)
+	ListUsersByAge(ctx context.Context, arg ListUsersByAgeParams) ([]User, error)
+	ListUsersByAgeDesc(ctx context.Context, arg ListUsersByAgeDescParams) ([]User, error)
+	// The ListUsersBy queries return the page of matching users after
+	// (after_key, after_id) in the order of one column. There is one query per
+	// column and direction, so that the (column, user_id) index of the column
+	// serves both the keyset condition and the order. after_key is the value of
+	// the column for the last user of the previous page.
+	ListUsersByCreatedAt(ctx context.Context, arg ListUsersByCreatedAtParams) ([]User, error)
+	ListUsersByCreatedAtDesc(ctx context.Context, arg ListUsersByCreatedAtDescParams) ([]User, error)
+	ListUsersByEmail(ctx context.Context, arg ListUsersByEmailParams) ([]User, error)
+	ListUsersByEmailDesc(ctx context.Context, arg ListUsersByEmailDescParams) ([]User, error)
+	ListUsersByFirstName(ctx context.Context, arg ListUsersByFirstNameParams) ([]User, error)
+	ListUsersByFirstNameDesc(ctx context.Context, arg ListUsersByFirstNameDescParams) ([]User, error)
+	ListUsersByLastName(ctx context.Context, arg ListUsersByLastNameParams) ([]User, error)
+	ListUsersByLastNameDesc(ctx context.Context, arg ListUsersByLastNameDescParams) ([]User, error)
+	// Returns the erased users whose events are not erased outside the database
+	// yet, oldest erasure first.
+	ListUsersWithEventsNotErased(ctx context.Context, limit int32) ([]uuid.UUID, error)
+	ListWebhookSubscriptions(ctx context.Context) ([]WebhookSubscription, error)
+	MarkEventsErased(ctx context.Context, userID uuid.UUID) error
+	MarkOutboxFailed(ctx context.Context, arg MarkOutboxFailedParams) error
+	MarkOutboxPublished(ctx context.Context, id uuid.UUID) error
+	MarkWebhookDelivered(ctx context.Context, id uuid.UUID) error
+	// Schedules a retry, or moves the delivery to the dead letters when
+	// next_attempt_at is NULL.
+	MarkWebhookFailed(ctx context.Context, arg MarkWebhookFailedParams) error
+	// Anonymises the users deleted before deleted_before, audits it and returns
+	// them, so that their personal data can be removed from the rest of the
+	// database too. Purged users count as erased; users purged before they were
+	// also marked erased are returned once more, without a second audit entry.
+	// The rows are kept so that references to them stay valid.
+	PurgeDeletedUsers(ctx context.Context, arg PurgeDeletedUsersParams) ([]uuid.UUID, error)
+	RestoreUser(ctx context.Context, userID uuid.UUID) (User, error)
+	// Requeues a dead delivery for immediate delivery.
+	RetryWebhookDelivery(ctx context.Context, id uuid.UUID) (int64, error)
+	SaveIdempotentResponse(ctx context.Context, arg SaveIdempotentResponseParams) error
+	// Removes the personal data of a user from its audit entries and from the
+	// events about it in the outbox and in webhook deliveries. Events are found
+	// by their subject, without an index, which is fine for the rare erasures.
+	// The stored responses of idempotent requests that mention the user are
+	// deleted, so a retry after the erasure is handled as a new request.
+	ScrubUserData(ctx context.Context, userID uuid.UUID) error
+	// Matches words by prefix with full-text search, names and emails by trigram
+	// similarity, which tolerates typos, and phone numbers by substring.
+	SearchUsers(ctx context.Context, arg SearchUsersParams) ([]SearchUsersRow, error)
+	SoftDeleteUser(ctx context.Context, userID uuid.UUID) (User, error)
+	// Applies a partial update: NULL leaves a required column unchanged, and the
+	// set_ flags say whether a nullable column is written, possibly with NULL.
 	UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error)
+	UpdateUserStatus(ctx context.Context, arg UpdateUserStatusParams) (User, error)
 }
 
 var _ Querier = (*Queries)(nil)
@@ -8,14 +8,201 @@ package db
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/sqlc-dev/pqtype"
 )
 
+const claimIdempotencyKey = `-- name: ClaimIdempotencyKey :one
+INSERT INTO idempotency_keys (key, request_hash)
+VALUES ($1, $2)
+ON CONFLICT (key) DO UPDATE
+SET request_hash = EXCLUDED.request_hash, status_code = NULL, headers = '{}', body = NULL, created_at = now()
+WHERE idempotency_keys.created_at < $3
+    RETURNING key, request_hash, status_code, headers, body, created_at
+`
+
+type ClaimIdempotencyKeyParams struct {
+	Key           string
+	RequestHash   string
+	ExpiredBefore time.Time
+}
+
+// Records a new key, or takes over one that expired before expired_before.
+// Returns no row if the key is in use.
+func (q *Queries) ClaimIdempotencyKey(ctx context.Context, arg ClaimIdempotencyKeyParams) (IdempotencyKey, error) {
+	row := q.db.QueryRowContext(ctx, claimIdempotencyKey, arg.Key, arg.RequestHash, arg.ExpiredBefore)
+	var i IdempotencyKey
+	err := row.Scan(
+		&i.Key,
+		&i.RequestHash,
+		&i.StatusCode,
+		&i.Headers,
+		&i.Body,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const claimOutboxEvents = `-- name: ClaimOutboxEvents :many
+SELECT id, subject, payload, created_at, published_at, attempts, last_error FROM outbox
+WHERE published_at IS NULL
+ORDER BY created_at, id
+LIMIT $1
+FOR UPDATE SKIP LOCKED
+`
+
+// Locks the oldest unpublished events; concurrent relays skip locked rows.
+func (q *Queries) ClaimOutboxEvents(ctx context.Context, limit int32) ([]Outbox, error) {
+	rows, err := q.db.QueryContext(ctx, claimOutboxEvents, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Outbox
+	for rows.Next() {
+		var i Outbox
+		if err := rows.Scan(
+			&i.ID,
+			&i.Subject,
+			&i.Payload,
+			&i.CreatedAt,
+			&i.PublishedAt,
+			&i.Attempts,
+			&i.LastError,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const claimWebhookDeliveries = `-- name: ClaimWebhookDeliveries :many
+UPDATE webhook_deliveries d
+SET next_attempt_at = $1
+FROM webhook_subscriptions s
+WHERE s.id = d.subscription_id AND d.id IN (
+    SELECT id FROM webhook_deliveries
+    WHERE status = 'pending' AND next_attempt_at <= now()
+    ORDER BY next_attempt_at, id
+    LIMIT $2
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING d.id, d.subscription_id, d.event_id, d.event_type, d.payload, d.status, d.attempts, d.next_attempt_at, d.last_error, d.created_at, d.delivered_at, s.url, s.secret
+`
+
+type ClaimWebhookDeliveriesParams struct {
+	LeaseUntil time.Time
+	BatchSize  int32
+}
+
+type ClaimWebhookDeliveriesRow struct {
+	ID             uuid.UUID
+	SubscriptionID uuid.UUID
+	EventID        string
+	EventType      string
+	Payload        json.RawMessage
+	Status         string
+	Attempts       int32
+	NextAttemptAt  time.Time
+	LastError      sql.NullString
+	CreatedAt      time.Time
+	DeliveredAt    sql.NullTime
+	Url            string
+	Secret         string
+}
+
+// Leases due deliveries, along with their subscription, until lease_until by
+// moving their next attempt to then, so that they are delivered outside any
+// transaction without other workers taking them. Concurrent workers skip
+// locked rows. A delivery whose worker dies is due again once its lease ends.
+func (q *Queries) ClaimWebhookDeliveries(ctx context.Context, arg ClaimWebhookDeliveriesParams) ([]ClaimWebhookDeliveriesRow, error) {
+	rows, err := q.db.QueryContext(ctx, claimWebhookDeliveries, arg.LeaseUntil, arg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ClaimWebhookDeliveriesRow
+	for rows.Next() {
+		var i ClaimWebhookDeliveriesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.SubscriptionID,
+			&i.EventID,
+			&i.EventType,
+			&i.Payload,
+			&i.Status,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.LastError,
+			&i.CreatedAt,
+			&i.DeliveredAt,
+			&i.Url,
+			&i.Secret,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const countUsers = `-- name: CountUsers :one
+SELECT count(*) FROM users
+WHERE ($1::bool OR deleted_at IS NULL)
+  AND ($2::text IS NULL OR status = $2)
+  AND ($3::int IS NULL OR age >= $3)
+  AND ($4::int IS NULL OR age <= $4)
+  AND ($5::text IS NULL OR lower(email) LIKE $5 || '%')
+  AND ($6::text IS NULL
+       OR lower(first_name) LIKE $6 || '%'
+       OR lower(last_name) LIKE $6 || '%')
+`
+
+type CountUsersParams struct {
+	IncludeDeleted bool
+	Status         sql.NullString
+	MinAge         sql.NullInt32
+	MaxAge         sql.NullInt32
+	EmailPrefix    sql.NullString
+	NamePrefix     sql.NullString
+}
+
+// Counts the users matching the filters of the ListUsersBy queries.
+func (q *Queries) CountUsers(ctx context.Context, arg CountUsersParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUsers,
+		arg.IncludeDeleted,
+		arg.Status,
+		arg.MinAge,
+		arg.MaxAge,
+		arg.EmailPrefix,
+		arg.NamePrefix,
+	)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (first_name, last_name, email, phone, age, status)
 VALUES ($1, $2, $3, $4, $5, $6)
-    RETURNING user_id, first_name, last_name, email, phone, age, status
+    RETURNING user_id, first_name, last_name, email, phone, age, status, created_at, version, updated_at, deleted_at, purged_at, erased_at, events_erased_at
 `
 
 type CreateUserParams struct {
@@ -24,7 +211,7 @@ type CreateUserParams struct {
 	Email     string
 	Phone     sql.NullString
 	Age       sql.NullInt32
-	Status    sql.NullString
+	Status    string
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
@@ -45,21 +232,158 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Phone,
 		&i.Age,
 		&i.Status,
+		&i.CreatedAt,
+		&i.Version,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.PurgedAt,
+		&i.ErasedAt,
+		&i.EventsErasedAt,
+	)
+	return i, err
+}
+
+const createWebhookSubscription = `-- name: CreateWebhookSubscription :one
+INSERT INTO webhook_subscriptions (url, event_types, secret)
+VALUES ($1, $2, $3)
+    RETURNING id, url, event_types, secret, created_at
+`
+
+type CreateWebhookSubscriptionParams struct {
+	Url        string
+	EventTypes []string
+	Secret     string
+}
+
+func (q *Queries) CreateWebhookSubscription(ctx context.Context, arg CreateWebhookSubscriptionParams) (WebhookSubscription, error) {
+	row := q.db.QueryRowContext(ctx, createWebhookSubscription, arg.Url, pq.Array(arg.EventTypes), arg.Secret)
+	var i WebhookSubscription
+	err := row.Scan(
+		&i.ID,
+		&i.Url,
+		pq.Array(&i.EventTypes),
+		&i.Secret,
+		&i.CreatedAt,
 	)
 	return i, err
 }
 
-const deleteUser = `-- name: DeleteUser :exec
-DELETE FROM users WHERE user_id = $1
+const deleteExpiredIdempotencyKeys = `-- name: DeleteExpiredIdempotencyKeys :execrows
+DELETE FROM idempotency_keys WHERE created_at < $1
+`
+
+func (q *Queries) DeleteExpiredIdempotencyKeys(ctx context.Context, createdAt time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteExpiredIdempotencyKeys, createdAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteIdempotencyKey = `-- name: DeleteIdempotencyKey :exec
+DELETE FROM idempotency_keys WHERE key = $1
 `
 
-func (q *Queries) DeleteUser(ctx context.Context, userID uuid.UUID) error {
-	_, err := q.db.ExecContext(ctx, deleteUser, userID)
+func (q *Queries) DeleteIdempotencyKey(ctx context.Context, key string) error {
+	_, err := q.db.ExecContext(ctx, deleteIdempotencyKey, key)
 	return err
 }
 
+const deleteWebhookSubscription = `-- name: DeleteWebhookSubscription :execrows
+DELETE FROM webhook_subscriptions WHERE id = $1
+`
+
+func (q *Queries) DeleteWebhookSubscription(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteWebhookSubscription, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const enqueueWebhookDeliveries = `-- name: EnqueueWebhookDeliveries :execrows
+INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload)
+SELECT s.id, $1::text, $2::text, $3::jsonb
+FROM webhook_subscriptions s
+WHERE $2::text = ANY (s.event_types)
+ON CONFLICT (subscription_id, event_id) DO NOTHING
+`
+
+type EnqueueWebhookDeliveriesParams struct {
+	EventID   string
+	EventType string
+	Payload   json.RawMessage
+}
+
+// Queues an event for every subscription to its type. Redelivered events are
+// ignored.
+func (q *Queries) EnqueueWebhookDeliveries(ctx context.Context, arg EnqueueWebhookDeliveriesParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, enqueueWebhookDeliveries, arg.EventID, arg.EventType, arg.Payload)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const eraseUser = `-- name: EraseUser :one
+UPDATE users
+SET first_name = '',
+    last_name = '',
+    email = user_id::text || '@erased.invalid',
+    phone = NULL,
+    age = NULL,
+    status = 'closed',
+    erased_at = now(),
+    version = version + 1,
+    updated_at = now()
+WHERE user_id = $1
+    RETURNING user_id, first_name, last_name, email, phone, age, status, created_at, version, updated_at, deleted_at, purged_at, erased_at, events_erased_at
+`
+
+// Pseudonymises a user and closes it. The row is kept so that references to
+// it stay valid.
+func (q *Queries) EraseUser(ctx context.Context, userID uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, eraseUser, userID)
+	var i User
+	err := row.Scan(
+		&i.UserID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Email,
+		&i.Phone,
+		&i.Age,
+		&i.Status,
+		&i.CreatedAt,
+		&i.Version,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.PurgedAt,
+		&i.ErasedAt,
+		&i.EventsErasedAt,
+	)
+	return i, err
+}
+
+const getIdempotencyKey = `-- name: GetIdempotencyKey :one
+SELECT key, request_hash, status_code, headers, body, created_at FROM idempotency_keys WHERE key = $1
+`
+
+func (q *Queries) GetIdempotencyKey(ctx context.Context, key string) (IdempotencyKey, error) {
+	row := q.db.QueryRowContext(ctx, getIdempotencyKey, key)
+	var i IdempotencyKey
+	err := row.Scan(
+		&i.Key,
+		&i.RequestHash,
+		&i.StatusCode,
+		&i.Headers,
+		&i.Body,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
 const getUser = `-- name: GetUser :one
-SELECT user_id, first_name, last_name, email, phone, age, status FROM users WHERE user_id = $1
+SELECT user_id, first_name, last_name, email, phone, age, status, created_at, version, updated_at, deleted_at, purged_at, erased_at, events_erased_at FROM users WHERE user_id = $1 AND deleted_at IS NULL
 `
 
 func (q *Queries) GetUser(ctx context.Context, userID uuid.UUID) (User, error) {
@@ -73,16 +397,276 @@ func (q *Queries) GetUser(ctx context.Context, userID uuid.UUID) (User, error) {
 		&i.Phone,
 		&i.Age,
 		&i.Status,
+		&i.CreatedAt,
+		&i.Version,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.PurgedAt,
+		&i.ErasedAt,
+		&i.EventsErasedAt,
+	)
+	return i, err
+}
+
+const getUserForUpdate = `-- name: GetUserForUpdate :one
+SELECT user_id, first_name, last_name, email, phone, age, status, created_at, version, updated_at, deleted_at, purged_at, erased_at, events_erased_at FROM users WHERE user_id = $1 FOR UPDATE
+`
+
+// Locks the user until the end of the transaction. Deleted users are
+// returned too, so that they can be restored.
+func (q *Queries) GetUserForUpdate(ctx context.Context, userID uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserForUpdate, userID)
+	var i User
+	err := row.Scan(
+		&i.UserID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Email,
+		&i.Phone,
+		&i.Age,
+		&i.Status,
+		&i.CreatedAt,
+		&i.Version,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.PurgedAt,
+		&i.ErasedAt,
+		&i.EventsErasedAt,
+	)
+	return i, err
+}
+
+const getUserIncludingDeleted = `-- name: GetUserIncludingDeleted :one
+SELECT user_id, first_name, last_name, email, phone, age, status, created_at, version, updated_at, deleted_at, purged_at, erased_at, events_erased_at FROM users WHERE user_id = $1
+`
+
+func (q *Queries) GetUserIncludingDeleted(ctx context.Context, userID uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserIncludingDeleted, userID)
+	var i User
+	err := row.Scan(
+		&i.UserID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Email,
+		&i.Phone,
+		&i.Age,
+		&i.Status,
+		&i.CreatedAt,
+		&i.Version,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.PurgedAt,
+		&i.ErasedAt,
+		&i.EventsErasedAt,
+	)
+	return i, err
+}
+
+const getWebhookSubscription = `-- name: GetWebhookSubscription :one
+SELECT id, url, event_types, secret, created_at FROM webhook_subscriptions WHERE id = $1
+`
+
+func (q *Queries) GetWebhookSubscription(ctx context.Context, id uuid.UUID) (WebhookSubscription, error) {
+	row := q.db.QueryRowContext(ctx, getWebhookSubscription, id)
+	var i WebhookSubscription
+	err := row.Scan(
+		&i.ID,
+		&i.Url,
+		pq.Array(&i.EventTypes),
+		&i.Secret,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const insertOutboxEvent = `-- name: InsertOutboxEvent :one
+INSERT INTO outbox (subject, payload)
+VALUES ($1, $2)
+    RETURNING id, subject, payload, created_at, published_at, attempts, last_error
+`
+
+type InsertOutboxEventParams struct {
+	Subject string
+	Payload json.RawMessage
+}
+
+func (q *Queries) InsertOutboxEvent(ctx context.Context, arg InsertOutboxEventParams) (Outbox, error) {
+	row := q.db.QueryRowContext(ctx, insertOutboxEvent, arg.Subject, arg.Payload)
+	var i Outbox
+	err := row.Scan(
+		&i.ID,
+		&i.Subject,
+		&i.Payload,
+		&i.CreatedAt,
+		&i.PublishedAt,
+		&i.Attempts,
+		&i.LastError,
 	)
 	return i, err
 }
 
-const listUsers = `-- name: ListUsers :many
-SELECT user_id, first_name, last_name, email, phone, age, status FROM users
+const insertUserAudit = `-- name: InsertUserAudit :exec
+INSERT INTO user_audit (user_id, action, actor, reason, source, changed, before, after)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+`
+
+type InsertUserAuditParams struct {
+	UserID  uuid.UUID
+	Action  string
+	Actor   sql.NullString
+	Reason  sql.NullString
+	Source  string
+	Changed []string
+	Before  pqtype.NullRawMessage
+	After   pqtype.NullRawMessage
+}
+
+func (q *Queries) InsertUserAudit(ctx context.Context, arg InsertUserAuditParams) error {
+	_, err := q.db.ExecContext(ctx, insertUserAudit,
+		arg.UserID,
+		arg.Action,
+		arg.Actor,
+		arg.Reason,
+		arg.Source,
+		pq.Array(arg.Changed),
+		arg.Before,
+		arg.After,
+	)
+	return err
+}
+
+const listDeadWebhookDeliveries = `-- name: ListDeadWebhookDeliveries :many
+SELECT id, subscription_id, event_id, event_type, payload, status, attempts, next_attempt_at, last_error, created_at, delivered_at FROM webhook_deliveries
+WHERE status = 'dead'
+ORDER BY created_at DESC, id
+LIMIT $1
+`
+
+func (q *Queries) ListDeadWebhookDeliveries(ctx context.Context, limit int32) ([]WebhookDelivery, error) {
+	rows, err := q.db.QueryContext(ctx, listDeadWebhookDeliveries, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebhookDelivery
+	for rows.Next() {
+		var i WebhookDelivery
+		if err := rows.Scan(
+			&i.ID,
+			&i.SubscriptionID,
+			&i.EventID,
+			&i.EventType,
+			&i.Payload,
+			&i.Status,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.LastError,
+			&i.CreatedAt,
+			&i.DeliveredAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserAudit = `-- name: ListUserAudit :many
+SELECT id, user_id, action, actor, reason, source, changed, before, after, created_at FROM user_audit
+WHERE user_id = $1
+  AND ($2::bigint IS NULL OR id < $2)
+ORDER BY id DESC
+LIMIT $3
+`
+
+type ListUserAuditParams struct {
+	UserID   uuid.UUID
+	BeforeID sql.NullInt64
+	PageSize int32
+}
+
+// Returns the audit entries of a user newest first, starting before
+// before_id if it is set.
+func (q *Queries) ListUserAudit(ctx context.Context, arg ListUserAuditParams) ([]UserAudit, error) {
+	rows, err := q.db.QueryContext(ctx, listUserAudit, arg.UserID, arg.BeforeID, arg.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []UserAudit
+	for rows.Next() {
+		var i UserAudit
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Action,
+			&i.Actor,
+			&i.Reason,
+			&i.Source,
+			pq.Array(&i.Changed),
+			&i.Before,
+			&i.After,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsersByAge = `-- name: ListUsersByAge :many
+SELECT user_id, first_name, last_name, email, phone, age, status, created_at, version, updated_at, deleted_at, purged_at, erased_at, events_erased_at FROM users
+WHERE ($1::bool OR deleted_at IS NULL)
+  AND ($2::text IS NULL OR status = $2)
+  AND ($3::int IS NULL OR age >= $3)
+  AND ($4::int IS NULL OR age <= $4)
+  AND ($5::text IS NULL OR lower(email) LIKE $5 || '%')
+  AND ($6::text IS NULL
+       OR lower(first_name) LIKE $6 || '%'
+       OR lower(last_name) LIKE $6 || '%')
+  AND ($7::uuid IS NULL
+       OR (COALESCE(age, 0), user_id) > ($8::int, $7::uuid))
+ORDER BY COALESCE(age, 0), user_id
+LIMIT $9
 `
 
-func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
-	rows, err := q.db.QueryContext(ctx, listUsers)
+type ListUsersByAgeParams struct {
+	IncludeDeleted bool
+	Status         sql.NullString
+	MinAge         sql.NullInt32
+	MaxAge         sql.NullInt32
+	EmailPrefix    sql.NullString
+	NamePrefix     sql.NullString
+	AfterID        uuid.NullUUID
+	AfterKey       sql.NullInt32
+	PageSize       int32
+}
+
+func (q *Queries) ListUsersByAge(ctx context.Context, arg ListUsersByAgeParams) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, listUsersByAge,
+		arg.IncludeDeleted,
+		arg.Status,
+		arg.MinAge,
+		arg.MaxAge,
+		arg.EmailPrefix,
+		arg.NamePrefix,
+		arg.AfterID,
+		arg.AfterKey,
+		arg.PageSize,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -98,6 +682,13 @@ func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
 			&i.Phone,
 			&i.Age,
 			&i.Status,
+			&i.CreatedAt,
+			&i.Version,
+			&i.UpdatedAt,
+			&i.DeletedAt,
+			&i.PurgedAt,
+			&i.ErasedAt,
+			&i.EventsErasedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -112,33 +703,1158 @@ func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
 	return items, nil
 }
 
-const updateUser = `-- name: UpdateUser :one
-UPDATE users
-SET first_name = $2, last_name = $3, email = $4, phone = $5, age = $6, status = $7
-WHERE user_id = $1
-    RETURNING user_id, first_name, last_name, email, phone, age, status
+const listUsersByAgeDesc = `-- name: ListUsersByAgeDesc :many
+SELECT user_id, first_name, last_name, email, phone, age, status, created_at, version, updated_at, deleted_at, purged_at, erased_at, events_erased_at FROM users
+WHERE ($1::bool OR deleted_at IS NULL)
+  AND ($2::text IS NULL OR status = $2)
+  AND ($3::int IS NULL OR age >= $3)
+  AND ($4::int IS NULL OR age <= $4)
+  AND ($5::text IS NULL OR lower(email) LIKE $5 || '%')
+  AND ($6::text IS NULL
+       OR lower(first_name) LIKE $6 || '%'
+       OR lower(last_name) LIKE $6 || '%')
+  AND ($7::uuid IS NULL
+       OR (COALESCE(age, 0), user_id) < ($8::int, $7::uuid))
+ORDER BY COALESCE(age, 0) DESC, user_id DESC
+LIMIT $9
 `
 
-type UpdateUserParams struct {
-	UserID    uuid.UUID
-	FirstName string
-	LastName  string
-	Email     string
-	Phone     sql.NullString
-	Age       sql.NullInt32
-	Status    sql.NullString
+type ListUsersByAgeDescParams struct {
+	IncludeDeleted bool
+	Status         sql.NullString
+	MinAge         sql.NullInt32
+	MaxAge         sql.NullInt32
+	EmailPrefix    sql.NullString
+	NamePrefix     sql.NullString
+	AfterID        uuid.NullUUID
+	AfterKey       sql.NullInt32
+	PageSize       int32
 }
 
-func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, updateUser,
-		arg.UserID,
-		arg.FirstName,
-		arg.LastName,
-		arg.Email,
-		arg.Phone,
-		arg.Age,
+func (q *Queries) ListUsersByAgeDesc(ctx context.Context, arg ListUsersByAgeDescParams) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, listUsersByAgeDesc,
+		arg.IncludeDeleted,
 		arg.Status,
+		arg.MinAge,
+		arg.MaxAge,
+		arg.EmailPrefix,
+		arg.NamePrefix,
+		arg.AfterID,
+		arg.AfterKey,
+		arg.PageSize,
 	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.UserID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.Age,
+			&i.Status,
+			&i.CreatedAt,
+			&i.Version,
+			&i.UpdatedAt,
+			&i.DeletedAt,
+			&i.PurgedAt,
+			&i.ErasedAt,
+			&i.EventsErasedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsersByCreatedAt = `-- name: ListUsersByCreatedAt :many
+
+SELECT user_id, first_name, last_name, email, phone, age, status, created_at, version, updated_at, deleted_at, purged_at, erased_at, events_erased_at FROM users
+WHERE ($1::bool OR deleted_at IS NULL)
+  AND ($2::text IS NULL OR status = $2)
+  AND ($3::int IS NULL OR age >= $3)
+  AND ($4::int IS NULL OR age <= $4)
+  AND ($5::text IS NULL OR lower(email) LIKE $5 || '%')
+  AND ($6::text IS NULL
+       OR lower(first_name) LIKE $6 || '%'
+       OR lower(last_name) LIKE $6 || '%')
+  AND ($7::uuid IS NULL
+       OR (created_at, user_id) > ($8::timestamptz, $7::uuid))
+ORDER BY created_at, user_id
+LIMIT $9
+`
+
+type ListUsersByCreatedAtParams struct {
+	IncludeDeleted bool
+	Status         sql.NullString
+	MinAge         sql.NullInt32
+	MaxAge         sql.NullInt32
+	EmailPrefix    sql.NullString
+	NamePrefix     sql.NullString
+	AfterID        uuid.NullUUID
+	AfterKey       sql.NullTime
+	PageSize       int32
+}
+
+// The ListUsersBy queries return the page of matching users after
+// (after_key, after_id) in the order of one column. There is one query per
+// column and direction, so that the (column, user_id) index of the column
+// serves both the keyset condition and the order. after_key is the value of
+// the column for the last user of the previous page.
+func (q *Queries) ListUsersByCreatedAt(ctx context.Context, arg ListUsersByCreatedAtParams) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, listUsersByCreatedAt,
+		arg.IncludeDeleted,
+		arg.Status,
+		arg.MinAge,
+		arg.MaxAge,
+		arg.EmailPrefix,
+		arg.NamePrefix,
+		arg.AfterID,
+		arg.AfterKey,
+		arg.PageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.UserID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.Age,
+			&i.Status,
+			&i.CreatedAt,
+			&i.Version,
+			&i.UpdatedAt,
+			&i.DeletedAt,
+			&i.PurgedAt,
+			&i.ErasedAt,
+			&i.EventsErasedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsersByCreatedAtDesc = `-- name: ListUsersByCreatedAtDesc :many
+SELECT user_id, first_name, last_name, email, phone, age, status, created_at, version, updated_at, deleted_at, purged_at, erased_at, events_erased_at FROM users
+WHERE ($1::bool OR deleted_at IS NULL)
+  AND ($2::text IS NULL OR status = $2)
+  AND ($3::int IS NULL OR age >= $3)
+  AND ($4::int IS NULL OR age <= $4)
+  AND ($5::text IS NULL OR lower(email) LIKE $5 || '%')
+  AND ($6::text IS NULL
+       OR lower(first_name) LIKE $6 || '%'
+       OR lower(last_name) LIKE $6 || '%')
+  AND ($7::uuid IS NULL
+       OR (created_at, user_id) < ($8::timestamptz, $7::uuid))
+ORDER BY created_at DESC, user_id DESC
+LIMIT $9
+`
+
+type ListUsersByCreatedAtDescParams struct {
+	IncludeDeleted bool
+	Status         sql.NullString
+	MinAge         sql.NullInt32
+	MaxAge         sql.NullInt32
+	EmailPrefix    sql.NullString
+	NamePrefix     sql.NullString
+	AfterID        uuid.NullUUID
+	AfterKey       sql.NullTime
+	PageSize       int32
+}
+
+func (q *Queries) ListUsersByCreatedAtDesc(ctx context.Context, arg ListUsersByCreatedAtDescParams) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, listUsersByCreatedAtDesc,
+		arg.IncludeDeleted,
+		arg.Status,
+		arg.MinAge,
+		arg.MaxAge,
+		arg.EmailPrefix,
+		arg.NamePrefix,
+		arg.AfterID,
+		arg.AfterKey,
+		arg.PageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.UserID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.Age,
+			&i.Status,
+			&i.CreatedAt,
+			&i.Version,
+			&i.UpdatedAt,
+			&i.DeletedAt,
+			&i.PurgedAt,
+			&i.ErasedAt,
+			&i.EventsErasedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsersByEmail = `-- name: ListUsersByEmail :many
+SELECT user_id, first_name, last_name, email, phone, age, status, created_at, version, updated_at, deleted_at, purged_at, erased_at, events_erased_at FROM users
+WHERE ($1::bool OR deleted_at IS NULL)
+  AND ($2::text IS NULL OR status = $2)
+  AND ($3::int IS NULL OR age >= $3)
+  AND ($4::int IS NULL OR age <= $4)
+  AND ($5::text IS NULL OR lower(email) LIKE $5 || '%')
+  AND ($6::text IS NULL
+       OR lower(first_name) LIKE $6 || '%'
+       OR lower(last_name) LIKE $6 || '%')
+  AND ($7::uuid IS NULL
+       OR (lower(email), user_id) > (lower($8::text), $7::uuid))
+ORDER BY lower(email), user_id
+LIMIT $9
+`
+
+type ListUsersByEmailParams struct {
+	IncludeDeleted bool
+	Status         sql.NullString
+	MinAge         sql.NullInt32
+	MaxAge         sql.NullInt32
+	EmailPrefix    sql.NullString
+	NamePrefix     sql.NullString
+	AfterID        uuid.NullUUID
+	AfterKey       sql.NullString
+	PageSize       int32
+}
+
+func (q *Queries) ListUsersByEmail(ctx context.Context, arg ListUsersByEmailParams) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, listUsersByEmail,
+		arg.IncludeDeleted,
+		arg.Status,
+		arg.MinAge,
+		arg.MaxAge,
+		arg.EmailPrefix,
+		arg.NamePrefix,
+		arg.AfterID,
+		arg.AfterKey,
+		arg.PageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.UserID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.Age,
+			&i.Status,
+			&i.CreatedAt,
+			&i.Version,
+			&i.UpdatedAt,
+			&i.DeletedAt,
+			&i.PurgedAt,
+			&i.ErasedAt,
+			&i.EventsErasedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsersByEmailDesc = `-- name: ListUsersByEmailDesc :many
+SELECT user_id, first_name, last_name, email, phone, age, status, created_at, version, updated_at, deleted_at, purged_at, erased_at, events_erased_at FROM users
+WHERE ($1::bool OR deleted_at IS NULL)
+  AND ($2::text IS NULL OR status = $2)
+  AND ($3::int IS NULL OR age >= $3)
+  AND ($4::int IS NULL OR age <= $4)
+  AND ($5::text IS NULL OR lower(email) LIKE $5 || '%')
+  AND ($6::text IS NULL
+       OR lower(first_name) LIKE $6 || '%'
+       OR lower(last_name) LIKE $6 || '%')
+  AND ($7::uuid IS NULL
+       OR (lower(email), user_id) < (lower($8::text), $7::uuid))
+ORDER BY lower(email) DESC, user_id DESC
+LIMIT $9
+`
+
+type ListUsersByEmailDescParams struct {
+	IncludeDeleted bool
+	Status         sql.NullString
+	MinAge         sql.NullInt32
+	MaxAge         sql.NullInt32
+	EmailPrefix    sql.NullString
+	NamePrefix     sql.NullString
+	AfterID        uuid.NullUUID
+	AfterKey       sql.NullString
+	PageSize       int32
+}
+
+func (q *Queries) ListUsersByEmailDesc(ctx context.Context, arg ListUsersByEmailDescParams) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, listUsersByEmailDesc,
+		arg.IncludeDeleted,
+		arg.Status,
+		arg.MinAge,
+		arg.MaxAge,
+		arg.EmailPrefix,
+		arg.NamePrefix,
+		arg.AfterID,
+		arg.AfterKey,
+		arg.PageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.UserID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.Age,
+			&i.Status,
+			&i.CreatedAt,
+			&i.Version,
+			&i.UpdatedAt,
+			&i.DeletedAt,
+			&i.PurgedAt,
+			&i.ErasedAt,
+			&i.EventsErasedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsersByFirstName = `-- name: ListUsersByFirstName :many
+SELECT user_id, first_name, last_name, email, phone, age, status, created_at, version, updated_at, deleted_at, purged_at, erased_at, events_erased_at FROM users
+WHERE ($1::bool OR deleted_at IS NULL)
+  AND ($2::text IS NULL OR status = $2)
+  AND ($3::int IS NULL OR age >= $3)
+  AND ($4::int IS NULL OR age <= $4)
+  AND ($5::text IS NULL OR lower(email) LIKE $5 || '%')
+  AND ($6::text IS NULL
+       OR lower(first_name) LIKE $6 || '%'
+       OR lower(last_name) LIKE $6 || '%')
+  AND ($7::uuid IS NULL
+       OR (lower(first_name), user_id) > (lower($8::text), $7::uuid))
+ORDER BY lower(first_name), user_id
+LIMIT $9
+`
+
+type ListUsersByFirstNameParams struct {
+	IncludeDeleted bool
+	Status         sql.NullString
+	MinAge         sql.NullInt32
+	MaxAge         sql.NullInt32
+	EmailPrefix    sql.NullString
+	NamePrefix     sql.NullString
+	AfterID        uuid.NullUUID
+	AfterKey       sql.NullString
+	PageSize       int32
+}
+
+func (q *Queries) ListUsersByFirstName(ctx context.Context, arg ListUsersByFirstNameParams) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, listUsersByFirstName,
+		arg.IncludeDeleted,
+		arg.Status,
+		arg.MinAge,
+		arg.MaxAge,
+		arg.EmailPrefix,
+		arg.NamePrefix,
+		arg.AfterID,
+		arg.AfterKey,
+		arg.PageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.UserID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.Age,
+			&i.Status,
+			&i.CreatedAt,
+			&i.Version,
+			&i.UpdatedAt,
+			&i.DeletedAt,
+			&i.PurgedAt,
+			&i.ErasedAt,
+			&i.EventsErasedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsersByFirstNameDesc = `-- name: ListUsersByFirstNameDesc :many
+SELECT user_id, first_name, last_name, email, phone, age, status, created_at, version, updated_at, deleted_at, purged_at, erased_at, events_erased_at FROM users
+WHERE ($1::bool OR deleted_at IS NULL)
+  AND ($2::text IS NULL OR status = $2)
+  AND ($3::int IS NULL OR age >= $3)
+  AND ($4::int IS NULL OR age <= $4)
+  AND ($5::text IS NULL OR lower(email) LIKE $5 || '%')
+  AND ($6::text IS NULL
+       OR lower(first_name) LIKE $6 || '%'
+       OR lower(last_name) LIKE $6 || '%')
+  AND ($7::uuid IS NULL
+       OR (lower(first_name), user_id) < (lower($8::text), $7::uuid))
+ORDER BY lower(first_name) DESC, user_id DESC
+LIMIT $9
+`
+
+type ListUsersByFirstNameDescParams struct {
+	IncludeDeleted bool
+	Status         sql.NullString
+	MinAge         sql.NullInt32
+	MaxAge         sql.NullInt32
+	EmailPrefix    sql.NullString
+	NamePrefix     sql.NullString
+	AfterID        uuid.NullUUID
+	AfterKey       sql.NullString
+	PageSize       int32
+}
+
+func (q *Queries) ListUsersByFirstNameDesc(ctx context.Context, arg ListUsersByFirstNameDescParams) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, listUsersByFirstNameDesc,
+		arg.IncludeDeleted,
+		arg.Status,
+		arg.MinAge,
+		arg.MaxAge,
+		arg.EmailPrefix,
+		arg.NamePrefix,
+		arg.AfterID,
+		arg.AfterKey,
+		arg.PageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.UserID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.Age,
+			&i.Status,
+			&i.CreatedAt,
+			&i.Version,
+			&i.UpdatedAt,
+			&i.DeletedAt,
+			&i.PurgedAt,
+			&i.ErasedAt,
+			&i.EventsErasedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsersByLastName = `-- name: ListUsersByLastName :many
+SELECT user_id, first_name, last_name, email, phone, age, status, created_at, version, updated_at, deleted_at, purged_at, erased_at, events_erased_at FROM users
+WHERE ($1::bool OR deleted_at IS NULL)
+  AND ($2::text IS NULL OR status = $2)
+  AND ($3::int IS NULL OR age >= $3)
+  AND ($4::int IS NULL OR age <= $4)
+  AND ($5::text IS NULL OR lower(email) LIKE $5 || '%')
+  AND ($6::text IS NULL
+       OR lower(first_name) LIKE $6 || '%'
+       OR lower(last_name) LIKE $6 || '%')
+  AND ($7::uuid IS NULL
+       OR (lower(last_name), user_id) > (lower($8::text), $7::uuid))
+ORDER BY lower(last_name), user_id
+LIMIT $9
+`
+
+type ListUsersByLastNameParams struct {
+	IncludeDeleted bool
+	Status         sql.NullString
+	MinAge         sql.NullInt32
+	MaxAge         sql.NullInt32
+	EmailPrefix    sql.NullString
+	NamePrefix     sql.NullString
+	AfterID        uuid.NullUUID
+	AfterKey       sql.NullString
+	PageSize       int32
+}
+
+func (q *Queries) ListUsersByLastName(ctx context.Context, arg ListUsersByLastNameParams) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, listUsersByLastName,
+		arg.IncludeDeleted,
+		arg.Status,
+		arg.MinAge,
+		arg.MaxAge,
+		arg.EmailPrefix,
+		arg.NamePrefix,
+		arg.AfterID,
+		arg.AfterKey,
+		arg.PageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.UserID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.Age,
+			&i.Status,
+			&i.CreatedAt,
+			&i.Version,
+			&i.UpdatedAt,
+			&i.DeletedAt,
+			&i.PurgedAt,
+			&i.ErasedAt,
+			&i.EventsErasedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsersByLastNameDesc = `-- name: ListUsersByLastNameDesc :many
+SELECT user_id, first_name, last_name, email, phone, age, status, created_at, version, updated_at, deleted_at, purged_at, erased_at, events_erased_at FROM users
+WHERE ($1::bool OR deleted_at IS NULL)
+  AND ($2::text IS NULL OR status = $2)
+  AND ($3::int IS NULL OR age >= $3)
+  AND ($4::int IS NULL OR age <= $4)
+  AND ($5::text IS NULL OR lower(email) LIKE $5 || '%')
+  AND ($6::text IS NULL
+       OR lower(first_name) LIKE $6 || '%'
+       OR lower(last_name) LIKE $6 || '%')
+  AND ($7::uuid IS NULL
+       OR (lower(last_name), user_id) < (lower($8::text), $7::uuid))
+ORDER BY lower(last_name) DESC, user_id DESC
+LIMIT $9
+`
+
+type ListUsersByLastNameDescParams struct {
+	IncludeDeleted bool
+	Status         sql.NullString
+	MinAge         sql.NullInt32
+	MaxAge         sql.NullInt32
+	EmailPrefix    sql.NullString
+	NamePrefix     sql.NullString
+	AfterID        uuid.NullUUID
+	AfterKey       sql.NullString
+	PageSize       int32
+}
+
+func (q *Queries) ListUsersByLastNameDesc(ctx context.Context, arg ListUsersByLastNameDescParams) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, listUsersByLastNameDesc,
+		arg.IncludeDeleted,
+		arg.Status,
+		arg.MinAge,
+		arg.MaxAge,
+		arg.EmailPrefix,
+		arg.NamePrefix,
+		arg.AfterID,
+		arg.AfterKey,
+		arg.PageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.UserID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.Age,
+			&i.Status,
+			&i.CreatedAt,
+			&i.Version,
+			&i.UpdatedAt,
+			&i.DeletedAt,
+			&i.PurgedAt,
+			&i.ErasedAt,
+			&i.EventsErasedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsersWithEventsNotErased = `-- name: ListUsersWithEventsNotErased :many
+SELECT user_id FROM users
+WHERE erased_at IS NOT NULL AND events_erased_at IS NULL
+ORDER BY erased_at
+LIMIT $1
+`
+
+// Returns the erased users whose events are not erased outside the database
+// yet, oldest erasure first.
+func (q *Queries) ListUsersWithEventsNotErased(ctx context.Context, limit int32) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, listUsersWithEventsNotErased, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var user_id uuid.UUID
+		if err := rows.Scan(&user_id); err != nil {
+			return nil, err
+		}
+		items = append(items, user_id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWebhookSubscriptions = `-- name: ListWebhookSubscriptions :many
+SELECT id, url, event_types, secret, created_at FROM webhook_subscriptions ORDER BY created_at, id
+`
+
+func (q *Queries) ListWebhookSubscriptions(ctx context.Context) ([]WebhookSubscription, error) {
+	rows, err := q.db.QueryContext(ctx, listWebhookSubscriptions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebhookSubscription
+	for rows.Next() {
+		var i WebhookSubscription
+		if err := rows.Scan(
+			&i.ID,
+			&i.Url,
+			pq.Array(&i.EventTypes),
+			&i.Secret,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markEventsErased = `-- name: MarkEventsErased :exec
+UPDATE users SET events_erased_at = now() WHERE user_id = $1
+`
+
+func (q *Queries) MarkEventsErased(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, markEventsErased, userID)
+	return err
+}
+
+const markOutboxFailed = `-- name: MarkOutboxFailed :exec
+UPDATE outbox SET attempts = attempts + 1, last_error = $2
+WHERE id = $1
+`
+
+type MarkOutboxFailedParams struct {
+	ID        uuid.UUID
+	LastError sql.NullString
+}
+
+func (q *Queries) MarkOutboxFailed(ctx context.Context, arg MarkOutboxFailedParams) error {
+	_, err := q.db.ExecContext(ctx, markOutboxFailed, arg.ID, arg.LastError)
+	return err
+}
+
+const markOutboxPublished = `-- name: MarkOutboxPublished :exec
+UPDATE outbox SET published_at = now(), attempts = attempts + 1, last_error = NULL
+WHERE id = $1
+`
+
+func (q *Queries) MarkOutboxPublished(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, markOutboxPublished, id)
+	return err
+}
+
+const markWebhookDelivered = `-- name: MarkWebhookDelivered :exec
+UPDATE webhook_deliveries
+SET status = 'delivered', attempts = attempts + 1, delivered_at = now(), last_error = NULL
+WHERE id = $1
+`
+
+func (q *Queries) MarkWebhookDelivered(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, markWebhookDelivered, id)
+	return err
+}
+
+const markWebhookFailed = `-- name: MarkWebhookFailed :exec
+UPDATE webhook_deliveries
+SET attempts = attempts + 1,
+    last_error = $1,
+    status = CASE WHEN $2::timestamptz IS NULL THEN 'dead' ELSE 'pending' END,
+    next_attempt_at = COALESCE($2::timestamptz, next_attempt_at)
+WHERE id = $3
+`
+
+type MarkWebhookFailedParams struct {
+	LastError     sql.NullString
+	NextAttemptAt sql.NullTime
+	ID            uuid.UUID
+}
+
+// Schedules a retry, or moves the delivery to the dead letters when
+// next_attempt_at is NULL.
+func (q *Queries) MarkWebhookFailed(ctx context.Context, arg MarkWebhookFailedParams) error {
+	_, err := q.db.ExecContext(ctx, markWebhookFailed, arg.LastError, arg.NextAttemptAt, arg.ID)
+	return err
+}
+
+const purgeDeletedUsers = `-- name: PurgeDeletedUsers :many
+WITH due AS (
+    SELECT user_id, purged_at IS NULL AS fresh
+    FROM users
+    WHERE deleted_at < $1::timestamptz AND erased_at IS NULL
+    FOR UPDATE
+), purged AS (
+    UPDATE users
+    SET first_name = '',
+        last_name = '',
+        email = users.user_id::text || '@deleted.invalid',
+        phone = NULL,
+        age = NULL,
+        purged_at = COALESCE(users.purged_at, now()),
+        erased_at = now()
+    FROM due
+    WHERE users.user_id = due.user_id
+    RETURNING users.user_id, due.fresh
+), audited AS (
+    INSERT INTO user_audit (user_id, action, source, changed)
+    SELECT user_id, 'purged', $2::text, '{first_name,last_name,email,phone,age}'
+    FROM purged
+    WHERE fresh
+)
+SELECT user_id FROM purged
+`
+
+type PurgeDeletedUsersParams struct {
+	DeletedBefore time.Time
+	Source        string
+}
+
+// Anonymises the users deleted before deleted_before, audits it and returns
+// them, so that their personal data can be removed from the rest of the
+// database too. Purged users count as erased; users purged before they were
+// also marked erased are returned once more, without a second audit entry.
+// The rows are kept so that references to them stay valid.
+func (q *Queries) PurgeDeletedUsers(ctx context.Context, arg PurgeDeletedUsersParams) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, purgeDeletedUsers, arg.DeletedBefore, arg.Source)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var user_id uuid.UUID
+		if err := rows.Scan(&user_id); err != nil {
+			return nil, err
+		}
+		items = append(items, user_id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const restoreUser = `-- name: RestoreUser :one
+UPDATE users
+SET deleted_at = NULL, version = version + 1, updated_at = now()
+WHERE user_id = $1
+    RETURNING user_id, first_name, last_name, email, phone, age, status, created_at, version, updated_at, deleted_at, purged_at, erased_at, events_erased_at
+`
+
+func (q *Queries) RestoreUser(ctx context.Context, userID uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, restoreUser, userID)
+	var i User
+	err := row.Scan(
+		&i.UserID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Email,
+		&i.Phone,
+		&i.Age,
+		&i.Status,
+		&i.CreatedAt,
+		&i.Version,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.PurgedAt,
+		&i.ErasedAt,
+		&i.EventsErasedAt,
+	)
+	return i, err
+}
+
+const retryWebhookDelivery = `-- name: RetryWebhookDelivery :execrows
+UPDATE webhook_deliveries
+SET status = 'pending', attempts = 0, next_attempt_at = now()
+WHERE id = $1 AND status = 'dead'
+`
+
+// Requeues a dead delivery for immediate delivery.
+func (q *Queries) RetryWebhookDelivery(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, retryWebhookDelivery, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const saveIdempotentResponse = `-- name: SaveIdempotentResponse :exec
+UPDATE idempotency_keys
+SET status_code = $2, headers = $3, body = $4
+WHERE key = $1
+`
+
+type SaveIdempotentResponseParams struct {
+	Key        string
+	StatusCode sql.NullInt32
+	Headers    json.RawMessage
+	Body       []byte
+}
+
+func (q *Queries) SaveIdempotentResponse(ctx context.Context, arg SaveIdempotentResponseParams) error {
+	_, err := q.db.ExecContext(ctx, saveIdempotentResponse,
+		arg.Key,
+		arg.StatusCode,
+		arg.Headers,
+		arg.Body,
+	)
+	return err
+}
+
+const scrubUserData = `-- name: ScrubUserData :exec
+WITH audit AS (
+    UPDATE user_audit
+    SET before = strip_user_pii(before), after = strip_user_pii(after)
+    WHERE user_id = $1
+), events AS (
+    UPDATE outbox
+    SET payload = strip_event_pii(payload)
+    WHERE payload->>'subject' = ($1::uuid)::text
+), responses AS (
+    DELETE FROM idempotency_keys
+    WHERE position(convert_to(($1::uuid)::text, 'UTF8') IN body) > 0
+)
+UPDATE webhook_deliveries
+SET payload = strip_event_pii(payload)
+WHERE payload->>'subject' = ($1::uuid)::text
+`
+
+// Removes the personal data of a user from its audit entries and from the
+// events about it in the outbox and in webhook deliveries. Events are found
+// by their subject, without an index, which is fine for the rare erasures.
+// The stored responses of idempotent requests that mention the user are
+// deleted, so a retry after the erasure is handled as a new request.
+func (q *Queries) ScrubUserData(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, scrubUserData, userID)
+	return err
+}
+
+const searchUsers = `-- name: SearchUsers :many
+SELECT users.user_id, users.first_name, users.last_name, users.email, users.phone, users.age, users.status, users.created_at, users.version, users.updated_at, users.deleted_at, users.purged_at, users.erased_at, users.events_erased_at,
+    (ts_rank(to_tsvector('simple', users.first_name || ' ' || users.last_name || ' ' || users.email || ' ' || COALESCE(users.phone, '')),
+             to_tsquery('simple', $1::text))
+     + similarity(users.first_name || ' ' || users.last_name, $2::text)
+     + similarity(users.email, $2::text))::float8 AS rank
+FROM users
+WHERE users.deleted_at IS NULL
+  AND (to_tsvector('simple', users.first_name || ' ' || users.last_name || ' ' || users.email || ' ' || COALESCE(users.phone, ''))
+        @@ to_tsquery('simple', $1::text)
+   OR (users.first_name || ' ' || users.last_name) % $2::text
+   OR users.email % $2::text
+   OR ($3::text IS NOT NULL AND users.phone LIKE '%' || $3 || '%'))
+ORDER BY rank DESC, users.user_id
+LIMIT $4
+`
+
+type SearchUsersParams struct {
+	Tsquery     string
+	Term        string
+	Phone       sql.NullString
+	ResultLimit int32
+}
+
+type SearchUsersRow struct {
+	User User
+	Rank float64
+}
+
+// Matches words by prefix with full-text search, names and emails by trigram
+// similarity, which tolerates typos, and phone numbers by substring.
+func (q *Queries) SearchUsers(ctx context.Context, arg SearchUsersParams) ([]SearchUsersRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchUsers,
+		arg.Tsquery,
+		arg.Term,
+		arg.Phone,
+		arg.ResultLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchUsersRow
+	for rows.Next() {
+		var i SearchUsersRow
+		if err := rows.Scan(
+			&i.User.UserID,
+			&i.User.FirstName,
+			&i.User.LastName,
+			&i.User.Email,
+			&i.User.Phone,
+			&i.User.Age,
+			&i.User.Status,
+			&i.User.CreatedAt,
+			&i.User.Version,
+			&i.User.UpdatedAt,
+			&i.User.DeletedAt,
+			&i.User.PurgedAt,
+			&i.User.ErasedAt,
+			&i.User.EventsErasedAt,
+			&i.Rank,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const softDeleteUser = `-- name: SoftDeleteUser :one
+UPDATE users
+SET deleted_at = now(), version = version + 1, updated_at = now()
+WHERE user_id = $1
+    RETURNING user_id, first_name, last_name, email, phone, age, status, created_at, version, updated_at, deleted_at, purged_at, erased_at, events_erased_at
+`
+
+func (q *Queries) SoftDeleteUser(ctx context.Context, userID uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, softDeleteUser, userID)
+	var i User
+	err := row.Scan(
+		&i.UserID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Email,
+		&i.Phone,
+		&i.Age,
+		&i.Status,
+		&i.CreatedAt,
+		&i.Version,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.PurgedAt,
+		&i.ErasedAt,
+		&i.EventsErasedAt,
+	)
+	return i, err
+}
+
+const updateUser = `-- name: UpdateUser :one
+UPDATE users
+SET first_name = COALESCE($1, first_name),
+    last_name = COALESCE($2, last_name),
+    email = COALESCE($3, email),
+    phone = CASE WHEN $4::bool THEN $5 ELSE phone END,
+    age = CASE WHEN $6::bool THEN $7 ELSE age END,
+    version = version + 1,
+    updated_at = now()
+WHERE user_id = $8
+    RETURNING user_id, first_name, last_name, email, phone, age, status, created_at, version, updated_at, deleted_at, purged_at, erased_at, events_erased_at
+`
+
+type UpdateUserParams struct {
+	FirstName sql.NullString
+	LastName  sql.NullString
+	Email     sql.NullString
+	SetPhone  bool
+	Phone     sql.NullString
+	SetAge    bool
+	Age       sql.NullInt32
+	UserID    uuid.UUID
+}
+
+// Applies a partial update: NULL leaves a required column unchanged, and the
+// set_ flags say whether a nullable column is written, possibly with NULL.
+func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUser,
+		arg.FirstName,
+		arg.LastName,
+		arg.Email,
+		arg.SetPhone,
+		arg.Phone,
+		arg.SetAge,
+		arg.Age,
+		arg.UserID,
+	)
+	var i User
+	err := row.Scan(
+		&i.UserID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Email,
+		&i.Phone,
+		&i.Age,
+		&i.Status,
+		&i.CreatedAt,
+		&i.Version,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.PurgedAt,
+		&i.ErasedAt,
+		&i.EventsErasedAt,
+	)
+	return i, err
+}
+
+const updateUserStatus = `-- name: UpdateUserStatus :one
+UPDATE users
+SET status = $2, version = version + 1, updated_at = now()
+WHERE user_id = $1
+    RETURNING user_id, first_name, last_name, email, phone, age, status, created_at, version, updated_at, deleted_at, purged_at, erased_at, events_erased_at
+`
+
+type UpdateUserStatusParams struct {
+	UserID uuid.UUID
+	Status string
+}
+
+func (q *Queries) UpdateUserStatus(ctx context.Context, arg UpdateUserStatusParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserStatus, arg.UserID, arg.Status)
 	var i User
 	err := row.Scan(
 		&i.UserID,
@@ -148,6 +1864,13 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.Phone,
 		&i.Age,
 		&i.Status,
+		&i.CreatedAt,
+		&i.Version,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.PurgedAt,
+		&i.ErasedAt,
+		&i.EventsErasedAt,
 	)
 	return i, err
 }
@@ -0,0 +1,122 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/repository"
+)
+
+var (
+	// ErrInFlight is returned for a key whose first request has not finished.
+	ErrInFlight = errors.New("a request with this idempotency key is in progress")
+	// ErrKeyReused is returned for a key first used with another request.
+	ErrKeyReused = errors.New("idempotency key was used for a different request")
+)
+
+// Response is a stored response, returned again for retries of its request.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Store keeps the responses to requests made with an idempotency key for a
+// retention window. A key is claimed by its first request; retries within
+// the window get the stored response, later ones are handled as new requests.
+type Store struct {
+	repo      repository.IdempotencyRepository
+	retention time.Duration
+}
+
+func NewStore(repo repository.IdempotencyRepository) *Store {
+	return &Store{repo: repo, retention: 24 * time.Hour}
+}
+
+func (s *Store) SetRetention(d time.Duration) {
+	if d > 0 {
+		s.retention = d
+	}
+}
+
+// Begin claims key for the request identified by requestHash. It returns nil
+// if the caller should handle the request and then call Complete or Release,
+// or the stored response if the request was handled before.
+func (s *Store) Begin(ctx context.Context, key, requestHash string) (*Response, error) {
+	_, err := s.repo.ClaimIdempotencyKey(ctx, db.ClaimIdempotencyKeyParams{
+		Key:           key,
+		RequestHash:   requestHash,
+		ExpiredBefore: time.Now().Add(-s.retention),
+	})
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	stored, err := s.repo.GetIdempotencyKey(ctx, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInFlight // purged and reclaimed in the meantime
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stored.RequestHash != requestHash {
+		return nil, ErrKeyReused
+	}
+	if !stored.StatusCode.Valid {
+		return nil, ErrInFlight
+	}
+	resp := &Response{StatusCode: int(stored.StatusCode.Int32), Body: stored.Body}
+	if err := json.Unmarshal(stored.Headers, &resp.Header); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Complete stores the response to the request that claimed key.
+func (s *Store) Complete(ctx context.Context, key string, resp Response) error {
+	header, err := json.Marshal(resp.Header)
+	if err != nil {
+		return err
+	}
+	return s.repo.SaveIdempotentResponse(ctx, db.SaveIdempotentResponseParams{
+		Key:        key,
+		StatusCode: sql.NullInt32{Int32: int32(resp.StatusCode), Valid: true},
+		Headers:    header,
+		Body:       resp.Body,
+	})
+}
+
+// Release gives up key without storing a response, so that a retry is
+// handled again, e.g. after a server error.
+func (s *Store) Release(ctx context.Context, key string) error {
+	return s.repo.DeleteIdempotencyKey(ctx, key)
+}
+
+// Purge deletes the keys older than the retention window every interval
+// until ctx is done.
+func (s *Store) Purge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		n, err := s.repo.DeleteExpiredIdempotencyKeys(ctx, time.Now().Add(-s.retention))
+		if err != nil {
+			log.Printf("Failed to purge idempotency keys: %v\n", err)
+		} else if n > 0 {
+			log.Printf("Purged %d expired idempotency keys\n", n)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/repository/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBegin_ClaimsNewKey(t *testing.T) {
+	repo := new(mocks.MockIdempotencyRepository)
+	repo.On("ClaimIdempotencyKey", mock.Anything, mock.MatchedBy(func(arg db.ClaimIdempotencyKeyParams) bool {
+		// Keys older than the retention window may be taken over.
+		return arg.Key == "k1" && arg.RequestHash == "h1" && time.Since(arg.ExpiredBefore) >= time.Hour
+	})).Return(db.IdempotencyKey{Key: "k1"}, nil)
+
+	store := NewStore(repo)
+	store.SetRetention(time.Hour)
+	stored, err := store.Begin(context.Background(), "k1", "h1")
+
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+	repo.AssertExpectations(t)
+}
+
+func TestBegin_ExistingKey(t *testing.T) {
+	done := db.IdempotencyKey{
+		Key:         "done",
+		RequestHash: "h1",
+		StatusCode:  sql.NullInt32{Int32: http.StatusCreated, Valid: true},
+		Headers:     []byte(`{"Content-Type":["application/json"]}`),
+		Body:        []byte(`{"user_id":"1"}`),
+	}
+	repo := new(mocks.MockIdempotencyRepository)
+	repo.On("ClaimIdempotencyKey", mock.Anything, mock.Anything).Return(db.IdempotencyKey{}, sql.ErrNoRows)
+	repo.On("GetIdempotencyKey", mock.Anything, "done").Return(done, nil)
+	repo.On("GetIdempotencyKey", mock.Anything, "pending").Return(db.IdempotencyKey{Key: "pending", RequestHash: "h1"}, nil)
+	store := NewStore(repo)
+
+	stored, err := store.Begin(context.Background(), "done", "h1")
+	require.NoError(t, err)
+	assert.Equal(t, &Response{
+		StatusCode: http.StatusCreated,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       done.Body,
+	}, stored)
+
+	_, err = store.Begin(context.Background(), "done", "h2")
+	assert.ErrorIs(t, err, ErrKeyReused)
+
+	_, err = store.Begin(context.Background(), "pending", "h1")
+	assert.ErrorIs(t, err, ErrInFlight)
+}
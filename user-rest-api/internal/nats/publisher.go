@@ -2,26 +2,22 @@ package nats
 
 import (
 	"github.com/nats-io/nats.go"
-	"log"
 )
 
 var nc *nats.Conn
 
+// InitNATS connects to NATS. Connection failures at startup are retried in the
+// background, so the outbox relay can start before NATS is up.
 func InitNATS(url string) error {
 	var err error
-	nc, err = nats.Connect(url)
+	nc, err = nats.Connect(url, nats.RetryOnFailedConnect(true), nats.MaxReconnects(-1))
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func Publish(subject string, data []byte) {
-	if nc == nil {
-		log.Println("NATS connection not initialized")
-		return
-	}
-	if err := nc.Publish(subject, data); err != nil {
-		log.Printf("Failed to publish to NATS subject %s: %v\n", subject, err)
-	}
+// Conn returns the connection opened by InitNATS, or nil.
+func Conn() *nats.Conn {
+	return nc
 }
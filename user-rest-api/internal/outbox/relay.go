@@ -0,0 +1,121 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/repository"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	StreamName = "USERS"
+	maxBackoff = 30 * time.Second
+)
+
+// Publisher is the part of jetstream.JetStream used by the relay.
+type Publisher interface {
+	PublishMsg(ctx context.Context, msg *nats.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error)
+}
+
+// EnsureStream creates or updates the stream that stores user change events.
+// Its duplicate window makes republishing an event after a crash harmless.
+func EnsureStream(ctx context.Context, js jetstream.JetStream) error {
+	_, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:       StreamName,
+		Subjects:   []string{"users.>"},
+		Duplicates: 10 * time.Minute,
+	})
+	return err
+}
+
+// Relay publishes outbox events in the order they were written. Each event is
+// published with its outbox ID as Nats-Msg-Id, so an event that was published
+// but not marked before a crash is dropped by JetStream when it is retried.
+type Relay struct {
+	repo         repository.UserRepository
+	js           Publisher
+	pollInterval time.Duration
+	batchSize    int32
+}
+
+func NewRelay(repo repository.UserRepository, js Publisher) *Relay {
+	return &Relay{repo: repo, js: js, pollInterval: time.Second, batchSize: 100}
+}
+
+func (r *Relay) SetPollInterval(d time.Duration) {
+	if d > 0 {
+		r.pollInterval = d
+	}
+}
+
+func (r *Relay) SetBatchSize(n int) {
+	if n > 0 {
+		r.batchSize = int32(n)
+	}
+}
+
+// Run relays events until ctx is done. Failures are retried with exponential
+// backoff.
+func (r *Relay) Run(ctx context.Context) {
+	backoff := r.pollInterval
+	for {
+		n, err := r.PublishBatch(ctx)
+		wait := r.pollInterval
+		switch {
+		case err != nil:
+			log.Printf("Outbox relay failed: %v (retrying in %s)\n", err, backoff)
+			wait = backoff
+			backoff = min(2*backoff, maxBackoff)
+		case n == int(r.batchSize):
+			backoff = r.pollInterval
+			wait = 0 // more events are probably waiting
+		default:
+			backoff = r.pollInterval
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// PublishBatch claims up to one batch of unpublished events and publishes
+// them. It stops at the first failure so that later events are not published
+// ahead of it, and returns the number of events published.
+func (r *Relay) PublishBatch(ctx context.Context) (int, error) {
+	var published int
+	var publishErr error
+	err := r.repo.WithTx(ctx, func(repo repository.UserRepository) error {
+		events, err := repo.ClaimOutboxEvents(ctx, r.batchSize)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			msg := &nats.Msg{Subject: event.Subject, Data: event.Payload}
+			if _, publishErr = r.js.PublishMsg(ctx, msg, jetstream.WithMsgID(event.ID.String())); publishErr != nil {
+				// The failure is recorded in the same transaction as the
+				// events published before it.
+				return repo.MarkOutboxFailed(ctx, db.MarkOutboxFailedParams{
+					ID:        event.ID,
+					LastError: sql.NullString{String: publishErr.Error(), Valid: true},
+				})
+			}
+			if err := repo.MarkOutboxPublished(ctx, event.ID); err != nil {
+				return err
+			}
+			published++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Join(err, publishErr)
+	}
+	return published, publishErr
+}
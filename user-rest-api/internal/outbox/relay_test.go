@@ -0,0 +1,86 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/repository"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/repository/mocks"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type fakePublisher struct {
+	msgs   []*nats.Msg
+	failAt int // 1-based index of the publish that fails, 0 for none
+}
+
+func (p *fakePublisher) PublishMsg(_ context.Context, msg *nats.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	if len(p.msgs)+1 == p.failAt {
+		return nil, errors.New("nats down")
+	}
+	if len(opts) != 1 {
+		return nil, errors.New("expected a message ID option")
+	}
+	p.msgs = append(p.msgs, msg)
+	return &jetstream.PubAck{}, nil
+}
+
+func newRepo() *mocks.MockUserRepository {
+	repo := new(mocks.MockUserRepository)
+	repo.On("WithTx", mock.Anything, mock.Anything).
+		Return(func(ctx context.Context, fn func(repository.UserRepository) error) error {
+			return fn(repo)
+		})
+	return repo
+}
+
+func event(subject string) db.Outbox {
+	return db.Outbox{ID: uuid.New(), Subject: subject, Payload: json.RawMessage(`{}`)}
+}
+
+func TestPublishBatch_PublishesInOrderAndMarks(t *testing.T) {
+	repo := newRepo()
+	events := []db.Outbox{event("users.created"), event("users.updated"), event("users.deleted")}
+	repo.On("ClaimOutboxEvents", mock.Anything, int32(100)).Return(events, nil)
+	for _, e := range events {
+		repo.On("MarkOutboxPublished", mock.Anything, e.ID).Return(nil).Once()
+	}
+	pub := &fakePublisher{}
+
+	n, err := NewRelay(repo, pub).PublishBatch(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	if assert.Len(t, pub.msgs, 3) {
+		for i, e := range events {
+			assert.Equal(t, e.Subject, pub.msgs[i].Subject)
+		}
+	}
+	repo.AssertExpectations(t)
+}
+
+func TestPublishBatch_StopsAtFirstFailure(t *testing.T) {
+	repo := newRepo()
+	events := []db.Outbox{event("users.created"), event("users.updated"), event("users.deleted")}
+	repo.On("ClaimOutboxEvents", mock.Anything, int32(100)).Return(events, nil)
+	repo.On("MarkOutboxPublished", mock.Anything, events[0].ID).Return(nil).Once()
+	repo.On("MarkOutboxFailed", mock.Anything, mock.MatchedBy(func(arg db.MarkOutboxFailedParams) bool {
+		return arg.ID == events[1].ID && arg.LastError.String == "nats down"
+	})).Return(nil).Once()
+	pub := &fakePublisher{failAt: 2}
+
+	n, err := NewRelay(repo, pub).PublishBatch(context.Background())
+
+	assert.EqualError(t, err, "nats down")
+	assert.Equal(t, 1, n)
+	assert.Len(t, pub.msgs, 1)
+	repo.AssertNotCalled(t, "MarkOutboxPublished", mock.Anything, events[2].ID)
+	repo.AssertExpectations(t)
+}
@@ -0,0 +1,46 @@
+//go:generate mockery --name IdempotencyRepository --structname MockIdempotencyRepository --output ./mocks --case underscore
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
+)
+
+type IdempotencyRepository interface {
+	ClaimIdempotencyKey(ctx context.Context, arg db.ClaimIdempotencyKeyParams) (db.IdempotencyKey, error)
+	GetIdempotencyKey(ctx context.Context, key string) (db.IdempotencyKey, error)
+	SaveIdempotentResponse(ctx context.Context, arg db.SaveIdempotentResponseParams) error
+	DeleteIdempotencyKey(ctx context.Context, key string) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) (int64, error)
+}
+
+type PostgresIdempotencyRepository struct {
+	q *db.Queries
+}
+
+func NewPostgresIdempotencyRepository(conn *sql.DB) *PostgresIdempotencyRepository {
+	return &PostgresIdempotencyRepository{q: db.New(conn)}
+}
+
+func (r *PostgresIdempotencyRepository) ClaimIdempotencyKey(ctx context.Context, arg db.ClaimIdempotencyKeyParams) (db.IdempotencyKey, error) {
+	return r.q.ClaimIdempotencyKey(ctx, arg)
+}
+
+func (r *PostgresIdempotencyRepository) GetIdempotencyKey(ctx context.Context, key string) (db.IdempotencyKey, error) {
+	return r.q.GetIdempotencyKey(ctx, key)
+}
+
+func (r *PostgresIdempotencyRepository) SaveIdempotentResponse(ctx context.Context, arg db.SaveIdempotentResponseParams) error {
+	return r.q.SaveIdempotentResponse(ctx, arg)
+}
+
+func (r *PostgresIdempotencyRepository) DeleteIdempotencyKey(ctx context.Context, key string) error {
+	return r.q.DeleteIdempotencyKey(ctx, key)
+}
+
+func (r *PostgresIdempotencyRepository) DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	return r.q.DeleteExpiredIdempotencyKeys(ctx, before)
+}
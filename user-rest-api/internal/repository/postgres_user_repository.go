@@ -3,16 +3,20 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
 )
 
 type PostgresUserRepository struct {
-	q *db.Queries
+	conn *sql.DB // nil when bound to a transaction
+	q    *db.Queries
 }
 
-func NewPostgresUserRepository(q *db.Queries) *PostgresUserRepository {
-	return &PostgresUserRepository{q: q}
+func NewPostgresUserRepository(conn *sql.DB) *PostgresUserRepository {
+	return &PostgresUserRepository{conn: conn, q: db.New(conn)}
 }
 
 func (r *PostgresUserRepository) CreateUser(ctx context.Context, arg db.CreateUserParams) (db.User, error) {
@@ -23,14 +27,154 @@ func (r *PostgresUserRepository) UpdateUser(ctx context.Context, arg db.UpdateUs
 	return r.q.UpdateUser(ctx, arg)
 }
 
-func (r *PostgresUserRepository) DeleteUser(ctx context.Context, userID uuid.UUID) error {
-	return r.q.DeleteUser(ctx, userID)
+func (r *PostgresUserRepository) UpdateUserStatus(ctx context.Context, arg db.UpdateUserStatusParams) (db.User, error) {
+	return r.q.UpdateUserStatus(ctx, arg)
+}
+
+func (r *PostgresUserRepository) SoftDeleteUser(ctx context.Context, userID uuid.UUID) (db.User, error) {
+	return r.q.SoftDeleteUser(ctx, userID)
+}
+
+func (r *PostgresUserRepository) RestoreUser(ctx context.Context, userID uuid.UUID) (db.User, error) {
+	return r.q.RestoreUser(ctx, userID)
+}
+
+func (r *PostgresUserRepository) PurgeDeletedUsers(ctx context.Context, arg db.PurgeDeletedUsersParams) ([]uuid.UUID, error) {
+	return r.q.PurgeDeletedUsers(ctx, arg)
+}
+
+func (r *PostgresUserRepository) EraseUser(ctx context.Context, userID uuid.UUID) (db.User, error) {
+	return r.q.EraseUser(ctx, userID)
+}
+
+func (r *PostgresUserRepository) ScrubUserData(ctx context.Context, userID uuid.UUID) error {
+	return r.q.ScrubUserData(ctx, userID)
+}
+
+func (r *PostgresUserRepository) ListUsersWithEventsNotErased(ctx context.Context, limit int32) ([]uuid.UUID, error) {
+	return r.q.ListUsersWithEventsNotErased(ctx, limit)
+}
+
+func (r *PostgresUserRepository) MarkEventsErased(ctx context.Context, userID uuid.UUID) error {
+	return r.q.MarkEventsErased(ctx, userID)
+}
+
+func (r *PostgresUserRepository) GetUserIncludingDeleted(ctx context.Context, userID uuid.UUID) (db.User, error) {
+	return r.q.GetUserIncludingDeleted(ctx, userID)
 }
 
 func (r *PostgresUserRepository) GetUser(ctx context.Context, userID uuid.UUID) (db.User, error) {
 	return r.q.GetUser(ctx, userID)
 }
 
-func (r *PostgresUserRepository) ListUsers(ctx context.Context) ([]db.User, error) {
-	return r.q.ListUsers(ctx)
+func (r *PostgresUserRepository) GetUserForUpdate(ctx context.Context, userID uuid.UUID) (db.User, error) {
+	return r.q.GetUserForUpdate(ctx, userID)
+}
+
+func (r *PostgresUserRepository) ListUsers(ctx context.Context, arg ListUsersParams) ([]db.User, error) {
+	// The queries of a key type share their parameters.
+	byTime := db.ListUsersByCreatedAtParams{
+		IncludeDeleted: arg.IncludeDeleted,
+		Status:         arg.Status,
+		MinAge:         arg.MinAge,
+		MaxAge:         arg.MaxAge,
+		EmailPrefix:    arg.EmailPrefix,
+		NamePrefix:     arg.NamePrefix,
+		AfterID:        arg.AfterID,
+		AfterKey:       sql.NullTime{Time: arg.AfterTime, Valid: arg.AfterID.Valid},
+		PageSize:       arg.PageSize,
+	}
+	byText := db.ListUsersByEmailParams{
+		IncludeDeleted: arg.IncludeDeleted,
+		Status:         arg.Status,
+		MinAge:         arg.MinAge,
+		MaxAge:         arg.MaxAge,
+		EmailPrefix:    arg.EmailPrefix,
+		NamePrefix:     arg.NamePrefix,
+		AfterID:        arg.AfterID,
+		AfterKey:       sql.NullString{String: arg.AfterText, Valid: arg.AfterID.Valid},
+		PageSize:       arg.PageSize,
+	}
+	byAge := db.ListUsersByAgeParams{
+		IncludeDeleted: arg.IncludeDeleted,
+		Status:         arg.Status,
+		MinAge:         arg.MinAge,
+		MaxAge:         arg.MaxAge,
+		EmailPrefix:    arg.EmailPrefix,
+		NamePrefix:     arg.NamePrefix,
+		AfterID:        arg.AfterID,
+		AfterKey:       sql.NullInt32{Int32: arg.AfterAge, Valid: arg.AfterID.Valid},
+		PageSize:       arg.PageSize,
+	}
+	switch {
+	case arg.SortBy == "created_at" && arg.Descending:
+		return r.q.ListUsersByCreatedAtDesc(ctx, db.ListUsersByCreatedAtDescParams(byTime))
+	case arg.SortBy == "created_at":
+		return r.q.ListUsersByCreatedAt(ctx, byTime)
+	case arg.SortBy == "email" && arg.Descending:
+		return r.q.ListUsersByEmailDesc(ctx, db.ListUsersByEmailDescParams(byText))
+	case arg.SortBy == "email":
+		return r.q.ListUsersByEmail(ctx, byText)
+	case arg.SortBy == "first_name" && arg.Descending:
+		return r.q.ListUsersByFirstNameDesc(ctx, db.ListUsersByFirstNameDescParams(byText))
+	case arg.SortBy == "first_name":
+		return r.q.ListUsersByFirstName(ctx, db.ListUsersByFirstNameParams(byText))
+	case arg.SortBy == "last_name" && arg.Descending:
+		return r.q.ListUsersByLastNameDesc(ctx, db.ListUsersByLastNameDescParams(byText))
+	case arg.SortBy == "last_name":
+		return r.q.ListUsersByLastName(ctx, db.ListUsersByLastNameParams(byText))
+	case arg.SortBy == "age" && arg.Descending:
+		return r.q.ListUsersByAgeDesc(ctx, db.ListUsersByAgeDescParams(byAge))
+	case arg.SortBy == "age":
+		return r.q.ListUsersByAge(ctx, byAge)
+	}
+	return nil, fmt.Errorf("cannot sort users by %q", arg.SortBy)
+}
+
+func (r *PostgresUserRepository) CountUsers(ctx context.Context, arg db.CountUsersParams) (int64, error) {
+	return r.q.CountUsers(ctx, arg)
+}
+
+func (r *PostgresUserRepository) SearchUsers(ctx context.Context, arg db.SearchUsersParams) ([]db.SearchUsersRow, error) {
+	return r.q.SearchUsers(ctx, arg)
+}
+
+func (r *PostgresUserRepository) InsertUserAudit(ctx context.Context, arg db.InsertUserAuditParams) error {
+	return r.q.InsertUserAudit(ctx, arg)
+}
+
+func (r *PostgresUserRepository) ListUserAudit(ctx context.Context, arg db.ListUserAuditParams) ([]db.UserAudit, error) {
+	return r.q.ListUserAudit(ctx, arg)
+}
+
+func (r *PostgresUserRepository) InsertOutboxEvent(ctx context.Context, arg db.InsertOutboxEventParams) (db.Outbox, error) {
+	return r.q.InsertOutboxEvent(ctx, arg)
+}
+
+func (r *PostgresUserRepository) ClaimOutboxEvents(ctx context.Context, limit int32) ([]db.Outbox, error) {
+	return r.q.ClaimOutboxEvents(ctx, limit)
+}
+
+func (r *PostgresUserRepository) MarkOutboxPublished(ctx context.Context, id uuid.UUID) error {
+	return r.q.MarkOutboxPublished(ctx, id)
+}
+
+func (r *PostgresUserRepository) MarkOutboxFailed(ctx context.Context, arg db.MarkOutboxFailedParams) error {
+	return r.q.MarkOutboxFailed(ctx, arg)
+}
+
+// WithTx runs fn in a transaction. Calls on a repository that is already bound
+// to a transaction join it.
+func (r *PostgresUserRepository) WithTx(ctx context.Context, fn func(repo UserRepository) error) error {
+	if r.conn == nil {
+		return fn(r)
+	}
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(&PostgresUserRepository{q: r.q.WithTx(tx)}); err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	return tx.Commit()
 }
@@ -3,6 +3,9 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
 )
@@ -10,7 +13,64 @@ import (
 type UserRepository interface {
 	CreateUser(ctx context.Context, arg db.CreateUserParams) (db.User, error)
 	UpdateUser(ctx context.Context, arg db.UpdateUserParams) (db.User, error)
-	DeleteUser(ctx context.Context, userID uuid.UUID) error
+	UpdateUserStatus(ctx context.Context, arg db.UpdateUserStatusParams) (db.User, error)
+	SoftDeleteUser(ctx context.Context, userID uuid.UUID) (db.User, error)
+	RestoreUser(ctx context.Context, userID uuid.UUID) (db.User, error)
+	// PurgeDeletedUsers anonymises the users deleted before arg.DeletedBefore,
+	// audits it and returns them. Their other data is scrubbed separately.
+	PurgeDeletedUsers(ctx context.Context, arg db.PurgeDeletedUsersParams) ([]uuid.UUID, error)
+	// EraseUser pseudonymises a user and closes it.
+	EraseUser(ctx context.Context, userID uuid.UUID) (db.User, error)
+	// ScrubUserData removes the personal data of a user from its audit
+	// entries, from the events about it kept in the database and from the
+	// stored responses of idempotent requests.
+	ScrubUserData(ctx context.Context, userID uuid.UUID) error
+	// ListUsersWithEventsNotErased returns up to limit erased users whose
+	// events outside the database are not erased yet.
+	ListUsersWithEventsNotErased(ctx context.Context, limit int32) ([]uuid.UUID, error)
+	// MarkEventsErased records that the events of a user are erased outside
+	// the database.
+	MarkEventsErased(ctx context.Context, userID uuid.UUID) error
 	GetUser(ctx context.Context, userID uuid.UUID) (db.User, error)
-	ListUsers(ctx context.Context) ([]db.User, error)
+	// GetUserIncludingDeleted is GetUser that also returns deleted users.
+	GetUserIncludingDeleted(ctx context.Context, userID uuid.UUID) (db.User, error)
+	// GetUserForUpdate is GetUser that also locks the user until the end of
+	// the transaction. Unlike GetUser it returns deleted users.
+	GetUserForUpdate(ctx context.Context, userID uuid.UUID) (db.User, error)
+	// ListUsers runs the ListUsersBy query of the sort column and direction.
+	ListUsers(ctx context.Context, arg ListUsersParams) ([]db.User, error)
+	CountUsers(ctx context.Context, arg db.CountUsersParams) (int64, error)
+	SearchUsers(ctx context.Context, arg db.SearchUsersParams) ([]db.SearchUsersRow, error)
+
+	InsertUserAudit(ctx context.Context, arg db.InsertUserAuditParams) error
+	ListUserAudit(ctx context.Context, arg db.ListUserAuditParams) ([]db.UserAudit, error)
+
+	InsertOutboxEvent(ctx context.Context, arg db.InsertOutboxEventParams) (db.Outbox, error)
+	ClaimOutboxEvents(ctx context.Context, limit int32) ([]db.Outbox, error)
+	MarkOutboxPublished(ctx context.Context, id uuid.UUID) error
+	MarkOutboxFailed(ctx context.Context, arg db.MarkOutboxFailedParams) error
+
+	// WithTx runs fn with a repository bound to a single transaction, which is
+	// committed if fn returns nil and rolled back otherwise.
+	WithTx(ctx context.Context, fn func(repo UserRepository) error) error
+}
+
+// ListUsersParams selects a page of users sorted by SortBy: created_at,
+// email, first_name, last_name or age. When AfterID is valid the page starts
+// after that user, whose value of the sort column is in the After field of
+// the column's type.
+type ListUsersParams struct {
+	SortBy         string
+	Descending     bool
+	IncludeDeleted bool
+	Status         sql.NullString
+	MinAge         sql.NullInt32
+	MaxAge         sql.NullInt32
+	EmailPrefix    sql.NullString
+	NamePrefix     sql.NullString
+	AfterID        uuid.NullUUID
+	AfterTime      time.Time // created_at
+	AfterText      string    // email, first or last name
+	AfterAge       int32     // age, 0 if unknown
+	PageSize       int32
 }
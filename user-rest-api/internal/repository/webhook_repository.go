@@ -0,0 +1,94 @@
+//go:generate mockery --name WebhookRepository --structname MockWebhookRepository --output ./mocks --case underscore
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
+)
+
+type WebhookRepository interface {
+	CreateWebhookSubscription(ctx context.Context, arg db.CreateWebhookSubscriptionParams) (db.WebhookSubscription, error)
+	GetWebhookSubscription(ctx context.Context, id uuid.UUID) (db.WebhookSubscription, error)
+	ListWebhookSubscriptions(ctx context.Context) ([]db.WebhookSubscription, error)
+	DeleteWebhookSubscription(ctx context.Context, id uuid.UUID) (int64, error)
+
+	EnqueueWebhookDeliveries(ctx context.Context, arg db.EnqueueWebhookDeliveriesParams) (int64, error)
+	ClaimWebhookDeliveries(ctx context.Context, arg db.ClaimWebhookDeliveriesParams) ([]db.ClaimWebhookDeliveriesRow, error)
+	MarkWebhookDelivered(ctx context.Context, id uuid.UUID) error
+	MarkWebhookFailed(ctx context.Context, arg db.MarkWebhookFailedParams) error
+	ListDeadWebhookDeliveries(ctx context.Context, limit int32) ([]db.WebhookDelivery, error)
+	RetryWebhookDelivery(ctx context.Context, id uuid.UUID) (int64, error)
+
+	// WithTx runs fn with a repository bound to a single transaction, which is
+	// committed if fn returns nil and rolled back otherwise.
+	WithTx(ctx context.Context, fn func(repo WebhookRepository) error) error
+}
+
+type PostgresWebhookRepository struct {
+	conn *sql.DB // nil when bound to a transaction
+	q    *db.Queries
+}
+
+func NewPostgresWebhookRepository(conn *sql.DB) *PostgresWebhookRepository {
+	return &PostgresWebhookRepository{conn: conn, q: db.New(conn)}
+}
+
+func (r *PostgresWebhookRepository) CreateWebhookSubscription(ctx context.Context, arg db.CreateWebhookSubscriptionParams) (db.WebhookSubscription, error) {
+	return r.q.CreateWebhookSubscription(ctx, arg)
+}
+
+func (r *PostgresWebhookRepository) GetWebhookSubscription(ctx context.Context, id uuid.UUID) (db.WebhookSubscription, error) {
+	return r.q.GetWebhookSubscription(ctx, id)
+}
+
+func (r *PostgresWebhookRepository) ListWebhookSubscriptions(ctx context.Context) ([]db.WebhookSubscription, error) {
+	return r.q.ListWebhookSubscriptions(ctx)
+}
+
+func (r *PostgresWebhookRepository) DeleteWebhookSubscription(ctx context.Context, id uuid.UUID) (int64, error) {
+	return r.q.DeleteWebhookSubscription(ctx, id)
+}
+
+func (r *PostgresWebhookRepository) EnqueueWebhookDeliveries(ctx context.Context, arg db.EnqueueWebhookDeliveriesParams) (int64, error) {
+	return r.q.EnqueueWebhookDeliveries(ctx, arg)
+}
+
+func (r *PostgresWebhookRepository) ClaimWebhookDeliveries(ctx context.Context, arg db.ClaimWebhookDeliveriesParams) ([]db.ClaimWebhookDeliveriesRow, error) {
+	return r.q.ClaimWebhookDeliveries(ctx, arg)
+}
+
+func (r *PostgresWebhookRepository) MarkWebhookDelivered(ctx context.Context, id uuid.UUID) error {
+	return r.q.MarkWebhookDelivered(ctx, id)
+}
+
+func (r *PostgresWebhookRepository) MarkWebhookFailed(ctx context.Context, arg db.MarkWebhookFailedParams) error {
+	return r.q.MarkWebhookFailed(ctx, arg)
+}
+
+func (r *PostgresWebhookRepository) ListDeadWebhookDeliveries(ctx context.Context, limit int32) ([]db.WebhookDelivery, error) {
+	return r.q.ListDeadWebhookDeliveries(ctx, limit)
+}
+
+func (r *PostgresWebhookRepository) RetryWebhookDelivery(ctx context.Context, id uuid.UUID) (int64, error) {
+	return r.q.RetryWebhookDelivery(ctx, id)
+}
+
+// WithTx runs fn in a transaction. Calls on a repository that is already bound
+// to a transaction join it.
+func (r *PostgresWebhookRepository) WithTx(ctx context.Context, fn func(repo WebhookRepository) error) error {
+	if r.conn == nil {
+		return fn(r)
+	}
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(&PostgresWebhookRepository{q: r.q.WithTx(tx)}); err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,151 @@
+// Package streams reads and erases the data about users kept in JetStream
+// rather than in the database: the orders and trades the WebSocket API's
+// matching engine publishes, and the user change events.
+package streams
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/outbox"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Streams of the WebSocket API's matching engine. Order events are published
+// to a subject per user and trades to a subject per asset.
+const (
+	OrdersStream = "ORDERS"
+	TradesStream = "TRADES"
+)
+
+const (
+	fetchSize = 500
+	// fetchWait bounds how long a read waits for messages that are not
+	// there, which is how it notices that no message matches.
+	fetchWait = time.Second
+)
+
+// UserData reads the messages about a user from JetStream. Reads go through
+// the whole stream when messages are not filed by user, so they are meant for
+// rare requests like data exports.
+type UserData struct {
+	js jetstream.JetStream
+}
+
+func NewUserData(js jetstream.JetStream) *UserData {
+	return &UserData{js: js}
+}
+
+// Orders returns the order events of a user, oldest first.
+func (d *UserData) Orders(ctx context.Context, userID uuid.UUID) ([]json.RawMessage, error) {
+	var orders []json.RawMessage
+	err := d.read(ctx, OrdersStream, "orders."+userID.String(), func(msg jetstream.Msg) error {
+		orders = append(orders, json.RawMessage(msg.Data()))
+		return nil
+	})
+	return orders, err
+}
+
+// Trades returns the trades a user bought or sold in, oldest first.
+func (d *UserData) Trades(ctx context.Context, userID uuid.UUID) ([]json.RawMessage, error) {
+	var trades []json.RawMessage
+	err := d.read(ctx, TradesStream, "trades.>", func(msg jetstream.Msg) error {
+		if isParty(msg.Data(), userID.String()) {
+			trades = append(trades, json.RawMessage(msg.Data()))
+		}
+		return nil
+	})
+	return trades, err
+}
+
+// EraseEvents deletes the change events about a user from the stream the
+// outbox relay publishes to, overwriting them, and returns how many it
+// deleted. Trades and orders are financial records and are kept; they only
+// hold the ID of the user.
+func (d *UserData) EraseEvents(ctx context.Context, userID uuid.UUID) (int, error) {
+	var seqs []uint64
+	err := d.read(ctx, outbox.StreamName, "users.>", func(msg jetstream.Msg) error {
+		if isAbout(msg.Data(), userID.String()) {
+			meta, err := msg.Metadata()
+			if err != nil {
+				return err
+			}
+			seqs = append(seqs, meta.Sequence.Stream)
+		}
+		return nil
+	})
+	if err != nil || len(seqs) == 0 {
+		return 0, err
+	}
+	stream, err := d.js.Stream(ctx, outbox.StreamName)
+	if err != nil {
+		return 0, err
+	}
+	for i, seq := range seqs {
+		// The message may have been removed by the retention of the stream
+		// since it was read.
+		if err := stream.SecureDeleteMsg(ctx, seq); err != nil && !errors.Is(err, jetstream.ErrMsgNotFound) {
+			return i, err
+		}
+	}
+	return len(seqs), nil
+}
+
+// read calls fn with every message of stream on subject, oldest first. A
+// stream that does not exist has no messages.
+func (d *UserData) read(ctx context.Context, stream, subject string, fn func(jetstream.Msg) error) error {
+	consumer, err := d.js.OrderedConsumer(ctx, stream, jetstream.OrderedConsumerConfig{FilterSubjects: []string{subject}})
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for ctx.Err() == nil {
+		batch, err := consumer.Fetch(fetchSize, jetstream.FetchMaxWait(fetchWait))
+		if err != nil {
+			return err
+		}
+		n := 0
+		for msg := range batch.Messages() {
+			n++
+			if err := fn(msg); err != nil {
+				return err
+			}
+			meta, err := msg.Metadata()
+			if err != nil {
+				return err
+			}
+			if meta.NumPending == 0 {
+				return nil
+			}
+		}
+		if err := batch.Error(); err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
+
+// isParty reports whether userID bought or sold in a trade.
+func isParty(trade []byte, userID string) bool {
+	var t struct {
+		BuyerID  string `json:"buyer_id"`
+		SellerID string `json:"seller_id"`
+	}
+	return json.Unmarshal(trade, &t) == nil && (t.BuyerID == userID || t.SellerID == userID)
+}
+
+// isAbout reports whether a user change event is about userID.
+func isAbout(event []byte, userID string) bool {
+	var e struct {
+		Subject string `json:"subject"`
+	}
+	return json.Unmarshal(event, &e) == nil && e.Subject == userID
+}
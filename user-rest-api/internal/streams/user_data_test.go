@@ -0,0 +1,30 @@
+package streams
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsParty(t *testing.T) {
+	trade := []byte(`{"id":"BTC-T1","buyer_id":"u1","seller_id":"u2","Price":100}`)
+
+	assert.True(t, isParty(trade, "u1"))
+	assert.True(t, isParty(trade, "u2"))
+	assert.False(t, isParty(trade, "u3"))
+	assert.False(t, isParty([]byte("not json"), "u1"))
+}
+
+func TestIsAbout_MatchesEventSubject(t *testing.T) {
+	e, err := events.NewUserEvent(context.Background(), events.TypeUserUpdated, events.SourceRESTAPI, "u1", nil, map[string]string{"user_id": "u1"})
+	require.NoError(t, err)
+	data, err := json.Marshal(e)
+	require.NoError(t, err)
+
+	assert.True(t, isAbout(data, "u1"))
+	assert.False(t, isAbout(data, "u2"))
+}
@@ -1,6 +1,9 @@
 package internal
 
 import (
+	"reflect"
+	"strings"
+
 	"github.com/go-playground/validator/v10"
 )
 
@@ -8,4 +11,12 @@ var Validate *validator.Validate
 
 func InitValidator() {
 	Validate = validator.New()
+	// Report fields by their JSON names, as clients know them.
+	Validate.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
 }
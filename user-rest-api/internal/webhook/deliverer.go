@@ -0,0 +1,178 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/repository"
+)
+
+// Headers of a delivery. The signature is
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>" keyed with the secret>".
+const (
+	HeaderID        = "Webhook-ID"
+	HeaderSignature = "Webhook-Signature"
+)
+
+const maxBackoff = time.Hour
+
+// Sign returns the value of the signature header for body sent at t.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Deliverer posts due deliveries to their subscriptions. A failed delivery is
+// retried with exponential backoff and becomes a dead letter after
+// maxAttempts attempts.
+type Deliverer struct {
+	repo         repository.WebhookRepository
+	client       *http.Client
+	pollInterval time.Duration
+	batchSize    int32
+	maxAttempts  int32
+	baseBackoff  time.Duration
+}
+
+func NewDeliverer(repo repository.WebhookRepository) *Deliverer {
+	return &Deliverer{
+		repo:         repo,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		pollInterval: time.Second,
+		batchSize:    20,
+		maxAttempts:  8,
+		baseBackoff:  10 * time.Second,
+	}
+}
+
+func (d *Deliverer) SetPollInterval(interval time.Duration) {
+	if interval > 0 {
+		d.pollInterval = interval
+	}
+}
+
+func (d *Deliverer) SetBatchSize(n int) {
+	if n > 0 {
+		d.batchSize = int32(n)
+	}
+}
+
+func (d *Deliverer) SetMaxAttempts(n int) {
+	if n > 0 {
+		d.maxAttempts = int32(n)
+	}
+}
+
+// SetBackoff sets the delay before the first retry; it doubles with every
+// further attempt, up to an hour.
+func (d *Deliverer) SetBackoff(base time.Duration) {
+	if base > 0 {
+		d.baseBackoff = base
+	}
+}
+
+func (d *Deliverer) SetTimeout(timeout time.Duration) {
+	if timeout > 0 {
+		d.client.Timeout = timeout
+	}
+}
+
+// Run delivers until ctx is done.
+func (d *Deliverer) Run(ctx context.Context) {
+	for {
+		n, err := d.DeliverBatch(ctx)
+		if err != nil {
+			log.Printf("Webhook delivery failed: %v\n", err)
+		}
+		wait := d.pollInterval
+		if err == nil && n == int(d.batchSize) {
+			wait = 0 // more deliveries are probably due
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// DeliverBatch attempts up to one batch of due deliveries and returns how many
+// it attempted. The batch is leased in one statement and posted outside any
+// transaction, so a slow subscription holds no locks; each result is then
+// recorded on its own. The lease covers posting the whole batch, so other
+// workers only take a delivery again if this one dies.
+func (d *Deliverer) DeliverBatch(ctx context.Context) (int, error) {
+	lease := time.Duration(d.batchSize)*d.client.Timeout + time.Minute
+	due, err := d.repo.ClaimWebhookDeliveries(ctx, db.ClaimWebhookDeliveriesParams{
+		LeaseUntil: time.Now().Add(lease),
+		BatchSize:  d.batchSize,
+	})
+	if err != nil {
+		return 0, err
+	}
+	var errs []error
+	for _, delivery := range due {
+		if err := d.deliver(ctx, delivery); err != nil {
+			errs = append(errs, fmt.Errorf("recording delivery %s: %w", delivery.ID, err))
+		}
+	}
+	return len(due), errors.Join(errs...)
+}
+
+func (d *Deliverer) deliver(ctx context.Context, delivery db.ClaimWebhookDeliveriesRow) error {
+	postErr := d.post(ctx, delivery)
+	if postErr == nil {
+		return d.repo.MarkWebhookDelivered(ctx, delivery.ID)
+	}
+	attempt := delivery.Attempts + 1
+	arg := db.MarkWebhookFailedParams{ID: delivery.ID, LastError: sql.NullString{String: postErr.Error(), Valid: true}}
+	if attempt < d.maxAttempts {
+		arg.NextAttemptAt = sql.NullTime{Time: time.Now().Add(d.backoff(attempt)), Valid: true}
+	} else {
+		log.Printf("Webhook delivery %s is dead after %d attempts: %v\n", delivery.ID, attempt, postErr)
+	}
+	return d.repo.MarkWebhookFailed(ctx, arg)
+}
+
+func (d *Deliverer) backoff(attempt int32) time.Duration {
+	backoff := d.baseBackoff
+	for i := int32(1); i < attempt && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxBackoff)
+}
+
+func (d *Deliverer) post(ctx context.Context, delivery db.ClaimWebhookDeliveriesRow) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+	req.Header.Set(HeaderID, delivery.ID.String())
+	req.Header.Set(HeaderSignature, Sign(delivery.Secret, time.Now(), delivery.Payload))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/repository/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newRepo returns a repository without WithTx: deliveries must not be posted
+// inside a transaction.
+func newRepo() *mocks.MockWebhookRepository {
+	return new(mocks.MockWebhookRepository)
+}
+
+// leased matches a claim of the default batch leased for at least as long as
+// posting all of it may take.
+func leased() any {
+	return mock.MatchedBy(func(arg db.ClaimWebhookDeliveriesParams) bool {
+		return arg.BatchSize == 20 && arg.LeaseUntil.After(time.Now().Add(20*10*time.Second))
+	})
+}
+
+func dueDelivery(url string, attempts int32) db.ClaimWebhookDeliveriesRow {
+	return db.ClaimWebhookDeliveriesRow{
+		ID:       uuid.New(),
+		Payload:  []byte(`{"specversion":"1.0"}`),
+		Attempts: attempts,
+		Url:      url,
+		Secret:   "s3cret",
+	}
+}
+
+func TestDeliverBatch_SignsAndMarksDelivered(t *testing.T) {
+	var gotSignature, gotID string
+	var gotBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSignature = r.Header.Get(HeaderSignature)
+		gotID = r.Header.Get(HeaderID)
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	repo := newRepo()
+	d := dueDelivery(server.URL, 0)
+	repo.On("ClaimWebhookDeliveries", mock.Anything, leased()).Return([]db.ClaimWebhookDeliveriesRow{d}, nil)
+	repo.On("MarkWebhookDelivered", mock.Anything, d.ID).Return(nil).Once()
+
+	n, err := NewDeliverer(repo).DeliverBatch(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, d.ID.String(), gotID)
+	assert.Equal(t, string(d.Payload), string(gotBody))
+	ts, _, ok := strings.Cut(strings.TrimPrefix(gotSignature, "t="), ",")
+	require.True(t, ok, gotSignature)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	require.NoError(t, err)
+	assert.Equal(t, Sign("s3cret", time.Unix(sec, 0), gotBody), gotSignature)
+	repo.AssertExpectations(t)
+}
+
+func TestDeliverBatch_SchedulesRetryWithBackoff(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	repo := newRepo()
+	d := dueDelivery(server.URL, 2)
+	repo.On("ClaimWebhookDeliveries", mock.Anything, leased()).Return([]db.ClaimWebhookDeliveriesRow{d}, nil)
+	var arg db.MarkWebhookFailedParams
+	repo.On("MarkWebhookFailed", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { arg = args.Get(1).(db.MarkWebhookFailedParams) }).
+		Return(nil).Once()
+
+	before := time.Now()
+	_, err := NewDeliverer(repo).DeliverBatch(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, d.ID, arg.ID)
+	assert.Contains(t, arg.LastError.String, "500")
+	require.True(t, arg.NextAttemptAt.Valid)
+	// Third attempt: 10s doubled twice.
+	assert.WithinDuration(t, before.Add(40*time.Second), arg.NextAttemptAt.Time, 2*time.Second)
+}
+
+func TestDeliverBatch_DeadAfterMaxAttempts(t *testing.T) {
+	repo := newRepo()
+	d := dueDelivery("http://127.0.0.1:1", 7)
+	repo.On("ClaimWebhookDeliveries", mock.Anything, leased()).Return([]db.ClaimWebhookDeliveriesRow{d}, nil)
+	repo.On("MarkWebhookFailed", mock.Anything, mock.MatchedBy(func(arg db.MarkWebhookFailedParams) bool {
+		return arg.ID == d.ID && !arg.NextAttemptAt.Valid && arg.LastError.Valid
+	})).Return(nil).Once()
+
+	_, err := NewDeliverer(repo).DeliverBatch(context.Background())
+
+	require.NoError(t, err)
+	repo.AssertExpectations(t)
+}
+
+func TestDeliverBatch_RecordsEveryResult(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	repo := newRepo()
+	first, second := dueDelivery(server.URL, 0), dueDelivery(server.URL, 0)
+	repo.On("ClaimWebhookDeliveries", mock.Anything, leased()).Return([]db.ClaimWebhookDeliveriesRow{first, second}, nil)
+	repo.On("MarkWebhookDelivered", mock.Anything, first.ID).Return(errors.New("connection reset")).Once()
+	repo.On("MarkWebhookDelivered", mock.Anything, second.ID).Return(nil).Once()
+
+	n, err := NewDeliverer(repo).DeliverBatch(context.Background())
+
+	assert.Equal(t, 2, n)
+	assert.ErrorContains(t, err, first.ID.String())
+	repo.AssertExpectations(t)
+}
+
+func TestBackoffIsCapped(t *testing.T) {
+	d := NewDeliverer(nil)
+	assert.Equal(t, 10*time.Second, d.backoff(1))
+	assert.Equal(t, 80*time.Second, d.backoff(4))
+	assert.Equal(t, time.Hour, d.backoff(30))
+}
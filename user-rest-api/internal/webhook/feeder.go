@@ -0,0 +1,141 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/outbox"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/repository"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/events"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	// TradesStream is the JetStream stream the WebSocket API's matching engine
+	// publishes trades to.
+	TradesStream = "TRADES"
+	consumerName = "webhooks"
+	retryDelay   = 5 * time.Second
+)
+
+// EventTypes are the event types a subscription can ask for.
+var EventTypes = []string{
+	events.TypeUserCreated,
+	events.TypeUserUpdated,
+	events.TypeUserDeleted,
+	events.TypeTradeExecuted,
+}
+
+// Feeder turns user events and trades from JetStream into deliveries for the
+// matching subscriptions. Durable consumers make it resume where it stopped,
+// and deliveries are unique per event and subscription, so a redelivered
+// message is queued only once.
+type Feeder struct {
+	repo repository.WebhookRepository
+	js   jetstream.JetStream
+}
+
+func NewFeeder(repo repository.WebhookRepository, js jetstream.JetStream) *Feeder {
+	return &Feeder{repo: repo, js: js}
+}
+
+// Run consumes both streams until ctx is done.
+func (f *Feeder) Run(ctx context.Context) {
+	for _, stream := range []string{outbox.StreamName, TradesStream} {
+		go f.consume(ctx, stream)
+	}
+	<-ctx.Done()
+}
+
+func (f *Feeder) consume(ctx context.Context, stream string) {
+	var consumer jetstream.Consumer
+	for {
+		var err error
+		consumer, err = f.js.CreateOrUpdateConsumer(ctx, stream, jetstream.ConsumerConfig{
+			Durable:   consumerName,
+			AckPolicy: jetstream.AckExplicitPolicy,
+		})
+		if err == nil {
+			break
+		}
+		// The trades stream only exists once the WebSocket API has started.
+		log.Printf("Webhook feeder waiting for stream %s: %v\n", stream, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryDelay):
+		}
+	}
+	cc, err := consumer.Consume(func(msg jetstream.Msg) {
+		if err := f.handle(ctx, stream, msg); err != nil {
+			log.Printf("Failed to queue webhook deliveries from %s: %v\n", stream, err)
+			_ = msg.NakWithDelay(retryDelay)
+			return
+		}
+		_ = msg.Ack()
+	})
+	if err != nil {
+		log.Printf("Webhook feeder cannot consume %s: %v\n", stream, err)
+		return
+	}
+	<-ctx.Done()
+	cc.Stop()
+}
+
+func (f *Feeder) handle(ctx context.Context, stream string, msg jetstream.Msg) error {
+	meta, err := msg.Metadata()
+	if err != nil {
+		return err
+	}
+	arg, ok, err := delivery(ctx, stream, meta.Sequence.Stream, msg.Data())
+	if err != nil || !ok {
+		return err
+	}
+	_, err = f.repo.EnqueueWebhookDeliveries(ctx, arg)
+	return err
+}
+
+// delivery builds the delivery of a stream message. User events are sent as
+// published; trades are wrapped in a CloudEvent whose ID is the ID of the
+// trade, which the engine keeps unique across restarts, so it is the same
+// every time the message is redelivered, and never reused when the stream is
+// recreated. Messages that are not events or trades are skipped.
+func delivery(ctx context.Context, stream string, seq uint64, data []byte) (db.EnqueueWebhookDeliveriesParams, bool, error) {
+	if stream != TradesStream {
+		event, err := events.Parse(data)
+		if err != nil {
+			log.Printf("Skipping %s message %d: %v\n", stream, seq, err)
+			return db.EnqueueWebhookDeliveriesParams{}, false, nil
+		}
+		return db.EnqueueWebhookDeliveriesParams{EventID: event.ID, EventType: event.Type, Payload: data}, true, nil
+	}
+
+	var trade struct {
+		ID        string    `json:"id"`
+		Timestamp time.Time `json:"Timestamp"`
+	}
+	if err := json.Unmarshal(data, &trade); err != nil {
+		log.Printf("Skipping %s message %d: %v\n", stream, seq, err)
+		return db.EnqueueWebhookDeliveriesParams{}, false, nil
+	}
+	if trade.ID == "" {
+		log.Printf("Skipping %s message %d: trade without ID\n", stream, seq)
+		return db.EnqueueWebhookDeliveriesParams{}, false, nil
+	}
+	event, err := events.NewEvent(ctx, events.TypeTradeExecuted, events.SourceWSAPI, trade.ID, json.RawMessage(data))
+	if err != nil {
+		return db.EnqueueWebhookDeliveriesParams{}, false, err
+	}
+	event.ID = trade.ID
+	if !trade.Timestamp.IsZero() {
+		event.Time = trade.Timestamp.UTC()
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return db.EnqueueWebhookDeliveriesParams{}, false, err
+	}
+	return db.EnqueueWebhookDeliveriesParams{EventID: event.ID, EventType: event.Type, Payload: payload}, true, nil
+}
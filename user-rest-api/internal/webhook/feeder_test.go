@@ -0,0 +1,62 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/outbox"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelivery_UserEventIsSentAsPublished(t *testing.T) {
+	e, err := events.NewUserEvent(context.Background(), events.TypeUserCreated, events.SourceRESTAPI, "u1", nil, map[string]string{"user_id": "u1"})
+	require.NoError(t, err)
+	data, err := json.Marshal(e)
+	require.NoError(t, err)
+
+	arg, ok, err := delivery(context.Background(), outbox.StreamName, 7, data)
+
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, e.ID, arg.EventID)
+	assert.Equal(t, events.TypeUserCreated, arg.EventType)
+	assert.Equal(t, data, []byte(arg.Payload))
+}
+
+func TestDelivery_TradeIsWrappedWithStableID(t *testing.T) {
+	trade := []byte(`{"id":"BTC-T1","asset_id":"BTC","Price":100,"Timestamp":"2025-06-01T10:00:00Z"}`)
+
+	first, ok, err := delivery(context.Background(), TradesStream, 42, trade)
+	require.NoError(t, err)
+	require.True(t, ok)
+	again, _, err := delivery(context.Background(), TradesStream, 42, trade)
+	require.NoError(t, err)
+	// A recreated stream numbers its messages from 1 again.
+	recreated, _, err := delivery(context.Background(), TradesStream, 1, trade)
+	require.NoError(t, err)
+
+	assert.Equal(t, "BTC-T1", first.EventID)
+	assert.Equal(t, first.EventID, again.EventID)
+	assert.Equal(t, first.EventID, recreated.EventID)
+	assert.Equal(t, events.TypeTradeExecuted, first.EventType)
+	var e events.Event
+	require.NoError(t, json.Unmarshal(first.Payload, &e))
+	assert.Equal(t, "BTC-T1", e.Subject)
+	assert.Equal(t, "https://schemas.yaalalabs.com/trade/v1", e.DataSchema)
+	assert.JSONEq(t, string(trade), string(e.Data))
+}
+
+func TestDelivery_SkipsMalformedMessages(t *testing.T) {
+	_, ok, err := delivery(context.Background(), outbox.StreamName, 1, []byte(`{"user_id":"u1"}`))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	_, ok, err = delivery(context.Background(), TradesStream, 1, []byte(`not json`))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	_, ok, err = delivery(context.Background(), TradesStream, 1, []byte(`{"asset_id":"BTC"}`))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
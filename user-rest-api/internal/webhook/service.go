@@ -0,0 +1,161 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/repository"
+)
+
+var ErrNotFound = errors.New("not found")
+
+type Subscription struct {
+	ID         uuid.UUID `json:"id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	// Secret is only returned when the subscription is created.
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreateSubscriptionParams struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	// Secret signs deliveries. A random one is generated if it is empty.
+	Secret string `json:"secret,omitempty"`
+}
+
+type Delivery struct {
+	ID             uuid.UUID       `json:"id"`
+	SubscriptionID uuid.UUID       `json:"subscription_id"`
+	EventID        string          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int32           `json:"attempts"`
+	LastError      *string         `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// Service manages subscriptions and the dead letters of their deliveries.
+type Service interface {
+	CreateSubscription(ctx context.Context, arg CreateSubscriptionParams) (Subscription, error)
+	GetSubscription(ctx context.Context, id uuid.UUID) (Subscription, error)
+	ListSubscriptions(ctx context.Context) ([]Subscription, error)
+	DeleteSubscription(ctx context.Context, id uuid.UUID) error
+	ListDeadDeliveries(ctx context.Context, limit int32) ([]Delivery, error)
+	RetryDelivery(ctx context.Context, id uuid.UUID) error
+}
+
+type service struct {
+	repo repository.WebhookRepository
+}
+
+func NewService(repo repository.WebhookRepository) Service {
+	return &service{repo: repo}
+}
+
+func (s *service) CreateSubscription(ctx context.Context, arg CreateSubscriptionParams) (Subscription, error) {
+	secret := arg.Secret
+	if secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return Subscription{}, err
+		}
+		secret = hex.EncodeToString(b)
+	}
+	sub, err := s.repo.CreateWebhookSubscription(ctx, db.CreateWebhookSubscriptionParams{
+		Url:        arg.URL,
+		EventTypes: arg.EventTypes,
+		Secret:     secret,
+	})
+	if err != nil {
+		return Subscription{}, err
+	}
+	created := toSubscription(sub)
+	created.Secret = sub.Secret
+	return created, nil
+}
+
+func (s *service) GetSubscription(ctx context.Context, id uuid.UUID) (Subscription, error) {
+	sub, err := s.repo.GetWebhookSubscription(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Subscription{}, ErrNotFound
+	}
+	if err != nil {
+		return Subscription{}, err
+	}
+	return toSubscription(sub), nil
+}
+
+func (s *service) ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	subs, err := s.repo.ListWebhookSubscriptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Subscription, 0, len(subs))
+	for _, sub := range subs {
+		out = append(out, toSubscription(sub))
+	}
+	return out, nil
+}
+
+func (s *service) DeleteSubscription(ctx context.Context, id uuid.UUID) error {
+	n, err := s.repo.DeleteWebhookSubscription(ctx, id)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *service) ListDeadDeliveries(ctx context.Context, limit int32) ([]Delivery, error) {
+	rows, err := s.repo.ListDeadWebhookDeliveries(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Delivery, 0, len(rows))
+	for _, d := range rows {
+		var lastError *string
+		if d.LastError.Valid {
+			lastError = &d.LastError.String
+		}
+		out = append(out, Delivery{
+			ID:             d.ID,
+			SubscriptionID: d.SubscriptionID,
+			EventID:        d.EventID,
+			EventType:      d.EventType,
+			Payload:        d.Payload,
+			Status:         d.Status,
+			Attempts:       d.Attempts,
+			LastError:      lastError,
+			CreatedAt:      d.CreatedAt,
+		})
+	}
+	return out, nil
+}
+
+// RetryDelivery requeues a dead delivery.
+func (s *service) RetryDelivery(ctx context.Context, id uuid.UUID) error {
+	n, err := s.repo.RetryWebhookDelivery(ctx, id)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func toSubscription(sub db.WebhookSubscription) Subscription {
+	return Subscription{ID: sub.ID, URL: sub.Url, EventTypes: sub.EventTypes, CreatedAt: sub.CreatedAt}
+}
@@ -1,17 +1,24 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal"
 	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/config"
-	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/idempotency"
 	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/nats"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/outbox"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/repository"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/streams"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/webhook"
 	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/userservice"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/nats-io/nats.go/jetstream"
 
 	"github.com/laki88/yaalalabs-user-api/user-rest-api/api"
 )
@@ -32,14 +39,23 @@ func main() {
 
 	internal.InitValidator()
 
-	queries := db.New(conn)
-	//repo := repository.NewPostgresUserRepository(queries)
-	userService := userservice.NewService(queries)
+	repo := repository.NewPostgresUserRepository(conn)
+	startOutboxRelay(repo)
+	userService := userservice.NewService(repo)
 	handler := api.NewHandler(userService)
+	handler.Idempotency = startIdempotencyStore(conn)
+	handler.Streams = userStreams()
+	startUserPurge(userService, handler.Streams)
+
+	webhookRepo := repository.NewPostgresWebhookRepository(conn)
+	startWebhooks(webhookRepo)
+	webhookHandler := api.NewWebhookHandler(webhook.NewService(webhookRepo))
 
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 	r.Mount("/users", api.Routes(handler))
+	api.BulkRoutes(r, handler)
+	r.Mount("/webhooks", api.WebhookRoutes(webhookHandler))
 	r.Get("/docs/openapi.yaml", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "./docs/openapi.yaml")
 	})
@@ -50,3 +66,99 @@ func main() {
 	log.Println("Server running at http://localhost:" + config.AppConfig.Server.Port)
 	log.Fatal(http.ListenAndServe(":"+config.AppConfig.Server.Port, r))
 }
+
+// startOutboxRelay publishes user change events from the outbox to JetStream.
+// Events stay in the outbox while NATS is unavailable.
+func startOutboxRelay(repo repository.UserRepository) {
+	if nats.Conn() == nil {
+		log.Println("Warning: outbox relay not started, events stay in the outbox")
+		return
+	}
+	js, err := jetstream.New(nats.Conn())
+	if err != nil {
+		log.Printf("Warning: JetStream unavailable: %v (outbox relay not started)\n", err)
+		return
+	}
+	ctx := context.Background()
+	go func() {
+		// The stream is created once NATS is reachable.
+		for err := outbox.EnsureStream(ctx, js); err != nil; err = outbox.EnsureStream(ctx, js) {
+			log.Printf("Warning: cannot create %s stream: %v\n", outbox.StreamName, err)
+			time.Sleep(5 * time.Second)
+		}
+		relay := outbox.NewRelay(repo, js)
+		relay.SetPollInterval(config.AppConfig.Outbox.PollInterval)
+		relay.SetBatchSize(config.AppConfig.Outbox.BatchSize)
+		relay.Run(ctx)
+	}()
+}
+
+// userStreams returns the reader of the data about users kept in JetStream,
+// or nil if NATS is unavailable, in which case personal data cannot be
+// exported and erasures are not completed.
+func userStreams() api.StreamData {
+	if nats.Conn() == nil {
+		log.Println("Warning: personal data exports and erasures unavailable without NATS")
+		return nil
+	}
+	js, err := jetstream.New(nats.Conn())
+	if err != nil {
+		log.Printf("Warning: JetStream unavailable: %v (personal data exports and erasures unavailable)\n", err)
+		return nil
+	}
+	return streams.NewUserData(js)
+}
+
+// startIdempotencyStore returns the store of idempotent responses and purges
+// it of expired keys in the background.
+func startIdempotencyStore(conn *sql.DB) *idempotency.Store {
+	cfg := config.AppConfig.Idempotency
+	store := idempotency.NewStore(repository.NewPostgresIdempotencyRepository(conn))
+	store.SetRetention(cfg.Retention)
+	interval := cfg.PurgeInterval
+	if interval <= 0 {
+		interval = 10 * time.Minute
+	}
+	go store.Purge(context.Background(), interval)
+	return store
+}
+
+// startUserPurge erases deleted users once they are past the retention
+// period, and their events with events if it is not nil.
+func startUserPurge(service userservice.UserService, events userservice.EventEraser) {
+	cfg := config.AppConfig.Users
+	if cfg.DeletedRetention <= 0 {
+		log.Println("Warning: deleted users are kept forever, no retention period is set")
+		return
+	}
+	interval := cfg.PurgeInterval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	go userservice.PurgeDeleted(context.Background(), service, events, cfg.DeletedRetention, interval)
+}
+
+// startWebhooks delivers due webhooks and, when NATS is available, queues
+// deliveries for new user events and trades.
+func startWebhooks(repo repository.WebhookRepository) {
+	ctx := context.Background()
+	cfg := config.AppConfig.Webhooks
+	deliverer := webhook.NewDeliverer(repo)
+	deliverer.SetPollInterval(cfg.PollInterval)
+	deliverer.SetBatchSize(cfg.BatchSize)
+	deliverer.SetMaxAttempts(cfg.MaxAttempts)
+	deliverer.SetBackoff(cfg.Backoff)
+	deliverer.SetTimeout(cfg.Timeout)
+	go deliverer.Run(ctx)
+
+	if nats.Conn() == nil {
+		log.Println("Warning: webhook feeder not started, no new deliveries will be queued")
+		return
+	}
+	js, err := jetstream.New(nats.Conn())
+	if err != nil {
+		log.Printf("Warning: JetStream unavailable: %v (webhook feeder not started)\n", err)
+		return
+	}
+	go webhook.NewFeeder(repo, js).Run(ctx)
+}
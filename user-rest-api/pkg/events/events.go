@@ -0,0 +1,189 @@
+// Package events defines the envelope of the user change events published on
+// NATS. It follows the CloudEvents 1.0 JSON format and is shared by
+// user-rest-api and user-ws-api.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const SpecVersion = "1.0"
+
+// Event types. The version suffix changes whenever the data schema changes in
+// an incompatible way.
+const (
+	TypeUserCreated = "com.yaalalabs.user.created.v1"
+	TypeUserUpdated = "com.yaalalabs.user.updated.v1"
+	TypeUserDeleted = "com.yaalalabs.user.deleted.v1"
+	// TypeTradeExecuted carries a trade from the matching engine as published
+	// on the trades.> subjects.
+	TypeTradeExecuted = "com.yaalalabs.trade.executed.v1"
+)
+
+// Sources identify the service that made a change.
+const (
+	SourceRESTAPI = "/user-rest-api"
+	SourceWSAPI   = "/user-ws-api"
+)
+
+const dataSchemaPrefix = "https://schemas.yaalalabs.com/"
+
+var ErrUnsupported = errors.New("unsupported event")
+
+// Event is a CloudEvent. Actor is an extension attribute holding the ID of
+// whoever made the change, if known.
+type Event struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	DataSchema      string          `json:"dataschema"`
+	Actor           string          `json:"actor,omitempty"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// UserChange is the data of a user event. Before is absent for created
+// events and After for deleted events. Changed lists the fields that differ
+// between the two.
+type UserChange struct {
+	Before  json.RawMessage `json:"before,omitempty"`
+	After   json.RawMessage `json:"after,omitempty"`
+	Changed []string        `json:"changed"`
+}
+
+// Version returns the schema version of an event type, e.g. "v1".
+func Version(eventType string) string {
+	return eventType[strings.LastIndexByte(eventType, '.')+1:]
+}
+
+// NewUserEvent builds a user event. before and after are the user before and
+// after the change, or nil.
+func NewUserEvent(ctx context.Context, eventType, source, userID string, before, after any) (Event, error) {
+	change, err := diff(before, after)
+	if err != nil {
+		return Event{}, err
+	}
+	return NewEvent(ctx, eventType, source, userID, change)
+}
+
+// NewEvent builds an event of any type with a new ID.
+func NewEvent(ctx context.Context, eventType, source, subject string, data any) (Event, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{
+		SpecVersion:     SpecVersion,
+		ID:              uuid.NewString(),
+		Source:          source,
+		Type:            eventType,
+		Subject:         subject,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		DataSchema:      DataSchema(eventType),
+		Actor:           ActorFrom(ctx),
+		Data:            raw,
+	}, nil
+}
+
+// DataSchema returns the schema URI of an event type, e.g.
+// https://schemas.yaalalabs.com/user/v1.
+func DataSchema(eventType string) string {
+	parts := strings.Split(eventType, ".")
+	if len(parts) < 3 {
+		return ""
+	}
+	return dataSchemaPrefix + parts[len(parts)-3] + "/" + Version(eventType)
+}
+
+func diff(before, after any) (UserChange, error) {
+	var change UserChange
+	var b, a map[string]any
+	var err error
+	if before != nil {
+		if change.Before, b, err = marshalObject(before); err != nil {
+			return change, err
+		}
+	}
+	if after != nil {
+		if change.After, a, err = marshalObject(after); err != nil {
+			return change, err
+		}
+	}
+	change.Changed = []string{}
+	for k, v := range a {
+		if old, ok := b[k]; !ok || !reflect.DeepEqual(old, v) {
+			change.Changed = append(change.Changed, k)
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			change.Changed = append(change.Changed, k)
+		}
+	}
+	sort.Strings(change.Changed)
+	return change, nil
+}
+
+func marshalObject(v any) (json.RawMessage, map[string]any, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, nil, fmt.Errorf("event data must be a JSON object: %w", err)
+	}
+	return raw, fields, nil
+}
+
+// Parse decodes an event and checks that it is a user event this version of
+// the package understands.
+func Parse(data []byte) (Event, error) {
+	var e Event
+	if err := json.Unmarshal(data, &e); err != nil {
+		return e, err
+	}
+	if e.SpecVersion != SpecVersion {
+		return e, fmt.Errorf("%w: specversion %q", ErrUnsupported, e.SpecVersion)
+	}
+	switch e.Type {
+	case TypeUserCreated, TypeUserUpdated, TypeUserDeleted:
+		return e, nil
+	}
+	return e, fmt.Errorf("%w: type %q", ErrUnsupported, e.Type)
+}
+
+// UserChange decodes the data of a user event.
+func (e Event) UserChange() (UserChange, error) {
+	var change UserChange
+	err := json.Unmarshal(e.Data, &change)
+	return change, err
+}
+
+type actorKey struct{}
+
+// WithActor returns a context carrying the ID of whoever makes a change.
+func WithActor(ctx context.Context, actor string) context.Context {
+	if actor == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func ActorFrom(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
@@ -0,0 +1,64 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type user struct {
+	ID    string  `json:"user_id"`
+	Email string  `json:"email"`
+	Phone *string `json:"phone,omitempty"`
+}
+
+func TestNewUserEvent_Created(t *testing.T) {
+	e, err := NewUserEvent(context.Background(), TypeUserCreated, SourceRESTAPI, "u1", nil, user{ID: "u1", Email: "a@b.c"})
+	require.NoError(t, err)
+
+	assert.Equal(t, SpecVersion, e.SpecVersion)
+	assert.NotEmpty(t, e.ID)
+	assert.Equal(t, "u1", e.Subject)
+	assert.Equal(t, "https://schemas.yaalalabs.com/user/v1", e.DataSchema)
+	assert.Empty(t, e.Actor)
+	change, err := e.UserChange()
+	require.NoError(t, err)
+	assert.Nil(t, change.Before)
+	assert.Equal(t, []string{"email", "user_id"}, change.Changed)
+}
+
+func TestNewUserEvent_DiffIncludesRemovedFields(t *testing.T) {
+	phone := "123"
+	ctx := WithActor(context.Background(), "bob")
+	e, err := NewUserEvent(ctx, TypeUserUpdated, SourceWSAPI, "u1",
+		user{ID: "u1", Email: "a@b.c", Phone: &phone}, user{ID: "u1", Email: "x@b.c"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "bob", e.Actor)
+	change, err := e.UserChange()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"email", "phone"}, change.Changed)
+}
+
+func TestParse_RoundTrip(t *testing.T) {
+	e, err := NewUserEvent(context.Background(), TypeUserDeleted, SourceRESTAPI, "u1", user{ID: "u1"}, nil)
+	require.NoError(t, err)
+	data, err := json.Marshal(e)
+	require.NoError(t, err)
+
+	parsed, err := Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, e.ID, parsed.ID)
+	assert.Equal(t, "v1", Version(parsed.Type))
+	assert.True(t, e.Time.Equal(parsed.Time))
+}
+
+func TestParse_RejectsUnknownTypes(t *testing.T) {
+	_, err := Parse([]byte(`{"specversion":"1.0","type":"com.yaalalabs.user.created.v2"}`))
+	assert.ErrorIs(t, err, ErrUnsupported)
+	_, err = Parse([]byte(`{"specversion":"0.3","type":"com.yaalalabs.user.created.v1"}`))
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
@@ -0,0 +1,122 @@
+package userservice
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/sqlc-dev/pqtype"
+
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/repository"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/events"
+)
+
+// Audit actions.
+const (
+	AuditCreated  = "created"
+	AuditUpdated  = "updated"
+	AuditDeleted  = "deleted"
+	AuditRestored = "restored"
+	AuditPurged   = "purged"
+
+	AuditActivated = "activated"
+	AuditSuspended = "suspended"
+	AuditClosed    = "closed"
+
+	AuditErased = "erased"
+)
+
+var ErrInvalidAuditParams = newError(ErrValidation, "invalid audit parameters")
+
+// audit records the change carried by a user event in the audit log.
+func (s *service) audit(ctx context.Context, repo repository.UserRepository, action, reason string, userID uuid.UUID, event events.Event) error {
+	change, err := event.UserChange()
+	if err != nil {
+		return err
+	}
+	return repo.InsertUserAudit(ctx, db.InsertUserAuditParams{
+		UserID:  userID,
+		Action:  action,
+		Actor:   internal.ToNullString(event.Actor),
+		Reason:  internal.ToNullString(reason),
+		Source:  event.Source,
+		Changed: change.Changed,
+		Before:  pqtype.NullRawMessage{RawMessage: change.Before, Valid: change.Before != nil},
+		After:   pqtype.NullRawMessage{RawMessage: change.After, Valid: change.After != nil},
+	})
+}
+
+func (s *service) ListUserAudit(ctx context.Context, arg ListAuditParams) (AuditPage, error) {
+	query := db.ListUserAuditParams{UserID: arg.UserID, PageSize: min(arg.Limit, MaxPageSize)}
+	switch {
+	case arg.Limit < 0:
+		return AuditPage{}, fmt.Errorf("%w: negative limit", ErrInvalidAuditParams)
+	case arg.Limit == 0:
+		query.PageSize = DefaultPageSize
+	}
+	if arg.Cursor != "" {
+		id, err := decodeAuditCursor(arg.Cursor)
+		if err != nil {
+			return AuditPage{}, fmt.Errorf("%w: malformed cursor", ErrInvalidAuditParams)
+		}
+		query.BeforeID = sql.NullInt64{Int64: id, Valid: true}
+	}
+	limit := query.PageSize
+	query.PageSize++ // one more tells whether there is a next page
+
+	rows, err := s.repo.ListUserAudit(ctx, query)
+	if err != nil {
+		return AuditPage{}, err
+	}
+	page := AuditPage{Entries: make([]AuditEntry, 0, min(len(rows), int(limit)))}
+	for i, row := range rows {
+		if i == int(limit) {
+			page.NextCursor = encodeAuditCursor(rows[i-1].ID)
+			break
+		}
+		page.Entries = append(page.Entries, toAuditEntry(row))
+	}
+	return page, nil
+}
+
+// Audit cursors are the ID of the last entry of a page, opaque to clients
+// like the cursors of ListUsers.
+func encodeAuditCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodeAuditCursor(s string) (int64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(b), 10, 64)
+}
+
+func toAuditEntry(a db.UserAudit) AuditEntry {
+	entry := AuditEntry{
+		ID:        a.ID,
+		UserID:    a.UserID,
+		Action:    a.Action,
+		Actor:     a.Actor.String,
+		Reason:    a.Reason.String,
+		Source:    a.Source,
+		Changed:   a.Changed,
+		CreatedAt: a.CreatedAt,
+	}
+	if a.Before.Valid {
+		entry.Before = a.Before.RawMessage
+	}
+	if a.After.Valid {
+		entry.After = a.After.RawMessage
+	}
+	if entry.Changed == nil {
+		entry.Changed = []string{}
+	}
+	return entry
+}
@@ -0,0 +1,125 @@
+package userservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/repository"
+)
+
+// Operations of a batch.
+const (
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+// Statuses of batch operations.
+const (
+	OpSucceeded = "succeeded"
+	OpFailed    = "failed"
+	// OpRolledBack operations succeeded, but were undone because another
+	// operation failed.
+	OpRolledBack = "rolled_back"
+	// OpSkipped operations were not run because another operation failed.
+	OpSkipped = "skipped"
+)
+
+// MaxBatchOperations is the number of operations a batch can have.
+const MaxBatchOperations = 100
+
+var ErrInvalidBatch = newError(ErrValidation, "invalid batch")
+
+// Batch runs operations in order in one transaction, so that either all of
+// them are applied or none. Each is checked as by CreateUser, UpdateUser or
+// DeleteUser and its outcome is reported in the result; only errors that are
+// not about an operation, like a lost database connection, make it fail.
+// Events are written to the outbox in the transaction, so none is published
+// unless it commits.
+func (s *service) Batch(ctx context.Context, ops []BatchOperation) (BatchResult, error) {
+	switch {
+	case len(ops) == 0:
+		return BatchResult{}, fmt.Errorf("%w: no operations", ErrInvalidBatch)
+	case len(ops) > MaxBatchOperations:
+		return BatchResult{}, fmt.Errorf("%w: more than %d operations", ErrInvalidBatch, MaxBatchOperations)
+	}
+
+	result := BatchResult{Results: make([]BatchOperationResult, len(ops))}
+	creates := make([]db.CreateUserParams, len(ops))
+	updates := make([]db.UpdateUserParams, len(ops))
+	failed := false
+	for i, op := range ops {
+		err := op.Err
+		if err == nil {
+			switch op.Op {
+			case OpCreate:
+				creates[i], err = createUserParams(op.Create)
+			case OpUpdate:
+				updates[i], err = updateUserParams(op.Update)
+			case OpDelete:
+			default:
+				err = &ValidationError{Fields: []FieldError{{Field: "op", Message: "must be one of create update delete"}}}
+			}
+		}
+		result.Results[i].Op = op.Op
+		if err != nil {
+			result.Results[i].Status, result.Results[i].Err = OpFailed, err
+			failed = true
+		}
+	}
+
+	if !failed {
+		err := s.repo.WithTx(ctx, func(repo repository.UserRepository) error {
+			for i, op := range ops {
+				var user User
+				var err error
+				switch op.Op {
+				case OpCreate:
+					user, err = s.createUser(ctx, repo, creates[i])
+				case OpUpdate:
+					user, err = s.updateUser(ctx, repo, op.Update, updates[i])
+				case OpDelete:
+					err = s.deleteUser(ctx, repo, op.Delete)
+				}
+				res := &result.Results[i]
+				if isOperationError(err) {
+					res.Status, res.Err = OpFailed, err
+					failed = true
+					return errRollback
+				}
+				if err != nil {
+					return err
+				}
+				res.Status = OpSucceeded
+				if op.Op != OpDelete {
+					res.User = &user
+				}
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errRollback) {
+			return BatchResult{}, err
+		}
+	}
+
+	result.Committed = !failed
+	if failed {
+		for i, res := range result.Results {
+			switch res.Status {
+			case OpSucceeded:
+				result.Results[i].Status, result.Results[i].User = OpRolledBack, nil
+			case "":
+				result.Results[i].Status = OpSkipped
+			}
+		}
+	}
+	return result, nil
+}
+
+// isOperationError reports whether err is about the operation that returned
+// it, rather than the database.
+func isOperationError(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, ErrConflict) || errors.Is(err, ErrValidation)
+}
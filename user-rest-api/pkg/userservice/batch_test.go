@@ -0,0 +1,125 @@
+package userservice_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/repository/mocks"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/userservice"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func resultStatuses(result userservice.BatchResult) []string {
+	statuses := make([]string, len(result.Results))
+	for i, res := range result.Results {
+		statuses[i] = res.Status
+	}
+	return statuses
+}
+
+func TestBatch_CommitsEveryOperation(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+	updated, deleted := uuid.New(), uuid.New()
+
+	expectTx(repo)
+	expectCreate(repo, "new@example.com", nil)
+	repo.On("GetUserForUpdate", mock.Anything, updated).Return(db.User{UserID: updated, Version: 1}, nil)
+	repo.On("UpdateUser", mock.Anything, mock.MatchedBy(func(arg db.UpdateUserParams) bool {
+		return arg.UserID == updated && arg.LastName.String == "Batch"
+	})).Return(db.User{UserID: updated, LastName: "Batch", Version: 2}, nil)
+	expectEvent(repo, userservice.SubjectUserUpdated)
+	repo.On("GetUserForUpdate", mock.Anything, deleted).Return(db.User{UserID: deleted, Version: 1}, nil)
+	repo.On("SoftDeleteUser", mock.Anything, deleted).Return(db.User{}, nil)
+	expectEvent(repo, userservice.SubjectUserDeleted)
+
+	result, err := svc.Batch(context.Background(), []userservice.BatchOperation{
+		{Op: userservice.OpCreate, Create: userservice.CreateUserParams{Email: "new@example.com"}},
+		{Op: userservice.OpUpdate, Update: userservice.UpdateUserParams{UserID: updated, LastName: userservice.Value("Batch")}},
+		{Op: userservice.OpDelete, Delete: userservice.DeleteUserParams{UserID: deleted}},
+	})
+
+	assert.NoError(t, err)
+	assert.True(t, result.Committed)
+	assert.Equal(t, []string{userservice.OpSucceeded, userservice.OpSucceeded, userservice.OpSucceeded}, resultStatuses(result))
+	assert.Equal(t, "Batch", result.Results[1].User.LastName)
+	assert.Nil(t, result.Results[2].User)
+	repo.AssertExpectations(t)
+}
+
+func TestBatch_RollsBackOnFailure(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+	id := uuid.New()
+	stale := int32(1)
+
+	expectTx(repo)
+	expectCreate(repo, "new@example.com", nil)
+	repo.On("GetUserForUpdate", mock.Anything, id).Return(db.User{UserID: id, Version: 2}, nil)
+
+	result, err := svc.Batch(context.Background(), []userservice.BatchOperation{
+		{Op: userservice.OpCreate, Create: userservice.CreateUserParams{Email: "new@example.com"}},
+		{Op: userservice.OpDelete, Delete: userservice.DeleteUserParams{UserID: id, ExpectedVersion: &stale}},
+		{Op: userservice.OpDelete, Delete: userservice.DeleteUserParams{UserID: uuid.New()}},
+	})
+
+	assert.NoError(t, err)
+	assert.False(t, result.Committed)
+	assert.Equal(t, []string{userservice.OpRolledBack, userservice.OpFailed, userservice.OpSkipped}, resultStatuses(result))
+	assert.Nil(t, result.Results[0].User)
+	assert.ErrorIs(t, result.Results[1].Err, userservice.ErrVersionMismatch)
+	repo.AssertNotCalled(t, "SoftDeleteUser", mock.Anything, mock.Anything)
+	repo.AssertExpectations(t)
+}
+
+func TestBatch_InvalidOperationsSkipDatabase(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+	decodeErr := &userservice.ValidationError{Fields: []userservice.FieldError{{Field: "user_id", Message: "must be a UUID"}}}
+
+	result, err := svc.Batch(context.Background(), []userservice.BatchOperation{
+		{Op: userservice.OpCreate, Create: userservice.CreateUserParams{Email: "new@example.com"}},
+		{Op: userservice.OpDelete, Err: decodeErr},
+		{Op: "upsert"},
+		{Op: userservice.OpUpdate, Update: userservice.UpdateUserParams{UserID: uuid.New(), Email: userservice.Null[string]()}},
+	})
+
+	assert.NoError(t, err)
+	assert.False(t, result.Committed)
+	assert.Equal(t, []string{userservice.OpSkipped, userservice.OpFailed, userservice.OpFailed, userservice.OpFailed}, resultStatuses(result))
+	assert.Equal(t, decodeErr, result.Results[1].Err)
+	assert.ErrorIs(t, result.Results[2].Err, userservice.ErrValidation)
+	assert.ErrorIs(t, result.Results[3].Err, userservice.ErrInvalidUpdate)
+	repo.AssertNotCalled(t, "WithTx", mock.Anything, mock.Anything)
+}
+
+func TestBatch_FailsOnDatabaseErrors(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+	lost := errors.New("connection lost")
+
+	expectTx(repo)
+	repo.On("CreateUser", mock.Anything, mock.Anything).Return(db.User{}, lost)
+
+	_, err := svc.Batch(context.Background(), []userservice.BatchOperation{
+		{Op: userservice.OpCreate, Create: userservice.CreateUserParams{Email: "new@example.com"}},
+	})
+
+	assert.ErrorIs(t, err, lost)
+}
+
+func TestBatch_RejectsInvalidSizes(t *testing.T) {
+	svc := userservice.NewService(new(mocks.MockUserRepository))
+
+	for name, ops := range map[string][]userservice.BatchOperation{
+		"no operations":       nil,
+		"too many operations": make([]userservice.BatchOperation, userservice.MaxBatchOperations+1),
+	} {
+		_, err := svc.Batch(context.Background(), ops)
+		assert.ErrorIs(t, err, userservice.ErrInvalidBatch, name)
+	}
+}
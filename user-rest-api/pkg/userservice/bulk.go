@@ -0,0 +1,214 @@
+package userservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/repository"
+)
+
+// Modes of ImportUsers.
+const (
+	// ImportAtomic creates every row or none of them.
+	ImportAtomic = "atomic"
+	// ImportBestEffort creates the valid rows and reports the others.
+	ImportBestEffort = "best_effort"
+)
+
+// ImportModes are the modes of ImportUsers.
+var ImportModes = []string{ImportAtomic, ImportBestEffort}
+
+// Statuses of imported rows.
+const (
+	RowCreated = "created"
+	// RowValid rows would have been created by an import that was not a dry
+	// run.
+	RowValid  = "valid"
+	RowFailed = "failed"
+	// RowSkipped rows were not created because another row of an atomic
+	// import failed.
+	RowSkipped = "skipped"
+)
+
+// MaxImportRows is the number of rows an import can have.
+const MaxImportRows = 10000
+
+var ErrInvalidImport = newError(ErrValidation, "invalid import")
+
+// errRollback rolls back the transaction of a dry run or of a failed atomic
+// import.
+var errRollback = errors.New("rollback")
+
+// ImportUsers creates users from the rows of an imported file. Every row is
+// checked as by CreateUser and its outcome is reported in the result; only
+// errors that are not about a row, like a lost database connection, make it
+// fail. An atomic import stops at the first row the database rejects, so its
+// result only reports that one.
+func (s *service) ImportUsers(ctx context.Context, arg ImportUsersParams) (ImportResult, error) {
+	if arg.Mode == "" {
+		arg.Mode = ImportAtomic
+	}
+	switch {
+	case !slices.Contains(ImportModes, arg.Mode):
+		return ImportResult{}, fmt.Errorf("%w: unknown mode %q", ErrInvalidImport, arg.Mode)
+	case len(arg.Rows) == 0:
+		return ImportResult{}, fmt.Errorf("%w: no rows", ErrInvalidImport)
+	case len(arg.Rows) > MaxImportRows:
+		return ImportResult{}, fmt.Errorf("%w: more than %d rows", ErrInvalidImport, MaxImportRows)
+	}
+
+	result := ImportResult{Mode: arg.Mode, DryRun: arg.DryRun, Rows: make([]ImportRowResult, len(arg.Rows))}
+	params := make([]db.CreateUserParams, len(arg.Rows))
+	emails := make(map[string]int, len(arg.Rows))
+	for i, row := range arg.Rows {
+		result.Rows[i] = ImportRowResult{Line: row.Line, Errors: row.Errors}
+		var err error
+		params[i], err = createUserParams(row.User)
+		var invalid *ValidationError
+		if errors.As(err, &invalid) {
+			result.Rows[i].Errors = append(result.Rows[i].Errors, invalid.Fields...)
+		}
+		if line, ok := emails[row.User.Email]; ok && row.User.Email != "" {
+			result.Rows[i].Errors = append(result.Rows[i].Errors, FieldError{Field: "email", Message: fmt.Sprintf("is also on line %d", line)})
+		} else {
+			emails[row.User.Email] = row.Line
+		}
+	}
+
+	var err error
+	if arg.Mode == ImportAtomic {
+		err = s.importAtomic(ctx, params, &result)
+	} else {
+		err = s.importBestEffort(ctx, params, &result)
+	}
+	if err != nil {
+		return ImportResult{}, err
+	}
+	for _, row := range result.Rows {
+		switch row.Status {
+		case RowCreated, RowValid:
+			result.Created++
+		case RowFailed:
+			result.Failed++
+		}
+	}
+	return result, nil
+}
+
+// importAtomic creates the rows in one transaction.
+func (s *service) importAtomic(ctx context.Context, params []db.CreateUserParams, result *ImportResult) error {
+	failed := slices.ContainsFunc(result.Rows, func(row ImportRowResult) bool { return len(row.Errors) > 0 })
+	if !failed {
+		users := make([]User, len(params))
+		err := s.repo.WithTx(ctx, func(repo repository.UserRepository) error {
+			for i, arg := range params {
+				var err error
+				users[i], err = s.createUser(ctx, repo, arg)
+				if fields, ok := rowErrors(err); ok {
+					result.Rows[i].Errors = fields
+					failed = true
+					return errRollback
+				}
+				if err != nil {
+					return err
+				}
+			}
+			if result.DryRun {
+				return errRollback
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errRollback) {
+			return err
+		}
+		if !failed {
+			for i := range result.Rows {
+				result.Rows[i].Status = RowValid
+				if !result.DryRun {
+					result.Rows[i].Status = RowCreated
+					result.Rows[i].UserID = &users[i].UserID
+				}
+			}
+			return nil
+		}
+	}
+	for i, row := range result.Rows {
+		result.Rows[i].Status = RowSkipped
+		if len(row.Errors) > 0 {
+			result.Rows[i].Status = RowFailed
+		}
+	}
+	return nil
+}
+
+// importBestEffort creates each row in a transaction of its own.
+func (s *service) importBestEffort(ctx context.Context, params []db.CreateUserParams, result *ImportResult) error {
+	for i, arg := range params {
+		row := &result.Rows[i]
+		if len(row.Errors) > 0 {
+			row.Status = RowFailed
+			continue
+		}
+		var user User
+		err := s.repo.WithTx(ctx, func(repo repository.UserRepository) error {
+			var err error
+			if user, err = s.createUser(ctx, repo, arg); err != nil {
+				return err
+			}
+			if result.DryRun {
+				return errRollback
+			}
+			return nil
+		})
+		if fields, ok := rowErrors(err); ok {
+			row.Status, row.Errors = RowFailed, fields
+			continue
+		}
+		switch {
+		case errors.Is(err, errRollback):
+			row.Status = RowValid
+		case err != nil:
+			return err
+		default:
+			row.Status, row.UserID = RowCreated, &user.UserID
+		}
+	}
+	return nil
+}
+
+// rowErrors reports whether err is about the row being created, and why.
+func rowErrors(err error) ([]FieldError, bool) {
+	var invalid *ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		return invalid.Fields, true
+	case errors.Is(err, ErrEmailTaken):
+		return []FieldError{{Field: "email", Message: "is already in use"}}, true
+	}
+	return nil, false
+}
+
+// ExportUsers calls fn with every user matching the filters of arg, in the
+// order of arg.Sort, reading them one page at a time. Limit and Cursor are
+// ignored. It stops at the first error of fn and returns it.
+func (s *service) ExportUsers(ctx context.Context, arg ListUsersParams, fn func(User) error) error {
+	arg.Limit, arg.Cursor, arg.WithTotal = MaxPageSize, "", false
+	for {
+		page, err := s.ListUsers(ctx, arg)
+		if err != nil {
+			return err
+		}
+		for _, user := range page.Users {
+			if err := fn(user); err != nil {
+				return err
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		arg.Cursor = page.NextCursor
+	}
+}
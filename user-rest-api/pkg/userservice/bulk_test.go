@@ -0,0 +1,193 @@
+package userservice_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/repository"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/repository/mocks"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/userservice"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func importRow(line int, email string) userservice.ImportRow {
+	return userservice.ImportRow{Line: line, User: userservice.CreateUserParams{FirstName: "Jo", LastName: "Doe", Email: email}}
+}
+
+// expectCreate makes repo create the user with email, or fail with err.
+func expectCreate(repo *mocks.MockUserRepository, email string, err error) {
+	call := repo.On("CreateUser", mock.Anything, mock.MatchedBy(func(arg db.CreateUserParams) bool {
+		return arg.Email == email
+	})).Once()
+	if err != nil {
+		call.Return(db.User{}, err)
+		return
+	}
+	call.Return(db.User{UserID: uuid.New(), Email: email, Status: userservice.StatusActive}, nil)
+	expectEvent(repo, userservice.SubjectUserCreated)
+}
+
+func rowStatuses(result userservice.ImportResult) []string {
+	statuses := make([]string, len(result.Rows))
+	for i, row := range result.Rows {
+		statuses[i] = row.Status
+	}
+	return statuses
+}
+
+var emailTaken = &pq.Error{Code: "23505", Constraint: "users_email_key"}
+
+func TestImportUsers_Atomic(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+
+	expectTx(repo)
+	expectCreate(repo, "a@example.com", nil)
+	expectCreate(repo, "b@example.com", nil)
+
+	result, err := svc.ImportUsers(context.Background(), userservice.ImportUsersParams{
+		Rows: []userservice.ImportRow{importRow(2, "a@example.com"), importRow(3, "b@example.com")},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, userservice.ImportAtomic, result.Mode)
+	assert.Equal(t, []string{userservice.RowCreated, userservice.RowCreated}, rowStatuses(result))
+	assert.Equal(t, 2, result.Created)
+	assert.NotNil(t, result.Rows[0].UserID)
+	repo.AssertExpectations(t)
+}
+
+func TestImportUsers_AtomicStopsAtFirstFailure(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+
+	expectTx(repo)
+	expectCreate(repo, "a@example.com", nil)
+	expectCreate(repo, "taken@example.com", emailTaken)
+
+	result, err := svc.ImportUsers(context.Background(), userservice.ImportUsersParams{
+		Rows: []userservice.ImportRow{importRow(2, "a@example.com"), importRow(3, "taken@example.com"), importRow(4, "c@example.com")},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{userservice.RowSkipped, userservice.RowFailed, userservice.RowSkipped}, rowStatuses(result))
+	assert.Equal(t, []userservice.FieldError{{Field: "email", Message: "is already in use"}}, result.Rows[1].Errors)
+	assert.Nil(t, result.Rows[0].UserID)
+	assert.Equal(t, 0, result.Created)
+	assert.Equal(t, 1, result.Failed)
+	repo.AssertExpectations(t)
+}
+
+func TestImportUsers_AtomicWithInvalidRowsSkipsDatabase(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+
+	invalid := importRow(3, "b@example.com")
+	invalid.Errors = []userservice.FieldError{{Field: "age", Message: "must be an integer"}}
+	closed := importRow(4, "c@example.com")
+	closed.User.Status = new(string)
+	*closed.User.Status = userservice.StatusClosed
+
+	result, err := svc.ImportUsers(context.Background(), userservice.ImportUsersParams{
+		Rows: []userservice.ImportRow{importRow(2, "a@example.com"), invalid, closed, importRow(5, "a@example.com")},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{userservice.RowSkipped, userservice.RowFailed, userservice.RowFailed, userservice.RowFailed}, rowStatuses(result))
+	assert.Equal(t, "status", result.Rows[2].Errors[0].Field)
+	assert.Equal(t, []userservice.FieldError{{Field: "email", Message: "is also on line 2"}}, result.Rows[3].Errors)
+	repo.AssertNotCalled(t, "WithTx", mock.Anything, mock.Anything)
+}
+
+func TestImportUsers_BestEffort(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+
+	expectTx(repo)
+	expectCreate(repo, "a@example.com", nil)
+	expectCreate(repo, "taken@example.com", emailTaken)
+	expectCreate(repo, "c@example.com", nil)
+	invalid := importRow(4, "")
+	invalid.Errors = []userservice.FieldError{{Field: "email", Message: "is required"}}
+
+	result, err := svc.ImportUsers(context.Background(), userservice.ImportUsersParams{
+		Mode: userservice.ImportBestEffort,
+		Rows: []userservice.ImportRow{importRow(2, "a@example.com"), importRow(3, "taken@example.com"), invalid, importRow(5, "c@example.com")},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{userservice.RowCreated, userservice.RowFailed, userservice.RowFailed, userservice.RowCreated}, rowStatuses(result))
+	assert.Equal(t, 2, result.Created)
+	assert.Equal(t, 2, result.Failed)
+	repo.AssertExpectations(t)
+}
+
+func TestImportUsers_DryRunRollsBack(t *testing.T) {
+	for _, mode := range userservice.ImportModes {
+		t.Run(mode, func(t *testing.T) {
+			repo := new(mocks.MockUserRepository)
+			svc := userservice.NewService(repo)
+
+			// The rows are created, with their events, in transactions
+			// that are rolled back.
+			expectTx(repo)
+			expectCreate(repo, "a@example.com", nil)
+
+			result, err := svc.ImportUsers(context.Background(), userservice.ImportUsersParams{
+				Mode:   mode,
+				DryRun: true,
+				Rows:   []userservice.ImportRow{importRow(2, "a@example.com")},
+			})
+
+			assert.NoError(t, err)
+			assert.Equal(t, []string{userservice.RowValid}, rowStatuses(result))
+			assert.Nil(t, result.Rows[0].UserID)
+			assert.Equal(t, 1, result.Created)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestImportUsers_RejectsInvalidParams(t *testing.T) {
+	svc := userservice.NewService(new(mocks.MockUserRepository))
+
+	for name, arg := range map[string]userservice.ImportUsersParams{
+		"unknown mode":  {Mode: "partial", Rows: []userservice.ImportRow{importRow(2, "a@example.com")}},
+		"no rows":       {},
+		"too many rows": {Rows: make([]userservice.ImportRow, userservice.MaxImportRows+1)},
+	} {
+		_, err := svc.ImportUsers(context.Background(), arg)
+		assert.ErrorIs(t, err, userservice.ErrInvalidImport, name)
+	}
+}
+
+func TestExportUsers_ReadsEveryPage(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+
+	rows := make([]db.User, userservice.MaxPageSize+2)
+	for i := range rows {
+		rows[i] = db.User{UserID: uuid.New()}
+	}
+	repo.On("ListUsers", mock.Anything, mock.MatchedBy(func(arg repository.ListUsersParams) bool {
+		return !arg.AfterID.Valid && arg.PageSize == userservice.MaxPageSize+1 && arg.Status.String == userservice.StatusActive
+	})).Return(rows[:userservice.MaxPageSize+1], nil).Once()
+	repo.On("ListUsers", mock.Anything, mock.MatchedBy(func(arg repository.ListUsersParams) bool {
+		return arg.AfterID.UUID == rows[userservice.MaxPageSize-1].UserID
+	})).Return(rows[userservice.MaxPageSize:], nil).Once()
+
+	var ids []uuid.UUID
+	err := svc.ExportUsers(context.Background(), userservice.ListUsersParams{Status: userservice.StatusActive, Limit: 1}, func(user userservice.User) error {
+		ids = append(ids, user.UserID)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Len(t, ids, len(rows))
+	assert.Equal(t, rows[len(rows)-1].UserID, ids[len(ids)-1])
+	repo.AssertExpectations(t)
+}
@@ -0,0 +1,98 @@
+package userservice
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/repository"
+)
+
+// EraseUser pseudonymises a user, closes it and removes its personal data
+// from the audit log and from the events still held in the database, in one
+// transaction. Deleted users can be erased too. Erasing an erased user
+// returns it unchanged, so that an erasure can be retried.
+func (s *service) EraseUser(ctx context.Context, arg EraseUserParams) (User, error) {
+	if arg.Reason == "" {
+		return User{}, &ValidationError{Fields: []FieldError{{Field: "reason", Message: "is required"}}}
+	}
+	var user User
+	err := s.repo.WithTx(ctx, func(repo repository.UserRepository) error {
+		before, err := repo.GetUserForUpdate(ctx, arg.UserID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if before.ErasedAt.Valid {
+			user = toPublicUser(before)
+			return nil
+		}
+		if err := checkVersion(before, arg.ExpectedVersion); err != nil {
+			return err
+		}
+		erased, err := repo.EraseUser(ctx, arg.UserID)
+		if err != nil {
+			return err
+		}
+		user = toPublicUser(erased)
+		// The erasure is recorded first, so that its own audit entry and
+		// event are scrubbed too.
+		if err := s.recordChange(ctx, repo, AuditErased, arg.Reason, user.UserID, toPublicUser(before), user); err != nil {
+			return err
+		}
+		return scrubUserData(ctx, repo, arg.UserID)
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+func (s *service) UsersWithEventsNotErased(ctx context.Context, limit int32) ([]uuid.UUID, error) {
+	return s.repo.ListUsersWithEventsNotErased(ctx, limit)
+}
+
+func (s *service) MarkEventsErased(ctx context.Context, userID uuid.UUID) error {
+	return s.repo.MarkEventsErased(ctx, userID)
+}
+
+// scrubUserData removes the personal data of erased or purged users from the
+// audit log and from the events still held in the database. The events
+// published to JetStream are erased by the caller, see EventEraser.
+func scrubUserData(ctx context.Context, repo repository.UserRepository, userIDs ...uuid.UUID) error {
+	for _, id := range userIDs {
+		if err := repo.ScrubUserData(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *service) ExportPersonalData(ctx context.Context, userID uuid.UUID) (PersonalData, error) {
+	u, err := s.repo.GetUserIncludingDeleted(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PersonalData{}, ErrUserNotFound
+	}
+	if err != nil {
+		return PersonalData{}, err
+	}
+	data := PersonalData{User: toPublicUser(u), Audit: []AuditEntry{}}
+	arg := ListAuditParams{UserID: userID, Limit: MaxPageSize}
+	for {
+		page, err := s.ListUserAudit(ctx, arg)
+		if err != nil {
+			return PersonalData{}, err
+		}
+		data.Audit = append(data.Audit, page.Entries...)
+		if page.NextCursor == "" {
+			break
+		}
+		arg.Cursor = page.NextCursor
+	}
+	slices.Reverse(data.Audit)
+	return data, nil
+}
@@ -0,0 +1,143 @@
+package userservice_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/repository/mocks"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/events"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/userservice"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestEraseUser(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+	id := uuid.New()
+	version := int32(2)
+
+	expectTx(repo)
+	repo.On("GetUserForUpdate", mock.Anything, id).Return(db.User{UserID: id, Email: "john@example.com", Version: 2}, nil)
+	repo.On("EraseUser", mock.Anything, id).Return(db.User{
+		UserID: id, Email: id.String() + "@erased.invalid", Status: userservice.StatusClosed, Version: 3,
+		ErasedAt: sql.NullTime{Time: time.Now(), Valid: true},
+	}, nil)
+	var audit db.InsertUserAuditParams
+	repo.On("InsertOutboxEvent", mock.Anything, mock.MatchedBy(func(arg db.InsertOutboxEventParams) bool {
+		return arg.Subject == userservice.SubjectUserUpdated
+	})).Return(db.Outbox{}, nil).Once()
+	audited := repo.On("InsertUserAudit", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { audit = args.Get(1).(db.InsertUserAuditParams) }).
+		Return(nil).Once()
+	// The entry and event of the erasure are scrubbed with the others.
+	repo.On("ScrubUserData", mock.Anything, id).Return(nil).Once().NotBefore(audited)
+
+	user, err := svc.EraseUser(context.Background(), userservice.EraseUserParams{UserID: id, Reason: "GDPR request", ExpectedVersion: &version})
+
+	assert.NoError(t, err)
+	assert.Equal(t, userservice.StatusClosed, user.Status)
+	assert.NotNil(t, user.ErasedAt)
+	assert.Equal(t, userservice.AuditErased, audit.Action)
+	assert.Equal(t, "GDPR request", audit.Reason.String)
+	repo.AssertExpectations(t)
+}
+
+func TestPurgeDeletedUsers_ScrubsPurgedUsers(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+	first, second := uuid.New(), uuid.New()
+	before := time.Now()
+
+	expectTx(repo)
+	purged := repo.On("PurgeDeletedUsers", mock.Anything, db.PurgeDeletedUsersParams{Source: events.SourceRESTAPI, DeletedBefore: before}).
+		Return([]uuid.UUID{first, second}, nil)
+	repo.On("ScrubUserData", mock.Anything, first).Return(nil).Once().NotBefore(purged)
+	repo.On("ScrubUserData", mock.Anything, second).Return(nil).Once().NotBefore(purged)
+
+	ids, err := svc.PurgeDeletedUsers(context.Background(), before)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{first, second}, ids)
+	repo.AssertExpectations(t)
+}
+
+func TestEraseUser_ErasedUserIsUnchanged(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+	id := uuid.New()
+	stale := int32(1)
+
+	expectTx(repo)
+	repo.On("GetUserForUpdate", mock.Anything, id).Return(db.User{
+		UserID: id, Version: 3, ErasedAt: sql.NullTime{Time: time.Now(), Valid: true},
+	}, nil)
+
+	user, err := svc.EraseUser(context.Background(), userservice.EraseUserParams{UserID: id, Reason: "retry", ExpectedVersion: &stale})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), user.Version)
+	repo.AssertNotCalled(t, "EraseUser", mock.Anything, mock.Anything)
+	repo.AssertNotCalled(t, "InsertUserAudit", mock.Anything, mock.Anything)
+}
+
+func TestEraseUser_Errors(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+	missing, current := uuid.New(), uuid.New()
+	stale := int32(1)
+
+	_, err := svc.EraseUser(context.Background(), userservice.EraseUserParams{UserID: missing})
+	assert.ErrorIs(t, err, userservice.ErrValidation)
+
+	expectTx(repo)
+	repo.On("GetUserForUpdate", mock.Anything, missing).Return(db.User{}, sql.ErrNoRows)
+	repo.On("GetUserForUpdate", mock.Anything, current).Return(db.User{UserID: current, Version: 2}, nil)
+
+	_, err = svc.EraseUser(context.Background(), userservice.EraseUserParams{UserID: missing, Reason: "GDPR request"})
+	assert.ErrorIs(t, err, userservice.ErrUserNotFound)
+	_, err = svc.EraseUser(context.Background(), userservice.EraseUserParams{UserID: current, Reason: "GDPR request", ExpectedVersion: &stale})
+	assert.ErrorIs(t, err, userservice.ErrVersionMismatch)
+	repo.AssertNotCalled(t, "EraseUser", mock.Anything, mock.Anything)
+}
+
+func TestErasedUsersCannotBeChanged(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+	id := uuid.New()
+
+	expectTx(repo)
+	repo.On("GetUserForUpdate", mock.Anything, id).Return(db.User{
+		UserID: id, Version: 3, ErasedAt: sql.NullTime{Time: time.Now(), Valid: true},
+	}, nil)
+
+	_, err := svc.UpdateUser(context.Background(), userservice.UpdateUserParams{UserID: id, FirstName: userservice.Value("John")})
+	assert.ErrorIs(t, err, userservice.ErrUserErased)
+	_, err = svc.ChangeStatus(context.Background(), userservice.ChangeStatusParams{UserID: id, Status: userservice.StatusActive, Reason: "appeal"})
+	assert.ErrorIs(t, err, userservice.ErrUserErased)
+}
+
+func TestExportPersonalData(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+	id := uuid.New()
+
+	repo.On("GetUserIncludingDeleted", mock.Anything, id).Return(db.User{UserID: id, Email: "john@example.com"}, nil)
+	repo.On("ListUserAudit", mock.Anything, mock.Anything).
+		Return([]db.UserAudit{{ID: 9, UserID: id, Action: "updated"}, {ID: 3, UserID: id, Action: "created"}}, nil).Once()
+
+	data, err := svc.ExportPersonalData(context.Background(), id)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "john@example.com", data.User.Email)
+	assert.Equal(t, []string{userservice.AuditCreated, userservice.AuditUpdated}, []string{data.Audit[0].Action, data.Audit[1].Action})
+
+	missing := uuid.New()
+	repo.On("GetUserIncludingDeleted", mock.Anything, missing).Return(db.User{}, sql.ErrNoRows)
+	_, err = svc.ExportPersonalData(context.Background(), missing)
+	assert.ErrorIs(t, err, userservice.ErrUserNotFound)
+}
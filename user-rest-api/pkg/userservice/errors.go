@@ -0,0 +1,68 @@
+package userservice
+
+import (
+	"errors"
+	"strings"
+)
+
+// Kinds of errors returned by the service. Every error it defines matches one
+// of them with errors.Is, so callers can map errors to responses without
+// knowing each one.
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrConflict   = errors.New("conflict")
+	ErrValidation = errors.New("validation failed")
+)
+
+var (
+	ErrUserNotFound = newError(ErrNotFound, "user not found")
+	// ErrEmailTaken is returned when another user has the email address.
+	ErrEmailTaken = newError(ErrConflict, "email address is already in use")
+	// ErrVersionMismatch is returned when a user is not at the version the
+	// caller expected, because someone else changed it in the meantime.
+	ErrVersionMismatch = newError(ErrConflict, "version mismatch")
+	ErrInvalidUpdate   = newError(ErrValidation, "invalid update")
+	// ErrUserNotDeleted is returned when restoring a user that is not deleted.
+	ErrUserNotDeleted = newError(ErrConflict, "user is not deleted")
+	// ErrInvalidTransition is returned when a user cannot change to a status
+	// from its current one.
+	ErrInvalidTransition = newError(ErrConflict, "status transition not allowed")
+	// ErrUserErased is returned when changing a user whose personal data was
+	// erased.
+	ErrUserErased = newError(ErrConflict, "user is erased")
+)
+
+// kindError is an error of one of the kinds above.
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func newError(kind error, msg string) error {
+	return &kindError{kind: kind, msg: msg}
+}
+
+func (e *kindError) Error() string { return e.msg }
+func (e *kindError) Unwrap() error { return e.kind }
+
+// FieldError says why the value of a field is invalid.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists the invalid fields of a request. It matches
+// ErrValidation.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Field + " " + f.Message
+	}
+	return strings.Join(msgs, ", ")
+}
+
+func (e *ValidationError) Unwrap() error { return ErrValidation }
@@ -0,0 +1,187 @@
+package userservice
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/repository"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+// SortFields are the fields users can be sorted by.
+var SortFields = []string{"created_at", "email", "first_name", "last_name", "age"}
+
+var ErrInvalidListParams = newError(ErrValidation, "invalid list parameters")
+
+// cursor is the position after the last user of a page: the sort, the value
+// of the sort column and the ID of the user. It is encoded as base64 JSON,
+// which clients must treat as opaque.
+type cursor struct {
+	Sort string    `json:"s"`
+	Key  string    `json:"k"`
+	ID   uuid.UUID `json:"id"`
+}
+
+func (c cursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(b, &c)
+	return c, err
+}
+
+func (s *service) ListUsers(ctx context.Context, arg ListUsersParams) (UserPage, error) {
+	query, err := listQuery(arg)
+	if err != nil {
+		return UserPage{}, err
+	}
+	limit := query.PageSize
+	query.PageSize++ // one more tells whether there is a next page
+
+	rows, err := s.repo.ListUsers(ctx, query)
+	if err != nil {
+		return UserPage{}, err
+	}
+	page := UserPage{Users: make([]User, 0, min(len(rows), int(limit)))}
+	for i, row := range rows {
+		if i == int(limit) {
+			last := rows[i-1]
+			page.NextCursor = cursor{Sort: arg.Sort, Key: sortKey(last, query.SortBy), ID: last.UserID}.encode()
+			break
+		}
+		page.Users = append(page.Users, toPublicUser(row))
+	}
+
+	if arg.WithTotal {
+		total, err := s.repo.CountUsers(ctx, db.CountUsersParams{
+			IncludeDeleted: query.IncludeDeleted,
+			Status:         query.Status,
+			MinAge:         query.MinAge,
+			MaxAge:         query.MaxAge,
+			EmailPrefix:    query.EmailPrefix,
+			NamePrefix:     query.NamePrefix,
+		})
+		if err != nil {
+			return UserPage{}, err
+		}
+		page.Total = &total
+	}
+	return page, nil
+}
+
+func listQuery(arg ListUsersParams) (repository.ListUsersParams, error) {
+	query := repository.ListUsersParams{
+		SortBy:         strings.TrimPrefix(arg.Sort, "-"),
+		Descending:     strings.HasPrefix(arg.Sort, "-"),
+		IncludeDeleted: arg.IncludeDeleted,
+		Status:         sql.NullString{String: arg.Status, Valid: arg.Status != ""},
+		EmailPrefix:    likePrefix(arg.EmailPrefix),
+		NamePrefix:     likePrefix(arg.NamePrefix),
+		PageSize:       min(arg.Limit, MaxPageSize),
+	}
+	if query.SortBy == "" {
+		query.SortBy = SortFields[0]
+	}
+	if !slices.Contains(SortFields, query.SortBy) {
+		return query, fmt.Errorf("%w: cannot sort by %q", ErrInvalidListParams, query.SortBy)
+	}
+	if arg.Status != "" && !slices.Contains(Statuses, arg.Status) {
+		return query, fmt.Errorf("%w: unknown status %q", ErrInvalidListParams, arg.Status)
+	}
+	switch {
+	case arg.Limit < 0:
+		return query, fmt.Errorf("%w: negative limit", ErrInvalidListParams)
+	case arg.Limit == 0:
+		query.PageSize = DefaultPageSize
+	}
+	if arg.MinAge != nil {
+		query.MinAge = sql.NullInt32{Int32: *arg.MinAge, Valid: true}
+	}
+	if arg.MaxAge != nil {
+		query.MaxAge = sql.NullInt32{Int32: *arg.MaxAge, Valid: true}
+	}
+	if query.MinAge.Valid && query.MaxAge.Valid && query.MinAge.Int32 > query.MaxAge.Int32 {
+		return query, fmt.Errorf("%w: min_age is greater than max_age", ErrInvalidListParams)
+	}
+	if arg.Cursor != "" {
+		c, err := decodeCursor(arg.Cursor)
+		if err != nil || c.ID == uuid.Nil {
+			return query, fmt.Errorf("%w: malformed cursor", ErrInvalidListParams)
+		}
+		if c.Sort != arg.Sort {
+			return query, fmt.Errorf("%w: cursor was issued for a different sort", ErrInvalidListParams)
+		}
+		if err := setAfterKey(&query, c.Key); err != nil {
+			return query, fmt.Errorf("%w: malformed cursor", ErrInvalidListParams)
+		}
+		query.AfterID = uuid.NullUUID{UUID: c.ID, Valid: true}
+	}
+	return query, nil
+}
+
+// sortKey returns the value of the sort column of user for a cursor.
+func sortKey(user db.User, sortBy string) string {
+	switch sortBy {
+	case "email":
+		return user.Email
+	case "first_name":
+		return user.FirstName
+	case "last_name":
+		return user.LastName
+	case "age":
+		return strconv.Itoa(int(user.Age.Int32))
+	default:
+		return user.CreatedAt.Format(time.RFC3339Nano)
+	}
+}
+
+// setAfterKey sets the key of query to the sort key of a cursor.
+func setAfterKey(query *repository.ListUsersParams, key string) error {
+	switch query.SortBy {
+	case "email", "first_name", "last_name":
+		query.AfterText = key
+	case "age":
+		age, err := strconv.ParseInt(key, 10, 32)
+		if err != nil {
+			return err
+		}
+		query.AfterAge = int32(age)
+	default:
+		t, err := time.Parse(time.RFC3339Nano, key)
+		if err != nil {
+			return err
+		}
+		query.AfterTime = t
+	}
+	return nil
+}
+
+// likePrefix turns a prefix into a case-insensitive LIKE operand, escaping
+// the LIKE wildcards it contains.
+func likePrefix(prefix string) sql.NullString {
+	if prefix == "" {
+		return sql.NullString{}
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(prefix))
+	return sql.NullString{String: escaped, Valid: true}
+}
@@ -1,6 +1,11 @@
 package userservice
 
-import "github.com/google/uuid"
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type User struct {
 	UserID    uuid.UUID `json:"user_id"`
@@ -9,7 +14,18 @@ type User struct {
 	Email     string    `json:"email"`
 	Phone     *string   `json:"phone,omitempty"`
 	Age       *int32    `json:"age,omitempty"`
-	Status    *string   `json:"status,omitempty"`
+	// Status is one of Statuses. It is changed with ChangeStatus.
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Version is incremented by every update. Pass it as ExpectedVersion to
+	// update or delete the user only if nobody changed it since.
+	Version int32 `json:"version"`
+	// DeletedAt is set on deleted users, which are only listed on request.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// ErasedAt is set on users whose personal data was erased. They are
+	// closed and cannot be changed.
+	ErasedAt *time.Time `json:"erased_at,omitempty"`
 }
 
 type CreateUserParams struct {
@@ -18,15 +34,211 @@ type CreateUserParams struct {
 	Email     string  `json:"email"`
 	Phone     *string `json:"phone,omitempty"`
 	Age       *int32  `json:"age,omitempty"`
-	Status    *string `json:"status,omitempty"`
+	// Status is StatusPending or StatusActive, the default.
+	Status *string `json:"status,omitempty"`
 }
 
+// UpdateUserParams is a partial update with JSON Merge Patch semantics:
+// unset fields are left unchanged and null clears a field. FirstName,
+// LastName and Email cannot be cleared. Status may only be set to the current
+// status; it is changed with ChangeStatus.
 type UpdateUserParams struct {
-	UserID    uuid.UUID `json:"user_id"`
-	FirstName string    `json:"first_name"`
-	LastName  string    `json:"last_name"`
-	Email     string    `json:"email"`
-	Phone     *string   `json:"phone,omitempty"`
-	Age       *int32    `json:"age,omitempty"`
-	Status    *string   `json:"status,omitempty"`
+	UserID    uuid.UUID        `json:"user_id"`
+	FirstName Optional[string] `json:"first_name,omitzero"`
+	LastName  Optional[string] `json:"last_name,omitzero"`
+	Email     Optional[string] `json:"email,omitzero"`
+	Phone     Optional[string] `json:"phone,omitzero"`
+	Age       Optional[int32]  `json:"age,omitzero"`
+	Status    Optional[string] `json:"status,omitzero"`
+	// ExpectedVersion, if set, makes the update fail with ErrVersionMismatch
+	// unless the user is at that version.
+	ExpectedVersion *int32 `json:"expected_version,omitempty"`
+}
+
+type DeleteUserParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	// ExpectedVersion, if set, makes the delete fail with ErrVersionMismatch
+	// unless the user is at that version.
+	ExpectedVersion *int32 `json:"expected_version,omitempty"`
+}
+
+// ChangeStatusParams moves a user to another status, if the lifecycle allows
+// it. Reason is required and goes into the audit log.
+type ChangeStatusParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	Status string    `json:"status"`
+	Reason string    `json:"reason"`
+	// ExpectedVersion, if set, makes the change fail with ErrVersionMismatch
+	// unless the user is at that version.
+	ExpectedVersion *int32 `json:"expected_version,omitempty"`
+}
+
+// EraseUserParams erases the personal data of a user. Reason is required and
+// goes into the audit log.
+type EraseUserParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	Reason string    `json:"reason"`
+	// ExpectedVersion, if set, makes the erasure fail with
+	// ErrVersionMismatch unless the user is at that version.
+	ExpectedVersion *int32 `json:"expected_version,omitempty"`
+}
+
+// PersonalData is everything held about a user in the database. Audit is
+// oldest first.
+type PersonalData struct {
+	User  User         `json:"user"`
+	Audit []AuditEntry `json:"audit"`
+}
+
+type RestoreUserParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	// ExpectedVersion, if set, makes the restore fail with ErrVersionMismatch
+	// unless the user is at that version.
+	ExpectedVersion *int32 `json:"expected_version,omitempty"`
+}
+
+// ListUsersParams selects a page of users. Filters left empty match every
+// user.
+type ListUsersParams struct {
+	// Limit is the page size; 0 means DefaultPageSize and it is capped at
+	// MaxPageSize.
+	Limit int32 `json:"limit,omitempty"`
+	// Cursor is the NextCursor of the previous page, empty for the first one.
+	// It is only valid with the same Sort.
+	Cursor string `json:"cursor,omitempty"`
+	// Sort is one of SortFields, prefixed with "-" for descending order.
+	// Defaults to created_at.
+	Sort        string `json:"sort,omitempty"`
+	Status      string `json:"status,omitempty"`
+	MinAge      *int32 `json:"min_age,omitempty"`
+	MaxAge      *int32 `json:"max_age,omitempty"`
+	EmailPrefix string `json:"email_prefix,omitempty"`
+	// NamePrefix matches the first or the last name.
+	NamePrefix string `json:"name_prefix,omitempty"`
+	WithTotal  bool   `json:"with_total,omitempty"`
+	// IncludeDeleted lists deleted users along with the others.
+	IncludeDeleted bool `json:"include_deleted,omitempty"`
+}
+
+type UserPage struct {
+	Users []User `json:"users"`
+	// NextCursor is empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+	// Total counts every user matching the filters, if requested.
+	Total *int64 `json:"total,omitempty"`
+}
+
+// SearchResult is a user matching a search. Highlights holds the fields
+// that contain a search term, HTML escaped, with the terms wrapped in
+// <mark></mark>.
+type SearchResult struct {
+	User       User              `json:"user"`
+	Rank       float64           `json:"rank"`
+	Highlights map[string]string `json:"highlights,omitempty"`
+}
+
+// ListAuditParams selects a page of the audit log of a user.
+type ListAuditParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	// Limit is the page size; 0 means DefaultPageSize and it is capped at
+	// MaxPageSize.
+	Limit int32 `json:"limit,omitempty"`
+	// Cursor is the NextCursor of the previous page, empty for the first one.
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// AuditEntry is a change made to a user. Before is absent for created users
+// and After for deleted ones; purge entries only list the changed fields.
+// Once a user is erased, Before and After no longer hold its personal data.
+type AuditEntry struct {
+	ID     int64     `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+	// Action is one of AuditCreated, AuditUpdated, AuditDeleted,
+	// AuditRestored, AuditPurged, AuditActivated, AuditSuspended,
+	// AuditClosed and AuditErased.
+	Action string `json:"action"`
+	// Actor is whoever made the change, if known.
+	Actor string `json:"actor,omitempty"`
+	// Reason is given for status changes.
+	Reason string `json:"reason,omitempty"`
+	// Source is the CloudEvents source of the service the change was made
+	// through, e.g. events.SourceRESTAPI.
+	Source    string          `json:"source"`
+	Changed   []string        `json:"changed"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type AuditPage struct {
+	Entries []AuditEntry `json:"entries"`
+	// NextCursor is empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ImportUsersParams are the rows of an imported file and how to import them.
+type ImportUsersParams struct {
+	Rows []ImportRow `json:"rows"`
+	// Mode is one of ImportModes. Defaults to ImportAtomic.
+	Mode string `json:"mode,omitempty"`
+	// DryRun checks the rows, against the database too, without creating
+	// any user.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// ImportRow is a user to create. Errors, if any, are the problems found
+// decoding the row, which fail it.
+type ImportRow struct {
+	// Line is where the row is in the imported file.
+	Line   int              `json:"line"`
+	User   CreateUserParams `json:"user"`
+	Errors []FieldError     `json:"errors,omitempty"`
+}
+
+type ImportResult struct {
+	Mode   string `json:"mode"`
+	DryRun bool   `json:"dry_run"`
+	// Created counts the rows created, or that would have been by a dry run.
+	Created int               `json:"created"`
+	Failed  int               `json:"failed"`
+	Rows    []ImportRowResult `json:"rows"`
+}
+
+type ImportRowResult struct {
+	Line int `json:"line"`
+	// Status is RowCreated, RowValid, RowFailed or RowSkipped.
+	Status string `json:"status"`
+	// UserID is set on created rows.
+	UserID *uuid.UUID   `json:"user_id,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// BatchOperation is one operation of a batch. Op is OpCreate, OpUpdate or
+// OpDelete, and the field of the same name holds its parameters.
+type BatchOperation struct {
+	Op     string           `json:"op"`
+	Create CreateUserParams `json:"create,omitzero"`
+	Update UpdateUserParams `json:"update,omitzero"`
+	Delete DeleteUserParams `json:"delete,omitzero"`
+	// Err, if set, is a problem found decoding the operation, which fails
+	// it.
+	Err error `json:"-"`
+}
+
+type BatchResult struct {
+	// Committed is false if an operation failed, in which case none was
+	// applied.
+	Committed bool                   `json:"committed"`
+	Results   []BatchOperationResult `json:"results"`
+}
+
+type BatchOperationResult struct {
+	Op string `json:"op"`
+	// Status is OpSucceeded, OpFailed, OpRolledBack or OpSkipped.
+	Status string `json:"status"`
+	// User is the user created or updated by a succeeded operation.
+	User *User `json:"user,omitempty"`
+	// Err is why the operation failed: the Err of the BatchOperation, or an
+	// error matching ErrNotFound, ErrConflict or ErrValidation.
+	Err error `json:"-"`
 }
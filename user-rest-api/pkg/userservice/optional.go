@@ -0,0 +1,55 @@
+package userservice
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Optional is a field of a JSON Merge Patch (RFC 7396). A field absent from
+// the document is left unset, null sets it to null, and any other value sets
+// it to that value.
+type Optional[T any] struct {
+	Set   bool
+	Null  bool
+	Value T
+}
+
+// Value returns a set Optional holding v.
+func Value[T any](v T) Optional[T] {
+	return Optional[T]{Set: true, Value: v}
+}
+
+// Null returns a set Optional holding null.
+func Null[T any]() Optional[T] {
+	return Optional[T]{Set: true, Null: true}
+}
+
+// FromPtr returns v, or null if v is nil.
+func FromPtr[T any](v *T) Optional[T] {
+	if v == nil {
+		return Null[T]()
+	}
+	return Value(*v)
+}
+
+func (o *Optional[T]) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		o.Null = true
+		return nil
+	}
+	return json.Unmarshal(data, &o.Value)
+}
+
+// MarshalJSON writes the value, or null if it is null or unset. Unset fields
+// are only omitted by encoders that support omitzero.
+func (o Optional[T]) MarshalJSON() ([]byte, error) {
+	if !o.Set || o.Null {
+		return []byte("null"), nil
+	}
+	return json.Marshal(o.Value)
+}
+
+func (o Optional[T]) IsZero() bool {
+	return !o.Set
+}
@@ -0,0 +1,27 @@
+package userservice
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptional_UnmarshalDistinguishesAbsentNullAndValue(t *testing.T) {
+	var p struct {
+		A Optional[string] `json:"a"`
+		B Optional[string] `json:"b"`
+		C Optional[int32]  `json:"c"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(`{"b":null,"c":7}`), &p))
+
+	assert.Equal(t, Optional[string]{}, p.A)
+	assert.Equal(t, Null[string](), p.B)
+	assert.Equal(t, Value(int32(7)), p.C)
+}
+
+func TestOptional_MarshalOmitsUnsetFields(t *testing.T) {
+	b, err := json.Marshal(UpdateUserParams{Phone: Null[string](), Age: Value(int32(3))})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"user_id":"00000000-0000-0000-0000-000000000000","phone":null,"age":3}`, string(b))
+}
@@ -0,0 +1,65 @@
+package userservice
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// eventErasureBatch is how many users PurgeDeleted erases the events of at
+// most on each run.
+const eventErasureBatch = 100
+
+// EventEraser deletes the events about a user published outside the
+// database. It is implemented by streams.UserData.
+type EventEraser interface {
+	EraseEvents(ctx context.Context, userID uuid.UUID) (int, error)
+}
+
+// PurgeDeleted purges the users that were deleted longer than retention ago
+// every interval until ctx is done, and erases their events with events if it
+// is not nil. Which users still have events to erase is recorded in the
+// database, so users erased on request whose events could not be erased then,
+// and users whose events fail to be erased, are tried again on the next run,
+// even after a restart.
+func PurgeDeleted(ctx context.Context, s UserService, events EventEraser, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		purged, err := s.PurgeDeletedUsers(ctx, time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("Failed to purge deleted users: %v\n", err)
+		} else if len(purged) > 0 {
+			log.Printf("Purged %d deleted users\n", len(purged))
+		}
+		if events != nil {
+			eraseEvents(ctx, s, events)
+		}
+	}
+}
+
+// eraseEvents erases the events of the erased users whose events are not
+// erased yet, and records it for each user it succeeds for.
+func eraseEvents(ctx context.Context, s UserService, events EventEraser) {
+	users, err := s.UsersWithEventsNotErased(ctx, eventErasureBatch)
+	if err != nil {
+		log.Printf("Failed to list users with events to erase: %v\n", err)
+		return
+	}
+	for _, id := range users {
+		if _, err := events.EraseEvents(ctx, id); err != nil {
+			log.Printf("Failed to erase events of erased user %s: %v\n", id, err)
+			continue
+		}
+		if err := s.MarkEventsErased(ctx, id); err != nil {
+			log.Printf("Failed to record erased events of user %s: %v\n", id, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package userservice_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/userservice"
+	"github.com/stretchr/testify/assert"
+)
+
+// purgingService purges users once and keeps which erased users have their
+// events erased, like the database; other methods are not implemented.
+type purgingService struct {
+	userservice.UserService
+	mu        sync.Mutex
+	purged    []uuid.UUID
+	notErased []uuid.UUID
+}
+
+func (s *purgingService) PurgeDeletedUsers(context.Context, time.Time) ([]uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	purged := s.purged
+	s.purged = nil
+	s.notErased = append(s.notErased, purged...)
+	return purged, nil
+}
+
+func (s *purgingService) UsersWithEventsNotErased(_ context.Context, limit int32) ([]uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.notErased[:min(int(limit), len(s.notErased))]), nil
+}
+
+func (s *purgingService) MarkEventsErased(_ context.Context, userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notErased = slices.DeleteFunc(s.notErased, func(id uuid.UUID) bool { return id == userID })
+	return nil
+}
+
+// flakyEraser fails to erase the events of a user the first time.
+type flakyEraser struct {
+	mu     sync.Mutex
+	calls  map[uuid.UUID]int
+	erased []uuid.UUID
+}
+
+func (e *flakyEraser) EraseEvents(_ context.Context, userID uuid.UUID) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.calls[userID]++
+	if e.calls[userID] == 1 {
+		return 0, errors.New("stream unavailable")
+	}
+	e.erased = append(e.erased, userID)
+	return 1, nil
+}
+
+func TestPurgeDeleted_RetriesEventErasure(t *testing.T) {
+	purged, erasedBefore := uuid.New(), uuid.New()
+	eraser := &flakyEraser{calls: make(map[uuid.UUID]int)}
+	// erasedBefore was erased before a restart, without its events.
+	service := &purgingService{purged: []uuid.UUID{purged}, notErased: []uuid.UUID{erasedBefore}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go userservice.PurgeDeleted(ctx, service, eraser, time.Hour, time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		service.mu.Lock()
+		defer service.mu.Unlock()
+		return len(service.notErased) == 0
+	}, time.Second, time.Millisecond)
+	cancel()
+	eraser.mu.Lock()
+	defer eraser.mu.Unlock()
+	assert.ElementsMatch(t, []uuid.UUID{erasedBefore, purged}, eraser.erased)
+	assert.Equal(t, 2, eraser.calls[purged])
+	assert.Equal(t, 2, eraser.calls[erasedBefore])
+}
@@ -0,0 +1,137 @@
+package userservice
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
+)
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+	minSearchLength    = 2
+)
+
+var ErrInvalidSearch = newError(ErrValidation, "invalid search")
+
+// SearchUsers finds users by partial name, email or phone number, best
+// matches first.
+func (s *service) SearchUsers(ctx context.Context, query string, limit int32) ([]SearchResult, error) {
+	query = strings.TrimSpace(query)
+	if utf8.RuneCountInString(query) < minSearchLength {
+		return nil, fmt.Errorf("%w: query must have at least %d characters", ErrInvalidSearch, minSearchLength)
+	}
+	switch {
+	case limit < 0:
+		return nil, fmt.Errorf("%w: negative limit", ErrInvalidSearch)
+	case limit == 0:
+		limit = DefaultSearchLimit
+	}
+
+	terms := searchTerms(query)
+	arg := db.SearchUsersParams{
+		Tsquery:     tsQuery(terms),
+		Term:        strings.ToLower(query),
+		ResultLimit: min(limit, MaxSearchLimit),
+	}
+	if digits := onlyDigits(query); len(digits) >= minSearchLength {
+		arg.Phone = sql.NullString{String: digits, Valid: true}
+	}
+	rows, err := s.repo.SearchUsers(ctx, arg)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]SearchResult, 0, len(rows))
+	for _, row := range rows {
+		user := toPublicUser(row.User)
+		results = append(results, SearchResult{User: user, Rank: row.Rank, Highlights: highlights(user, terms)})
+	}
+	return results, nil
+}
+
+// searchTerms splits a query into lower case words of letters and digits.
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// tsQuery matches every term as a word prefix, e.g. "jo:* & smi:*". The
+// terms contain no tsquery operators.
+func tsQuery(terms []string) string {
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		parts[i] = t + ":*"
+	}
+	return strings.Join(parts, " & ")
+}
+
+func onlyDigits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+func highlights(u User, terms []string) map[string]string {
+	fields := map[string]string{"first_name": u.FirstName, "last_name": u.LastName, "email": u.Email}
+	if u.Phone != nil {
+		fields["phone"] = *u.Phone
+	}
+	out := make(map[string]string)
+	for name, value := range fields {
+		if marked, ok := mark(value, terms); ok {
+			out[name] = marked
+		}
+	}
+	return out
+}
+
+// mark escapes value and wraps every case-insensitive occurrence of a term
+// in <mark></mark>. Overlapping occurrences are merged.
+func mark(value string, terms []string) (string, bool) {
+	lower := strings.ToLower(value)
+	if len(lower) != len(value) {
+		return "", false // case mapping changed byte offsets
+	}
+	marked := make([]bool, len(value))
+	found := false
+	for _, t := range terms {
+		for i := 0; ; {
+			j := strings.Index(lower[i:], t)
+			if j < 0 {
+				break
+			}
+			for k := i + j; k < i+j+len(t); k++ {
+				marked[k] = true
+			}
+			found = true
+			i += j + len(t)
+		}
+	}
+	if !found {
+		return "", false
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); {
+		j := i
+		for j < len(value) && marked[j] == marked[i] {
+			j++
+		}
+		if marked[i] {
+			b.WriteString("<mark>" + html.EscapeString(value[i:j]) + "</mark>")
+		} else {
+			b.WriteString(html.EscapeString(value[i:j]))
+		}
+		i = j
+	}
+	return b.String(), true
+}
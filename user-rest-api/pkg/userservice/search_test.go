@@ -0,0 +1,73 @@
+package userservice
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/repository/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSearchUsers_BuildsQueryAndHighlights(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := NewService(repo)
+
+	phone := "555-0100"
+	row := db.SearchUsersRow{
+		User: db.User{UserID: uuid.New(), FirstName: "John", LastName: "Smith", Email: "john.smith@example.com", Phone: sqlString(phone)},
+		Rank: 1.2,
+	}
+	repo.On("SearchUsers", mock.Anything, db.SearchUsersParams{
+		Tsquery:     "jo:* & smi:*",
+		Term:        "jo smi",
+		ResultLimit: DefaultSearchLimit,
+	}).Return([]db.SearchUsersRow{row}, nil)
+
+	results, err := svc.SearchUsers(context.Background(), "  Jo Smi ", 0)
+
+	assert.NoError(t, err)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, 1.2, results[0].Rank)
+		assert.Equal(t, map[string]string{
+			"first_name": "<mark>Jo</mark>hn",
+			"last_name":  "<mark>Smi</mark>th",
+			"email":      "<mark>jo</mark>hn.<mark>smi</mark>th@example.com",
+		}, results[0].Highlights)
+	}
+}
+
+func TestSearchUsers_PhoneDigits(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := NewService(repo)
+
+	repo.On("SearchUsers", mock.Anything, mock.MatchedBy(func(arg db.SearchUsersParams) bool {
+		return arg.Phone.Valid && arg.Phone.String == "5550" && arg.ResultLimit == MaxSearchLimit
+	})).Return([]db.SearchUsersRow{}, nil)
+
+	_, err := svc.SearchUsers(context.Background(), "555-0", 1000)
+
+	assert.NoError(t, err)
+	repo.AssertExpectations(t)
+}
+
+func TestSearchUsers_RejectsShortQueries(t *testing.T) {
+	_, err := NewService(new(mocks.MockUserRepository)).SearchUsers(context.Background(), " a ", 0)
+	assert.ErrorIs(t, err, ErrInvalidSearch)
+}
+
+func TestMark_EscapesAndMergesOverlaps(t *testing.T) {
+	got, ok := mark("<b>anna</b>", []string{"an", "nn"})
+	assert.True(t, ok)
+	assert.Equal(t, "&lt;b&gt;<mark>ann</mark>a&lt;/b&gt;", got)
+
+	_, ok = mark("bob", []string{"al"})
+	assert.False(t, ok)
+}
+
+func sqlString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: true}
+}
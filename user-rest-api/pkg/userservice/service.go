@@ -2,84 +2,360 @@ package userservice
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 
 	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal"
 	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
 	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/repository"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/events"
+)
+
+// Subjects of the user change events written to the outbox.
+const (
+	SubjectUserCreated = "users.created"
+	SubjectUserUpdated = "users.updated"
+	SubjectUserDeleted = "users.deleted"
 )
 
 type UserService interface {
 	CreateUser(ctx context.Context, arg CreateUserParams) (User, error)
 	UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error)
-	DeleteUser(ctx context.Context, userID uuid.UUID) error
+	// DeleteUser soft deletes a user: it is hidden until restored with
+	// RestoreUser or purged by PurgeDeletedUsers.
+	DeleteUser(ctx context.Context, arg DeleteUserParams) error
+	RestoreUser(ctx context.Context, arg RestoreUserParams) (User, error)
+	// ChangeStatus moves a user along its lifecycle. It fails with
+	// ErrInvalidTransition if the user cannot reach arg.Status from its
+	// current status.
+	ChangeStatus(ctx context.Context, arg ChangeStatusParams) (User, error)
+	// ListUserAudit returns the changes made to a user, newest first. Changes
+	// outlive the user, so it returns an empty page for unknown users.
+	ListUserAudit(ctx context.Context, arg ListAuditParams) (AuditPage, error)
+	// PurgeDeletedUsers erases the users deleted before deletedBefore, which
+	// cannot be restored afterwards, like EraseUser, and returns them.
+	PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) ([]uuid.UUID, error)
 	GetUser(ctx context.Context, userID uuid.UUID) (User, error)
-	GetAllUsers(ctx context.Context) ([]User, error)
+	ListUsers(ctx context.Context, arg ListUsersParams) (UserPage, error)
+	SearchUsers(ctx context.Context, query string, limit int32) ([]SearchResult, error)
+	// EraseUser erases the personal data of a user on request, keeping the
+	// user, pseudonymised, for the records that refer to it.
+	EraseUser(ctx context.Context, arg EraseUserParams) (User, error)
+	// UsersWithEventsNotErased returns up to limit erased or purged users
+	// whose events outside the database are still to be erased, see
+	// EventEraser. MarkEventsErased records that they are.
+	UsersWithEventsNotErased(ctx context.Context, limit int32) ([]uuid.UUID, error)
+	MarkEventsErased(ctx context.Context, userID uuid.UUID) error
+	// ExportPersonalData returns everything held about a user in the
+	// database, including deleted and erased users.
+	ExportPersonalData(ctx context.Context, userID uuid.UUID) (PersonalData, error)
+	// Batch applies every operation or none of them.
+	Batch(ctx context.Context, ops []BatchOperation) (BatchResult, error)
+	ImportUsers(ctx context.Context, arg ImportUsersParams) (ImportResult, error)
+	// ExportUsers calls fn with every user matching the filters of arg,
+	// without loading them all at once.
+	ExportUsers(ctx context.Context, arg ListUsersParams, fn func(User) error) error
 }
 
 type service struct {
-	repo repository.UserRepository
+	repo   repository.UserRepository
+	source string
 }
 
-func NewService(repo repository.UserRepository) UserService {
-	return &service{repo: repo}
+type Option func(*service)
+
+// WithEventSource sets the CloudEvents source of the events the service
+// writes. It defaults to events.SourceRESTAPI.
+func WithEventSource(source string) Option {
+	return func(s *service) { s.source = source }
+}
+
+func NewService(repo repository.UserRepository, opts ...Option) UserService {
+	s := &service{repo: repo, source: events.SourceRESTAPI}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// NewPostgresService returns a service backed by Postgres, for callers outside
+// this module that cannot use the internal repository package.
+func NewPostgresService(conn *sql.DB, opts ...Option) UserService {
+	return NewService(repository.NewPostgresUserRepository(conn), opts...)
 }
 
 func (s *service) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
+	dbArg, err := createUserParams(arg)
+	if err != nil {
+		return User{}, err
+	}
+	var user User
+	err = s.repo.WithTx(ctx, func(repo repository.UserRepository) error {
+		user, err = s.createUser(ctx, repo, dbArg)
+		return err
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// createUserParams checks arg and turns it into the parameters of the query.
+func createUserParams(arg CreateUserParams) (db.CreateUserParams, error) {
 	dbArg := db.CreateUserParams{
 		FirstName: arg.FirstName,
 		LastName:  arg.LastName,
 		Email:     arg.Email,
-		Phone:     internal.ToNullString(*arg.Phone),
 		Age:       internal.ToNullInt32(arg.Age),
-		Status:    internal.ToNullString(*arg.Status),
-	} //todo cannot
-	user, err := s.repo.CreateUser(ctx, dbArg)
+		Status:    StatusActive,
+	}
+	if arg.Phone != nil {
+		dbArg.Phone = internal.ToNullString(*arg.Phone)
+	}
+	if arg.Status != nil && *arg.Status != "" {
+		dbArg.Status = *arg.Status
+	}
+	if dbArg.Status != StatusPending && dbArg.Status != StatusActive {
+		return dbArg, &ValidationError{Fields: []FieldError{{Field: "status", Message: "must be pending or active for new users"}}}
+	}
+	return dbArg, nil
+}
+
+// createUser inserts a user and records its creation in the transaction of
+// repo.
+func (s *service) createUser(ctx context.Context, repo repository.UserRepository, arg db.CreateUserParams) (User, error) {
+	created, err := repo.CreateUser(ctx, arg)
+	if isUniqueViolation(err) {
+		return User{}, ErrEmailTaken
+	}
 	if err != nil {
 		return User{}, err
 	}
-	return toPublicUser(user), nil
+	user := toPublicUser(created)
+	return user, s.recordChange(ctx, repo, AuditCreated, "", user.UserID, nil, user)
 }
 
 func (s *service) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error) {
+	dbArg, err := updateUserParams(arg)
+	if err != nil {
+		return User{}, err
+	}
+	var user User
+	err = s.repo.WithTx(ctx, func(repo repository.UserRepository) error {
+		user, err = s.updateUser(ctx, repo, arg, dbArg)
+		return err
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// updateUserParams checks arg and turns it into the parameters of the query.
+func updateUserParams(arg UpdateUserParams) (db.UpdateUserParams, error) {
+	if arg.FirstName.Null || arg.LastName.Null || arg.Email.Null {
+		return db.UpdateUserParams{}, fmt.Errorf("%w: first_name, last_name and email cannot be null", ErrInvalidUpdate)
+	}
 	dbArg := db.UpdateUserParams{
 		UserID:    arg.UserID,
-		FirstName: arg.FirstName,
-		LastName:  arg.LastName,
-		Email:     arg.Email,
-		Phone:     internal.ToNullString(*arg.Phone),
-		Age:       internal.ToNullInt32(arg.Age),
-		Status:    internal.ToNullString(*arg.Status),
+		FirstName: nullString(arg.FirstName),
+		LastName:  nullString(arg.LastName),
+		Email:     nullString(arg.Email),
+		SetPhone:  arg.Phone.Set,
+		Phone:     nullString(arg.Phone),
+		SetAge:    arg.Age.Set,
 	}
-	user, err := s.repo.UpdateUser(ctx, dbArg)
+	if arg.Age.Set && !arg.Age.Null {
+		dbArg.Age = internal.ToNullInt32(&arg.Age.Value)
+	}
+	return dbArg, nil
+}
+
+// updateUser updates a user and records the change in the transaction of
+// repo.
+func (s *service) updateUser(ctx context.Context, repo repository.UserRepository, arg UpdateUserParams, dbArg db.UpdateUserParams) (User, error) {
+	before, err := lockUser(ctx, repo, arg.UserID)
 	if err != nil {
 		return User{}, err
 	}
-	return toPublicUser(user), nil
+	if err := checkVersion(before, arg.ExpectedVersion); err != nil {
+		return User{}, err
+	}
+	if arg.Status.Set && (arg.Status.Null || arg.Status.Value != before.Status) {
+		return User{}, fmt.Errorf("%w: status is changed by status transitions", ErrInvalidUpdate)
+	}
+	updated, err := repo.UpdateUser(ctx, dbArg)
+	if isUniqueViolation(err) {
+		return User{}, ErrEmailTaken
+	}
+	if err != nil {
+		return User{}, err
+	}
+	user := toPublicUser(updated)
+	return user, s.recordChange(ctx, repo, AuditUpdated, "", user.UserID, toPublicUser(before), user)
+}
+
+func (s *service) DeleteUser(ctx context.Context, arg DeleteUserParams) error {
+	return s.repo.WithTx(ctx, func(repo repository.UserRepository) error {
+		return s.deleteUser(ctx, repo, arg)
+	})
+}
+
+// deleteUser soft deletes a user and records the change in the transaction
+// of repo.
+func (s *service) deleteUser(ctx context.Context, repo repository.UserRepository, arg DeleteUserParams) error {
+	before, err := lockUser(ctx, repo, arg.UserID)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(before, arg.ExpectedVersion); err != nil {
+		return err
+	}
+	if _, err := repo.SoftDeleteUser(ctx, arg.UserID); err != nil {
+		return err
+	}
+	return s.recordChange(ctx, repo, AuditDeleted, "", arg.UserID, toPublicUser(before), nil)
 }
 
-func (s *service) DeleteUser(ctx context.Context, userID uuid.UUID) error {
-	return s.repo.DeleteUser(ctx, userID)
+// RestoreUser undoes DeleteUser. Consumers of the change events see the
+// restored user as updated.
+func (s *service) RestoreUser(ctx context.Context, arg RestoreUserParams) (User, error) {
+	var user User
+	err := s.repo.WithTx(ctx, func(repo repository.UserRepository) error {
+		before, err := repo.GetUserForUpdate(ctx, arg.UserID)
+		if errors.Is(err, sql.ErrNoRows) || before.PurgedAt.Valid {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if !before.DeletedAt.Valid {
+			return ErrUserNotDeleted
+		}
+		if err := checkVersion(before, arg.ExpectedVersion); err != nil {
+			return err
+		}
+		restored, err := repo.RestoreUser(ctx, arg.UserID)
+		if isUniqueViolation(err) {
+			return ErrEmailTaken
+		}
+		if err != nil {
+			return err
+		}
+		user = toPublicUser(restored)
+		return s.recordChange(ctx, repo, AuditRestored, "", user.UserID, toPublicUser(before), user)
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+func (s *service) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) ([]uuid.UUID, error) {
+	var purged []uuid.UUID
+	err := s.repo.WithTx(ctx, func(repo repository.UserRepository) error {
+		var err error
+		purged, err = repo.PurgeDeletedUsers(ctx, db.PurgeDeletedUsersParams{Source: s.source, DeletedBefore: deletedBefore})
+		if err != nil {
+			return err
+		}
+		return scrubUserData(ctx, repo, purged...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
+
+// lockUser locks a user that is not deleted, for the rest of the transaction.
+// Erased users cannot be changed, so it fails with ErrUserErased for them.
+func lockUser(ctx context.Context, repo repository.UserRepository, userID uuid.UUID) (db.User, error) {
+	u, err := repo.GetUserForUpdate(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && u.DeletedAt.Valid) {
+		return db.User{}, ErrUserNotFound
+	}
+	if err == nil && u.ErasedAt.Valid {
+		return db.User{}, ErrUserErased
+	}
+	return u, err
+}
+
+// checkVersion fails with ErrVersionMismatch if expected is set and u is at
+// another version. u must be locked for the check to hold until commit.
+func checkVersion(u db.User, expected *int32) error {
+	if expected != nil && *expected != u.Version {
+		return fmt.Errorf("%w: expected version %d, user is at version %d", ErrVersionMismatch, *expected, u.Version)
+	}
+	return nil
 }
 
 func (s *service) GetUser(ctx context.Context, userID uuid.UUID) (User, error) {
 	user, err := s.repo.GetUser(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrUserNotFound
+	}
 	if err != nil {
 		return User{}, err
 	}
 	return toPublicUser(user), nil
 }
 
-func (s *service) GetAllUsers(ctx context.Context) ([]User, error) {
-	dbUsers, err := s.repo.ListUsers(ctx)
+// isUniqueViolation reports whether err is a unique constraint violation. The
+// only unique column of users is email.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// nullString maps unset, null and empty strings to NULL, like
+// internal.ToNullString.
+func nullString(o Optional[string]) sql.NullString {
+	if !o.Set || o.Null {
+		return sql.NullString{}
+	}
+	return internal.ToNullString(o.Value)
+}
+
+// changeEvents are the subject and type of the event written for each audit
+// action. Consumers see restored users, status changes and erasures as
+// updates.
+var changeEvents = map[string]struct{ subject, eventType string }{
+	AuditCreated:  {SubjectUserCreated, events.TypeUserCreated},
+	AuditUpdated:  {SubjectUserUpdated, events.TypeUserUpdated},
+	AuditDeleted:  {SubjectUserDeleted, events.TypeUserDeleted},
+	AuditRestored: {SubjectUserUpdated, events.TypeUserUpdated},
+
+	AuditActivated: {SubjectUserUpdated, events.TypeUserUpdated},
+	AuditSuspended: {SubjectUserUpdated, events.TypeUserUpdated},
+	AuditClosed:    {SubjectUserUpdated, events.TypeUserUpdated},
+
+	AuditErased: {SubjectUserUpdated, events.TypeUserUpdated},
+}
+
+// recordChange audits a change and records its event in the outbox, in the
+// same transaction as the change itself. The outbox relay publishes the event
+// to NATS.
+func (s *service) recordChange(ctx context.Context, repo repository.UserRepository, action, reason string, userID uuid.UUID, before, after any) error {
+	ce := changeEvents[action]
+	event, err := events.NewUserEvent(ctx, ce.eventType, s.source, userID.String(), before, after)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	var users []User
-	for _, u := range dbUsers {
-		users = append(users, toPublicUser(u))
+	if err := s.audit(ctx, repo, action, reason, userID, event); err != nil {
+		return err
 	}
-	return users, nil
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = repo.InsertOutboxEvent(ctx, db.InsertOutboxEventParams{Subject: ce.subject, Payload: payload})
+	return err
 }
 
 func toPublicUser(u db.User) User {
@@ -91,9 +367,12 @@ func toPublicUser(u db.User) User {
 	if u.Age.Valid {
 		age = &u.Age.Int32
 	}
-	var status *string
-	if u.Status.Valid {
-		status = &u.Status.String
+	var deletedAt, erasedAt *time.Time
+	if u.DeletedAt.Valid {
+		deletedAt = &u.DeletedAt.Time
+	}
+	if u.ErasedAt.Valid {
+		erasedAt = &u.ErasedAt.Time
 	}
 	return User{
 		UserID:    u.UserID,
@@ -102,6 +381,11 @@ func toPublicUser(u db.User) User {
 		Email:     u.Email,
 		Phone:     phone,
 		Age:       age,
-		Status:    status,
+		Status:    u.Status,
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+		Version:   u.Version,
+		DeletedAt: deletedAt,
+		ErasedAt:  erasedAt,
 	}
 }
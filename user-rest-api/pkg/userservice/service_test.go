@@ -2,17 +2,42 @@ package userservice_test
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal"
 	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/repository"
 	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/repository/mocks"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/events"
 	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/userservice"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
+// expectTx makes repo run transactions against itself.
+func expectTx(repo *mocks.MockUserRepository) {
+	repo.On("WithTx", mock.Anything, mock.Anything).
+		Return(func(ctx context.Context, fn func(repository.UserRepository) error) error {
+			return fn(repo)
+		})
+}
+
+// expectEvent expects one outbox event for subject, along with its audit
+// entry.
+func expectEvent(repo *mocks.MockUserRepository, subject string) {
+	repo.On("InsertOutboxEvent", mock.Anything, mock.MatchedBy(func(arg db.InsertOutboxEventParams) bool {
+		return arg.Subject == subject
+	})).Return(db.Outbox{}, nil).Once()
+	repo.On("InsertUserAudit", mock.Anything, mock.Anything).Return(nil).Once()
+}
+
 func TestCreateUser(t *testing.T) {
 	repo := new(mocks.MockUserRepository)
 	svc := userservice.NewService(repo)
@@ -37,11 +62,13 @@ func TestCreateUser(t *testing.T) {
 		Email:     "john@example.com",
 		Phone:     internal.ToNullString(phone),
 		Age:       internal.ToNullInt32(&age),
-		Status:    internal.ToNullString(status),
+		Status:    status,
 	}
 
+	expectTx(repo)
 	repo.On("CreateUser", mock.Anything, mock.AnythingOfType("db.CreateUserParams")).
 		Return(expectedUser, nil)
+	expectEvent(repo, userservice.SubjectUserCreated)
 
 	user, err := svc.CreateUser(context.Background(), arg)
 
@@ -50,23 +77,37 @@ func TestCreateUser(t *testing.T) {
 	repo.AssertExpectations(t)
 }
 
+func TestCreateUser_EmailTaken(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+
+	expectTx(repo)
+	repo.On("CreateUser", mock.Anything, mock.Anything).
+		Return(db.User{}, &pq.Error{Code: "23505", Constraint: "users_email_key"})
+
+	_, err := svc.CreateUser(context.Background(), userservice.CreateUserParams{Email: "taken@example.com", Phone: new(string), Status: new(string)})
+
+	assert.ErrorIs(t, err, userservice.ErrEmailTaken)
+	repo.AssertNotCalled(t, "InsertOutboxEvent", mock.Anything, mock.Anything)
+}
+
 func TestUpdateUser(t *testing.T) {
 	repo := new(mocks.MockUserRepository)
 	svc := userservice.NewService(repo)
 
 	phone := "456"
-	status := "inactive"
+	status := userservice.StatusActive // unchanged
 	age := int32(30)
 	id := uuid.New()
 
 	arg := userservice.UpdateUserParams{
 		UserID:    id,
-		FirstName: "Jane",
-		LastName:  "Doe",
-		Email:     "jane@example.com",
-		Phone:     &phone,
-		Age:       &age,
-		Status:    &status,
+		FirstName: userservice.Value("Jane"),
+		LastName:  userservice.Value("Doe"),
+		Email:     userservice.Value("jane@example.com"),
+		Phone:     userservice.Value(phone),
+		Age:       userservice.Value(age),
+		Status:    userservice.Value(status),
 	}
 
 	expected := db.User{
@@ -76,11 +117,14 @@ func TestUpdateUser(t *testing.T) {
 		Email:     "jane@example.com",
 		Phone:     internal.ToNullString(phone),
 		Age:       internal.ToNullInt32(&age),
-		Status:    internal.ToNullString(status),
+		Status:    status,
 	}
 
+	expectTx(repo)
+	repo.On("GetUserForUpdate", mock.Anything, id).Return(db.User{UserID: id, FirstName: "Janet", Status: status}, nil)
 	repo.On("UpdateUser", mock.Anything, mock.AnythingOfType("db.UpdateUserParams")).
 		Return(expected, nil)
+	expectEvent(repo, userservice.SubjectUserUpdated)
 
 	user, err := svc.UpdateUser(context.Background(), arg)
 
@@ -89,19 +133,248 @@ func TestUpdateUser(t *testing.T) {
 	repo.AssertExpectations(t)
 }
 
+func TestUpdateUser_MergePatch(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+	id := uuid.New()
+
+	expectTx(repo)
+	repo.On("GetUserForUpdate", mock.Anything, id).Return(db.User{UserID: id}, nil)
+	repo.On("UpdateUser", mock.Anything, db.UpdateUserParams{
+		UserID:   id,
+		Email:    internal.ToNullString("new@example.com"),
+		SetPhone: true, // cleared
+		SetAge:   true,
+		Age:      sql.NullInt32{Int32: 41, Valid: true},
+	}).Return(db.User{UserID: id}, nil)
+	expectEvent(repo, userservice.SubjectUserUpdated)
+
+	var arg userservice.UpdateUserParams
+	assert.NoError(t, json.Unmarshal([]byte(`{"email":"new@example.com","phone":null,"age":41}`), &arg))
+	arg.UserID = id
+	_, err := svc.UpdateUser(context.Background(), arg)
+
+	assert.NoError(t, err)
+	repo.AssertExpectations(t)
+}
+
+func TestUpdateUser_RequiredFieldsCannotBeCleared(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+
+	_, err := svc.UpdateUser(context.Background(), userservice.UpdateUserParams{UserID: uuid.New(), Email: userservice.Null[string]()})
+
+	assert.ErrorIs(t, err, userservice.ErrInvalidUpdate)
+	repo.AssertNotCalled(t, "WithTx", mock.Anything, mock.Anything)
+}
+
+func TestUpdateUser_CannotChangeStatus(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+	id := uuid.New()
+
+	expectTx(repo)
+	repo.On("GetUserForUpdate", mock.Anything, id).Return(db.User{UserID: id, Status: userservice.StatusActive}, nil)
+
+	_, err := svc.UpdateUser(context.Background(), userservice.UpdateUserParams{UserID: id, Status: userservice.Value(userservice.StatusSuspended)})
+
+	assert.ErrorIs(t, err, userservice.ErrInvalidUpdate)
+	repo.AssertNotCalled(t, "UpdateUser", mock.Anything, mock.Anything)
+}
+
+func TestCreateUser_RejectsLaterStatuses(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+	status := userservice.StatusSuspended
+
+	_, err := svc.CreateUser(context.Background(), userservice.CreateUserParams{Email: "a@example.com", Phone: new(string), Status: &status})
+
+	var invalid *userservice.ValidationError
+	assert.ErrorAs(t, err, &invalid)
+	repo.AssertNotCalled(t, "WithTx", mock.Anything, mock.Anything)
+}
+
+func TestCanTransition(t *testing.T) {
+	allowed := map[string][]string{
+		userservice.StatusPending:   {userservice.StatusActive, userservice.StatusClosed},
+		userservice.StatusActive:    {userservice.StatusSuspended, userservice.StatusClosed},
+		userservice.StatusSuspended: {userservice.StatusActive, userservice.StatusClosed},
+		userservice.StatusClosed:    nil,
+	}
+	for _, from := range userservice.Statuses {
+		for _, to := range userservice.Statuses {
+			assert.Equal(t, slices.Contains(allowed[from], to), userservice.CanTransition(from, to), "%s to %s", from, to)
+		}
+	}
+	assert.True(t, userservice.CanTrade(userservice.StatusActive))
+	assert.False(t, userservice.CanTrade(userservice.StatusSuspended))
+}
+
+func TestChangeStatus(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+	id, closed := uuid.New(), uuid.New()
+
+	var audit db.InsertUserAuditParams
+	expectTx(repo)
+	repo.On("GetUserForUpdate", mock.Anything, id).Return(db.User{UserID: id, Status: userservice.StatusActive, Version: 1}, nil)
+	repo.On("GetUserForUpdate", mock.Anything, closed).Return(db.User{UserID: closed, Status: userservice.StatusClosed}, nil)
+	repo.On("UpdateUserStatus", mock.Anything, db.UpdateUserStatusParams{UserID: id, Status: userservice.StatusSuspended}).
+		Return(db.User{UserID: id, Status: userservice.StatusSuspended, Version: 2}, nil).Once()
+	repo.On("InsertOutboxEvent", mock.Anything, mock.MatchedBy(func(arg db.InsertOutboxEventParams) bool {
+		return arg.Subject == userservice.SubjectUserUpdated
+	})).Return(db.Outbox{}, nil).Once()
+	repo.On("InsertUserAudit", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { audit = args.Get(1).(db.InsertUserAuditParams) }).
+		Return(nil).Once()
+
+	_, err := svc.ChangeStatus(context.Background(), userservice.ChangeStatusParams{UserID: id, Status: userservice.StatusSuspended})
+	var invalid *userservice.ValidationError
+	assert.ErrorAs(t, err, &invalid, "a reason is required")
+
+	_, err = svc.ChangeStatus(context.Background(), userservice.ChangeStatusParams{UserID: closed, Status: userservice.StatusActive, Reason: "appeal"})
+	assert.ErrorIs(t, err, userservice.ErrInvalidTransition)
+
+	user, err := svc.ChangeStatus(context.Background(), userservice.ChangeStatusParams{UserID: id, Status: userservice.StatusSuspended, Reason: "chargeback"})
+	assert.NoError(t, err)
+	assert.Equal(t, userservice.StatusSuspended, user.Status)
+	assert.Equal(t, userservice.AuditSuspended, audit.Action)
+	assert.Equal(t, "chargeback", audit.Reason.String)
+	assert.Equal(t, []string{"status", "version"}, audit.Changed)
+	repo.AssertExpectations(t)
+}
+
 func TestDeleteUser(t *testing.T) {
 	repo := new(mocks.MockUserRepository)
 	svc := userservice.NewService(repo)
 
 	id := uuid.New()
-	repo.On("DeleteUser", mock.Anything, id).Return(nil)
+	expectTx(repo)
+	repo.On("GetUserForUpdate", mock.Anything, id).Return(db.User{UserID: id}, nil)
+	repo.On("SoftDeleteUser", mock.Anything, id).Return(db.User{UserID: id}, nil)
+	expectEvent(repo, userservice.SubjectUserDeleted)
+
+	err := svc.DeleteUser(context.Background(), userservice.DeleteUserParams{UserID: id})
+
+	assert.NoError(t, err)
+	repo.AssertExpectations(t)
+}
+
+func TestDeleteUserFailureWritesNoEvent(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+
+	id := uuid.New()
+	expectTx(repo)
+	repo.On("GetUserForUpdate", mock.Anything, id).Return(db.User{UserID: id}, nil)
+	repo.On("SoftDeleteUser", mock.Anything, id).Return(db.User{}, assert.AnError)
+
+	err := svc.DeleteUser(context.Background(), userservice.DeleteUserParams{UserID: id})
+
+	assert.ErrorIs(t, err, assert.AnError)
+	repo.AssertNotCalled(t, "InsertOutboxEvent", mock.Anything, mock.Anything)
+}
+
+func TestUpdateUser_VersionMismatch(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+	id := uuid.New()
+	version := int32(2)
+
+	expectTx(repo)
+	repo.On("GetUserForUpdate", mock.Anything, id).Return(db.User{UserID: id, Version: 3}, nil)
+
+	_, err := svc.UpdateUser(context.Background(), userservice.UpdateUserParams{UserID: id, Age: userservice.Value(int32(40)), ExpectedVersion: &version})
+
+	assert.ErrorIs(t, err, userservice.ErrVersionMismatch)
+	repo.AssertNotCalled(t, "UpdateUser", mock.Anything, mock.Anything)
+	repo.AssertNotCalled(t, "InsertOutboxEvent", mock.Anything, mock.Anything)
+}
+
+func TestDeleteUser_VersionCheck(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+	id, missing := uuid.New(), uuid.New()
+	stale, current := int32(1), int32(2)
+
+	expectTx(repo)
+	repo.On("GetUserForUpdate", mock.Anything, id).Return(db.User{UserID: id, Version: 2}, nil)
+	repo.On("GetUserForUpdate", mock.Anything, missing).Return(db.User{}, sql.ErrNoRows)
+	repo.On("SoftDeleteUser", mock.Anything, id).Return(db.User{UserID: id}, nil).Once()
+	expectEvent(repo, userservice.SubjectUserDeleted)
+
+	err := svc.DeleteUser(context.Background(), userservice.DeleteUserParams{UserID: id, ExpectedVersion: &stale})
+	assert.ErrorIs(t, err, userservice.ErrVersionMismatch)
+
+	err = svc.DeleteUser(context.Background(), userservice.DeleteUserParams{UserID: missing, ExpectedVersion: &current})
+	assert.ErrorIs(t, err, userservice.ErrUserNotFound)
+
+	err = svc.DeleteUser(context.Background(), userservice.DeleteUserParams{UserID: id, ExpectedVersion: &current})
+	assert.NoError(t, err)
+	repo.AssertExpectations(t)
+}
+
+func TestDeletedUsersCannotBeChanged(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+	id := uuid.New()
+
+	expectTx(repo)
+	repo.On("GetUserForUpdate", mock.Anything, id).
+		Return(db.User{UserID: id, DeletedAt: sql.NullTime{Time: time.Now(), Valid: true}}, nil)
+
+	_, err := svc.UpdateUser(context.Background(), userservice.UpdateUserParams{UserID: id, Age: userservice.Value(int32(40))})
+	assert.ErrorIs(t, err, userservice.ErrUserNotFound)
 
-	err := svc.DeleteUser(context.Background(), id)
+	err = svc.DeleteUser(context.Background(), userservice.DeleteUserParams{UserID: id})
+	assert.ErrorIs(t, err, userservice.ErrUserNotFound)
 
+	repo.AssertNotCalled(t, "UpdateUser", mock.Anything, mock.Anything)
+	repo.AssertNotCalled(t, "SoftDeleteUser", mock.Anything, mock.Anything)
+}
+
+func TestRestoreUser(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+	deleted := sql.NullTime{Time: time.Now(), Valid: true}
+	id, active, purged := uuid.New(), uuid.New(), uuid.New()
+
+	expectTx(repo)
+	repo.On("GetUserForUpdate", mock.Anything, id).Return(db.User{UserID: id, Version: 2, DeletedAt: deleted}, nil)
+	repo.On("GetUserForUpdate", mock.Anything, active).Return(db.User{UserID: active}, nil)
+	repo.On("GetUserForUpdate", mock.Anything, purged).Return(db.User{UserID: purged, DeletedAt: deleted, PurgedAt: deleted}, nil)
+	repo.On("RestoreUser", mock.Anything, id).Return(db.User{UserID: id, Version: 3}, nil).Once()
+	expectEvent(repo, userservice.SubjectUserUpdated)
+
+	_, err := svc.RestoreUser(context.Background(), userservice.RestoreUserParams{UserID: active})
+	assert.ErrorIs(t, err, userservice.ErrUserNotDeleted)
+
+	_, err = svc.RestoreUser(context.Background(), userservice.RestoreUserParams{UserID: purged})
+	assert.ErrorIs(t, err, userservice.ErrUserNotFound)
+
+	user, err := svc.RestoreUser(context.Background(), userservice.RestoreUserParams{UserID: id})
 	assert.NoError(t, err)
+	assert.Nil(t, user.DeletedAt)
+	assert.Equal(t, int32(3), user.Version)
 	repo.AssertExpectations(t)
 }
 
+func TestRestoreUser_EmailTaken(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+	id := uuid.New()
+
+	expectTx(repo)
+	repo.On("GetUserForUpdate", mock.Anything, id).
+		Return(db.User{UserID: id, DeletedAt: sql.NullTime{Time: time.Now(), Valid: true}}, nil)
+	repo.On("RestoreUser", mock.Anything, id).Return(db.User{}, &pq.Error{Code: "23505"})
+
+	_, err := svc.RestoreUser(context.Background(), userservice.RestoreUserParams{UserID: id})
+
+	assert.ErrorIs(t, err, userservice.ErrEmailTaken)
+	repo.AssertNotCalled(t, "InsertOutboxEvent", mock.Anything, mock.Anything)
+}
+
 func TestGetUser(t *testing.T) {
 	repo := new(mocks.MockUserRepository)
 	svc := userservice.NewService(repo)
@@ -123,20 +396,212 @@ func TestGetUser(t *testing.T) {
 	repo.AssertExpectations(t)
 }
 
-func TestGetAllUsers(t *testing.T) {
+func TestGetUser_Errors(t *testing.T) {
 	repo := new(mocks.MockUserRepository)
 	svc := userservice.NewService(repo)
 
-	expected := []db.User{
-		{UserID: uuid.New(), FirstName: "John"},
-		{UserID: uuid.New(), FirstName: "Jane"},
+	missing, broken := uuid.New(), uuid.New()
+	repo.On("GetUser", mock.Anything, missing).Return(db.User{}, sql.ErrNoRows)
+	repo.On("GetUser", mock.Anything, broken).Return(db.User{}, assert.AnError)
+
+	_, err := svc.GetUser(context.Background(), missing)
+	assert.ErrorIs(t, err, userservice.ErrUserNotFound)
+	assert.ErrorIs(t, err, userservice.ErrNotFound)
+
+	_, err = svc.GetUser(context.Background(), broken)
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.NotErrorIs(t, err, userservice.ErrNotFound)
+}
+
+func TestListUsers_PagesWithCursor(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+
+	rows := []db.User{
+		{UserID: uuid.New(), FirstName: "John", Age: sql.NullInt32{Int32: 32, Valid: true}},
+		{UserID: uuid.New(), FirstName: "Jane", Age: sql.NullInt32{Int32: 31, Valid: true}},
+		{UserID: uuid.New(), FirstName: "Jim", Age: sql.NullInt32{Int32: 30, Valid: true}},
 	}
+	repo.On("ListUsers", mock.Anything, mock.MatchedBy(func(arg repository.ListUsersParams) bool {
+		return arg.SortBy == "age" && arg.Descending && arg.PageSize == 3 && !arg.AfterID.Valid &&
+			arg.NamePrefix.String == `j\_` && arg.MinAge.Int32 == 18
+	})).Return(rows, nil).Once()
+
+	minAge := int32(18)
+	page, err := svc.ListUsers(context.Background(), userservice.ListUsersParams{Limit: 2, Sort: "-age", NamePrefix: "J_", MinAge: &minAge})
+
+	assert.NoError(t, err)
+	assert.Len(t, page.Users, 2)
+	assert.NotEmpty(t, page.NextCursor)
+	assert.Nil(t, page.Total)
 
-	repo.On("ListUsers", mock.Anything).Return(expected, nil)
+	// The cursor continues after the last user returned.
+	repo.On("ListUsers", mock.Anything, mock.MatchedBy(func(arg repository.ListUsersParams) bool {
+		return arg.AfterID.UUID == rows[1].UserID && arg.AfterAge == 31
+	})).Return(rows[2:], nil).Once()
+	repo.On("CountUsers", mock.Anything, mock.Anything).Return(int64(3), nil).Once()
 
-	users, err := svc.GetAllUsers(context.Background())
+	page, err = svc.ListUsers(context.Background(), userservice.ListUsersParams{Limit: 2, Sort: "-age", Cursor: page.NextCursor, WithTotal: true})
 
 	assert.NoError(t, err)
-	assert.Len(t, users, 2)
+	assert.Len(t, page.Users, 1)
+	assert.Empty(t, page.NextCursor)
+	assert.Equal(t, int64(3), *page.Total)
 	repo.AssertExpectations(t)
 }
+
+func TestListUsers_CursorKeepsTheSortColumnValue(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+	created := time.Date(2024, 5, 1, 12, 0, 0, 123456000, time.UTC)
+	rows := []db.User{
+		{UserID: uuid.New(), Email: "Ann@example.com", CreatedAt: created},
+		{UserID: uuid.New(), Email: "bob@example.com", CreatedAt: created.Add(time.Microsecond)},
+	}
+
+	for sort, want := range map[string]func(repository.ListUsersParams) bool{
+		"email":       func(arg repository.ListUsersParams) bool { return arg.AfterText == "Ann@example.com" },
+		"-created_at": func(arg repository.ListUsersParams) bool { return arg.AfterTime.Equal(created) },
+	} {
+		repo.On("ListUsers", mock.Anything, mock.MatchedBy(func(arg repository.ListUsersParams) bool {
+			return !arg.AfterID.Valid
+		})).Return(rows, nil).Once()
+		page, err := svc.ListUsers(context.Background(), userservice.ListUsersParams{Limit: 1, Sort: sort})
+		require.NoError(t, err, sort)
+
+		repo.On("ListUsers", mock.Anything, mock.MatchedBy(func(arg repository.ListUsersParams) bool {
+			return arg.AfterID.UUID == rows[0].UserID && want(arg)
+		})).Return(rows[1:], nil).Once()
+		_, err = svc.ListUsers(context.Background(), userservice.ListUsersParams{Limit: 1, Sort: sort, Cursor: page.NextCursor})
+		require.NoError(t, err, sort)
+	}
+	repo.AssertExpectations(t)
+}
+
+func TestListUsers_RejectsInvalidParams(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+	minAge, maxAge := int32(40), int32(20)
+
+	for name, arg := range map[string]userservice.ListUsersParams{
+		"unknown sort":     {Sort: "phone"},
+		"malformed cursor": {Cursor: "%%%"},
+		"age range":        {MinAge: &minAge, MaxAge: &maxAge},
+		"negative limit":   {Limit: -1},
+	} {
+		_, err := svc.ListUsers(context.Background(), arg)
+		assert.ErrorIs(t, err, userservice.ErrInvalidListParams, name)
+	}
+	repo.AssertNotCalled(t, "ListUsers", mock.Anything, mock.Anything)
+}
+
+func TestUpdateUserEventCarriesDiffAndActor(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+
+	id := uuid.New()
+	status := "active"
+	before := db.User{UserID: id, FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Status: status}
+	after := before
+	after.Email = "jane.doe@example.com"
+
+	var payload []byte
+	expectTx(repo)
+	repo.On("GetUserForUpdate", mock.Anything, id).Return(before, nil)
+	repo.On("UpdateUser", mock.Anything, mock.AnythingOfType("db.UpdateUserParams")).Return(after, nil)
+	repo.On("InsertOutboxEvent", mock.Anything, mock.AnythingOfType("db.InsertOutboxEventParams")).
+		Run(func(args mock.Arguments) { payload = args.Get(1).(db.InsertOutboxEventParams).Payload }).
+		Return(db.Outbox{}, nil)
+	repo.On("InsertUserAudit", mock.Anything, mock.Anything).Return(nil)
+
+	ctx := events.WithActor(context.Background(), "admin-1")
+	_, err := svc.UpdateUser(ctx, userservice.UpdateUserParams{UserID: id, Email: userservice.Value("jane.doe@example.com")})
+	assert.NoError(t, err)
+
+	event, err := events.Parse(payload)
+	assert.NoError(t, err)
+	assert.Equal(t, events.TypeUserUpdated, event.Type)
+	assert.Equal(t, events.SourceRESTAPI, event.Source)
+	assert.Equal(t, id.String(), event.Subject)
+	assert.Equal(t, "admin-1", event.Actor)
+	change, err := event.UserChange()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"email"}, change.Changed)
+	assert.JSONEq(t, `"jane@example.com"`, string(mustField(t, change.Before, "email")))
+	assert.JSONEq(t, `"jane.doe@example.com"`, string(mustField(t, change.After, "email")))
+}
+
+func TestChangesAreAudited(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo, userservice.WithEventSource(events.SourceWSAPI))
+
+	id := uuid.New()
+	user := db.User{UserID: id, FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Version: 1}
+	deleted := user
+	deleted.DeletedAt = sql.NullTime{Time: time.Now(), Valid: true}
+
+	var audits []db.InsertUserAuditParams
+	expectTx(repo)
+	repo.On("GetUserForUpdate", mock.Anything, id).Return(user, nil).Once()
+	repo.On("GetUserForUpdate", mock.Anything, id).Return(deleted, nil).Once()
+	repo.On("SoftDeleteUser", mock.Anything, id).Return(deleted, nil)
+	repo.On("RestoreUser", mock.Anything, id).Return(user, nil)
+	repo.On("InsertOutboxEvent", mock.Anything, mock.Anything).Return(db.Outbox{}, nil)
+	repo.On("InsertUserAudit", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { audits = append(audits, args.Get(1).(db.InsertUserAuditParams)) }).
+		Return(nil)
+
+	ctx := events.WithActor(context.Background(), "trader-7")
+	assert.NoError(t, svc.DeleteUser(ctx, userservice.DeleteUserParams{UserID: id}))
+	_, err := svc.RestoreUser(context.Background(), userservice.RestoreUserParams{UserID: id})
+	assert.NoError(t, err)
+
+	if assert.Len(t, audits, 2) {
+		del, restore := audits[0], audits[1]
+		assert.Equal(t, userservice.AuditDeleted, del.Action)
+		assert.Equal(t, "trader-7", del.Actor.String)
+		assert.Equal(t, events.SourceWSAPI, del.Source)
+		assert.JSONEq(t, `"jane@example.com"`, string(mustField(t, del.Before.RawMessage, "email")))
+		assert.False(t, del.After.Valid)
+
+		assert.Equal(t, userservice.AuditRestored, restore.Action)
+		assert.False(t, restore.Actor.Valid)
+		assert.Contains(t, restore.Changed, "deleted_at")
+		assert.True(t, restore.Before.Valid)
+		assert.True(t, restore.After.Valid)
+	}
+}
+
+func TestListUserAudit_PagesWithCursor(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	svc := userservice.NewService(repo)
+	id := uuid.New()
+
+	rows := []db.UserAudit{{ID: 9, UserID: id, Action: "updated"}, {ID: 7, UserID: id, Action: "updated"}, {ID: 3, UserID: id, Action: "created"}}
+	repo.On("ListUserAudit", mock.Anything, db.ListUserAuditParams{UserID: id, PageSize: 3}).Return(rows, nil).Once()
+
+	page, err := svc.ListUserAudit(context.Background(), userservice.ListAuditParams{UserID: id, Limit: 2})
+	assert.NoError(t, err)
+	assert.Len(t, page.Entries, 2)
+	assert.Equal(t, []string{}, page.Entries[0].Changed)
+	assert.NotEmpty(t, page.NextCursor)
+
+	repo.On("ListUserAudit", mock.Anything, db.ListUserAuditParams{UserID: id, BeforeID: sql.NullInt64{Int64: 7, Valid: true}, PageSize: 3}).
+		Return(rows[2:], nil).Once()
+
+	page, err = svc.ListUserAudit(context.Background(), userservice.ListAuditParams{UserID: id, Limit: 2, Cursor: page.NextCursor})
+	assert.NoError(t, err)
+	assert.Len(t, page.Entries, 1)
+	assert.Empty(t, page.NextCursor)
+	repo.AssertExpectations(t)
+
+	_, err = svc.ListUserAudit(context.Background(), userservice.ListAuditParams{UserID: id, Cursor: "%%%"})
+	assert.ErrorIs(t, err, userservice.ErrInvalidAuditParams)
+}
+
+func mustField(t *testing.T, obj json.RawMessage, name string) json.RawMessage {
+	t.Helper()
+	var fields map[string]json.RawMessage
+	assert.NoError(t, json.Unmarshal(obj, &fields))
+	return fields[name]
+}
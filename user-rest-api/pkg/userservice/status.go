@@ -0,0 +1,74 @@
+package userservice
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/db"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/internal/repository"
+)
+
+// Statuses of a user. New users are pending or active; closed is final.
+const (
+	StatusPending   = "pending"
+	StatusActive    = "active"
+	StatusSuspended = "suspended"
+	StatusClosed    = "closed"
+)
+
+// Statuses lists every status.
+var Statuses = []string{StatusPending, StatusActive, StatusSuspended, StatusClosed}
+
+// transitions lists the statuses each status can change to.
+var transitions = map[string][]string{
+	StatusPending:   {StatusActive, StatusClosed},
+	StatusActive:    {StatusSuspended, StatusClosed},
+	StatusSuspended: {StatusActive, StatusClosed},
+}
+
+// statusActions are the audit actions of the changes to each status.
+var statusActions = map[string]string{
+	StatusActive:    AuditActivated,
+	StatusSuspended: AuditSuspended,
+	StatusClosed:    AuditClosed,
+}
+
+// CanTransition reports whether a user can change from status from to to.
+func CanTransition(from, to string) bool {
+	return slices.Contains(transitions[from], to)
+}
+
+// CanTrade reports whether a user in status may submit orders.
+func CanTrade(status string) bool {
+	return status == StatusActive
+}
+
+func (s *service) ChangeStatus(ctx context.Context, arg ChangeStatusParams) (User, error) {
+	if arg.Reason == "" {
+		return User{}, &ValidationError{Fields: []FieldError{{Field: "reason", Message: "is required"}}}
+	}
+	var user User
+	err := s.repo.WithTx(ctx, func(repo repository.UserRepository) error {
+		before, err := lockUser(ctx, repo, arg.UserID)
+		if err != nil {
+			return err
+		}
+		if err := checkVersion(before, arg.ExpectedVersion); err != nil {
+			return err
+		}
+		if !CanTransition(before.Status, arg.Status) {
+			return fmt.Errorf("%w: %s users cannot become %s", ErrInvalidTransition, before.Status, arg.Status)
+		}
+		updated, err := repo.UpdateUserStatus(ctx, db.UpdateUserStatusParams{UserID: arg.UserID, Status: arg.Status})
+		if err != nil {
+			return err
+		}
+		user = toPublicUser(updated)
+		return s.recordChange(ctx, repo, statusActions[arg.Status], arg.Reason, user.UserID, toPublicUser(before), user)
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+	"user-ws-api/engine"
+	"user-ws-api/matcher"
+	"user-ws-api/models"
+	"user-ws-api/stream"
+	"user-ws-api/utils"
+
+	nats "github.com/nats-io/nats.go"
+)
+
+const (
+	feedBuffer     = 100
+	consumerBuffer = 4096
+)
+
+// engineFeeds are the outputs of the matching engine.
+type engineFeeds struct {
+	trades  chan models.Trade
+	events  chan models.OrderEvent
+	books   chan models.BookUpdate
+	dropped *feedDrops
+}
+
+// feedDrops counts, per consumer, the engine output dropped because the
+// consumer fell too far behind.
+type feedDrops struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+func (d *feedDrops) add(consumer, feed, id string) {
+	d.mu.Lock()
+	d.counts[consumer]++
+	d.mu.Unlock()
+	slog.Warn("Engine feed consumer is behind, message dropped", "Consumer", consumer, "Feed", feed, "ID", id)
+}
+
+func (d *feedDrops) snapshot() map[string]uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return maps.Clone(d.counts)
+}
+
+// feedConsumer follows the trades and order events of the engine, like the
+// WebSocket hub, the FIX gateway and the JetStream publisher.
+type feedConsumer interface {
+	SetTradeChannel(<-chan models.Trade)
+	SetEventChannel(<-chan models.OrderEvent)
+}
+
+// bookConsumer also follows book updates, like the WebSocket hub and the
+// JetStream publisher.
+type bookConsumer interface {
+	SetBookChannel(<-chan models.BookUpdate)
+}
+
+// newEngine creates the order router with all of its outputs enabled.
+func newEngine() (*engine.OrderRouter, engineFeeds) {
+	feeds := engineFeeds{
+		trades: make(chan models.Trade, feedBuffer),
+		events: make(chan models.OrderEvent, feedBuffer),
+		books:  make(chan models.BookUpdate, feedBuffer),
+
+		dropped: &feedDrops{counts: make(map[string]uint64)},
+	}
+	router := engine.NewOrderRouter(&matcher.SimpleMatcher{}, feeds.trades)
+	router.SetEventChannel(feeds.events)
+	router.SetBookChannel(feeds.books)
+	return router, feeds
+}
+
+// connect copies the feeds to every consumer, by name; book updates only go
+// to the consumers that take them, and are dropped if none does. Each
+// consumer has its own buffer, so the engine never waits for a consumer: one
+// that falls behind loses what does not fit, which is counted in f.dropped.
+// It must be called once, before the first order is submitted.
+func (f engineFeeds) connect(consumers map[string]feedConsumer) {
+	names := slices.Sorted(maps.Keys(consumers))
+	trades := utils.FanOut(f.trades, len(names), consumerBuffer, func(i int, trade models.Trade) {
+		f.dropped.add(names[i], "trades", trade.ID)
+	})
+	events := utils.FanOut(f.events, len(names), consumerBuffer, func(i int, event models.OrderEvent) {
+		f.dropped.add(names[i], "events", event.ID)
+	})
+	var bookNames []string
+	for i, name := range names {
+		c := consumers[name]
+		c.SetTradeChannel(trades[i])
+		c.SetEventChannel(events[i])
+		if _, ok := c.(bookConsumer); ok {
+			bookNames = append(bookNames, name)
+		}
+	}
+	books := utils.FanOut(f.books, len(bookNames), consumerBuffer, func(i int, update models.BookUpdate) {
+		f.dropped.add(bookNames[i], "books", update.ID)
+	})
+	for i, name := range bookNames {
+		consumers[name].(bookConsumer).SetBookChannel(books[i])
+	}
+}
+
+// startJetStream returns a publisher spooling to spoolDir, with the drop copy
+// enabled on dropCopySubject unless it is empty. The connection reconnects
+// forever, as spooled messages wait for JetStream to come back.
+func startJetStream(natsURL, spoolDir, dropCopySubject string) (*stream.Publisher, error) {
+	nc, err := nats.Connect(natsURL, nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	publisher, err := stream.NewPublisher(ctx, nc, spoolDir)
+	if err != nil {
+		return nil, err
+	}
+	if dropCopySubject != "" {
+		if err := publisher.EnableDropCopy(ctx, dropCopySubject); err != nil {
+			publisher.Close()
+			return nil, err
+		}
+	}
+	return publisher, nil
+}
@@ -2,15 +2,15 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	_ "github.com/lib/pq"
 	"log/slog"
 	"os"
 	"user-ws-api/config"
-	"user-ws-api/engine"
-	"user-ws-api/matcher"
-	"user-ws-api/models"
+	"user-ws-api/fix"
 
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/events"
 	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/userservice"
 	"net/http"
 
@@ -28,23 +28,97 @@ func main() {
 	slog.Info("Connecting to database", "driver", config.AppConfig.Database.Driver)
 	sqlDB, err := sql.Open(config.AppConfig.Database.Driver, config.AppConfig.Database.URL)
 	if err != nil {
-		slog.Error("cannot connect to db:", err)
+		slog.Error("Cannot connect to database", "error", err)
 		os.Exit(1)
 	}
 
-	userService := userservice.NewService(sqlDB)
+	userService := userservice.NewPostgresService(sqlDB, userservice.WithEventSource(events.SourceWSAPI))
 
-	systemMatcher := &matcher.SimpleMatcher{}
-	tradeCh := make(chan models.Trade, 100)
-	orderRouter := engine.NewOrderRouter(systemMatcher, tradeCh)
+	orderRouter, feeds := newEngine()
+
+	policy, err := ws.ParseSlowConsumerPolicy(config.AppConfig.WebSocket.SlowConsumerPolicy)
+	if err != nil {
+		slog.Error("Invalid websocket config", "error", err)
+		os.Exit(1)
+	}
 
 	hub := ws.NewHub(userService, orderRouter)
-	hub.SetTradeChannel(tradeCh)
+	hub.SetSendQueue(config.AppConfig.WebSocket.SendQueueSize, policy)
+	hub.SetShards(config.AppConfig.WebSocket.Shards)
+	hub.SetReplayBufferSize(config.AppConfig.WebSocket.ReplayBufferSize)
+	hub.SetRateLimits(config.AppConfig.RateLimits)
+	if grace := config.AppConfig.WebSocket.CancelOnDisconnectGrace; grace > 0 {
+		hub.SetCancelOnDisconnectGrace(grace)
+	}
+	hub.SetAdmins(config.AppConfig.WebSocket.AdminUsers)
+	hub.SetAuthSecret(config.AppConfig.WebSocket.AuthSecret)
+	if retention := config.AppConfig.WebSocket.ClientOrderIDRetention; retention > 0 {
+		hub.SetClientOrderIDRetention(retention)
+	}
+	if ttl := config.AppConfig.WebSocket.UserStatusTTL; ttl > 0 {
+		hub.SetUserStatusTTL(ttl)
+	}
+
+	// The engine output goes to the WebSocket hub and, if enabled, the FIX
+	// gateway and JetStream.
+	consumers := map[string]feedConsumer{"websocket": hub}
+	fixCfg := config.AppConfig.FIX
+	var acceptor *fix.Acceptor
+	if fixCfg.Port != "" {
+		acceptor = fix.NewAcceptor(fixCfg.SenderCompID, orderRouter)
+		acceptor.SetGate(hub.Gate())
+		for _, s := range fixCfg.Sessions {
+			acceptor.AddSession(s.CompID, s.UserID, s.Password)
+		}
+		consumers["fix"] = acceptor
+	}
+	// The drop copy is published through JetStream too, so that none of it
+	// is lost while NATS is unreachable.
+	dropCopySubject := config.AppConfig.NATS.DropCopySubject
+	if config.AppConfig.NATS.JetStream || dropCopySubject != "" {
+		spoolDir := config.AppConfig.NATS.SpoolDir
+		if spoolDir == "" {
+			spoolDir = "data/jetstream"
+		}
+		publisher, err := startJetStream(config.AppConfig.NATS.URL, spoolDir, dropCopySubject)
+		if err != nil {
+			slog.Error("Cannot start JetStream publisher", "error", err)
+			os.Exit(1)
+		}
+		if config.AppConfig.NATS.JetStream {
+			slog.Info("Publishing engine output to JetStream", "url", config.AppConfig.NATS.URL)
+			consumers["jetstream"] = publisher
+		}
+		if dropCopySubject != "" {
+			slog.Info("Publishing drop copy to JetStream", "subject", dropCopySubject)
+			hub.SetDropCopyPublisher(publisher)
+		}
+	}
+	feeds.connect(consumers)
 	go hub.Run()
 
+	if acceptor != nil {
+		slog.Info("FIX gateway started", "port", fixCfg.Port, "SenderCompID", fixCfg.SenderCompID)
+		go func() {
+			if err := acceptor.ListenAndServe(":" + fixCfg.Port); err != nil {
+				slog.Error("FIX gateway failed", "error", err)
+			}
+		}()
+	}
+
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		ws.ServeWs(hub, w, r)
 	})
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		metrics := struct {
+			ws.MetricsSnapshot
+			FeedDropped map[string]uint64 `json:"feed_dropped"`
+		}{hub.Metrics(), feeds.dropped.snapshot()}
+		if err := json.NewEncoder(w).Encode(metrics); err != nil {
+			slog.Error("Cannot encode metrics", "error", err)
+		}
+	})
 
 	slog.Info("Starting NATS listener", "url", config.AppConfig.NATS.URL)
 	go ws.StartNATSListener(hub, config.AppConfig.NATS.URL)
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+	"user-ws-api/models"
+	"user-ws-api/stream"
+
+	"github.com/nats-io/nats-server/v2/server"
+	nats "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder is a feed consumer that does not take book updates.
+type recorder struct {
+	trades chan models.Trade
+}
+
+func (r *recorder) SetTradeChannel(tradeCh <-chan models.Trade) {
+	go func() {
+		for trade := range tradeCh {
+			r.trades <- trade
+		}
+	}()
+}
+
+func (r *recorder) SetEventChannel(eventCh <-chan models.OrderEvent) {
+	go func() {
+		for range eventCh {
+		}
+	}()
+}
+
+// stalled is a feed consumer that never reads.
+type stalled struct{}
+
+func (stalled) SetTradeChannel(<-chan models.Trade)      {}
+func (stalled) SetEventChannel(<-chan models.OrderEvent) {}
+
+func TestStalledConsumerDoesNotHoldBackEngine(t *testing.T) {
+	const n = consumerBuffer + 100
+	router, feeds := newEngine()
+	other := &recorder{trades: make(chan models.Trade, n)}
+	feeds.connect(map[string]feedConsumer{"other": other, "stalled": stalled{}})
+
+	now := time.Now()
+	for i := range n {
+		at := now.Add(time.Duration(2*i) * time.Microsecond)
+		router.Submit(models.Order{ID: fmt.Sprintf("s%d", i), UserID: "seller", AssetID: "BTC", Side: models.Sell, Price: 100, Quantity: 1, CreatedAt: at})
+		router.Submit(models.Order{ID: fmt.Sprintf("b%d", i), UserID: "buyer", AssetID: "BTC", Side: models.Buy, Price: 100, Quantity: 1, CreatedAt: at.Add(time.Microsecond)})
+	}
+
+	require.Eventually(t, func() bool { return len(other.trades) == n }, 10*time.Second, 10*time.Millisecond)
+	// The trades beyond its buffer, and the order events, are dropped for
+	// the stalled consumer only.
+	assert.GreaterOrEqual(t, feeds.dropped.snapshot()["stalled"], uint64(100))
+	assert.Zero(t, feeds.dropped.snapshot()["other"])
+}
+
+func TestEngineOutputReachesJetStream(t *testing.T) {
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir()})
+	require.NoError(t, err)
+	go s.Start()
+	require.True(t, s.ReadyForConnections(5*time.Second))
+	t.Cleanup(s.Shutdown)
+
+	publisher, err := startJetStream(s.ClientURL(), t.TempDir(), "")
+	require.NoError(t, err)
+	t.Cleanup(publisher.Close)
+	router, feeds := newEngine()
+	other := &recorder{trades: make(chan models.Trade, 1)}
+	feeds.connect(map[string]feedConsumer{"other": other, "jetstream": publisher})
+
+	now := time.Now()
+	router.Submit(models.Order{ID: "s1", UserID: "seller", AssetID: "BTC", Side: models.Sell, Price: 100, Quantity: 1, CreatedAt: now})
+	router.Submit(models.Order{ID: "b1", UserID: "buyer", AssetID: "BTC", Side: models.Buy, Price: 100, Quantity: 1, CreatedAt: now.Add(time.Millisecond)})
+
+	select {
+	case trade := <-other.trades:
+		assert.Equal(t, "b1", trade.BuyOrderID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the trade did not reach every consumer")
+	}
+
+	nc, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	t.Cleanup(nc.Close)
+	js, err := jetstream.New(nc)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for name, subject := range map[string]string{
+		stream.TradesStream: stream.TradeSubject("BTC"),
+		stream.OrdersStream: stream.OrderSubject("buyer"),
+		stream.BookStream:   stream.BookSubject("BTC"),
+	} {
+		require.Eventually(t, func() bool {
+			str, err := js.Stream(ctx, name)
+			if err != nil {
+				return false
+			}
+			_, err = str.GetLastMsgForSubject(ctx, subject)
+			return err == nil
+		}, 5*time.Second, 20*time.Millisecond, subject)
+	}
+}
@@ -0,0 +1,128 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec encodes WebSocket messages for one negotiated subprotocol.
+type Codec interface {
+	// Name is the Sec-WebSocket-Protocol value that selects the codec.
+	Name() string
+	// MessageType is the WebSocket frame type the codec writes.
+	MessageType() int
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	JSON    Codec = jsonCodec{}
+	MsgPack Codec = msgpackCodec{}
+)
+
+// Subprotocols lists the supported subprotocols in server preference order.
+var Subprotocols = []string{MsgPack.Name(), JSON.Name()}
+
+// CodecFor returns the codec for a negotiated subprotocol. JSON is used when
+// the client did not ask for one.
+func CodecFor(subprotocol string) Codec {
+	if subprotocol == MsgPack.Name() {
+		return MsgPack
+	}
+	return JSON
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                       { return "json" }
+func (jsonCodec) MessageType() int                   { return websocket.TextMessage }
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// msgpackCodec reuses the json struct tags so both encodings share field names.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string     { return "msgpack" }
+func (msgpackCodec) MessageType() int { return websocket.BinaryMessage }
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// RawPayload is a payload that is already encoded with the codec of the
+// message carrying it and is embedded verbatim, like json.RawMessage.
+type RawPayload []byte
+
+func (p RawPayload) MarshalJSON() ([]byte, error) {
+	if len(p) == 0 {
+		return []byte("null"), nil
+	}
+	return p, nil
+}
+
+func (p *RawPayload) UnmarshalJSON(data []byte) error {
+	*p = append((*p)[:0], data...)
+	return nil
+}
+
+func (p RawPayload) EncodeMsgpack(enc *msgpack.Encoder) error {
+	if len(p) == 0 {
+		return enc.EncodeNil()
+	}
+	return enc.Encode(msgpack.RawMessage(p))
+}
+
+func (p *RawPayload) DecodeMsgpack(dec *msgpack.Decoder) error {
+	raw, err := dec.DecodeRaw()
+	if err != nil {
+		return err
+	}
+	*p = RawPayload(raw)
+	return nil
+}
+
+// Transcode re-encodes a JSON document with codec. It is used for payloads
+// that arrive as JSON, e.g. from NATS, and go out to binary clients.
+func Transcode(codec Codec, data json.RawMessage) (RawPayload, error) {
+	if codec == JSON {
+		return RawPayload(data), nil
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return codec.Marshal(v)
+}
+
+// UnmarshalViaJSON decodes a payload encoded with codec into v through JSON,
+// so types with their own JSON decoding, such as merge patches that tell an
+// absent field from null, behave the same for every codec.
+func UnmarshalViaJSON(codec Codec, data []byte, v any) error {
+	if codec == JSON {
+		return json.Unmarshal(data, v)
+	}
+	var generic any
+	if err := codec.Unmarshal(data, &generic); err != nil {
+		return err
+	}
+	b, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,78 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type envelope struct {
+	Type    string     `json:"type"`
+	Payload RawPayload `json:"payload"`
+}
+
+func TestCodecFor(t *testing.T) {
+	assert.Equal(t, MsgPack, CodecFor("msgpack"))
+	assert.Equal(t, JSON, CodecFor("json"))
+	assert.Equal(t, JSON, CodecFor(""))
+}
+
+func TestRawPayloadRoundTrip(t *testing.T) {
+	for _, codec := range []Codec{JSON, MsgPack} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			inner, err := codec.Marshal(map[string]any{"user_id": "u1"})
+			require.NoError(t, err)
+
+			data, err := codec.Marshal(envelope{Type: "get_by_id", Payload: inner})
+			require.NoError(t, err)
+
+			var decoded envelope
+			require.NoError(t, codec.Unmarshal(data, &decoded))
+			assert.Equal(t, "get_by_id", decoded.Type)
+
+			var payload struct {
+				UserID string `json:"user_id"`
+			}
+			require.NoError(t, codec.Unmarshal(decoded.Payload, &payload))
+			assert.Equal(t, "u1", payload.UserID)
+		})
+	}
+}
+
+func TestTranscode(t *testing.T) {
+	data, err := Transcode(MsgPack, json.RawMessage(`{"status":"Active","age":30}`))
+	require.NoError(t, err)
+
+	var v map[string]any
+	require.NoError(t, MsgPack.Unmarshal(data, &v))
+	assert.Equal(t, "Active", v["status"])
+	assert.EqualValues(t, 30, v["age"])
+}
+
+type nullable struct {
+	Set, Null bool
+}
+
+func (n *nullable) UnmarshalJSON(data []byte) error {
+	n.Set, n.Null = true, string(data) == "null"
+	return nil
+}
+
+func TestUnmarshalViaJSON(t *testing.T) {
+	for _, codec := range []Codec{JSON, MsgPack} {
+		data, err := codec.Marshal(map[string]any{"phone": nil, "age": 30})
+		require.NoError(t, err)
+
+		var v struct {
+			Phone  nullable `json:"phone"`
+			Status nullable `json:"status"`
+			Age    int      `json:"age"`
+		}
+		require.NoError(t, UnmarshalViaJSON(codec, data, &v), codec.Name())
+		assert.Equal(t, nullable{Set: true, Null: true}, v.Phone, codec.Name())
+		assert.Equal(t, nullable{}, v.Status, codec.Name())
+		assert.Equal(t, 30, v.Age, codec.Name())
+	}
+}
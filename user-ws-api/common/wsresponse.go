@@ -1,22 +1,25 @@
 package common
 
-import "encoding/json"
-
 type WSResponse struct {
-	Status string          `json:"status"` // "ok" or "error"
-	Entity string          `json:"entity"`
-	Type   string          `json:"type"` // e.g. "create", "update", etc.
-	Data   json.RawMessage `json:"data"` // flexible payload
+	Status string     `json:"status"` // "ok" or "error"
+	Entity string     `json:"entity"`
+	Type   string     `json:"type"` // e.g. "create", "update", etc.
+	Data   RawPayload `json:"data"` // flexible payload
 }
 
 func MakeWSResponse(status, entity, msgType string, payload any) []byte {
-	data, _ := json.Marshal(payload)
+	return EncodeWSResponse(JSON, status, entity, msgType, payload)
+}
+
+// EncodeWSResponse builds a response envelope encoded with codec.
+func EncodeWSResponse(codec Codec, status, entity, msgType string, payload any) []byte {
+	data, _ := codec.Marshal(payload)
 	resp := WSResponse{
 		Status: status,
 		Entity: entity,
 		Type:   msgType,
 		Data:   data,
 	}
-	raw, _ := json.Marshal(resp)
+	raw, _ := codec.Marshal(resp)
 	return raw
 }
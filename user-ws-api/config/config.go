@@ -3,6 +3,7 @@ package config
 import (
 	"log/slog"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -18,8 +19,73 @@ type Config struct {
 	} `yaml:"database"`
 
 	NATS struct {
-		URL string `yaml:"url"`
+		URL             string `yaml:"url"`
+		DropCopySubject string `yaml:"dropcopy_subject"` // durable JetStream subject, empty disables the NATS drop copy
+		// JetStream publishes trades, order events and book updates to durable streams.
+		JetStream bool `yaml:"jetstream"`
+		// SpoolDir keeps the messages not yet acknowledged by JetStream
+		// across restarts. Defaults to data/jetstream.
+		SpoolDir string `yaml:"spool_dir"`
 	} `yaml:"nats"`
+
+	WebSocket struct {
+		SendQueueSize      int    `yaml:"send_queue_size"`
+		SlowConsumerPolicy string `yaml:"slow_consumer_policy"` // drop_oldest, conflate or disconnect
+		Shards             int    `yaml:"shards"`               // fan-out workers, defaults to GOMAXPROCS
+		ReplayBufferSize   int    `yaml:"replay_buffer_size"`   // messages kept per topic for resume
+		// CancelOnDisconnectGrace is how long a user has to reconnect before a
+		// cancel-on-disconnect session's orders are canceled.
+		CancelOnDisconnectGrace time.Duration `yaml:"cancel_on_disconnect_grace"`
+		AdminUsers              []string      `yaml:"admin_users"` // may use the kill switch on any user
+		// AuthSecret signs the tokens connections authenticate with. Admin
+		// users need an authenticated connection.
+		AuthSecret string `yaml:"auth_secret"`
+		// ClientOrderIDRetention is how long a resubmitted order with the same
+		// ClientOrderID is acknowledged again instead of being entered.
+		ClientOrderIDRetention time.Duration `yaml:"client_order_id_retention"`
+		// UserStatusTTL is how long the status of a user is cached for order
+		// checks when no user event changes it.
+		UserStatusTTL time.Duration `yaml:"user_status_ttl"`
+	} `yaml:"websocket"`
+
+	RateLimits RateLimits `yaml:"rate_limits"`
+
+	FIX struct {
+		Port         string       `yaml:"port"` // empty disables the FIX gateway
+		SenderCompID string       `yaml:"sender_comp_id"`
+		Sessions     []FIXSession `yaml:"sessions"`
+	} `yaml:"fix"`
+}
+
+// FIXSession lets the counterparty logging on as CompID with Password trade
+// as UserID. Sessions without a password cannot log on. Passwords are sent in
+// the clear, so the port must only be reachable through TLS or a private
+// network.
+type FIXSession struct {
+	CompID   string `yaml:"comp_id"`
+	UserID   string `yaml:"user_id"`
+	Password string `yaml:"password"`
+}
+
+// RateLimit is a token bucket refilled at Rate tokens per second up to Burst.
+// A zero Rate disables the limit.
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+type ClassLimits struct {
+	PerConnection RateLimit `yaml:"per_connection"`
+	PerUser       RateLimit `yaml:"per_user"`
+}
+
+type RateLimits struct {
+	Orders  ClassLimits `yaml:"orders"`
+	Queries ClassLimits `yaml:"queries"`
+	// A client rejected more than MaxViolations times within ViolationWindow
+	// is disconnected. Zero disables disconnection.
+	MaxViolations   int           `yaml:"max_violations"`
+	ViolationWindow time.Duration `yaml:"violation_window"`
 }
 
 var AppConfig Config
@@ -1,7 +1,10 @@
 package engine
 
 import (
+	"fmt"
 	"log/slog"
+	"math"
+	"time"
 	"user-ws-api/matcher"
 	"user-ws-api/models"
 	"user-ws-api/utils"
@@ -15,6 +18,16 @@ type Asset struct {
 	book       *Book
 	submitCh   chan models.Order
 	depthReqCh chan chan BookDepthResponse
+	cancelCh   chan cancelRequest
+}
+
+// cancelRequest cancels a single order if orderID is set, otherwise all
+// orders of userID.
+type cancelRequest struct {
+	userID  string
+	orderID string
+	reason  string
+	respCh  chan []models.Order
 }
 
 type BookDepthResponse struct {
@@ -22,27 +35,65 @@ type BookDepthResponse struct {
 	SellDepth int
 }
 
-func NewAsset(assetID string, matcher matcher.Matcher, tradeCh chan<- models.Trade) *Asset {
+func NewAsset(assetID string, matcher matcher.Matcher, tradeCh chan<- models.Trade, eventCh chan<- models.OrderEvent, bookCh chan<- models.BookUpdate) *Asset {
+	book := NewBook(assetID, matcher, tradeCh, eventCh)
+	book.bookCh = bookCh
 	asset := &Asset{
-		book:       NewBook(assetID, matcher, tradeCh),
+		book:       book,
 		submitCh:   make(chan models.Order, 100),
 		depthReqCh: make(chan chan BookDepthResponse),
+		cancelCh:   make(chan cancelRequest),
 	}
 	go asset.run()
 	return asset
 }
 
 func (a *Asset) run() {
+	// expiry fires when the earliest GTD/GTT order in the book is due.
+	expiry := time.NewTimer(time.Hour)
+	expiry.Stop()
+	var expiryC <-chan time.Time
+	arm := func() {
+		expiry.Stop()
+		expiryC = nil
+		if next := a.book.NextExpiry(); !next.IsZero() {
+			expiry.Reset(time.Until(next))
+			expiryC = expiry.C
+		}
+	}
+
 	for {
 		select {
 		case order := <-a.submitCh:
+			// Expire due orders first so they cannot match before the timer fires.
+			a.book.ExpireDue(time.Now())
 			a.book.Submit(order)
+			a.book.publishUpdate()
+			arm()
+
+		case <-expiryC:
+			a.book.ExpireDue(time.Now())
+			a.book.publishUpdate()
+			arm()
 
 		case respCh := <-a.depthReqCh:
 			respCh <- BookDepthResponse{
 				BuyDepth:  a.book.BuyDepth(),
 				SellDepth: a.book.SellDepth(),
 			}
+
+		case req := <-a.cancelCh:
+			var canceled []models.Order
+			if req.orderID != "" {
+				canceled = a.book.Cancel(req.orderID, req.reason)
+			} else {
+				canceled = a.book.CancelUser(req.userID, req.reason)
+			}
+			if len(canceled) > 0 {
+				a.book.publishUpdate()
+			}
+			req.respCh <- canceled
+			arm()
 		}
 	}
 }
@@ -57,6 +108,25 @@ func (a *Asset) Submit(order models.Order) {
 	a.submitCh <- order
 }
 
+// CancelUser removes all of a user's resting orders from the book and returns them.
+func (a *Asset) CancelUser(userID, reason string) []models.Order {
+	respCh := make(chan []models.Order)
+	a.cancelCh <- cancelRequest{userID: userID, reason: reason, respCh: respCh}
+	return <-respCh
+}
+
+// Cancel removes a resting order from the book. It returns false if the order
+// is not resting, e.g. because it has been filled.
+func (a *Asset) Cancel(orderID, reason string) (models.Order, bool) {
+	respCh := make(chan []models.Order)
+	a.cancelCh <- cancelRequest{orderID: orderID, reason: reason, respCh: respCh}
+	canceled := <-respCh
+	if len(canceled) == 0 {
+		return models.Order{}, false
+	}
+	return canceled[0], true
+}
+
 func (r *OrderRouter) GetBook(assetID string) (*Book, bool) {
 	asset, ok := r.assets[assetID]
 	if !ok {
@@ -74,15 +144,52 @@ func (r *OrderRouter) GetAsset(assetID string) *Asset {
 	return <-respCh
 }
 
+// Book matches the orders of one asset. Matching waits on the output
+// channels, so their reader must never wait for consumers, like utils.FanOut.
 type Book struct {
 	assetID    string
 	buyOrders  *utils.OrderHeapQueue
 	sellOrders *utils.OrderHeapQueue
 	matcher    matcher.Matcher
 	tradeCh    chan<- models.Trade
+	eventCh    chan<- models.OrderEvent
+	// nextExpiry is no later than the earliest ExpiresAt of a resting order,
+	// zero if none expires. It may be stale after fills.
+	nextExpiry time.Time
+	// positions is each user's net filled quantity since the book was
+	// created, positive when long. It backs reduce-only orders. Like the
+	// resting orders, it is kept in memory only: after a restart users have
+	// no position, so reduce-only orders are rejected until they trade again.
+	positions map[string]float64
+	bookCh    chan<- models.BookUpdate
+	// Sequences for trade, event and book update IDs within idEpoch.
+	tradeSeq, eventSeq, updateSeq uint64
 }
 
-func NewBook(assetID string, matcher matcher.Matcher, tradeCh chan<- models.Trade) *Book {
+// ticksPerUnit is the number of price ticks in one unit of price; post-only
+// orders are repriced to the grid of ticks. Prices are divided by it rather
+// than multiplied by the tick size, so that repriced prices are the closest
+// floats to the tick.
+const ticksPerUnit = 100
+
+// tickEpsilon absorbs the float error of a price already on the grid.
+const tickEpsilon = 1e-6
+
+// tickBelow returns the highest price on the tick grid below price.
+func tickBelow(price float64) float64 {
+	return (math.Ceil(price*ticksPerUnit-tickEpsilon) - 1) / ticksPerUnit
+}
+
+// tickAbove returns the lowest price on the tick grid above price.
+func tickAbove(price float64) float64 {
+	return (math.Floor(price*ticksPerUnit+tickEpsilon) + 1) / ticksPerUnit
+}
+
+// idEpoch makes the IDs of trades, events and book updates unique across
+// restarts, as JetStream deduplicates on them while the sequences start over.
+var idEpoch = fmt.Sprintf("%d", time.Now().UnixNano())
+
+func NewBook(assetID string, matcher matcher.Matcher, tradeCh chan<- models.Trade, eventCh chan<- models.OrderEvent) *Book {
 	buyQueue := utils.NewOrderHeapQueue(func(a, b models.Order) bool {
 		if a.Price == b.Price {
 			return a.CreatedAt.Before(b.CreatedAt)
@@ -102,12 +209,54 @@ func NewBook(assetID string, matcher matcher.Matcher, tradeCh chan<- models.Trad
 		buyOrders:  buyQueue,
 		sellOrders: sellQueue,
 		tradeCh:    tradeCh,
+		eventCh:    eventCh,
+		positions:  make(map[string]float64),
 	}
 }
 
 func (b *Book) Submit(order models.Order) {
 	slog.Debug("Book.Submit", "order", order)
 
+	if order.Expires() && !order.ExpiresAt.After(time.Now()) {
+		b.emit(order, models.Rejected, "expired")
+		return
+	}
+	if order.ReduceOnly {
+		// Never let a reduce-only order flip or grow the position, even once
+		// the reduce-only orders already resting are filled too.
+		position := b.positions[order.UserID]
+		if order.Side == models.Buy {
+			position = -position
+		}
+		position -= b.restingReduceOnly(order.UserID, order.Side)
+		if position <= 0 {
+			b.emit(order, models.Rejected, "reduce_only")
+			return
+		}
+		order.Quantity = math.Min(order.Quantity, position)
+	}
+	if order.PostOnly != "" {
+		if price, crosses := b.crossingPrice(order); crosses {
+			if order.PostOnly != models.PostOnlyReprice {
+				b.emit(order, models.Rejected, "post_only")
+				return
+			}
+			if order.Side == models.Buy {
+				order.Price = tickBelow(price)
+			} else {
+				order.Price = tickAbove(price)
+			}
+			if order.Price <= 0 {
+				b.emit(order, models.Rejected, "post_only")
+				return
+			}
+			b.emit(order, models.Repriced, "post_only")
+		}
+	}
+
+	// The order is accepted; fills are reported as trades.
+	b.emit(order, models.New, "")
+
 	matchResult := b.matcher.Match(order, b)
 	slog.Debug("Book.Submit after Match", "matchResult", matchResult)
 
@@ -119,13 +268,140 @@ func (b *Book) Submit(order models.Order) {
 		} else {
 			b.sellOrders.Push(order)
 		}
+		if order.Expires() && (b.nextExpiry.IsZero() || order.ExpiresAt.Before(b.nextExpiry)) {
+			b.nextExpiry = order.ExpiresAt
+		}
 	}
 
 	for _, trade := range matchResult.Trades {
+		b.tradeSeq++
+		trade.ID = fmt.Sprintf("%s-%s-T%d", b.assetID, idEpoch, b.tradeSeq)
+		trade.AssetID = b.assetID
+		b.positions[trade.BuyerID] += trade.Quantity
+		b.positions[trade.SellerID] -= trade.Quantity
 		b.tradeCh <- trade
 	}
 }
 
+// restingReduceOnly returns the quantity of the reduce-only orders of the
+// user resting on side.
+func (b *Book) restingReduceOnly(userID string, side models.OrderSide) float64 {
+	orders := b.sellOrders
+	if side == models.Buy {
+		orders = b.buyOrders
+	}
+	var qty float64
+	orders.Each(func(o models.Order) {
+		if o.ReduceOnly && o.UserID == userID {
+			qty += o.Quantity
+		}
+	})
+	return qty
+}
+
+// crossingPrice returns the best opposite price if the order would trade
+// against it, using the same rules as SimpleMatcher.
+func (b *Book) crossingPrice(order models.Order) (float64, bool) {
+	if order.Side == models.Buy {
+		sell, ok := b.PeekSell()
+		return sell.Price, ok && sell.UserID != order.UserID && sell.Quantity > 0 && sell.Price <= order.Price
+	}
+	buy, ok := b.PeekBuy()
+	return buy.Price, ok && buy.UserID != order.UserID && buy.Quantity > 0 && buy.Price >= order.Price
+}
+
+// ExpireDue cancels the resting orders whose expiry is not after now.
+func (b *Book) ExpireDue(now time.Time) {
+	if b.nextExpiry.IsZero() || b.nextExpiry.After(now) {
+		return
+	}
+	due := func(o models.Order) bool { return o.Expires() && !o.ExpiresAt.After(now) }
+	expired := append(b.buyOrders.RemoveIf(due), b.sellOrders.RemoveIf(due)...)
+	for _, order := range expired {
+		b.emit(order, models.Canceled, "expired")
+	}
+
+	b.nextExpiry = time.Time{}
+	earliest := func(o models.Order) {
+		if o.Expires() && (b.nextExpiry.IsZero() || o.ExpiresAt.Before(b.nextExpiry)) {
+			b.nextExpiry = o.ExpiresAt
+		}
+	}
+	b.buyOrders.Each(earliest)
+	b.sellOrders.Each(earliest)
+}
+
+// NextExpiry returns when ExpireDue should next run, zero if never.
+func (b *Book) NextExpiry() time.Time {
+	return b.nextExpiry
+}
+
+// Position returns the user's net filled quantity in the asset.
+func (b *Book) Position(userID string) float64 {
+	return b.positions[userID]
+}
+
+// Cancel removes the order from the book and reports it as canceled.
+func (b *Book) Cancel(orderID, reason string) []models.Order {
+	byID := func(o models.Order) bool { return o.ID == orderID }
+	canceled := append(b.buyOrders.RemoveIf(byID), b.sellOrders.RemoveIf(byID)...)
+	for _, order := range canceled {
+		b.emit(order, models.Canceled, reason)
+	}
+	return canceled
+}
+
+// CancelUser removes the user's orders from both sides of the book and
+// reports each of them as canceled.
+func (b *Book) CancelUser(userID, reason string) []models.Order {
+	byUser := func(o models.Order) bool { return o.UserID == userID }
+	canceled := append(b.buyOrders.RemoveIf(byUser), b.sellOrders.RemoveIf(byUser)...)
+	for _, order := range canceled {
+		b.emit(order, models.Canceled, reason)
+	}
+	return canceled
+}
+
+func (b *Book) emit(order models.Order, status models.OrderStatus, reason string) {
+	if b.eventCh == nil {
+		return
+	}
+	b.eventSeq++
+	b.eventCh <- models.OrderEvent{
+		ID:           fmt.Sprintf("%s-%s-E%d", b.assetID, idEpoch, b.eventSeq),
+		OrderID:      order.ID,
+		UserID:       order.UserID,
+		AssetID:      b.assetID,
+		Status:       status,
+		Reason:       reason,
+		RemainingQty: order.Quantity,
+		Price:        order.Price,
+		Timestamp:    time.Now(),
+	}
+}
+
+// publishUpdate reports the state of the book after a change.
+func (b *Book) publishUpdate() {
+	if b.bookCh == nil {
+		return
+	}
+	b.updateSeq++
+	update := models.BookUpdate{
+		ID:        fmt.Sprintf("%s-%s-B%d", b.assetID, idEpoch, b.updateSeq),
+		AssetID:   b.assetID,
+		BuyDepth:  b.BuyDepth(),
+		SellDepth: b.SellDepth(),
+		Timestamp: time.Now(),
+	}
+	if buy, ok := b.PeekBuy(); ok {
+		update.BidPrice = buy.Price
+	}
+	if sell, ok := b.PeekSell(); ok {
+		update.AskPrice = sell.Price
+	}
+	b.bookCh <- update
+}
+
 func (b *Book) PeekBuy() (models.Order, bool)  { return b.buyOrders.Peek() }
 func (b *Book) PeekSell() (models.Order, bool) { return b.sellOrders.Peek() }
 func (b *Book) PopBuy() models.Order           { return b.buyOrders.Pop() }
@@ -7,11 +7,14 @@ import (
 )
 
 type OrderRouter struct {
-	matcher    matcher.Matcher
-	tradeCh    chan models.Trade
-	submitCh   chan models.Order
-	assets     map[string]*Asset
-	getAssetCh chan getAssetRequest
+	matcher     matcher.Matcher
+	tradeCh     chan models.Trade
+	eventCh     chan models.OrderEvent
+	bookCh      chan models.BookUpdate
+	submitCh    chan models.Order
+	assets      map[string]*Asset
+	getAssetCh  chan getAssetRequest
+	allAssetsCh chan chan []*Asset
 }
 
 type getAssetRequest struct {
@@ -21,11 +24,12 @@ type getAssetRequest struct {
 
 func NewOrderRouter(m matcher.Matcher, tradeCh chan models.Trade) *OrderRouter {
 	r := &OrderRouter{
-		matcher:    m,
-		tradeCh:    tradeCh,
-		submitCh:   make(chan models.Order, 100),
-		assets:     make(map[string]*Asset),
-		getAssetCh: make(chan getAssetRequest),
+		matcher:     m,
+		tradeCh:     tradeCh,
+		submitCh:    make(chan models.Order, 100),
+		assets:      make(map[string]*Asset),
+		getAssetCh:  make(chan getAssetRequest),
+		allAssetsCh: make(chan chan []*Asset),
 	}
 	go r.run()
 	return r
@@ -37,7 +41,7 @@ func (r *OrderRouter) run() {
 		case order := <-r.submitCh:
 			asset, ok := r.assets[order.AssetID]
 			if !ok {
-				asset = NewAsset(order.AssetID, r.matcher, r.tradeCh)
+				asset = NewAsset(order.AssetID, r.matcher, r.tradeCh, r.eventCh, r.bookCh)
 				r.assets[order.AssetID] = asset
 			}
 			slog.Debug("OrderRouter.run", "order", order)
@@ -45,10 +49,50 @@ func (r *OrderRouter) run() {
 
 		case req := <-r.getAssetCh:
 			req.respCh <- r.assets[req.assetID]
+
+		case respCh := <-r.allAssetsCh:
+			assets := make([]*Asset, 0, len(r.assets))
+			for _, asset := range r.assets {
+				assets = append(assets, asset)
+			}
+			respCh <- assets
 		}
 	}
 }
 
+// SetEventChannel makes books report order state changes, such as
+// cancellations, on eventCh. It must be called before the first order is
+// submitted.
+func (r *OrderRouter) SetEventChannel(eventCh chan models.OrderEvent) {
+	r.eventCh = eventCh
+}
+
+// SetBookChannel makes books report a BookUpdate after every change on
+// bookCh. It must be called before the first order is submitted.
+func (r *OrderRouter) SetBookChannel(bookCh chan models.BookUpdate) {
+	r.bookCh = bookCh
+}
+
 func (r *OrderRouter) Submit(order models.Order) {
 	r.submitCh <- order
 }
+
+// Cancel cancels a single resting order.
+func (r *OrderRouter) Cancel(assetID, orderID, reason string) (models.Order, bool) {
+	asset := r.GetAsset(assetID)
+	if asset == nil {
+		return models.Order{}, false
+	}
+	return asset.Cancel(orderID, reason)
+}
+
+// CancelAll cancels the user's resting orders on every asset and returns them.
+func (r *OrderRouter) CancelAll(userID, reason string) []models.Order {
+	respCh := make(chan []*Asset)
+	r.allAssetsCh <- respCh
+	var canceled []models.Order
+	for _, asset := range <-respCh {
+		canceled = append(canceled, asset.CancelUser(userID, reason)...)
+	}
+	return canceled
+}
@@ -0,0 +1,249 @@
+// user-ws/fix/acceptor.go
+package fix
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+	"user-ws-api/gateway"
+	"user-ws-api/interfaces"
+	"user-ws-api/models"
+)
+
+// doneRetention is how long filled, canceled and rejected orders are kept.
+const doneRetention = 10 * time.Minute
+
+// Acceptor is a FIX 4.4 order entry gateway. Counterparties log on with a
+// SenderCompID that is mapped to a user and the password of that session, and
+// their orders go through the same OrderSubmitter as orders sent over
+// WebSocket.
+type Acceptor struct {
+	compID string // our SenderCompID
+	router interfaces.OrderSubmitter
+	gate   *gateway.Gate
+
+	mu     sync.Mutex
+	states map[string]*sessionState // counterparty CompID -> state
+	// orders tracks every order entered over FIX by engine order ID.
+	// Orders that are no longer working are kept for doneRetention, so that
+	// fills racing a cancel are still reported.
+	orders    map[string]*orderState
+	clOrdIDs  map[string]string // counterparty CompID + ClOrdID -> engine order ID
+	lastSweep time.Time
+
+	execIDPrefix string
+	execSeq      atomic.Uint64
+
+	listener net.Listener
+}
+
+func NewAcceptor(senderCompID string, router interfaces.OrderSubmitter) *Acceptor {
+	return &Acceptor{
+		compID:       senderCompID,
+		router:       router,
+		gate:         gateway.NewGate(nil, 0),
+		states:       make(map[string]*sessionState),
+		orders:       make(map[string]*orderState),
+		clOrdIDs:     make(map[string]string),
+		execIDPrefix: fmt.Sprintf("%d", time.Now().UnixNano()),
+	}
+}
+
+// SetGate makes orders go through the same checks as orders sent over
+// WebSocket, including the status of the user. By default orders are only
+// validated. It must be called before Serve.
+func (a *Acceptor) SetGate(gate *gateway.Gate) {
+	a.gate = gate
+}
+
+// AddSession allows the counterparty compID to log on with password and
+// trade as userID. Sessions without a password cannot log on. It must be
+// called before Serve.
+func (a *Acceptor) AddSession(compID, userID, password string) {
+	a.states[compID] = newSessionState(compID, userID, password)
+}
+
+// SetTradeChannel reports fills of FIX orders as ExecutionReports.
+func (a *Acceptor) SetTradeChannel(tradeCh <-chan models.Trade) {
+	go func() {
+		for trade := range tradeCh {
+			a.onTrade(trade)
+		}
+	}()
+}
+
+// SetEventChannel reports unsolicited order events, such as expiry or the
+// kill switch, as ExecutionReports.
+func (a *Acceptor) SetEventChannel(eventCh <-chan models.OrderEvent) {
+	go func() {
+		for event := range eventCh {
+			a.onEvent(event)
+		}
+	}()
+}
+
+func (a *Acceptor) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return a.Serve(l)
+}
+
+// Serve accepts connections on l until Close is called.
+func (a *Acceptor) Serve(l net.Listener) error {
+	a.mu.Lock()
+	a.listener = l
+	a.mu.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go a.handle(conn)
+	}
+}
+
+// Close stops accepting connections and drops every logged on session.
+func (a *Acceptor) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, state := range a.states {
+		state.mu.Lock()
+		if state.conn != nil {
+			_ = state.conn.Close()
+		}
+		state.mu.Unlock()
+	}
+	if a.listener == nil {
+		return nil
+	}
+	return a.listener.Close()
+}
+
+func (a *Acceptor) handle(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	r := bufio.NewReader(conn)
+
+	_ = conn.SetReadDeadline(time.Now().Add(logonTimeout))
+	raw, err := ReadMessage(r)
+	if err != nil {
+		slog.Warn("FIX connection closed before logon", "Remote", conn.RemoteAddr(), "Error", err)
+		return
+	}
+	msg, err := Parse(raw)
+	if err != nil || msg.Type() != MsgLogon {
+		slog.Warn("First FIX message must be a valid Logon", "Remote", conn.RemoteAddr(), "Error", err)
+		return
+	}
+	s, err := a.logon(conn, msg)
+	if err != nil {
+		slog.Warn("FIX logon refused", "Remote", conn.RemoteAddr(), "Error", err)
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	slog.Info("FIX session logged on", "CompID", s.state.compID, "UserID", s.state.userID)
+
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		s.state.mu.Lock()
+		if s.state.conn == conn {
+			s.state.conn = nil
+		}
+		s.state.mu.Unlock()
+		slog.Info("FIX session logged out", "CompID", s.state.compID)
+	}()
+	go s.monitor(done)
+	s.readLoop(r)
+}
+
+// checkCredentials checks the Username and Password of a Logon. The Username
+// is optional and must be the SenderCompID if sent.
+func checkCredentials(state *sessionState, msg *Message) error {
+	if username, ok := msg.Get(TagUsername); ok && username != state.compID {
+		return fmt.Errorf("Username %q does not match SenderCompID %q", username, state.compID)
+	}
+	password, _ := msg.Get(TagPassword)
+	if state.password == "" || subtle.ConstantTimeCompare([]byte(password), []byte(state.password)) != 1 {
+		return fmt.Errorf("invalid password for SenderCompID %q", state.compID)
+	}
+	return nil
+}
+
+// logon validates a Logon, attaches conn to the counterparty's session state
+// and answers it.
+func (a *Acceptor) logon(conn net.Conn, msg *Message) (*session, error) {
+	compID, _ := msg.Get(TagSenderCompID)
+	if target, _ := msg.Get(TagTargetCompID); target != a.compID {
+		return nil, fmt.Errorf("unknown TargetCompID %q", target)
+	}
+	a.mu.Lock()
+	state, ok := a.states[compID]
+	a.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown SenderCompID %q", compID)
+	}
+	if err := checkCredentials(state, msg); err != nil {
+		return nil, err
+	}
+	heartBtInt, err := msg.Int(TagHeartBtInt)
+	if err != nil || heartBtInt <= 0 {
+		return nil, fmt.Errorf("invalid HeartBtInt: %v", err)
+	}
+	seq, err := msg.Int(TagMsgSeqNum)
+	if err != nil {
+		return nil, err
+	}
+
+	state.mu.Lock()
+	if state.conn != nil {
+		state.mu.Unlock()
+		return nil, fmt.Errorf("session %s already logged on", compID)
+	}
+	reset := msg.Flag(TagResetSeqNumFlag)
+	if reset {
+		state.outSeq, state.inSeq = 1, 1
+		state.sent = make(map[int]*Message)
+	}
+	state.conn = conn
+	expected := state.inSeq
+	state.mu.Unlock()
+
+	s := &session{
+		acceptor:  a,
+		state:     state,
+		conn:      conn,
+		heartbeat: time.Duration(heartBtInt) * time.Second,
+		lastRecv:  time.Now(),
+	}
+	if seq < expected {
+		s.logout(fmt.Sprintf("MsgSeqNum too low, expecting %d but received %d", expected, seq))
+		state.mu.Lock()
+		state.conn = nil
+		state.mu.Unlock()
+		return nil, fmt.Errorf("logon MsgSeqNum %d below expected %d", seq, expected)
+	}
+
+	reply := NewMessage(MsgLogon).Set(TagEncryptMethod, "0").SetInt(TagHeartBtInt, heartBtInt)
+	if reset {
+		reply.Set(TagResetSeqNumFlag, "Y")
+	}
+	s.send(reply)
+	if seq > expected {
+		s.resendUntil = seq
+		s.send(NewMessage(MsgResendRequest).SetInt(TagBeginSeqNo, expected).SetInt(TagEndSeqNo, 0))
+	} else {
+		state.setExpectedSeq(seq + 1)
+	}
+	return s, nil
+}
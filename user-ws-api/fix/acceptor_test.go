@@ -0,0 +1,374 @@
+package fix
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+	"user-ws-api/engine"
+	"user-ws-api/gateway"
+	"user-ws-api/matcher"
+	"user-ws-api/models"
+
+	"github.com/google/uuid"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/userservice"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const gatewayCompID = "GATEWAY"
+
+// initiator is a minimal FIX client used to drive the acceptor.
+type initiator struct {
+	t      *testing.T
+	conn   net.Conn
+	r      *bufio.Reader
+	compID string
+	seq    int
+}
+
+// startAcceptor serves an acceptor with the sessions BUYER and SELLER, after
+// passing it to configure.
+func startAcceptor(t *testing.T, configure ...func(*Acceptor)) string {
+	tradeCh := make(chan models.Trade, 10)
+	eventCh := make(chan models.OrderEvent, 10)
+	router := engine.NewOrderRouter(&matcher.SimpleMatcher{}, tradeCh)
+	router.SetEventChannel(eventCh)
+
+	a := NewAcceptor(gatewayCompID, router)
+	a.AddSession("BUYER", "u1", password("BUYER"))
+	a.AddSession("SELLER", "u2", password("SELLER"))
+	a.SetTradeChannel(tradeCh)
+	a.SetEventChannel(eventCh)
+	for _, f := range configure {
+		f(a)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = a.Serve(l) }()
+	t.Cleanup(func() { _ = a.Close() })
+	return l.Addr().String()
+}
+
+func password(compID string) string {
+	return "secret-" + compID
+}
+
+func logon(t *testing.T, addr, compID string, heartBtInt int) *initiator {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	i := &initiator{t: t, conn: conn, r: bufio.NewReader(conn), compID: compID, seq: 1}
+	i.send(NewMessage(MsgLogon).Set(TagEncryptMethod, "0").SetInt(TagHeartBtInt, heartBtInt).Set(TagResetSeqNumFlag, "Y").
+		Set(TagUsername, compID).Set(TagPassword, password(compID)))
+	reply := i.expect(MsgLogon)
+	assert.Equal(t, "Y", mustGet(t, reply, TagResetSeqNumFlag))
+	return i
+}
+
+func (i *initiator) send(msg *Message) {
+	msg.Set(TagSenderCompID, i.compID).Set(TagTargetCompID, gatewayCompID).
+		SetInt(TagMsgSeqNum, i.seq).SetTime(TagSendingTime, time.Now())
+	i.seq++
+	_, err := i.conn.Write(msg.Bytes())
+	require.NoError(i.t, err)
+}
+
+// expect reads the next message, skipping heartbeats, and checks its type.
+func (i *initiator) expect(msgType string) *Message {
+	for {
+		require.NoError(i.t, i.conn.SetReadDeadline(time.Now().Add(3*time.Second)))
+		raw, err := ReadMessage(i.r)
+		require.NoError(i.t, err)
+		msg, err := Parse(raw)
+		require.NoError(i.t, err)
+		if msg.Type() == MsgHeartbeat && msgType != MsgHeartbeat {
+			continue
+		}
+		require.Equal(i.t, msgType, msg.Type(), string(raw))
+		return msg
+	}
+}
+
+func (i *initiator) expectReport(execType, ordStatus string) *Message {
+	msg := i.expect(MsgExecutionReport)
+	assert.Equal(i.t, execType, mustGet(i.t, msg, TagExecType), "ExecType")
+	assert.Equal(i.t, ordStatus, mustGet(i.t, msg, TagOrdStatus), "OrdStatus")
+	return msg
+}
+
+func mustGet(t *testing.T, msg *Message, tag int) string {
+	v, ok := msg.Get(tag)
+	require.True(t, ok, "tag %d missing", tag)
+	return v
+}
+
+func newOrder(clOrdID, side string, qty, price float64) *Message {
+	return NewMessage(MsgNewOrderSingle).
+		Set(TagClOrdID, clOrdID).
+		Set(TagSymbol, "BTC").
+		Set(TagSide, side).
+		SetFloat(TagOrderQty, qty).
+		Set(TagOrdType, "2").
+		SetFloat(TagPrice, price).
+		SetTime(TagTransactTime, time.Now())
+}
+
+func TestAcceptor_OrdersTradeAcrossSessions(t *testing.T) {
+	addr := startAcceptor(t)
+	buyer := logon(t, addr, "BUYER", 30)
+	seller := logon(t, addr, "SELLER", 30)
+
+	buyer.send(newOrder("b1", "1", 2, 100))
+	buyer.expectReport(execNew, statusNew)
+
+	seller.send(newOrder("s1", "2", 2, 100))
+	seller.expectReport(execNew, statusNew)
+
+	fill := buyer.expectReport(execTrade, statusFilled)
+	assert.Equal(t, "2", mustGet(t, fill, TagLastQty))
+	assert.Equal(t, "100", mustGet(t, fill, TagLastPx))
+	assert.Equal(t, "0", mustGet(t, fill, TagLeavesQty))
+	seller.expectReport(execTrade, statusFilled)
+}
+
+func TestAcceptor_CancelAndReplace(t *testing.T) {
+	addr := startAcceptor(t)
+	buyer := logon(t, addr, "BUYER", 30)
+	seller := logon(t, addr, "SELLER", 30)
+
+	buyer.send(newOrder("b1", "1", 1, 100))
+	buyer.expectReport(execNew, statusNew)
+
+	buyer.send(NewMessage(MsgOrderCancelReplaceRequest).
+		Set(TagClOrdID, "b2").Set(TagOrigClOrdID, "b1").Set(TagSymbol, "BTC").Set(TagSide, "1").
+		SetFloat(TagOrderQty, 3).Set(TagOrdType, "2").SetFloat(TagPrice, 101))
+	replaced := buyer.expectReport(execReplaced, statusNew)
+	assert.Equal(t, "b1", mustGet(t, replaced, TagOrigClOrdID))
+	assert.Equal(t, "3", mustGet(t, replaced, TagLeavesQty))
+
+	seller.send(newOrder("s1", "2", 1, 101))
+	seller.expectReport(execNew, statusNew)
+	buyer.expectReport(execTrade, statusPartiallyFilled)
+
+	buyer.send(NewMessage(MsgOrderCancelRequest).
+		Set(TagClOrdID, "b3").Set(TagOrigClOrdID, "b2").Set(TagSymbol, "BTC").Set(TagSide, "1"))
+	canceled := buyer.expectReport(execCanceled, statusCanceled)
+	assert.Equal(t, "b3", mustGet(t, canceled, TagClOrdID))
+	assert.Equal(t, "1", mustGet(t, canceled, TagCumQty))
+
+	buyer.send(NewMessage(MsgOrderCancelRequest).
+		Set(TagClOrdID, "b4").Set(TagOrigClOrdID, "nope").Set(TagSymbol, "BTC").Set(TagSide, "1"))
+	reject := buyer.expect(MsgOrderCancelReject)
+	assert.Equal(t, "1", mustGet(t, reject, TagCxlRejReason))
+}
+
+func TestAcceptor_RejectsUnsupportedOrders(t *testing.T) {
+	addr := startAcceptor(t)
+	buyer := logon(t, addr, "BUYER", 30)
+
+	buyer.send(newOrder("m1", "1", 1, 100).Set(TagOrdType, "1"))
+	reject := buyer.expectReport(execRejected, statusRejected)
+	assert.Contains(t, mustGet(t, reject, TagText), "limit")
+}
+
+// statusUsers serves GetUser with a status that can be changed; other methods
+// are not implemented.
+type statusUsers struct {
+	userservice.UserService
+	mu     sync.Mutex
+	status string
+}
+
+func (f *statusUsers) setStatus(status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status = status
+}
+
+func (f *statusUsers) GetUser(_ context.Context, id uuid.UUID) (userservice.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return userservice.User{UserID: id, Status: f.status}, nil
+}
+
+func TestAcceptor_RejectsUsersThatCannotTrade(t *testing.T) {
+	users := &statusUsers{status: userservice.StatusActive}
+	addr := startAcceptor(t, func(a *Acceptor) {
+		a.AddSession("TRADER", uuid.NewString(), password("TRADER"))
+		// Statuses are looked up for every order.
+		a.SetGate(gateway.NewGate(users, 0))
+	})
+	trader := logon(t, addr, "TRADER", 30)
+
+	trader.send(newOrder("t1", "1", 1, 100))
+	trader.expectReport(execNew, statusNew)
+
+	users.setStatus(userservice.StatusSuspended)
+	trader.send(newOrder("t2", "1", 1, 100))
+	reject := trader.expectReport(execRejected, statusRejected)
+	assert.Contains(t, mustGet(t, reject, TagText), "suspended")
+
+	trader.send(NewMessage(MsgOrderCancelReplaceRequest).
+		Set(TagClOrdID, "t3").Set(TagOrigClOrdID, "t1").Set(TagSymbol, "BTC").Set(TagSide, "1").
+		SetFloat(TagOrderQty, 2).Set(TagOrdType, "2").SetFloat(TagPrice, 101))
+	cxlReject := trader.expect(MsgOrderCancelReject)
+	assert.Contains(t, mustGet(t, cxlReject, TagText), "suspended")
+	assert.Equal(t, statusNew, mustGet(t, cxlReject, TagOrdStatus), "the order keeps working")
+
+	// Suspended users can still cancel.
+	trader.send(NewMessage(MsgOrderCancelRequest).
+		Set(TagClOrdID, "t4").Set(TagOrigClOrdID, "t1").Set(TagSymbol, "BTC").Set(TagSide, "1"))
+	trader.expectReport(execCanceled, statusCanceled)
+}
+
+func TestAcceptor_SessionLevelMessages(t *testing.T) {
+	addr := startAcceptor(t)
+	buyer := logon(t, addr, "BUYER", 30)
+
+	buyer.send(NewMessage(MsgTestRequest).Set(TagTestReqID, "ping"))
+	hb := buyer.expect(MsgHeartbeat)
+	assert.Equal(t, "ping", mustGet(t, hb, TagTestReqID))
+
+	// A sequence gap is answered with a ResendRequest and healed by a gap fill.
+	buyer.seq += 3
+	buyer.send(NewMessage(MsgHeartbeat))
+	resend := buyer.expect(MsgResendRequest)
+	assert.Equal(t, "3", mustGet(t, resend, TagBeginSeqNo))
+	next := buyer.seq
+	gapFill := NewMessage(MsgSequenceReset).Set(TagGapFillFlag, "Y").SetInt(TagNewSeqNo, next)
+	buyer.seq = 3
+	buyer.send(gapFill.Set(TagPossDupFlag, "Y"))
+	buyer.seq = next
+
+	buyer.send(newOrder("b1", "1", 1, 100))
+	buyer.expectReport(execNew, statusNew)
+
+	// Our own ResendRequest gets admin messages gap filled and the execution
+	// report sent again as a possible duplicate.
+	buyer.send(NewMessage(MsgResendRequest).SetInt(TagBeginSeqNo, 1).SetInt(TagEndSeqNo, 0))
+	fill := buyer.expect(MsgSequenceReset)
+	assert.Equal(t, "Y", mustGet(t, fill, TagGapFillFlag))
+	dup := buyer.expectReport(execNew, statusNew)
+	assert.Equal(t, "Y", mustGet(t, dup, TagPossDupFlag))
+	assert.Equal(t, "b1", mustGet(t, dup, TagClOrdID))
+
+	buyer.send(NewMessage(MsgLogout))
+	buyer.expect(MsgLogout)
+}
+
+func TestAcceptor_TestRequestOnSilence(t *testing.T) {
+	addr := startAcceptor(t)
+	buyer := logon(t, addr, "BUYER", 1)
+
+	testReq := buyer.expect(MsgTestRequest)
+	buyer.send(NewMessage(MsgHeartbeat).Set(TagTestReqID, mustGet(t, testReq, TagTestReqID)))
+}
+
+func TestAcceptor_RefusesUnknownCompID(t *testing.T) {
+	addr := startAcceptor(t)
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	i := &initiator{t: t, conn: conn, r: bufio.NewReader(conn), compID: "STRANGER", seq: 1}
+	i.send(NewMessage(MsgLogon).Set(TagEncryptMethod, "0").SetInt(TagHeartBtInt, 30))
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = ReadMessage(i.r)
+	assert.Error(t, err)
+}
+
+func TestAcceptor_RefusesLogonWithoutPassword(t *testing.T) {
+	addr := startAcceptor(t, func(a *Acceptor) {
+		a.AddSession("NOPASS", "u3", "")
+	})
+	for name, tc := range map[string]struct{ compID, password string }{
+		"missing":      {"BUYER", ""},
+		"wrong":        {"BUYER", "guess"},
+		"unconfigured": {"NOPASS", ""},
+	} {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		i := &initiator{t: t, conn: conn, r: bufio.NewReader(conn), compID: tc.compID, seq: 1}
+		logonMsg := NewMessage(MsgLogon).Set(TagEncryptMethod, "0").SetInt(TagHeartBtInt, 30)
+		if tc.password != "" {
+			logonMsg.Set(TagPassword, tc.password)
+		}
+		i.send(logonMsg)
+
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		_, err = ReadMessage(i.r)
+		assert.Error(t, err, name)
+		_ = conn.Close()
+	}
+
+	// The refused logons did not take the session.
+	logon(t, addr, "BUYER", 30)
+}
+
+func TestAcceptor_SweepsDoneOrders(t *testing.T) {
+	a := NewAcceptor(gatewayCompID, nil)
+	now := time.Now()
+	done := &orderState{orderID: "C-d1", keys: []string{clOrdKey("C", "d1"), clOrdKey("C", "d2")}, doneAt: now}
+	working := &orderState{orderID: "C-w1", keys: []string{clOrdKey("C", "w1")}}
+	for _, o := range []*orderState{done, working} {
+		a.orders[o.orderID] = o
+		for _, key := range o.keys {
+			a.clOrdIDs[key] = o.orderID
+		}
+	}
+
+	a.sweep(now.Add(time.Minute))
+	assert.Len(t, a.orders, 2, "done orders are kept for a while")
+
+	a.sweep(now.Add(doneRetention))
+	assert.Equal(t, map[string]*orderState{"C-w1": working}, a.orders)
+	assert.Equal(t, map[string]string{clOrdKey("C", "w1"): "C-w1"}, a.clOrdIDs)
+}
+
+// countingRouter counts the orders submitted to it.
+type countingRouter struct {
+	mu     sync.Mutex
+	orders []models.Order
+}
+
+func (r *countingRouter) Submit(order models.Order) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.orders = append(r.orders, order)
+}
+
+// slowUsers takes a while to serve GetUser.
+type slowUsers struct {
+	*statusUsers
+}
+
+func (f slowUsers) GetUser(ctx context.Context, id uuid.UUID) (userservice.User, error) {
+	time.Sleep(10 * time.Millisecond)
+	return f.statusUsers.GetUser(ctx, id)
+}
+
+func TestAcceptor_AcceptsConcurrentDuplicateClOrdIDOnce(t *testing.T) {
+	router := &countingRouter{}
+	a := NewAcceptor(gatewayCompID, router)
+	// A slow status lookup widens the window between the checks of the order
+	// and its acceptance.
+	a.SetGate(gateway.NewGate(slowUsers{&statusUsers{status: userservice.StatusActive}}, 0))
+	s := &session{acceptor: a, state: newSessionState("BUYER", uuid.NewString(), password("BUYER"))}
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.onNewOrder(s, newOrder("d1", "1", 1, 100))
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, router.orders, 1)
+	assert.Len(t, a.orders, 1)
+}
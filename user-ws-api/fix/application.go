@@ -0,0 +1,418 @@
+// user-ws/fix/application.go
+package fix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+	"user-ws-api/interfaces"
+	"user-ws-api/models"
+)
+
+// ExecType values.
+const (
+	execNew      = "0"
+	execCanceled = "4"
+	execReplaced = "5"
+	execRejected = "8"
+	execRestated = "D"
+	execExpired  = "C"
+	execTrade    = "F"
+)
+
+// OrdStatus values.
+const (
+	statusNew             = "0"
+	statusPartiallyFilled = "1"
+	statusFilled          = "2"
+	statusCanceled        = "4"
+	statusRejected        = "8"
+	statusExpired         = "C"
+)
+
+// Cancel reasons used for cancels the gateway reports itself.
+const (
+	reasonCancel  = "fix_cancel"
+	reasonReplace = "fix_replace"
+)
+
+type orderState struct {
+	orderID string
+	clOrdID string
+	compID  string
+	side    string // FIX Side
+	order   models.Order
+	qty     float64 // total OrderQty, including filled quantity
+	cumQty  float64
+	avgPx   float64
+	// terminal is the OrdStatus once the order is no longer working; fills
+	// reported afterwards keep it.
+	terminal string
+	// keys are the clOrdIDs entries of the order, one per ClOrdID it had.
+	keys []string
+	// doneAt is when the order was filled or became terminal.
+	doneAt time.Time
+}
+
+func (o *orderState) status() string {
+	switch {
+	case o.terminal != "":
+		return o.terminal
+	case o.cumQty >= o.qty:
+		return statusFilled
+	case o.cumQty > 0:
+		return statusPartiallyFilled
+	default:
+		return statusNew
+	}
+}
+
+func (o *orderState) leavesQty() float64 {
+	if o.terminal != "" || o.cumQty >= o.qty {
+		return 0
+	}
+	return o.qty - o.cumQty
+}
+
+func clOrdKey(compID, clOrdID string) string {
+	return compID + "\x00" + clOrdID
+}
+
+// finish marks o as no longer working; it is dropped by sweep later. a.mu
+// must be held.
+func (a *Acceptor) finish(o *orderState, now time.Time) {
+	if o.doneAt.IsZero() {
+		o.doneAt = now
+	}
+}
+
+// sweep drops the orders finished more than doneRetention ago, at most once
+// a minute, so that their ClOrdIDs can be used again. a.mu must be held.
+func (a *Acceptor) sweep(now time.Time) {
+	if now.Sub(a.lastSweep) < time.Minute {
+		return
+	}
+	a.lastSweep = now
+	for id, o := range a.orders {
+		if !o.doneAt.IsZero() && now.Sub(o.doneAt) >= doneRetention {
+			delete(a.orders, id)
+			for _, key := range o.keys {
+				delete(a.clOrdIDs, key)
+			}
+		}
+	}
+}
+
+func (a *Acceptor) nextExecID() string {
+	return a.execIDPrefix + "-" + strconv.FormatUint(a.execSeq.Add(1), 10)
+}
+
+func (a *Acceptor) execReport(o *orderState, execType string) *Message {
+	return NewMessage(MsgExecutionReport).
+		Set(TagOrderID, o.orderID).
+		Set(TagClOrdID, o.clOrdID).
+		Set(TagExecID, a.nextExecID()).
+		Set(TagExecType, execType).
+		Set(TagOrdStatus, o.status()).
+		Set(TagSymbol, o.order.AssetID).
+		Set(TagSide, o.side).
+		SetFloat(TagOrderQty, o.qty).
+		SetFloat(TagPrice, o.order.Price).
+		SetFloat(TagLeavesQty, o.leavesQty()).
+		SetFloat(TagCumQty, o.cumQty).
+		SetFloat(TagAvgPx, o.avgPx).
+		SetTime(TagTransactTime, time.Now())
+}
+
+// parseOrder translates a NewOrderSingle into an engine order. Only limit
+// orders are supported. Day orders are treated as GTC since the engine has no
+// trading day.
+func parseOrder(msg *Message, userID, orderID string) (models.Order, error) {
+	order := models.Order{ID: orderID, UserID: userID, CreatedAt: time.Now()}
+	var err error
+	if order.AssetID, err = msg.String(TagSymbol); err != nil {
+		return order, err
+	}
+	side, _ := msg.Get(TagSide)
+	switch side {
+	case "1":
+		order.Side = models.Buy
+	case "2":
+		order.Side = models.Sell
+	default:
+		return order, fmt.Errorf("unsupported Side %q", side)
+	}
+	if ordType, _ := msg.Get(TagOrdType); ordType != "2" {
+		return order, errors.New("only limit orders (OrdType=2) are supported")
+	}
+	if order.Quantity, err = msg.Float(TagOrderQty); err != nil || order.Quantity <= 0 {
+		return order, fmt.Errorf("invalid OrderQty: %v", err)
+	}
+	if order.Price, err = msg.Float(TagPrice); err != nil || order.Price <= 0 {
+		return order, fmt.Errorf("invalid Price: %v", err)
+	}
+	switch tif, _ := msg.Get(TagTimeInForce); tif {
+	case "", "0", "1":
+		order.TimeInForce = models.GTC
+	case "6":
+		order.TimeInForce = models.GTD
+		if order.ExpiresAt, err = msg.Time(TagExpireTime); err != nil {
+			return order, fmt.Errorf("GTD requires ExpireTime: %w", err)
+		}
+	default:
+		return order, fmt.Errorf("unsupported TimeInForce %q", tif)
+	}
+	// ExecInst 6 is "Participate don't initiate", i.e. post-only.
+	if execInst, _ := msg.Get(TagExecInst); strings.Contains(execInst, "6") {
+		order.PostOnly = models.PostOnlyReject
+	}
+	return order, nil
+}
+
+func (a *Acceptor) onNewOrder(s *session, msg *Message) {
+	clOrdID, err := msg.String(TagClOrdID)
+	if err != nil {
+		s.reject(msg, rejectRequiredTagMissing, err.Error())
+		return
+	}
+	orderID := s.state.compID + "-" + clOrdID
+	order, err := parseOrder(msg, s.state.userID, orderID)
+	order.ClientOrderID = clOrdID
+	if err == nil {
+		err = a.gate.Check(context.Background(), s.state.userID, order)
+	}
+	side, _ := msg.Get(TagSide)
+	key := clOrdKey(s.state.compID, clOrdID)
+	o := &orderState{orderID: orderID, clOrdID: clOrdID, compID: s.state.compID, side: side, order: order, qty: order.Quantity, keys: []string{key}}
+	var report *Message
+	if err == nil {
+		// The ClOrdID is checked and taken in one step, so that two orders
+		// sent at once with the same ClOrdID cannot both be accepted.
+		a.mu.Lock()
+		if _, dup := a.clOrdIDs[key]; dup {
+			err = errors.New("duplicate ClOrdID")
+		} else {
+			a.sweep(time.Now())
+			a.orders[orderID] = o
+			a.clOrdIDs[key] = orderID
+			report = a.execReport(o, execNew)
+		}
+		a.mu.Unlock()
+	}
+	if err != nil {
+		slog.Warn("Rejecting FIX order", "CompID", s.state.compID, "ClOrdID", clOrdID, "Error", err)
+		rejected := &orderState{orderID: "NONE", clOrdID: clOrdID, side: side, order: order, qty: order.Quantity, terminal: statusRejected}
+		s.send(a.execReport(rejected, execRejected).Set(TagText, err.Error()))
+		return
+	}
+
+	// The acknowledgement goes out before the order can trade.
+	s.send(report)
+	a.router.Submit(order)
+}
+
+// onCancel handles OrderCancelRequest and, if replace is set,
+// OrderCancelReplaceRequest. A replace cancels the resting order and enters
+// the remaining quantity at the new price under the same OrderID, so it
+// loses time priority.
+func (a *Acceptor) onCancel(s *session, msg *Message, replace bool) {
+	clOrdID, err := msg.String(TagClOrdID)
+	if err != nil {
+		s.reject(msg, rejectRequiredTagMissing, err.Error())
+		return
+	}
+	origClOrdID, err := msg.String(TagOrigClOrdID)
+	if err != nil {
+		s.reject(msg, rejectRequiredTagMissing, err.Error())
+		return
+	}
+	var newQty, newPrice float64
+	if replace {
+		if newQty, err = msg.Float(TagOrderQty); err != nil || newQty <= 0 {
+			a.cancelReject(s, nil, clOrdID, origClOrdID, replace, "99", "invalid OrderQty")
+			return
+		}
+		if newPrice, err = msg.Float(TagPrice); err != nil || newPrice <= 0 {
+			a.cancelReject(s, nil, clOrdID, origClOrdID, replace, "99", "invalid Price")
+			return
+		}
+	}
+
+	// The new ClOrdID is checked and taken in one step, so that two requests
+	// sent at once with the same ClOrdID cannot both go through; it is given
+	// back if the request is rejected.
+	key := clOrdKey(s.state.compID, clOrdID)
+	a.mu.Lock()
+	o := a.orders[a.clOrdIDs[clOrdKey(s.state.compID, origClOrdID)]]
+	_, dup := a.clOrdIDs[key]
+	if o != nil && !dup {
+		a.clOrdIDs[key] = o.orderID
+	}
+	a.mu.Unlock()
+	switch {
+	case o == nil:
+		a.cancelReject(s, nil, clOrdID, origClOrdID, replace, "1", "unknown order")
+		return
+	case dup:
+		a.cancelReject(s, o, clOrdID, origClOrdID, replace, "6", "duplicate ClOrdID")
+		return
+	}
+	release := func() {
+		a.mu.Lock()
+		delete(a.clOrdIDs, key)
+		a.mu.Unlock()
+	}
+	if replace {
+		a.mu.Lock()
+		replacement := o.order
+		a.mu.Unlock()
+		replacement.Quantity, replacement.Price = newQty, newPrice
+		if err := a.gate.Check(context.Background(), s.state.userID, replacement); err != nil {
+			slog.Warn("Rejecting FIX replace", "CompID", s.state.compID, "ClOrdID", clOrdID, "Error", err)
+			release()
+			a.cancelReject(s, o, clOrdID, origClOrdID, replace, "99", err.Error())
+			return
+		}
+	}
+	canceller, ok := a.router.(interfaces.OrderCanceller)
+	if !ok {
+		release()
+		a.cancelReject(s, o, clOrdID, origClOrdID, replace, "99", "order cancellation not supported")
+		return
+	}
+
+	reason := reasonCancel
+	if replace {
+		reason = reasonReplace
+	}
+	canceled, ok := canceller.Cancel(o.order.AssetID, o.orderID, reason)
+	if !ok {
+		release()
+		a.cancelReject(s, o, clOrdID, origClOrdID, replace, "0", "too late to cancel")
+		return
+	}
+
+	a.mu.Lock()
+	o.clOrdID = clOrdID
+	o.keys = append(o.keys, key)
+	// The engine's remaining quantity is authoritative; fills may not have
+	// reached onTrade yet.
+	leaves := newQty - (o.qty - canceled.Quantity)
+	if !replace || leaves <= 0 {
+		o.terminal = statusCanceled
+		a.finish(o, time.Now())
+		report := a.execReport(o, execCanceled).Set(TagOrigClOrdID, origClOrdID)
+		a.mu.Unlock()
+		s.send(report)
+		return
+	}
+	o.qty = newQty
+	o.order.Quantity = leaves
+	o.order.Price = newPrice
+	o.order.CreatedAt = time.Now()
+	resubmit := o.order
+	report := a.execReport(o, execReplaced).Set(TagOrigClOrdID, origClOrdID)
+	a.mu.Unlock()
+
+	s.send(report)
+	a.router.Submit(resubmit)
+}
+
+func (a *Acceptor) cancelReject(s *session, o *orderState, clOrdID, origClOrdID string, replace bool, reason, text string) {
+	orderID, status := "NONE", statusRejected
+	if o != nil {
+		a.mu.Lock()
+		orderID, status = o.orderID, o.status()
+		a.mu.Unlock()
+	}
+	responseTo := "1"
+	if replace {
+		responseTo = "2"
+	}
+	s.send(NewMessage(MsgOrderCancelReject).
+		Set(TagOrderID, orderID).
+		Set(TagClOrdID, clOrdID).
+		Set(TagOrigClOrdID, origClOrdID).
+		Set(TagOrdStatus, status).
+		Set(TagCxlRejResponseTo, responseTo).
+		Set(TagCxlRejReason, reason).
+		Set(TagText, text))
+}
+
+type pendingReport struct {
+	compID string
+	msg    *Message
+}
+
+func (a *Acceptor) deliver(reports []pendingReport) {
+	for _, r := range reports {
+		a.mu.Lock()
+		state := a.states[r.compID]
+		a.mu.Unlock()
+		state.send(a.compID, r.msg)
+	}
+}
+
+func (a *Acceptor) onTrade(trade models.Trade) {
+	var reports []pendingReport
+	a.mu.Lock()
+	for _, id := range []string{trade.BuyOrderID, trade.SellOrderID} {
+		o, ok := a.orders[id]
+		if !ok {
+			continue
+		}
+		o.avgPx = (o.avgPx*o.cumQty + trade.Price*trade.Quantity) / (o.cumQty + trade.Quantity)
+		o.cumQty += trade.Quantity
+		if o.status() == statusFilled {
+			a.finish(o, time.Now())
+		}
+		report := a.execReport(o, execTrade).
+			SetFloat(TagLastQty, trade.Quantity).
+			SetFloat(TagLastPx, trade.Price)
+		reports = append(reports, pendingReport{o.compID, report})
+	}
+	a.mu.Unlock()
+	a.deliver(reports)
+}
+
+func (a *Acceptor) onEvent(event models.OrderEvent) {
+	if event.Reason == reasonCancel || event.Reason == reasonReplace {
+		return // reported by onCancel
+	}
+	a.mu.Lock()
+	o, ok := a.orders[event.OrderID]
+	if !ok || o.terminal != "" {
+		a.mu.Unlock()
+		return
+	}
+	var report *Message
+	switch event.Status {
+	case models.Canceled:
+		if event.Reason == "expired" {
+			o.terminal = statusExpired
+			report = a.execReport(o, execExpired)
+		} else {
+			o.terminal = statusCanceled
+			report = a.execReport(o, execCanceled)
+		}
+	case models.Rejected:
+		o.terminal = statusRejected
+		report = a.execReport(o, execRejected)
+	case models.Repriced:
+		o.order.Price = event.Price
+		report = a.execReport(o, execRestated)
+	default:
+		a.mu.Unlock()
+		return
+	}
+	if o.terminal != "" {
+		a.finish(o, time.Now())
+	}
+	report.Set(TagText, event.Reason)
+	a.mu.Unlock()
+	a.deliver([]pendingReport{{o.compID, report}})
+}
@@ -0,0 +1,317 @@
+// user-ws/fix/message.go
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	BeginString = "FIX.4.4"
+	soh         = '\x01'
+	// maxBodyLength bounds a message so a corrupt BodyLength cannot make the
+	// reader allocate without limit.
+	maxBodyLength = 64 * 1024
+	timeFormat    = "20060102-15:04:05.000"
+)
+
+// Tags used by the gateway.
+const (
+	TagAvgPx               = 6
+	TagBeginSeqNo          = 7
+	TagBeginString         = 8
+	TagBodyLength          = 9
+	TagCheckSum            = 10
+	TagClOrdID             = 11
+	TagCumQty              = 14
+	TagEndSeqNo            = 16
+	TagExecID              = 17
+	TagExecInst            = 18
+	TagLastPx              = 31
+	TagLastQty             = 32
+	TagMsgSeqNum           = 34
+	TagMsgType             = 35
+	TagNewSeqNo            = 36
+	TagOrderID             = 37
+	TagOrderQty            = 38
+	TagOrdStatus           = 39
+	TagOrdType             = 40
+	TagOrigClOrdID         = 41
+	TagPossDupFlag         = 43
+	TagPrice               = 44
+	TagRefSeqNum           = 45
+	TagSenderCompID        = 49
+	TagSendingTime         = 52
+	TagSide                = 54
+	TagSymbol              = 55
+	TagTargetCompID        = 56
+	TagText                = 58
+	TagTimeInForce         = 59
+	TagTransactTime        = 60
+	TagEncryptMethod       = 98
+	TagCxlRejReason        = 102
+	TagHeartBtInt          = 108
+	TagTestReqID           = 112
+	TagOrigSendingTime     = 122
+	TagGapFillFlag         = 123
+	TagExpireTime          = 126
+	TagResetSeqNumFlag     = 141
+	TagExecType            = 150
+	TagLeavesQty           = 151
+	TagSessionRejectReason = 373
+	TagUsername            = 553
+	TagPassword            = 554
+	TagCxlRejResponseTo    = 434
+)
+
+// Message types.
+const (
+	MsgHeartbeat                 = "0"
+	MsgTestRequest               = "1"
+	MsgResendRequest             = "2"
+	MsgReject                    = "3"
+	MsgSequenceReset             = "4"
+	MsgLogout                    = "5"
+	MsgExecutionReport           = "8"
+	MsgOrderCancelReject         = "9"
+	MsgLogon                     = "A"
+	MsgNewOrderSingle            = "D"
+	MsgOrderCancelRequest        = "F"
+	MsgOrderCancelReplaceRequest = "G"
+)
+
+// headerTags are written right after MsgType, in this order.
+var headerTags = []int{TagSenderCompID, TagTargetCompID, TagMsgSeqNum, TagPossDupFlag, TagSendingTime, TagOrigSendingTime}
+
+var ErrMissingTag = errors.New("required tag missing")
+
+type Field struct {
+	Tag   int
+	Value string
+}
+
+// Message is a FIX message as an ordered list of fields. BeginString,
+// BodyLength and CheckSum are not stored; they are added by Bytes and
+// verified by Parse.
+type Message struct {
+	Fields []Field
+}
+
+func NewMessage(msgType string) *Message {
+	return &Message{Fields: []Field{{TagMsgType, msgType}}}
+}
+
+func (m *Message) Type() string {
+	v, _ := m.Get(TagMsgType)
+	return v
+}
+
+func (m *Message) Get(tag int) (string, bool) {
+	for _, f := range m.Fields {
+		if f.Tag == tag {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// Set replaces the value of tag, or appends the field if it is not present.
+func (m *Message) Set(tag int, value string) *Message {
+	for i := range m.Fields {
+		if m.Fields[i].Tag == tag {
+			m.Fields[i].Value = value
+			return m
+		}
+	}
+	m.Fields = append(m.Fields, Field{tag, value})
+	return m
+}
+
+func (m *Message) SetInt(tag int, v int) *Message {
+	return m.Set(tag, strconv.Itoa(v))
+}
+
+func (m *Message) SetFloat(tag int, v float64) *Message {
+	return m.Set(tag, strconv.FormatFloat(v, 'f', -1, 64))
+}
+
+func (m *Message) SetTime(tag int, t time.Time) *Message {
+	return m.Set(tag, t.UTC().Format(timeFormat))
+}
+
+func (m *Message) String(tag int) (string, error) {
+	v, ok := m.Get(tag)
+	if !ok || v == "" {
+		return "", fmt.Errorf("%w: %d", ErrMissingTag, tag)
+	}
+	return v, nil
+}
+
+func (m *Message) Int(tag int) (int, error) {
+	v, err := m.String(tag)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("tag %d: %w", tag, err)
+	}
+	return n, nil
+}
+
+func (m *Message) Float(tag int) (float64, error) {
+	v, err := m.String(tag)
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("tag %d: %w", tag, err)
+	}
+	return f, nil
+}
+
+func (m *Message) Time(tag int) (time.Time, error) {
+	v, err := m.String(tag)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, layout := range []string{timeFormat, "20060102-15:04:05"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("tag %d: invalid UTCTimestamp %q", tag, v)
+}
+
+// Flag reports whether a Boolean field is set to Y.
+func (m *Message) Flag(tag int) bool {
+	v, _ := m.Get(tag)
+	return v == "Y"
+}
+
+// Clone returns a copy that can be modified independently.
+func (m *Message) Clone() *Message {
+	return &Message{Fields: append([]Field(nil), m.Fields...)}
+}
+
+// Bytes encodes the message with MsgType and the standard header first.
+func (m *Message) Bytes() []byte {
+	var body bytes.Buffer
+	writeField := func(tag int, value string) {
+		body.WriteString(strconv.Itoa(tag))
+		body.WriteByte('=')
+		body.WriteString(value)
+		body.WriteByte(soh)
+	}
+	writeField(TagMsgType, m.Type())
+	for _, tag := range headerTags {
+		if v, ok := m.Get(tag); ok {
+			writeField(tag, v)
+		}
+	}
+	for _, f := range m.Fields {
+		if f.Tag == TagMsgType || isHeaderTag(f.Tag) {
+			continue
+		}
+		writeField(f.Tag, f.Value)
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "8=%s\x019=%d\x01", BeginString, body.Len())
+	out.Write(body.Bytes())
+	fmt.Fprintf(&out, "10=%03d\x01", checksum(out.Bytes()))
+	return out.Bytes()
+}
+
+func isHeaderTag(tag int) bool {
+	for _, t := range headerTags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func checksum(data []byte) int {
+	sum := 0
+	for _, b := range data {
+		sum += int(b)
+	}
+	return sum % 256
+}
+
+// Parse decodes a complete message, verifying BeginString, BodyLength and
+// CheckSum.
+func Parse(data []byte) (*Message, error) {
+	if len(data) == 0 || data[len(data)-1] != soh {
+		return nil, errors.New("message does not end with SOH")
+	}
+	var fields []Field
+	for _, raw := range bytes.Split(data[:len(data)-1], []byte{soh}) {
+		tag, value, ok := bytes.Cut(raw, []byte("="))
+		if !ok {
+			return nil, fmt.Errorf("malformed field %q", raw)
+		}
+		n, err := strconv.Atoi(string(tag))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("malformed tag %q", tag)
+		}
+		fields = append(fields, Field{n, string(value)})
+	}
+	if len(fields) < 4 || fields[0].Tag != TagBeginString || fields[1].Tag != TagBodyLength ||
+		fields[2].Tag != TagMsgType || fields[len(fields)-1].Tag != TagCheckSum {
+		return nil, errors.New("message must start with 8, 9, 35 and end with 10")
+	}
+	if fields[0].Value != BeginString {
+		return nil, fmt.Errorf("unsupported BeginString %q", fields[0].Value)
+	}
+
+	trailer := bytes.LastIndex(data[:len(data)-1], []byte{soh}) + 1
+	if want, err := strconv.Atoi(fields[len(fields)-1].Value); err != nil || want != checksum(data[:trailer]) {
+		return nil, fmt.Errorf("checksum mismatch, got %s want %03d", fields[len(fields)-1].Value, checksum(data[:trailer]))
+	}
+	bodyStart := len(fmt.Sprintf("8=%s\x019=%s\x01", fields[0].Value, fields[1].Value))
+	if n, err := strconv.Atoi(fields[1].Value); err != nil || n != trailer-bodyStart {
+		return nil, fmt.Errorf("body length mismatch, got %s want %d", fields[1].Value, trailer-bodyStart)
+	}
+	return &Message{Fields: fields[2 : len(fields)-1]}, nil
+}
+
+// ReadMessage reads the raw bytes of the next message from r using BodyLength
+// to find its end.
+func ReadMessage(r *bufio.Reader) ([]byte, error) {
+	begin, err := r.ReadBytes(soh)
+	if err != nil {
+		return nil, err
+	}
+	length, err := r.ReadBytes(soh)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(length, []byte("9=")) {
+		return nil, fmt.Errorf("expected BodyLength, got %q", length)
+	}
+	n, err := strconv.Atoi(string(length[2 : len(length)-1]))
+	if err != nil || n < 0 || n > maxBodyLength {
+		return nil, fmt.Errorf("invalid BodyLength %q", length)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	trailer, err := r.ReadBytes(soh)
+	if err != nil {
+		return nil, err
+	}
+	msg := make([]byte, 0, len(begin)+len(length)+n+len(trailer))
+	msg = append(msg, begin...)
+	msg = append(msg, length...)
+	msg = append(msg, body...)
+	return append(msg, trailer...), nil
+}
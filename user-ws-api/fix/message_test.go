@@ -0,0 +1,48 @@
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessage_RoundTrip(t *testing.T) {
+	msg := NewMessage(MsgNewOrderSingle).
+		Set(TagClOrdID, "c1").
+		Set(TagSymbol, "BTC").
+		SetInt(TagMsgSeqNum, 7).
+		Set(TagSenderCompID, "CLIENT")
+	raw := msg.Bytes()
+
+	// Header fields come right after MsgType whatever order they were set in.
+	assert.True(t, bytes.HasPrefix(raw, []byte("8=FIX.4.4\x019=")))
+	assert.Contains(t, string(raw), "\x0135=D\x0149=CLIENT\x0134=7\x0111=c1\x0155=BTC\x0110=")
+
+	parsed, err := Parse(raw)
+	require.NoError(t, err)
+	assert.Equal(t, MsgNewOrderSingle, parsed.Type())
+	seq, err := parsed.Int(TagMsgSeqNum)
+	require.NoError(t, err)
+	assert.Equal(t, 7, seq)
+
+	read, err := ReadMessage(bufio.NewReader(bytes.NewReader(append(raw, raw...))))
+	require.NoError(t, err)
+	assert.Equal(t, raw, read)
+}
+
+func TestParse_RejectsCorruptMessages(t *testing.T) {
+	raw := string(NewMessage(MsgHeartbeat).Bytes())
+
+	_, err := Parse([]byte(strings.Replace(raw, "35=0", "35=1", 1)))
+	assert.ErrorContains(t, err, "checksum")
+
+	_, err = Parse([]byte(strings.Replace(raw, "8=FIX.4.4", "8=FIX.4.2", 1)))
+	assert.ErrorContains(t, err, "BeginString")
+
+	_, err = Parse([]byte(strings.Replace(raw, "9=5", "9=6", 1)))
+	assert.Error(t, err)
+}
@@ -0,0 +1,338 @@
+// user-ws/fix/session.go
+package fix
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	logonTimeout = 10 * time.Second
+	writeTimeout = 10 * time.Second
+	// maxStoredMessages bounds how far back a ResendRequest can be served
+	// with the original messages; older ones are gap filled.
+	maxStoredMessages = 10000
+)
+
+const TagRefMsgType = 372
+
+// SessionRejectReason values.
+const (
+	rejectRequiredTagMissing = 1
+	rejectValueIncorrect     = 5
+	rejectCompIDProblem      = 9
+	rejectInvalidMsgType     = 11
+)
+
+// sessionState is the sequencing state of one counterparty. It outlives
+// connections, so a counterparty that reconnects can recover missed
+// execution reports with a ResendRequest.
+type sessionState struct {
+	compID   string // counterparty's SenderCompID
+	userID   string // user the counterparty trades as
+	password string // required on Logon
+
+	mu       sync.Mutex
+	outSeq   int // next outgoing MsgSeqNum
+	inSeq    int // next expected incoming MsgSeqNum
+	sent     map[int]*Message
+	conn     net.Conn // nil while logged out
+	lastSent time.Time
+}
+
+func newSessionState(compID, userID, password string) *sessionState {
+	return &sessionState{compID: compID, userID: userID, password: password, outSeq: 1, inSeq: 1, sent: make(map[int]*Message)}
+}
+
+func isAdmin(msgType string) bool {
+	switch msgType {
+	case MsgHeartbeat, MsgTestRequest, MsgResendRequest, MsgReject, MsgSequenceReset, MsgLogout, MsgLogon:
+		return true
+	}
+	return false
+}
+
+// send stamps msg with the next sequence number and writes it. Application
+// messages are stored for resend and consume a sequence number even while
+// the counterparty is logged out; it recovers them after the next logon.
+func (s *sessionState) send(senderCompID string, msg *Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil && isAdmin(msg.Type()) {
+		return
+	}
+	seq := s.outSeq
+	s.outSeq++
+	s.stamp(senderCompID, msg, seq)
+	if !isAdmin(msg.Type()) {
+		s.sent[seq] = msg
+		delete(s.sent, seq-maxStoredMessages)
+	}
+	s.writeLocked(msg)
+}
+
+func (s *sessionState) stamp(senderCompID string, msg *Message, seq int) {
+	msg.Set(TagSenderCompID, senderCompID).
+		Set(TagTargetCompID, s.compID).
+		SetInt(TagMsgSeqNum, seq).
+		SetTime(TagSendingTime, time.Now())
+}
+
+func (s *sessionState) writeLocked(msg *Message) {
+	if s.conn == nil {
+		return
+	}
+	_ = s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := s.conn.Write(msg.Bytes()); err != nil {
+		slog.Warn("FIX write failed, closing session", "CompID", s.compID, "Error", err)
+		_ = s.conn.Close()
+		return
+	}
+	s.lastSent = time.Now()
+}
+
+// resend answers a ResendRequest. Stored application messages are sent again
+// with PossDupFlag set; admin messages and messages no longer stored are
+// replaced by SequenceReset-GapFill.
+func (s *sessionState) resend(senderCompID string, begin, end int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if last := s.outSeq - 1; end == 0 || end > last {
+		end = last
+	}
+	gapStart := 0
+	flushGap := func(next int) {
+		if gapStart == 0 {
+			return
+		}
+		gap := NewMessage(MsgSequenceReset).Set(TagGapFillFlag, "Y").SetInt(TagNewSeqNo, next)
+		s.stamp(senderCompID, gap, gapStart)
+		gap.Set(TagPossDupFlag, "Y")
+		s.writeLocked(gap)
+		gapStart = 0
+	}
+	for seq := begin; seq <= end; seq++ {
+		orig, ok := s.sent[seq]
+		if !ok {
+			if gapStart == 0 {
+				gapStart = seq
+			}
+			continue
+		}
+		flushGap(seq)
+		dup := orig.Clone()
+		origTime, _ := orig.Get(TagSendingTime)
+		dup.Set(TagPossDupFlag, "Y").Set(TagOrigSendingTime, origTime).SetTime(TagSendingTime, time.Now())
+		s.writeLocked(dup)
+	}
+	flushGap(end + 1)
+}
+
+func (s *sessionState) expectedSeq() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inSeq
+}
+
+func (s *sessionState) setExpectedSeq(seq int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inSeq = seq
+}
+
+func (s *sessionState) lastSentAt() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastSent
+}
+
+// session is one logged on connection.
+type session struct {
+	acceptor  *Acceptor
+	state     *sessionState
+	conn      net.Conn
+	heartbeat time.Duration
+	// resendUntil is the highest sequence number seen while a gap was being
+	// recovered; no new ResendRequest is sent until it has been reached.
+	resendUntil int
+
+	mu          sync.Mutex
+	lastRecv    time.Time
+	testReqSent bool
+}
+
+func (s *session) send(msg *Message) {
+	s.state.send(s.acceptor.compID, msg)
+}
+
+func (s *session) touch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastRecv = time.Now()
+	s.testReqSent = false
+}
+
+func (s *session) readLoop(r *bufio.Reader) {
+	for {
+		raw, err := ReadMessage(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				slog.Warn("FIX read failed", "CompID", s.state.compID, "Error", err)
+			}
+			return
+		}
+		msg, err := Parse(raw)
+		if err != nil {
+			// Garbled messages are ignored; the gap is recovered by resend.
+			slog.Warn("Ignoring garbled FIX message", "CompID", s.state.compID, "Error", err)
+			continue
+		}
+		s.touch()
+		if !s.process(msg) {
+			return
+		}
+	}
+}
+
+// process applies sequence number checks and dispatches msg. It returns
+// false when the session must end.
+func (s *session) process(msg *Message) bool {
+	sender, _ := msg.Get(TagSenderCompID)
+	target, _ := msg.Get(TagTargetCompID)
+	if sender != s.state.compID || target != s.acceptor.compID {
+		s.reject(msg, rejectCompIDProblem, "CompID problem")
+		s.logout("Incorrect SenderCompID or TargetCompID")
+		return false
+	}
+	seq, err := msg.Int(TagMsgSeqNum)
+	if err != nil {
+		s.logout("MsgSeqNum missing")
+		return false
+	}
+	if msg.Type() == MsgSequenceReset && !msg.Flag(TagGapFillFlag) {
+		// Reset mode ignores MsgSeqNum.
+		if newSeq, err := msg.Int(TagNewSeqNo); err == nil {
+			s.state.setExpectedSeq(newSeq)
+		}
+		return true
+	}
+
+	expected := s.state.expectedSeq()
+	switch {
+	case seq > expected:
+		if seq > s.resendUntil {
+			if s.resendUntil < expected {
+				s.send(NewMessage(MsgResendRequest).SetInt(TagBeginSeqNo, expected).SetInt(TagEndSeqNo, 0))
+			}
+			s.resendUntil = seq
+		}
+		switch msg.Type() {
+		case MsgLogout:
+			s.send(NewMessage(MsgLogout))
+			return false
+		case MsgResendRequest:
+			s.handleResendRequest(msg)
+		}
+		return true
+	case seq < expected:
+		if msg.Flag(TagPossDupFlag) {
+			return true
+		}
+		s.logout(fmt.Sprintf("MsgSeqNum too low, expecting %d but received %d", expected, seq))
+		return false
+	}
+	s.state.setExpectedSeq(seq + 1)
+
+	switch msg.Type() {
+	case MsgHeartbeat, MsgReject:
+	case MsgTestRequest:
+		id, _ := msg.Get(TagTestReqID)
+		s.send(NewMessage(MsgHeartbeat).Set(TagTestReqID, id))
+	case MsgResendRequest:
+		s.handleResendRequest(msg)
+	case MsgSequenceReset:
+		if newSeq, err := msg.Int(TagNewSeqNo); err == nil && newSeq > seq {
+			s.state.setExpectedSeq(newSeq)
+		}
+	case MsgLogout:
+		s.send(NewMessage(MsgLogout))
+		return false
+	case MsgLogon:
+		s.reject(msg, rejectValueIncorrect, "Already logged on")
+	case MsgNewOrderSingle:
+		s.acceptor.onNewOrder(s, msg)
+	case MsgOrderCancelRequest:
+		s.acceptor.onCancel(s, msg, false)
+	case MsgOrderCancelReplaceRequest:
+		s.acceptor.onCancel(s, msg, true)
+	default:
+		s.reject(msg, rejectInvalidMsgType, "Unsupported MsgType")
+	}
+	return true
+}
+
+func (s *session) handleResendRequest(msg *Message) {
+	begin, err := msg.Int(TagBeginSeqNo)
+	if err != nil {
+		s.reject(msg, rejectRequiredTagMissing, err.Error())
+		return
+	}
+	end, _ := msg.Int(TagEndSeqNo)
+	s.state.resend(s.acceptor.compID, begin, end)
+}
+
+// reject sends a session level Reject for msg.
+func (s *session) reject(msg *Message, reason int, text string) {
+	ref, _ := msg.Get(TagMsgSeqNum)
+	s.send(NewMessage(MsgReject).
+		Set(TagRefSeqNum, ref).
+		Set(TagRefMsgType, msg.Type()).
+		SetInt(TagSessionRejectReason, reason).
+		Set(TagText, text))
+}
+
+func (s *session) logout(text string) {
+	slog.Warn("FIX logout", "CompID", s.state.compID, "Reason", text)
+	s.send(NewMessage(MsgLogout).Set(TagText, text))
+}
+
+// monitor sends heartbeats when the session is idle, a TestRequest when the
+// counterparty is silent for longer than its heartbeat interval, and drops
+// the connection when the TestRequest goes unanswered.
+func (s *session) monitor(done <-chan struct{}) {
+	ticker := time.NewTicker(s.heartbeat / 4)
+	defer ticker.Stop()
+	grace := s.heartbeat / 5
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if now.Sub(s.state.lastSentAt()) >= s.heartbeat {
+				s.send(NewMessage(MsgHeartbeat))
+			}
+			s.mu.Lock()
+			silent := now.Sub(s.lastRecv)
+			sendTestReq := silent >= s.heartbeat+grace && !s.testReqSent
+			if sendTestReq {
+				s.testReqSent = true
+			}
+			s.mu.Unlock()
+			switch {
+			case silent >= 2*s.heartbeat+grace:
+				slog.Warn("FIX heartbeat timeout", "CompID", s.state.compID)
+				_ = s.conn.Close()
+				return
+			case sendTestReq:
+				s.send(NewMessage(MsgTestRequest).Set(TagTestReqID, "TEST-"+strconv.FormatInt(now.UnixNano(), 10)))
+			}
+		}
+	}
+}
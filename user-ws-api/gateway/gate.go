@@ -0,0 +1,63 @@
+// Package gateway holds the checks every order goes through before it reaches
+// the matching engine, whether it is sent over WebSocket or FIX.
+package gateway
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user-ws-api/models"
+
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/events"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/userservice"
+)
+
+// Gate rejects the orders the engine must not see: invalid ones and those of
+// users that may not trade.
+type Gate struct {
+	statuses *userStatuses
+}
+
+// NewGate returns a gate that looks up the status of users in service and
+// trusts it for ttl. With a nil service only the orders are validated.
+func NewGate(service userservice.UserService, ttl time.Duration) *Gate {
+	return &Gate{statuses: newUserStatuses(service, ttl)}
+}
+
+// Check fails if userID may not submit order. Errors wrapping ErrCannotTrade
+// and ErrUserStatusUnavailable are about the user, others about the order.
+func (g *Gate) Check(ctx context.Context, userID string, order models.Order) error {
+	if err := Validate(order); err != nil {
+		return err
+	}
+	return g.statuses.checkTrading(ctx, userID, time.Now())
+}
+
+// Observe takes the status of a user from a user event, so that a suspension
+// applies before the cached status expires.
+func (g *Gate) Observe(event events.Event) {
+	g.statuses.observe(event, time.Now())
+}
+
+// Validate checks that the time in force and post-only mode of order are
+// consistent.
+func Validate(order models.Order) error {
+	switch order.TimeInForce {
+	case "", models.GTC:
+		if !order.ExpiresAt.IsZero() {
+			return errors.New("ExpiresAt requires TimeInForce GTD or GTT")
+		}
+	case models.GTD, models.GTT:
+		if order.ExpiresAt.IsZero() {
+			return errors.New("TimeInForce GTD and GTT require ExpiresAt")
+		}
+	default:
+		return errors.New("Unknown TimeInForce")
+	}
+	switch order.PostOnly {
+	case "", models.PostOnlyReject, models.PostOnlyReprice:
+	default:
+		return errors.New("Unknown PostOnly mode")
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+	"time"
+	"user-ws-api/models"
+
+	"github.com/google/uuid"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/userservice"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate(models.Order{}))
+	assert.NoError(t, Validate(models.Order{TimeInForce: models.GTT, ExpiresAt: time.Now()}))
+	assert.Error(t, Validate(models.Order{TimeInForce: models.GTD}))
+	assert.Error(t, Validate(models.Order{ExpiresAt: time.Now()}))
+	assert.Error(t, Validate(models.Order{PostOnly: "MAYBE"}))
+}
+
+func TestGate_Check(t *testing.T) {
+	active, suspended := uuid.New(), uuid.New()
+	gate := NewGate(&fakeUsers{users: map[uuid.UUID]userservice.User{
+		active:    {UserID: active, Status: userservice.StatusActive},
+		suspended: {UserID: suspended, Status: userservice.StatusSuspended},
+	}}, time.Minute)
+	ctx := context.Background()
+
+	assert.NoError(t, gate.Check(ctx, active.String(), models.Order{}))
+	assert.ErrorIs(t, gate.Check(ctx, suspended.String(), models.Order{}), ErrCannotTrade)
+	assert.ErrorIs(t, gate.Check(ctx, uuid.NewString(), models.Order{}), ErrCannotTrade)
+	err := gate.Check(ctx, active.String(), models.Order{PostOnly: "MAYBE"})
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrCannotTrade)
+
+	// Without a user service only orders are checked.
+	assert.NoError(t, NewGate(nil, time.Minute).Check(ctx, suspended.String(), models.Order{}))
+}
@@ -0,0 +1,122 @@
+// user-ws/gateway/user_status.go
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/events"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/userservice"
+)
+
+// DefaultUserStatusTTL is how long the status of a user is trusted by default.
+const DefaultUserStatusTTL = 30 * time.Second
+
+var (
+	// ErrUserStatusUnavailable is returned when the status of a user cannot
+	// be looked up, so its orders can be retried.
+	ErrUserStatusUnavailable = errors.New("user status is unavailable")
+	// ErrCannotTrade is returned for users that may not submit orders.
+	ErrCannotTrade = errors.New("cannot submit orders")
+)
+
+// userStatuses caches the statuses of users, so that orders do not wait for
+// the database. Entries are refreshed by user events and otherwise looked up
+// again after ttl, which bounds how long a suspended user can keep trading
+// when events are not received.
+type userStatuses struct {
+	service userservice.UserService
+	ttl     time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]statusEntry
+	lastSweep time.Time
+}
+
+type statusEntry struct {
+	status  string // empty for users that do not exist
+	expires time.Time
+}
+
+func newUserStatuses(service userservice.UserService, ttl time.Duration) *userStatuses {
+	return &userStatuses{service: service, ttl: ttl, entries: make(map[string]statusEntry)}
+}
+
+// checkTrading fails if userID may not submit orders, or if its status cannot
+// be looked up. User IDs that are not UUIDs do not name users of the user
+// service and cannot trade.
+func (s *userStatuses) checkTrading(ctx context.Context, userID string, now time.Time) error {
+	if s.service == nil {
+		return nil
+	}
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return fmt.Errorf("user ID is not a UUID and %w", ErrCannotTrade)
+	}
+	status, err := s.status(ctx, id, now)
+	if err != nil {
+		return err
+	}
+	if status == "" {
+		return fmt.Errorf("user does not exist and %w", ErrCannotTrade)
+	}
+	if !userservice.CanTrade(status) {
+		return fmt.Errorf("user is %s and %w", status, ErrCannotTrade)
+	}
+	return nil
+}
+
+func (s *userStatuses) status(ctx context.Context, id uuid.UUID, now time.Time) (string, error) {
+	s.mu.Lock()
+	e, ok := s.entries[id.String()]
+	s.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.status, nil
+	}
+	user, err := s.service.GetUser(ctx, id)
+	switch {
+	case errors.Is(err, userservice.ErrUserNotFound):
+	case err != nil:
+		return "", fmt.Errorf("%w: %v", ErrUserStatusUnavailable, err)
+	}
+	s.set(id.String(), user.Status, now)
+	return user.Status, nil
+}
+
+func (s *userStatuses) set(userID, status string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Expired entries are dropped at most once a minute.
+	if now.Sub(s.lastSweep) >= time.Minute {
+		s.lastSweep = now
+		for key, e := range s.entries {
+			if !now.Before(e.expires) {
+				delete(s.entries, key)
+			}
+		}
+	}
+	s.entries[userID] = statusEntry{status: status, expires: now.Add(s.ttl)}
+}
+
+// observe updates the status of the user a user event is about. Deleted users
+// cannot trade.
+func (s *userStatuses) observe(event events.Event, now time.Time) {
+	change, err := event.UserChange()
+	if err != nil {
+		return
+	}
+	var after struct {
+		Status string `json:"status"`
+	}
+	if len(change.After) > 0 {
+		if err := json.Unmarshal(change.After, &after); err != nil {
+			return
+		}
+	}
+	s.set(event.Subject, after.Status, now)
+}
@@ -0,0 +1,56 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/events"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/userservice"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeUsers serves GetUser from a map; other methods are not implemented.
+type fakeUsers struct {
+	userservice.UserService
+	users   map[uuid.UUID]userservice.User
+	lookups int
+}
+
+func (f *fakeUsers) GetUser(_ context.Context, id uuid.UUID) (userservice.User, error) {
+	f.lookups++
+	user, ok := f.users[id]
+	if !ok {
+		return userservice.User{}, userservice.ErrUserNotFound
+	}
+	return user, nil
+}
+
+func TestUserStatuses_CacheAndEvents(t *testing.T) {
+	id := uuid.New()
+	users := &fakeUsers{users: map[uuid.UUID]userservice.User{id: {UserID: id, Status: userservice.StatusActive}}}
+	statuses := newUserStatuses(users, time.Minute)
+	ctx, now := context.Background(), time.Now()
+
+	assert.NoError(t, statuses.checkTrading(ctx, id.String(), now))
+	assert.NoError(t, statuses.checkTrading(ctx, id.String(), now.Add(time.Second)))
+	assert.Equal(t, 1, users.lookups, "the status is cached")
+	assert.ErrorIs(t, statuses.checkTrading(ctx, "not-a-uuid", now), ErrCannotTrade, "IDs outside the user service cannot trade")
+
+	// A suspension takes effect on the event, before the entry expires.
+	suspend, err := events.NewUserEvent(ctx, events.TypeUserUpdated, events.SourceRESTAPI, id.String(),
+		map[string]string{"status": userservice.StatusActive}, map[string]string{"status": userservice.StatusSuspended})
+	require.NoError(t, err)
+	data, _ := json.Marshal(suspend)
+	event, err := events.Parse(data)
+	require.NoError(t, err)
+	statuses.observe(event, now)
+	assert.ErrorContains(t, statuses.checkTrading(ctx, id.String(), now), "suspended")
+
+	// Without events the status is looked up again once the entry expires.
+	assert.NoError(t, statuses.checkTrading(ctx, id.String(), now.Add(2*time.Minute)))
+	assert.Equal(t, 2, users.lookups)
+}
@@ -5,3 +5,8 @@ import "user-ws-api/models"
 type OrderSubmitter interface {
 	Submit(order models.Order)
 }
+
+type OrderCanceller interface {
+	Cancel(assetID, orderID, reason string) (models.Order, bool)
+	CancelAll(userID, reason string) []models.Order
+}
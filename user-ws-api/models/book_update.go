@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// BookUpdate summarises an asset's book after it changed. BidPrice and
+// AskPrice are the prices of the orders the matcher trades against next,
+// zero if that side is empty.
+type BookUpdate struct {
+	ID        string    `json:"id"` // unique, e.g. BTC-1700000000000000000-B3
+	AssetID   string    `json:"asset_id"`
+	BuyDepth  int       `json:"buy_depth"`
+	SellDepth int       `json:"sell_depth"`
+	BidPrice  float64   `json:"bid_price,omitempty"`
+	AskPrice  float64   `json:"ask_price,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
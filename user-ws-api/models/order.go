@@ -9,12 +9,44 @@ const (
 	Sell OrderSide = "SELL"
 )
 
+type TimeInForce string
+
+const (
+	GTC TimeInForce = "GTC" // good till canceled, the default
+	GTD TimeInForce = "GTD" // good till date
+	GTT TimeInForce = "GTT" // good till time
+)
+
+// PostOnlyMode decides what happens to a post-only order that would cross the book.
+type PostOnlyMode string
+
+const (
+	PostOnlyReject  PostOnlyMode = "REJECT"
+	PostOnlyReprice PostOnlyMode = "REPRICE" // rest one tick behind the best opposite price
+)
+
 type Order struct {
-	ID        string
-	UserID    string
-	AssetID   string
-	Quantity  float64
-	Price     float64
-	Side      OrderSide
-	CreatedAt time.Time
+	ID string
+	// ClientOrderID is chosen by the client to make submissions idempotent:
+	// an order resubmitted with the same ClientOrderID is not entered again.
+	ClientOrderID string
+	UserID        string
+	AssetID       string
+	Quantity      float64
+	Price         float64
+	Side          OrderSide
+	CreatedAt     time.Time
+	// TimeInForce GTD and GTT orders are canceled once ExpiresAt has passed.
+	TimeInForce TimeInForce
+	ExpiresAt   time.Time
+	PostOnly    PostOnlyMode
+	// ReduceOnly orders may only reduce the user's position in the asset, as
+	// filled since the engine started, net of the reduce-only orders already
+	// resting.
+	ReduceOnly bool
+}
+
+// Expires reports whether the order has an expiry time.
+func (o Order) Expires() bool {
+	return (o.TimeInForce == GTD || o.TimeInForce == GTT) && !o.ExpiresAt.IsZero()
 }
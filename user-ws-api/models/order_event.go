@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+type OrderStatus string
+
+const (
+	New      OrderStatus = "NEW"
+	Canceled OrderStatus = "CANCELED"
+	Rejected OrderStatus = "REJECTED"
+	Repriced OrderStatus = "REPRICED"
+)
+
+// OrderEvent reports a change in an order's state other than a fill, which is
+// reported as a Trade.
+type OrderEvent struct {
+	ID           string      `json:"id"` // unique, e.g. BTC-1700000000000000000-E7
+	OrderID      string      `json:"order_id"`
+	UserID       string      `json:"user_id"`
+	AssetID      string      `json:"asset_id"`
+	Status       OrderStatus `json:"status"`
+	Reason       string      `json:"reason,omitempty"`
+	RemainingQty float64     `json:"remaining_qty"`
+	Price        float64     `json:"price,omitempty"`
+	Timestamp    time.Time   `json:"timestamp"`
+}
@@ -3,6 +3,8 @@ package models
 import "time"
 
 type Trade struct {
+	ID          string `json:"id"` // unique, e.g. BTC-1700000000000000000-T42
+	AssetID     string `json:"asset_id"`
 	BuyOrderID  string
 	SellOrderID string
 	BuyerID     string `json:"buyer_id"`
@@ -0,0 +1,213 @@
+// user-ws/stream/publisher.go
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+	"user-ws-api/models"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	TradesStream = "TRADES"
+	OrdersStream = "ORDERS"
+	BookStream   = "BOOK"
+	// DropCopyStream keeps the drop copy of the WebSocket hub, on the subject
+	// given to EnableDropCopy.
+	DropCopyStream = "DROPCOPY"
+
+	publishAckTimeout = 5 * time.Second
+	minRetryBackoff   = 100 * time.Millisecond
+	maxRetryBackoff   = 5 * time.Second
+)
+
+var streams = []jetstream.StreamConfig{
+	{Name: TradesStream, Subjects: []string{"trades.>"}},
+	{Name: OrdersStream, Subjects: []string{"orders.>"}},
+	// Book updates supersede each other, so only the recent ones are kept.
+	{Name: BookStream, Subjects: []string{"book.>"}, MaxMsgsPerSubject: 100},
+}
+
+var tokenReplacer = strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_")
+
+// token makes an ID safe to use as a single subject token.
+func token(id string) string {
+	return tokenReplacer.Replace(id)
+}
+
+func TradeSubject(assetID string) string { return "trades." + token(assetID) }
+func OrderSubject(userID string) string  { return "orders." + token(userID) }
+func BookSubject(assetID string) string  { return "book." + token(assetID) }
+
+// Publisher writes engine output to durable JetStream subjects. Messages are
+// first appended to a spool on disk, so that the engine feed is read without
+// waiting for JetStream, and are then published one at a time, in order. A
+// failed publish is retried with backoff until it is acknowledged, holding
+// back the later messages, and messages still in the spool are published
+// after a restart. Every message carries the ID of the trade, event or update
+// as Nats-Msg-Id, so retries after a lost ack are deduplicated by the server
+// and consumers get each message at least once.
+type Publisher struct {
+	js    jetstream.JetStream
+	spool *spool
+	stop  context.CancelFunc
+	done  chan struct{}
+
+	dropCopySubject string // set by EnableDropCopy
+}
+
+// NewPublisher creates or updates the streams and returns a publisher that
+// spools messages in spoolDir. It publishes until it is closed.
+func NewPublisher(ctx context.Context, nc *nats.Conn, spoolDir string) (*Publisher, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, err
+	}
+	for _, cfg := range streams {
+		if _, err := js.CreateOrUpdateStream(ctx, cfg); err != nil {
+			return nil, fmt.Errorf("stream %s: %w", cfg.Name, err)
+		}
+	}
+	sp, err := openSpool(spoolDir)
+	if err != nil {
+		return nil, fmt.Errorf("spool: %w", err)
+	}
+	runCtx, stop := context.WithCancel(context.Background())
+	p := &Publisher{js: js, spool: sp, stop: stop, done: make(chan struct{})}
+	go p.run(runCtx)
+	return p, nil
+}
+
+// Close stops publishing. Messages still in the spool are published by the
+// next publisher using the same directory.
+func (p *Publisher) Close() {
+	p.stop()
+	<-p.done
+}
+
+func (p *Publisher) SetTradeChannel(tradeCh <-chan models.Trade) {
+	go func() {
+		for trade := range tradeCh {
+			p.publish(TradeSubject(trade.AssetID), trade.ID, trade)
+		}
+	}()
+}
+
+func (p *Publisher) SetEventChannel(eventCh <-chan models.OrderEvent) {
+	go func() {
+		for event := range eventCh {
+			p.publish(OrderSubject(event.UserID), event.ID, event)
+		}
+	}()
+}
+
+func (p *Publisher) SetBookChannel(bookCh <-chan models.BookUpdate) {
+	go func() {
+		for update := range bookCh {
+			p.publish(BookSubject(update.AssetID), update.ID, update)
+		}
+	}()
+}
+
+// EnableDropCopy creates or updates the drop-copy stream on subject, to
+// which PublishDropCopy then publishes. It must be called before the first
+// PublishDropCopy.
+func (p *Publisher) EnableDropCopy(ctx context.Context, subject string) error {
+	cfg := jetstream.StreamConfig{Name: DropCopyStream, Subjects: []string{subject}}
+	if _, err := p.js.CreateOrUpdateStream(ctx, cfg); err != nil {
+		return fmt.Errorf("stream %s: %w", cfg.Name, err)
+	}
+	p.dropCopySubject = subject
+	return nil
+}
+
+// PublishDropCopy spools an encoded drop-copy message with the given ID. It
+// only waits for the spool on disk, never for JetStream.
+func (p *Publisher) PublishDropCopy(id string, data []byte) {
+	if err := p.spool.append(record{Subject: p.dropCopySubject, ID: id, Data: data}); err != nil {
+		slog.Error("Cannot spool drop copy message", "Subject", p.dropCopySubject, "ID", id, "Error", err)
+	}
+}
+
+// Wait blocks until every message spooled so far has been acknowledged.
+func (p *Publisher) Wait(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for p.spool.pending() {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (p *Publisher) publish(subject, id string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("Cannot encode JetStream message", "Subject", subject, "ID", id, "Error", err)
+		return
+	}
+	if err := p.spool.append(record{Subject: subject, ID: id, Data: data}); err != nil {
+		slog.Error("Cannot spool JetStream message", "Subject", subject, "ID", id, "Error", err)
+	}
+}
+
+// run publishes the spooled messages in order until ctx is done.
+func (p *Publisher) run(ctx context.Context) {
+	defer close(p.done)
+	defer func() { _ = p.spool.close() }()
+	for {
+		rec, next, ok, err := p.spool.peek()
+		if err != nil {
+			slog.Error("Cannot read JetStream spool", "Error", err)
+		}
+		if !ok {
+			select {
+			case <-p.spool.notify:
+				continue
+			case <-time.After(time.Second):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		if !p.send(ctx, rec) {
+			return
+		}
+		if err := p.spool.ack(next); err != nil {
+			slog.Error("Cannot record published JetStream message", "ID", rec.ID, "Error", err)
+		}
+	}
+}
+
+// send publishes rec, retrying with backoff until it is acknowledged. It
+// returns false if ctx is done first.
+func (p *Publisher) send(ctx context.Context, rec record) bool {
+	msg := nats.NewMsg(rec.Subject)
+	msg.Data = rec.Data
+	msg.Header.Set(jetstream.MsgIDHeader, rec.ID)
+	backoff := minRetryBackoff
+	for attempt := 1; ; attempt++ {
+		pubCtx, cancel := context.WithTimeout(ctx, publishAckTimeout)
+		_, err := p.js.PublishMsg(pubCtx, msg)
+		cancel()
+		if err == nil {
+			return true
+		}
+		slog.Warn("JetStream publish failed, retrying", "Subject", rec.Subject, "ID", rec.ID, "Attempt", attempt, "Error", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return false
+		}
+		backoff = min(2*backoff, maxRetryBackoff)
+	}
+}
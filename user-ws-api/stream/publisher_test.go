@@ -0,0 +1,135 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+	"user-ws-api/models"
+
+	"github.com/nats-io/nats-server/v2/server"
+	nats "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runJetStream(t *testing.T) *nats.Conn {
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir()})
+	require.NoError(t, err)
+	go s.Start()
+	require.True(t, s.ReadyForConnections(5*time.Second))
+	t.Cleanup(s.Shutdown)
+
+	nc, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func TestPublisher_PublishesToDurableSubjects(t *testing.T) {
+	nc := runJetStream(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	p, err := NewPublisher(ctx, nc, t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(p.Close)
+
+	tradeCh := make(chan models.Trade, 2)
+	eventCh := make(chan models.OrderEvent, 1)
+	bookCh := make(chan models.BookUpdate, 1)
+	p.SetTradeChannel(tradeCh)
+	p.SetEventChannel(eventCh)
+	p.SetBookChannel(bookCh)
+
+	trade := models.Trade{ID: "BTC-T1", AssetID: "BTC", BuyerID: "u1", SellerID: "u2", Quantity: 1, Price: 100}
+	tradeCh <- trade
+	tradeCh <- trade // a retry of the same trade is deduplicated
+	eventCh <- models.OrderEvent{ID: "BTC-E1", OrderID: "o1", UserID: "user.1", AssetID: "BTC", Status: models.Canceled}
+	bookCh <- models.BookUpdate{ID: "BTC-B1", AssetID: "BTC", BuyDepth: 1}
+
+	js, err := jetstream.New(nc)
+	require.NoError(t, err)
+	lastMsg := func(streamName, subject string) *jetstream.RawStreamMsg {
+		var msg *jetstream.RawStreamMsg
+		require.Eventually(t, func() bool {
+			s, err := js.Stream(ctx, streamName)
+			require.NoError(t, err)
+			msg, err = s.GetLastMsgForSubject(ctx, subject)
+			return err == nil
+		}, 5*time.Second, 20*time.Millisecond, subject)
+		return msg
+	}
+
+	var got models.Trade
+	require.NoError(t, json.Unmarshal(lastMsg(TradesStream, "trades.BTC").Data, &got))
+	assert.Equal(t, "BTC-T1", got.ID)
+	assert.Equal(t, "BTC-E1", lastMsg(OrdersStream, "orders.user_1").Header.Get(jetstream.MsgIDHeader))
+	lastMsg(BookStream, "book.BTC")
+
+	require.NoError(t, p.Wait(ctx))
+	trades, err := js.Stream(ctx, TradesStream)
+	require.NoError(t, err)
+	info, err := trades.Info(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), info.State.Msgs)
+}
+
+func TestPublisher_RetriesInOrderUntilAcknowledged(t *testing.T) {
+	nc := runJetStream(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	p, err := NewPublisher(ctx, nc, t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(p.Close)
+
+	// Without the stream every publish fails.
+	js, err := jetstream.New(nc)
+	require.NoError(t, err)
+	require.NoError(t, js.DeleteStream(ctx, TradesStream))
+	tradeCh := make(chan models.Trade, 3)
+	p.SetTradeChannel(tradeCh)
+	for _, id := range []string{"BTC-T1", "BTC-T2", "BTC-T3"} {
+		tradeCh <- models.Trade{ID: id, AssetID: "BTC"}
+	}
+	time.Sleep(300 * time.Millisecond)
+
+	trades, err := js.CreateStream(ctx, streams[0])
+	require.NoError(t, err)
+	require.NoError(t, p.Wait(ctx))
+
+	var ids []string
+	for seq := uint64(1); seq <= 3; seq++ {
+		msg, err := trades.GetMsg(ctx, seq)
+		require.NoError(t, err)
+		ids = append(ids, msg.Header.Get(jetstream.MsgIDHeader))
+	}
+	assert.Equal(t, []string{"BTC-T1", "BTC-T2", "BTC-T3"}, ids)
+}
+
+func TestPublisher_PublishesDropCopy(t *testing.T) {
+	nc := runJetStream(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	p, err := NewPublisher(ctx, nc, t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(p.Close)
+	require.NoError(t, p.EnableDropCopy(ctx, "dropcopy.executions"))
+
+	p.PublishDropCopy("7-1", []byte(`{"seq":1}`))
+	p.PublishDropCopy("7-1", []byte(`{"seq":1}`)) // a retry is deduplicated
+	p.PublishDropCopy("7-2", []byte(`{"seq":2}`))
+	require.NoError(t, p.Wait(ctx))
+
+	js, err := jetstream.New(nc)
+	require.NoError(t, err)
+	s, err := js.Stream(ctx, DropCopyStream)
+	require.NoError(t, err)
+	info, err := s.Info(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), info.State.Msgs)
+	msg, err := s.GetLastMsgForSubject(ctx, "dropcopy.executions")
+	require.NoError(t, err)
+	assert.Equal(t, "7-2", msg.Header.Get(jetstream.MsgIDHeader))
+	assert.JSONEq(t, `{"seq":2}`, string(msg.Data))
+}
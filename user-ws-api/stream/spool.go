@@ -0,0 +1,178 @@
+// user-ws/stream/spool.go
+package stream
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// record is a message waiting in the spool to be published.
+type record struct {
+	Subject string `json:"subject"`
+	ID      string `json:"id"`
+	Data    []byte `json:"data"`
+}
+
+// spool is a durable queue of records on disk. Records are appended to a log
+// file and read back in order; the position of the first unpublished record
+// is kept in an offset file, so records survive a restart until they are
+// acknowledged. Both files are emptied whenever every record is published.
+// Writes are not synced, so the spool survives crashes of the process but not
+// of the machine.
+type spool struct {
+	mu         sync.Mutex
+	log        *os.File
+	offsetPath string
+	size       int64 // bytes in the log
+	offset     int64 // bytes of the log already published
+	notify     chan struct{}
+}
+
+const recordHeaderLen = 4
+
+func openSpool(dir string) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	log, err := os.OpenFile(filepath.Join(dir, "spool.log"), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &spool{log: log, offsetPath: filepath.Join(dir, "spool.offset"), notify: make(chan struct{}, 1)}
+	if err := s.recover(); err != nil {
+		_ = log.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// recover reads the offset and drops a record left incomplete by a crash.
+func (s *spool) recover() error {
+	info, err := s.log.Stat()
+	if err != nil {
+		return err
+	}
+	s.size = info.Size()
+	b, err := os.ReadFile(s.offsetPath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if s.offset, err = strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64); err != nil {
+			return fmt.Errorf("spool offset: %w", err)
+		}
+	}
+	if s.offset > s.size {
+		return fmt.Errorf("spool offset %d is past the end of the log", s.offset)
+	}
+	end := s.offset
+	for end < s.size {
+		_, next, err := s.readAt(end)
+		if err != nil {
+			break
+		}
+		end = next
+	}
+	if end < s.size {
+		if err := s.log.Truncate(end); err != nil {
+			return err
+		}
+		s.size = end
+	}
+	return nil
+}
+
+// append adds rec to the end of the spool.
+func (s *spool) append(rec record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, recordHeaderLen+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[recordHeaderLen:], data)
+
+	s.mu.Lock()
+	n, err := s.log.Write(buf)
+	s.size += int64(n)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// peek returns the first unpublished record and the offset after it, or
+// false if every record is published.
+func (s *spool) peek() (record, int64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.offset == s.size {
+		return record{}, 0, false, s.reset()
+	}
+	rec, next, err := s.readAt(s.offset)
+	return rec, next, err == nil, err
+}
+
+// ack marks the records before offset as published.
+func (s *spool) ack(offset int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset = offset
+	return os.WriteFile(s.offsetPath, []byte(strconv.FormatInt(offset, 10)), 0o644)
+}
+
+// pending tells whether some records are not published yet.
+func (s *spool) pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.offset < s.size
+}
+
+// reset empties the files once every record is published. s.mu must be held.
+func (s *spool) reset() error {
+	if s.size == 0 {
+		return nil
+	}
+	if err := s.log.Truncate(0); err != nil {
+		return err
+	}
+	s.size, s.offset = 0, 0
+	return os.WriteFile(s.offsetPath, []byte("0"), 0o644)
+}
+
+func (s *spool) readAt(offset int64) (record, int64, error) {
+	var header [recordHeaderLen]byte
+	if _, err := s.log.ReadAt(header[:], offset); err != nil {
+		return record{}, 0, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(header[:]))
+	if _, err := s.log.ReadAt(data, offset+recordHeaderLen); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return record{}, 0, err
+	}
+	var rec record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return record{}, 0, err
+	}
+	return rec, offset + recordHeaderLen + int64(len(data)), nil
+}
+
+func (s *spool) close() error {
+	return s.log.Close()
+}
@@ -0,0 +1,53 @@
+package stream
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpool_KeepsUnpublishedRecordsAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openSpool(dir)
+	require.NoError(t, err)
+	for _, id := range []string{"T1", "T2", "T3"} {
+		require.NoError(t, s.append(record{Subject: "trades.BTC", ID: id, Data: []byte(`{}`)}))
+	}
+	rec, next, ok, err := s.peek()
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "T1", rec.ID)
+	require.NoError(t, s.ack(next))
+	require.NoError(t, s.close())
+
+	// A crash in the middle of an append leaves a partial record.
+	f, err := os.OpenFile(filepath.Join(dir, "spool.log"), os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 1})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	s, err = openSpool(dir)
+	require.NoError(t, err)
+	defer func() { _ = s.close() }()
+	var ids []string
+	for {
+		rec, next, ok, err := s.peek()
+		require.NoError(t, err)
+		if !ok {
+			break
+		}
+		ids = append(ids, rec.ID)
+		require.NoError(t, s.ack(next))
+	}
+	assert.Equal(t, []string{"T2", "T3"}, ids)
+	assert.False(t, s.pending())
+
+	// The files are emptied once everything is published.
+	info, err := os.Stat(filepath.Join(dir, "spool.log"))
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+}
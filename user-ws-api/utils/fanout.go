@@ -0,0 +1,33 @@
+package utils
+
+// FanOut copies every value received on in to n output channels with the
+// given buffer size, so several consumers can follow one producer. It never
+// waits for a reader: a value that does not fit in the buffer of a reader
+// that fell behind is dropped for that reader alone, and reported to dropped
+// with the index of the output, if dropped is not nil. The producer is thus
+// only held back by FanOut itself. The outputs are closed when in is closed.
+func FanOut[T any](in <-chan T, n, buffer int, dropped func(i int, v T)) []<-chan T {
+	outs := make([]chan T, n)
+	result := make([]<-chan T, n)
+	for i := range outs {
+		outs[i] = make(chan T, buffer)
+		result[i] = outs[i]
+	}
+	go func() {
+		for v := range in {
+			for i, out := range outs {
+				select {
+				case out <- v:
+				default:
+					if dropped != nil {
+						dropped(i, v)
+					}
+				}
+			}
+		}
+		for _, out := range outs {
+			close(out)
+		}
+	}()
+	return result
+}
@@ -44,6 +44,31 @@ func (q *OrderHeapQueue) Len() int {
 	return len(q.h.orders)
 }
 
+// Each calls fn for every queued order, in no particular order.
+func (q *OrderHeapQueue) Each(fn func(models.Order)) {
+	for _, o := range q.h.orders {
+		fn(o)
+	}
+}
+
+// RemoveIf removes every order matching pred and returns them.
+func (q *OrderHeapQueue) RemoveIf(pred func(models.Order) bool) []models.Order {
+	var removed []models.Order
+	kept := q.h.orders[:0]
+	for _, o := range q.h.orders {
+		if pred(o) {
+			removed = append(removed, o)
+		} else {
+			kept = append(kept, o)
+		}
+	}
+	if len(removed) > 0 {
+		q.h.orders = kept
+		heap.Init(q.h)
+	}
+	return removed
+}
+
 // orderHeap (heap.Interface)
 
 func (h *orderHeap) Len() int { return len(h.orders) }
@@ -0,0 +1,47 @@
+// user-ws/ws/auth.go
+package ws
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+)
+
+var (
+	errMissingUserID = errors.New("missing user_id")
+	errInvalidToken  = errors.New("invalid token")
+)
+
+// SignUserID returns the token that authenticates userID to a hub with the
+// given secret: the hex encoded HMAC-SHA256 of the user ID. Tokens are issued
+// by whoever holds the secret, e.g. a login service.
+func SignUserID(secret []byte, userID string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(userID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// authenticate returns the user a connection request is for, from the
+// user_id query parameter. With an auth secret set the request must carry
+// the token of that user, and the user is authenticated; without one anyone
+// can claim any user ID, so nobody is authenticated.
+func (h *Hub) authenticate(r *http.Request) (userID string, authenticated bool, err error) {
+	userID = r.URL.Query().Get("user_id")
+	if userID == "" {
+		return "", false, errMissingUserID
+	}
+	if len(h.authSecret) == 0 {
+		return userID, false, nil
+	}
+	token, err := hex.DecodeString(r.URL.Query().Get("token"))
+	if err != nil {
+		return "", false, errInvalidToken
+	}
+	expected, _ := hex.DecodeString(SignUserID(h.authSecret, userID))
+	if !hmac.Equal(token, expected) {
+		return "", false, errInvalidToken
+	}
+	return userID, true, nil
+}
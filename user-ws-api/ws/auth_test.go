@@ -0,0 +1,71 @@
+package ws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_Authenticate(t *testing.T) {
+	hub := NewHub(nil, nil)
+	request := func(query string) *http.Request {
+		return httptest.NewRequest(http.MethodGet, "/ws?"+query, nil)
+	}
+
+	userID, authenticated, err := hub.authenticate(request("user_id=admin"))
+	require.NoError(t, err)
+	assert.Equal(t, "admin", userID)
+	assert.False(t, authenticated, "without a secret user IDs are not proven")
+
+	hub.SetAuthSecret("s3cret")
+	userID, authenticated, err = hub.authenticate(request("user_id=admin&token=" + SignUserID([]byte("s3cret"), "admin")))
+	require.NoError(t, err)
+	assert.Equal(t, "admin", userID)
+	assert.True(t, authenticated)
+
+	for _, query := range []string{
+		"user_id=admin",
+		"user_id=admin&token=nothex",
+		"user_id=admin&token=" + SignUserID([]byte("s3cret"), "u1"),
+		"user_id=admin&token=" + SignUserID([]byte("other"), "admin"),
+	} {
+		_, _, err := hub.authenticate(request(query))
+		assert.ErrorIs(t, err, errInvalidToken, query)
+	}
+	_, _, err = hub.authenticate(request(""))
+	assert.ErrorIs(t, err, errMissingUserID)
+}
+
+func TestServeWs_RefusesInvalidTokensBeforeUpgrade(t *testing.T) {
+	hub := NewHub(nil, nil)
+	hub.SetAuthSecret("s3cret")
+	go hub.Run()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ServeWs(hub, w, r)
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?user_id=admin"
+
+	_, resp, err := websocket.DefaultDialer.Dial(url+"&token=bad", nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"&token="+SignUserID([]byte("s3cret"), "admin"), nil)
+	require.NoError(t, err)
+	_ = conn.Close()
+}
+
+func TestHub_AdminRightsRequireAuthentication(t *testing.T) {
+	hub := NewHub(nil, nil)
+	hub.SetAdmins([]string{"admin"})
+
+	claimed := &Client{hub: hub, userID: "admin"}
+	proven := &Client{hub: hub, userID: "admin", authenticated: true}
+	assert.False(t, hub.canSubscribe(claimed, DropCopyTopic))
+	assert.True(t, hub.canSubscribe(proven, DropCopyTopic))
+}
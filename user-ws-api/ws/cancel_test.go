@@ -0,0 +1,129 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+	"user-ws-api/engine"
+	"user-ws-api/matcher"
+	"user-ws-api/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTradingHub returns a running hub backed by a real order router with
+// resting buy orders for u1 on two assets and one for u2.
+func newTradingHub(t *testing.T) (*Hub, *engine.OrderRouter) {
+	tradeCh := make(chan models.Trade, 10)
+	eventCh := make(chan models.OrderEvent, 10)
+	router := engine.NewOrderRouter(&matcher.SimpleMatcher{}, tradeCh)
+	router.SetEventChannel(eventCh)
+
+	hub := NewHub(nil, router)
+	hub.SetTradeChannel(tradeCh)
+	hub.SetEventChannel(eventCh)
+	go hub.Run()
+
+	router.Submit(models.Order{ID: "o1", UserID: "u1", AssetID: "BTC", Quantity: 1, Price: 100, Side: models.Buy})
+	router.Submit(models.Order{ID: "o2", UserID: "u1", AssetID: "ETH", Quantity: 2, Price: 10, Side: models.Buy})
+	router.Submit(models.Order{ID: "o3", UserID: "u2", AssetID: "BTC", Quantity: 1, Price: 99, Side: models.Buy})
+	require.Eventually(t, func() bool {
+		btc, eth := router.GetAsset("BTC"), router.GetAsset("ETH")
+		return btc != nil && eth != nil && btc.GetBookDepth().BuyDepth == 2 && eth.GetBookDepth().BuyDepth == 1
+	}, time.Second, 5*time.Millisecond)
+	return hub, router
+}
+
+func TestHub_KillSwitchCancelsOwnOrdersOnEveryAsset(t *testing.T) {
+	hub, router := newTradingHub(t)
+	c := newTestClient(hub, "u1")
+
+	(&CancelAllOrdersHandler{}).HandleMessage(c, nil, WSMessage{Type: "cancel_all", Entity: "orders"})
+
+	assert.Equal(t, 1, router.GetAsset("BTC").GetBookDepth().BuyDepth)
+	assert.Equal(t, 0, router.GetAsset("ETH").GetBookDepth().BuyDepth)
+
+	var canceled []string
+	require.Eventually(t, func() bool {
+		hub.sync()
+		for _, msg := range decodeAll(t, c.queue) {
+			if msg.Type != "order_event" {
+				continue
+			}
+			var event models.OrderEvent
+			require.NoError(t, json.Unmarshal(msg.Payload, &event))
+			if event.Status != models.Canceled {
+				continue
+			}
+			assert.Equal(t, "kill_switch", event.Reason)
+			canceled = append(canceled, event.OrderID)
+		}
+		return len(canceled) == 2
+	}, time.Second, 5*time.Millisecond)
+	assert.ElementsMatch(t, []string{"o1", "o2"}, canceled)
+}
+
+func TestHub_KillSwitchForOtherUserRequiresAdmin(t *testing.T) {
+	hub, router := newTradingHub(t)
+	hub.SetAdmins([]string{"admin"})
+	c := newTestClient(hub, "u2")
+	admin := newTestClient(hub, "admin")
+	msg := WSMessage{Type: "cancel_all", Entity: "orders", Payload: []byte(`{"user_id":"u1"}`)}
+
+	(&CancelAllOrdersHandler{}).HandleMessage(c, nil, msg)
+	assert.Contains(t, string(c.queue.drain()[0]), "Not allowed")
+	assert.Equal(t, 2, router.GetAsset("BTC").GetBookDepth().BuyDepth)
+
+	(&CancelAllOrdersHandler{}).HandleMessage(admin, nil, msg)
+	assert.Contains(t, string(admin.queue.drain()[0]), `"canceled":2`)
+	assert.Equal(t, 1, router.GetAsset("BTC").GetBookDepth().BuyDepth)
+}
+
+func TestHub_CancelOnDisconnectAfterGracePeriod(t *testing.T) {
+	hub, router := newTradingHub(t)
+	hub.SetCancelOnDisconnectGrace(20 * time.Millisecond)
+
+	c := newTestClient(hub, "u1")
+	c.cancelOnDisconnect.Store(true)
+	hub.unregister <- c
+
+	assert.Eventually(t, func() bool {
+		return router.GetAsset("ETH").GetBookDepth().BuyDepth == 0
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 1, router.GetAsset("BTC").GetBookDepth().BuyDepth)
+}
+
+func TestHub_ReconnectWithinGraceKeepsOrders(t *testing.T) {
+	hub, router := newTradingHub(t)
+	hub.SetCancelOnDisconnectGrace(50 * time.Millisecond)
+
+	c := newTestClient(hub, "u1")
+	c.cancelOnDisconnect.Store(true)
+	hub.unregister <- c
+	newTestClient(hub, "u1")
+
+	time.Sleep(100 * time.Millisecond)
+	hub.sync()
+	assert.Equal(t, 1, router.GetAsset("ETH").GetBookDepth().BuyDepth)
+	assert.Equal(t, 2, router.GetAsset("BTC").GetBookDepth().BuyDepth)
+}
+
+func TestHub_CancelOnDisconnectWaitsForLastConnection(t *testing.T) {
+	hub, router := newTradingHub(t)
+	hub.SetCancelOnDisconnectGrace(20 * time.Millisecond)
+
+	flagged := newTestClient(hub, "u1")
+	flagged.cancelOnDisconnect.Store(true)
+	other := newTestClient(hub, "u1")
+	hub.unregister <- flagged
+
+	time.Sleep(60 * time.Millisecond)
+	hub.sync()
+	assert.Equal(t, 1, router.GetAsset("ETH").GetBookDepth().BuyDepth, "u1 is still connected")
+
+	hub.unregister <- other
+	assert.Eventually(t, func() bool {
+		return router.GetAsset("ETH").GetBookDepth().BuyDepth == 0
+	}, time.Second, 5*time.Millisecond)
+}
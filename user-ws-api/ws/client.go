@@ -3,56 +3,86 @@ package ws
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"github.com/gorilla/websocket"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/events"
 	"log/slog"
 	"net/http"
+	"sync/atomic"
 	"time"
 	"user-ws-api/common"
 )
 
 type WSMessage struct {
-	Type    string          `json:"type"`
-	Entity  string          `json:"entity"`
-	Payload json.RawMessage `json:"payload"`
+	Type    string            `json:"type"`
+	Entity  string            `json:"entity"`
+	Payload common.RawPayload `json:"payload"` // encoded with the connection's codec
+	// Topic and Seq are set on messages published to a topic. Sequence numbers
+	// are per topic and increase by one per message, so a gap means loss.
+	Topic string `json:"topic,omitempty"`
+	Seq   uint64 `json:"seq,omitempty"`
+	// Epoch is set on drop-copy messages. Sequence numbers restart with the
+	// epoch, which changes with every restart of the server.
+	Epoch int64 `json:"epoch,omitempty"`
 }
 
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+	CheckOrigin:  func(r *http.Request) bool { return true },
+	Subprotocols: common.Subprotocols,
 }
 
 type Client struct {
-	hub                *Hub
-	conn               *websocket.Conn
-	send               chan []byte
-	subscribedEntities map[string]bool
-	userID             string
+	hub     *Hub
+	conn    *websocket.Conn
+	queue   *sendQueue
+	codec   common.Codec // negotiated via Sec-WebSocket-Protocol, JSON by default
+	limiter *connLimiter
+	userID  string
+	// authenticated is set if the connection proved it is userID with a
+	// token. Admin rights are only granted to authenticated connections.
+	authenticated bool
+	// cancelOnDisconnect makes the hub cancel the user's resting orders when
+	// this connection drops and the user does not reconnect in time.
+	cancelOnDisconnect atomic.Bool
 }
 
 type BroadcastMessage struct {
-	Entity  string
+	Entity  string // topic the message is published on
+	Type    string
 	Message []byte
+	// Key marks the message as conflatable with earlier messages of the same
+	// key. Only snapshots that make earlier ones obsolete, like book updates,
+	// may have one.
+	Key string
 }
 
 func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	userID, authenticated, err := hub.authenticate(r)
+	switch {
+	case errors.Is(err, errMissingUserID):
+		slog.Error("Missing user_id in web socket connection")
+		http.Error(w, "Missing user_id", http.StatusBadRequest)
+		return
+	case err != nil:
+		slog.Warn("Refusing web socket connection", "UserID", r.URL.Query().Get("user_id"), "Error", err)
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		slog.Error("WebSocket upgrade error:", "Error", err)
 		return
 	}
-	userID := r.URL.Query().Get("user_id")
-	if userID == "" {
-		slog.Error("Missing user_id in web socket connection")
-		http.Error(w, "Missing user_id", http.StatusBadRequest)
-		return
-	}
 	client := &Client{
-		hub:                hub,
-		conn:               conn,
-		send:               make(chan []byte, 256),
-		subscribedEntities: make(map[string]bool),
-		userID:             userID,
+		hub:           hub,
+		conn:          conn,
+		queue:         newSendQueue(hub.sendQueueSize, hub.slowConsumerPolicy),
+		codec:         common.CodecFor(conn.Subprotocol()),
+		limiter:       newConnLimiter(hub.rateLimits),
+		userID:        userID,
+		authenticated: authenticated,
 	}
+	client.cancelOnDisconnect.Store(r.URL.Query().Get("cancel_on_disconnect") == "true")
 	hub.register <- client
 	go client.writePump()
 	go client.readPump()
@@ -87,18 +117,16 @@ func (c *Client) readPump() {
 		slog.Info("Received message from client: ", "message", message)
 
 		var msg WSMessage
-		if err := json.Unmarshal(message, &msg); err != nil {
-			slog.Error("Invalid JSON:", "Error", err)
+		if err := c.codec.Unmarshal(message, &msg); err != nil {
+			slog.Error("Invalid message:", "Codec", c.codec.Name(), "Error", err)
 			continue
 		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-
-		if !(msg.Entity == "users" || msg.Entity == "orders") {
-			handleErrorMessage(cancel, "Unsupported entity:", msg, c)
+		if !c.checkRate(msg) {
 			continue
 		}
 
+		ctx, cancel := context.WithTimeout(events.WithActor(context.Background(), c.userID), 10*time.Second)
+
 		// Dispatch based on entity and type using the Hub's handler registry
 		entityHandlers, ok := c.hub.handlers[msg.Entity]
 		if !ok {
@@ -117,25 +145,67 @@ func (c *Client) readPump() {
 
 func handleErrorMessage(cancel context.CancelFunc, errorMessage string, msg WSMessage, c *Client) {
 	slog.Error(errorMessage, "Entity", msg.Entity)
-	c.send <- common.MakeWSResponse("error", msg.Entity, msg.Type, map[string]string{"error": "Unsupported entity"})
+	c.respond("error", msg.Entity, msg.Type, map[string]string{"error": "Unsupported entity"})
 	cancel()
 }
 
+// respond sends a status envelope encoded with the client's codec.
+func (c *Client) respond(status, entity, msgType string, payload any) {
+	c.enqueue(common.EncodeWSResponse(c.codec, status, entity, msgType, payload))
+}
+
+// reply sends payload as is, encoded with the client's codec.
+func (c *Client) reply(payload any) {
+	data, err := c.codec.Marshal(payload)
+	if err != nil {
+		slog.Error("Cannot encode reply", "Codec", c.codec.Name(), "Error", err)
+		return
+	}
+	c.enqueue(data)
+}
+
+// enqueue hands a message to the write pump without blocking the caller.
+func (c *Client) enqueue(data []byte) {
+	c.enqueueKeyed(data, "")
+}
+
+func (c *Client) enqueueKeyed(data []byte, key string) {
+	switch c.queue.push(outboundMessage{data: data, key: key}) {
+	case pushConflated:
+		c.hub.metrics.Conflated.Add(1)
+	case pushDropped:
+		c.hub.metrics.Dropped.Add(1)
+	case pushOverflow:
+		c.hub.metrics.Dropped.Add(1)
+		c.hub.metrics.SlowDisconnects.Add(1)
+		slog.Warn("Disconnecting slow consumer", "UserID", c.userID, "Dropped", c.queue.droppedCount())
+		c.queue.disconnect(websocket.ClosePolicyViolation, "slow consumer")
+	}
+}
+
 func (c *Client) writePump() {
 	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
+	defer func() {
+		ticker.Stop()
+		_ = c.conn.Close()
+	}()
 
 	for {
 		select {
-		case message, ok := <-c.send:
-			if !ok {
-				return
-			}
-			if err := c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second)); err != nil {
-				return
+		case <-c.queue.done:
+			if code, reason := c.queue.closeStatus(); code != 0 {
+				_ = c.conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
 			}
-			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
-				return
+			return
+		case <-c.queue.notify:
+			for _, message := range c.queue.drain() {
+				if err := c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second)); err != nil {
+					return
+				}
+				if err := c.conn.WriteMessage(c.codec.MessageType(), message); err != nil {
+					return
+				}
 			}
 		case <-ticker.C:
 			if err := c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second)); err != nil {
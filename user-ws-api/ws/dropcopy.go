@@ -0,0 +1,14 @@
+// user-ws/ws/dropcopy.go
+package ws
+
+// DropCopyTopic carries every trade and order event across all assets and
+// users for back-office reconciliation. Only admins may subscribe.
+const DropCopyTopic = "dropcopy"
+
+// DropCopyPublisher durably publishes the drop copy outside the hub, like the
+// JetStream publisher does. PublishDropCopy is called by Run for every
+// message, with an ID unique across runs, so it must not wait for the
+// network; it must not drop messages either.
+type DropCopyPublisher interface {
+	PublishDropCopy(id string, data []byte)
+}
@@ -0,0 +1,66 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+	"user-ws-api/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dropCopyRecorder records the drop-copy messages it is given.
+type dropCopyRecorder struct {
+	mu   sync.Mutex
+	ids  []string
+	data [][]byte
+}
+
+func (r *dropCopyRecorder) PublishDropCopy(id string, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ids = append(r.ids, id)
+	r.data = append(r.data, data)
+}
+
+func TestHub_DropCopyCarriesAllExecutions(t *testing.T) {
+	dropCopy := &dropCopyRecorder{}
+	hub := NewHub(nil, nil)
+	hub.SetAdmins([]string{"backoffice"})
+	hub.SetDropCopyPublisher(dropCopy)
+	go hub.Run()
+
+	backOffice := newTestClient(hub, "backoffice")
+	trader := newTestClient(hub, "u3")
+	assert.True(t, hub.canSubscribe(backOffice, DropCopyTopic))
+	assert.False(t, hub.canSubscribe(trader, DropCopyTopic))
+	hub.subscribe(backOffice, DropCopyTopic, true)
+
+	hub.sendTrade <- tradeFor("u1", "u2")
+	hub.sendEvent <- models.OrderEvent{OrderID: "o1", UserID: "u1", Status: models.Canceled, Timestamp: time.Now()}
+	hub.sync()
+
+	msgs := decodeAll(t, backOffice.queue)
+	require.Len(t, msgs, 2)
+	assert.Equal(t, "trade", msgs[0].Type)
+	assert.Equal(t, "order_event", msgs[1].Type)
+	dropCopy.mu.Lock()
+	defer dropCopy.mu.Unlock()
+	require.Len(t, dropCopy.data, 2, "every message is published")
+	for i, msg := range msgs {
+		assert.Equal(t, DropCopyTopic, msg.Topic)
+		assert.Equal(t, uint64(i+1), msg.Seq)
+
+		var published WSMessage
+		require.NoError(t, json.Unmarshal(dropCopy.data[i], &published))
+		assert.Equal(t, msg.Seq, published.Seq)
+		assert.Equal(t, msg.Type, published.Type)
+		assert.Equal(t, hub.epoch, msg.Epoch)
+		assert.Equal(t, hub.epoch, published.Epoch)
+		assert.Equal(t, fmt.Sprintf("%d-%d", hub.epoch, msg.Seq), dropCopy.ids[i])
+	}
+	assert.Empty(t, queued(trader.queue))
+}
@@ -4,7 +4,15 @@ package ws
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/userservice"
+	"log/slog"
+	"runtime"
+	"strings"
+	"time"
+	"user-ws-api/common"
+	"user-ws-api/config"
+	"user-ws-api/gateway"
 	"user-ws-api/interfaces"
 	"user-ws-api/models"
 )
@@ -15,32 +23,174 @@ type MessageHandler interface {
 }
 
 type Hub struct {
-	clients     map[*Client]bool
 	broadcast   chan BroadcastMessage
 	register    chan *Client
 	unregister  chan *Client
 	userService userservice.UserService
 	router      interfaces.OrderSubmitter
+	// subscriptions go through Run like registrations, so that a shard never
+	// sees a subscription before the registration of its client.
+	subscriptions chan shardOp
 	// handlers registry: entity -> type -> handler
 	handlers  map[string]map[string]MessageHandler
 	sendTrade chan models.Trade
+	// shards partition clients by user ID; each shard fans out to its own
+	// subscribers, so a broadcast costs one serialisation plus one send per shard.
+	shards []*shard
+	// replay holds per-topic sequence numbers and recent messages. It is only
+	// touched by Run, which makes Run the single sequencer for every topic.
+	// The buffers of idle user topics are evicted, see evictReplay.
+	replay           map[string]*replayBuffer
+	replayBufferSize int
+	resume           chan resumeRequest
+	syncReq          chan chan struct{}
+
+	sendQueueSize      int
+	slowConsumerPolicy SlowConsumerPolicy
+	metrics            Metrics
+
+	rateLimits config.RateLimits
+	userLimits *userLimiter
+
+	sendEvent  chan models.OrderEvent
+	admins     map[string]bool
+	authSecret []byte
+	// Cancel-on-disconnect state, only touched by Run. A user's orders are
+	// canceled once cancelGrace has passed after the last connection of the
+	// user dropped, if any of them was flagged, unless the user reconnected
+	// in the meantime. conns counts the connections of each user and flagged
+	// marks the users whose flagged connections dropped while others stayed.
+	cancelGrace    time.Duration
+	conns          map[string]int
+	flagged        map[string]bool
+	pendingCancels map[string]*pendingCancel
+	cancelDue      chan *pendingCancel
+
+	// dropCopy publishes every drop-copy message outside the hub, nil if
+	// disabled. epoch tells apart the sequences of each run.
+	dropCopy DropCopyPublisher
+	epoch    int64
+
+	clientOrderIDs *clientOrderIDs
+	gate           *gateway.Gate
+}
+
+type pendingCancel struct {
+	userID string
+	timer  *time.Timer
+}
+
+const defaultCancelGrace = 5 * time.Second
+
+// publicTopics can be subscribed to by any client, as can book topics; user
+// topics are private.
+var publicTopics = map[string]bool{
+	"users": true,
+}
+
+const bookTopicPrefix = "book:"
+
+// BookTopic is the public topic of the book updates of an asset.
+func BookTopic(assetID string) string {
+	return bookTopicPrefix + assetID
 }
 
 func NewHub(userService userservice.UserService, router interfaces.OrderSubmitter) *Hub {
 	h := &Hub{
-		clients:    make(map[*Client]bool),
 		broadcast:  make(chan BroadcastMessage),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 
+		subscriptions: make(chan shardOp),
+
 		userService: userService,
 		router:      router,
 		sendTrade:   make(chan models.Trade),
+		shards:      newShards(runtime.GOMAXPROCS(0)),
+
+		replay:           make(map[string]*replayBuffer),
+		replayBufferSize: defaultReplayBufferSize,
+		resume:           make(chan resumeRequest),
+		syncReq:          make(chan chan struct{}),
+
+		sendQueueSize:      defaultSendQueueSize,
+		slowConsumerPolicy: Disconnect,
+
+		userLimits: newUserLimiter(config.RateLimits{}),
+
+		sendEvent:      make(chan models.OrderEvent),
+		admins:         make(map[string]bool),
+		cancelGrace:    defaultCancelGrace,
+		conns:          make(map[string]int),
+		flagged:        make(map[string]bool),
+		pendingCancels: make(map[string]*pendingCancel),
+		cancelDue:      make(chan *pendingCancel),
+
+		epoch:          time.Now().UnixNano(),
+		clientOrderIDs: newClientOrderIDs(defaultClientOrderIDRetention),
+		gate:           gateway.NewGate(userService, gateway.DefaultUserStatusTTL),
 	}
 	h.registerHandlers()
 	return h
 }
 
+func newShards(n int) []*shard {
+	shards := make([]*shard, n)
+	for i := range shards {
+		shards[i] = newShard()
+	}
+	return shards
+}
+
+// SetShards sets the number of fan-out workers. It must be called before Run.
+func (h *Hub) SetShards(n int) {
+	if n > 0 {
+		h.shards = newShards(n)
+	}
+}
+
+// SetSendQueue configures the outbound queue of clients registered afterwards.
+func (h *Hub) SetSendQueue(size int, policy SlowConsumerPolicy) {
+	h.sendQueueSize = size
+	h.slowConsumerPolicy = policy
+}
+
+// SetRateLimits configures message rate limits. Connections opened before the
+// call keep their previous per-connection limits.
+func (h *Hub) SetRateLimits(limits config.RateLimits) {
+	h.rateLimits = limits
+	h.userLimits = newUserLimiter(limits)
+}
+
+// SetReplayBufferSize sets how many messages are kept per topic for resuming
+// clients. It must be called before Run.
+func (h *Hub) SetReplayBufferSize(size int) {
+	h.replayBufferSize = size
+}
+
+// SetClientOrderIDRetention sets how long a ClientOrderID is remembered, and
+// a retried order acknowledged without being entered again. It must be called
+// before Run.
+func (h *Hub) SetClientOrderIDRetention(retention time.Duration) {
+	h.clientOrderIDs = newClientOrderIDs(retention)
+}
+
+// SetUserStatusTTL sets how long the status of a user is trusted before it is
+// looked up again, when no user event updates it. It must be called before
+// Run.
+func (h *Hub) SetUserStatusTTL(ttl time.Duration) {
+	h.gate = gateway.NewGate(h.userService, ttl)
+}
+
+// Gate returns the checks orders go through, for the other gateways to share.
+func (h *Hub) Gate() *gateway.Gate {
+	return h.gate
+}
+
+func (h *Hub) Metrics() MetricsSnapshot {
+	return h.metrics.Snapshot()
+}
+
 func (h *Hub) SetTradeChannel(tradeCh <-chan models.Trade) {
 	go func() {
 		for trade := range tradeCh {
@@ -49,6 +199,60 @@ func (h *Hub) SetTradeChannel(tradeCh <-chan models.Trade) {
 	}()
 }
 
+// SetEventChannel forwards order events, such as cancellations, to the
+// owning user's topic.
+func (h *Hub) SetEventChannel(eventCh <-chan models.OrderEvent) {
+	go func() {
+		for event := range eventCh {
+			h.sendEvent <- event
+		}
+	}()
+}
+
+// SetBookChannel publishes the book updates received on bookCh on the topic
+// of their asset. Each update replaces the previous one, so they are conflated
+// per asset for slow consumers.
+func (h *Hub) SetBookChannel(bookCh <-chan models.BookUpdate) {
+	go func() {
+		for book := range bookCh {
+			payload, _ := json.Marshal(book)
+			topic := BookTopic(book.AssetID)
+			h.broadcast <- BroadcastMessage{Entity: topic, Type: "book_update", Message: payload, Key: topic}
+		}
+	}()
+}
+
+// SetDropCopyPublisher passes every drop-copy message, JSON encoded with its
+// epoch and sequence number, to dropCopy as well as to the drop-copy topic.
+// It must be called before Run.
+func (h *Hub) SetDropCopyPublisher(dropCopy DropCopyPublisher) {
+	h.dropCopy = dropCopy
+}
+
+// SetCancelOnDisconnectGrace sets how long a user has to reconnect before a
+// cancel-on-disconnect session's orders are canceled. It must be called
+// before Run.
+func (h *Hub) SetCancelOnDisconnectGrace(grace time.Duration) {
+	h.cancelGrace = grace
+}
+
+// SetAdmins sets the users allowed to trigger the kill switch for other
+// users and to follow the drop copy. Admin rights require an authenticated
+// connection, see SetAuthSecret. It must be called before Run.
+func (h *Hub) SetAdmins(userIDs []string) {
+	h.admins = make(map[string]bool, len(userIDs))
+	for _, id := range userIDs {
+		h.admins[id] = true
+	}
+}
+
+// SetAuthSecret makes every connection authenticate with the token of its
+// user ID, see SignUserID. Without a secret user IDs are taken on trust and
+// nobody has admin rights. It must be called before connections are served.
+func (h *Hub) SetAuthSecret(secret string) {
+	h.authSecret = []byte(secret)
+}
+
 func (h *Hub) registerHandlers() {
 	h.handlers = map[string]map[string]MessageHandler{
 		"users": {
@@ -59,45 +263,237 @@ func (h *Hub) registerHandlers() {
 			"get_by_id": &GetUserByIDHandler{service: h.userService},
 		},
 		"orders": {
-			"order": &CreateOrderHandler{router: h.router},
+			"order":      &CreateOrderHandler{router: h.router},
+			"cancel_all": &CancelAllOrdersHandler{},
+		},
+		"session": {
+			"subscribe":   &SubscribeHandler{subscribe: true},
+			"unsubscribe": &SubscribeHandler{subscribe: false},
+			"resume":      &ResumeHandler{},
+
+			"cancel_on_disconnect": &CancelOnDisconnectHandler{},
 		},
 	}
 }
 
 func (h *Hub) Run() {
+	for _, s := range h.shards {
+		go s.run()
+	}
+	sweep := time.NewTicker(time.Minute)
+	defer sweep.Stop()
 	for {
 		select {
 		case client := <-h.register:
-			h.clients[client] = true
+			h.conns[client.userID]++
+			if p, ok := h.pendingCancels[client.userID]; ok {
+				p.timer.Stop()
+				delete(h.pendingCancels, client.userID)
+				slog.Info("User reconnected, cancel-on-disconnect aborted", "UserID", client.userID)
+			}
+			h.shardFor(client.userID).ops <- shardOp{kind: opRegister, client: client}
 		case client := <-h.unregister:
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
+			h.shardFor(client.userID).ops <- shardOp{kind: opUnregister, client: client}
+			h.disconnected(client)
+		case op := <-h.subscriptions:
+			h.shardFor(op.client.userID).ops <- op
+		case p := <-h.cancelDue:
+			// A timer stopped by a reconnect may already have fired.
+			if h.pendingCancels[p.userID] == p {
+				delete(h.pendingCancels, p.userID)
+				go h.cancelAll(p.userID, "cancel_on_disconnect")
 			}
 		case broadcastMessage := <-h.broadcast:
-			for client := range h.clients {
-				if client.subscribedEntities[broadcastMessage.Entity] {
-					select {
-					case client.send <- broadcastMessage.Message:
-					default:
-						close(client.send)
-						delete(h.clients, client)
-					}
-				}
-			}
+			msg := h.sequence(broadcastMessage.Entity, WSMessage{
+				Type:    broadcastMessage.Type,
+				Entity:  broadcastMessage.Entity,
+				Payload: broadcastMessage.Message,
+			}, nil)
+			msg.key = broadcastMessage.Key
+			h.publish(msg)
 		case trade := <-h.sendTrade:
-			for client := range h.clients {
-				if client.userID == trade.BuyerID || client.userID == trade.SellerID {
-					payload, _ := json.Marshal(trade)
-					msg := WSMessage{
-						Type:    "trade",
-						Entity:  "orders",
-						Payload: payload,
-					}
-					data, _ := json.Marshal(msg)
-					client.send <- data
-				}
+			payload, _ := json.Marshal(trade)
+			h.publishToUser(trade.BuyerID, WSMessage{Type: "trade", Entity: "orders", Payload: payload}, trade)
+			if trade.SellerID != trade.BuyerID {
+				h.publishToUser(trade.SellerID, WSMessage{Type: "trade", Entity: "orders", Payload: payload}, trade)
 			}
+			h.publishDropCopy(WSMessage{Type: "trade", Entity: "orders", Payload: payload}, trade)
+		case event := <-h.sendEvent:
+			payload, _ := json.Marshal(event)
+			h.publishToUser(event.UserID, WSMessage{Type: "order_event", Entity: "orders", Payload: payload}, event)
+			h.publishDropCopy(WSMessage{Type: "order_event", Entity: "orders", Payload: payload}, event)
+		case req := <-h.resume:
+			h.replayTo(req)
+		case done := <-h.syncReq:
+			h.syncShards()
+			close(done)
+		case now := <-sweep.C:
+			h.evictReplay(now)
+		}
+	}
+}
+
+// disconnected schedules the cancel of the user's orders once its last
+// connection is gone, if any of its connections asked for it.
+func (h *Hub) disconnected(c *Client) {
+	if c.cancelOnDisconnect.Load() {
+		h.flagged[c.userID] = true
+	}
+	h.conns[c.userID]--
+	if h.conns[c.userID] > 0 {
+		return
+	}
+	delete(h.conns, c.userID)
+	if h.flagged[c.userID] {
+		delete(h.flagged, c.userID)
+		h.scheduleCancel(c.userID)
+	}
+}
+
+func (h *Hub) scheduleCancel(userID string) {
+	if _, ok := h.pendingCancels[userID]; ok {
+		return
+	}
+	p := &pendingCancel{userID: userID}
+	p.timer = time.AfterFunc(h.cancelGrace, func() { h.cancelDue <- p })
+	h.pendingCancels[userID] = p
+}
+
+// cancelAll cancels every resting order of userID. It blocks until all books
+// have processed the request, so it must not be called from Run: a book may
+// itself be waiting for Run to take a trade.
+func (h *Hub) cancelAll(userID, reason string) ([]models.Order, bool) {
+	canceller, ok := h.router.(interfaces.OrderCanceller)
+	if !ok {
+		return nil, false
+	}
+	canceled := canceller.CancelAll(userID, reason)
+	slog.Info("Canceled open orders", "UserID", userID, "Reason", reason, "Count", len(canceled))
+	return canceled, true
+}
+
+// isAdmin reports whether c may act for other users. The user ID of a
+// connection is only trusted if it is authenticated.
+func (h *Hub) isAdmin(c *Client) bool {
+	return c.authenticated && h.admins[c.userID]
+}
+
+// sequence stamps env with the next sequence number of topic, serialises it
+// once and retains it for replay. value is the unencoded payload, if any.
+func (h *Hub) sequence(topic string, env WSMessage, value any) *topicMessage {
+	buf, ok := h.replay[topic]
+	if !ok {
+		buf = newReplayBuffer(h.replayBufferSize)
+		h.replay[topic] = buf
+	}
+	msg := &topicMessage{topic: topic, value: value}
+	buf.next(msg)
+	env.Topic = topic
+	env.Seq = msg.seq
+	msg.env = env
+	msg.data, _ = common.JSON.Marshal(env)
+	return msg
+}
+
+// evictReplay drops the replay buffers of the topics of users without
+// connections that have been idle for replayIdleTimeout, so that buffers do
+// not pile up for every user ever seen.
+func (h *Hub) evictReplay(now time.Time) {
+	for topic, buf := range h.replay {
+		userID, ok := strings.CutPrefix(topic, userTopicPrefix)
+		if ok && h.conns[userID] == 0 && now.Sub(buf.last) >= replayIdleTimeout {
+			delete(h.replay, topic)
 		}
 	}
 }
+
+type resumeRequest struct {
+	client  *Client
+	topic   string
+	lastSeq uint64
+}
+
+// replayTo sends a resuming client everything after its last seen sequence
+// number and subscribes it to the topic, or tells it to resnapshot if the gap
+// is no longer covered by the replay buffer. Messages already delivered since
+// the client reconnected may be replayed again; clients drop any seq they
+// have already seen.
+func (h *Hub) replayTo(req resumeRequest) {
+	var msgs []*topicMessage
+	var seq uint64
+	ok := true
+	if buf, exists := h.replay[req.topic]; exists {
+		msgs, ok = buf.since(req.lastSeq)
+		seq = buf.seq
+	} else if req.lastSeq > 0 {
+		ok = false
+	}
+	if !ok {
+		slog.Info("Replay gap too large, requesting resnapshot", "UserID", req.client.userID, "Topic", req.topic, "LastSeq", req.lastSeq)
+		req.client.respond("ok", "session", "resnapshot", map[string]any{"topic": req.topic, "seq": seq})
+		msgs = nil
+	}
+	h.shardFor(req.client.userID).ops <- shardOp{kind: opReplay, client: req.client, topic: req.topic, replay: msgs}
+}
+
+func (h *Hub) shardFor(userID string) *shard {
+	return h.shards[shardIndex(userID, len(h.shards))]
+}
+
+// publish fans a message out to the subscribers of its topic on every shard.
+func (h *Hub) publish(msg *topicMessage) {
+	for _, s := range h.shards {
+		s.ops <- shardOp{kind: opPublish, msg: msg}
+	}
+}
+
+// publishToUser delivers a message on a user's private topic. Only the shard
+// owning that user's connections is involved.
+func (h *Hub) publishToUser(userID string, env WSMessage, value any) {
+	msg := h.sequence(UserTopic(userID), env, value)
+	h.shardFor(userID).ops <- shardOp{kind: opPublish, msg: msg}
+}
+
+// publishDropCopy sequences env on the drop-copy topic. Since Run is the
+// only sequencer, the WebSocket topic and the NATS subject carry the same
+// sequence numbers, which restart at 1 with the epoch of the hub; together
+// they identify the message to the publisher.
+func (h *Hub) publishDropCopy(env WSMessage, value any) {
+	env.Epoch = h.epoch
+	msg := h.sequence(DropCopyTopic, env, value)
+	h.publish(msg)
+	if h.dropCopy != nil {
+		h.dropCopy.PublishDropCopy(fmt.Sprintf("%d-%d", h.epoch, msg.seq), msg.data)
+	}
+}
+
+func (h *Hub) subscribe(c *Client, topic string, subscribe bool) {
+	kind := opUnsubscribe
+	if subscribe {
+		kind = opSubscribe
+	}
+	h.subscriptions <- shardOp{kind: kind, client: c, topic: topic}
+}
+
+func (h *Hub) canSubscribe(c *Client, topic string) bool {
+	if topic == DropCopyTopic {
+		return h.isAdmin(c)
+	}
+	return publicTopics[topic] || strings.HasPrefix(topic, bookTopicPrefix) || topic == UserTopic(c.userID)
+}
+
+// sync waits until Run and every shard have applied all previously sent
+// operations.
+func (h *Hub) sync() {
+	done := make(chan struct{})
+	h.syncReq <- done
+	<-done
+}
+
+func (h *Hub) syncShards() {
+	for _, s := range h.shards {
+		done := make(chan struct{})
+		s.ops <- shardOp{kind: opSync, done: done}
+		<-done
+	}
+}
@@ -0,0 +1,79 @@
+package ws
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"user-ws-api/common"
+)
+
+// benchHub starts a hub with n clients whose queues are drained by a goroutine
+// each, standing in for the write pump.
+func benchHub(b *testing.B, shards, n int, topic string) (*Hub, []*Client) {
+	hub := NewHub(nil, nil)
+	hub.SetShards(shards)
+	hub.SetSendQueue(256, DropOldest)
+	go hub.Run()
+
+	clients := make([]*Client, n)
+	for i := range clients {
+		c := &Client{hub: hub, queue: newSendQueue(256, DropOldest), codec: common.JSON, userID: fmt.Sprintf("u%d", i)}
+		hub.register <- c
+		if topic != "" {
+			hub.subscribe(c, topic, true)
+		}
+		go func() {
+			for {
+				select {
+				case <-c.queue.done:
+					return
+				case <-c.queue.notify:
+					c.queue.drain()
+				}
+			}
+		}()
+		clients[i] = c
+	}
+	hub.sync()
+
+	b.Cleanup(func() {
+		for _, c := range clients {
+			hub.unregister <- c
+		}
+	})
+	return hub, clients
+}
+
+func BenchmarkHubBroadcast(b *testing.B) {
+	payload := []byte(`{"user_id":"6c1f2f1e-2f6b-4d8a-9e4e-1c2b3d4e5f60","first_name":"Alice"}`)
+	for _, shards := range []int{1, 8} {
+		for _, n := range []int{1000, 10000} {
+			b.Run(fmt.Sprintf("shards=%d/clients=%d", shards, n), func(b *testing.B) {
+				hub, _ := benchHub(b, shards, n, "users")
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					hub.broadcast <- BroadcastMessage{Entity: "users", Message: payload}
+				}
+				hub.sync()
+				b.ReportMetric(float64(b.N*n)/b.Elapsed().Seconds(), "deliveries/s")
+			})
+		}
+	}
+}
+
+func BenchmarkHubUserTrades(b *testing.B) {
+	for _, shards := range []int{1, 8} {
+		b.Run(fmt.Sprintf("shards=%d/clients=10000", shards), func(b *testing.B) {
+			hub, clients := benchHub(b, shards, 10000, "")
+			rng := rand.New(rand.NewSource(1))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				buyer := clients[rng.Intn(len(clients))].userID
+				seller := clients[rng.Intn(len(clients))].userID
+				hub.sendTrade <- tradeFor(buyer, seller)
+			}
+			hub.sync()
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "trades/s")
+		})
+	}
+}
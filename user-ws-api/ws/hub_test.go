@@ -0,0 +1,119 @@
+package ws
+
+import (
+	"fmt"
+	"testing"
+	"time"
+	"user-ws-api/common"
+	"user-ws-api/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestClient registers an authenticated client without a connection.
+func newTestClient(h *Hub, userID string) *Client {
+	c := &Client{hub: h, queue: newSendQueue(1024, DropOldest), codec: common.JSON, userID: userID, authenticated: true}
+	h.register <- c
+	return c
+}
+
+func TestHub_BroadcastOnlyReachesTopicSubscribers(t *testing.T) {
+	hub := NewHub(nil, nil)
+	hub.SetShards(4)
+	go hub.Run()
+
+	subscribed := newTestClient(hub, "u1")
+	other := newTestClient(hub, "u2")
+	hub.subscribe(subscribed, "users", true)
+
+	hub.broadcast <- BroadcastMessage{Entity: "users", Type: "update", Message: []byte(`{"user_id":"x"}`)}
+	hub.sync()
+
+	assert.Equal(t, []string{`{"type":"update","entity":"users","payload":{"user_id":"x"},"topic":"users","seq":1}`}, queued(subscribed.queue))
+	assert.Empty(t, queued(other.queue))
+
+	hub.subscribe(subscribed, "users", false)
+	hub.broadcast <- BroadcastMessage{Entity: "users", Type: "update", Message: []byte(`{}`)}
+	hub.sync()
+	assert.Empty(t, queued(subscribed.queue))
+}
+
+func TestHub_SubscribeRightAfterRegisterIsKept(t *testing.T) {
+	hub := NewHub(nil, nil)
+	hub.SetShards(4)
+	go hub.Run()
+
+	var clients []*Client
+	for i := range 50 {
+		c := newTestClient(hub, fmt.Sprintf("u%d", i))
+		hub.subscribe(c, "users", true)
+		clients = append(clients, c)
+	}
+	hub.broadcast <- BroadcastMessage{Entity: "users", Type: "update", Message: []byte(`{}`)}
+	hub.sync()
+
+	for _, c := range clients {
+		assert.Len(t, queued(c.queue), 1, c.userID)
+	}
+}
+
+func TestHub_TradesGoToCounterpartiesOnly(t *testing.T) {
+	hub := NewHub(nil, nil)
+	hub.SetShards(4)
+	go hub.Run()
+
+	clients := make(map[string]*Client)
+	for i := 1; i <= 8; i++ {
+		uid := fmt.Sprintf("u%d", i)
+		clients[uid] = newTestClient(hub, uid)
+	}
+
+	hub.sendTrade <- tradeFor("u1", "u5")
+	hub.sync()
+
+	for uid, c := range clients {
+		if uid == "u1" || uid == "u5" {
+			assert.Len(t, queued(c.queue), 1, uid)
+		} else {
+			assert.Empty(t, queued(c.queue), uid)
+		}
+	}
+}
+
+func TestHub_CanSubscribe(t *testing.T) {
+	hub := NewHub(nil, nil)
+	c := &Client{hub: hub, userID: "u1"}
+
+	assert.True(t, hub.canSubscribe(c, "users"))
+	assert.True(t, hub.canSubscribe(c, UserTopic("u1")))
+	assert.False(t, hub.canSubscribe(c, UserTopic("u2")))
+	assert.True(t, hub.canSubscribe(c, BookTopic("BTC")))
+}
+
+func TestHub_ConflatesBookUpdatesButNotUserChanges(t *testing.T) {
+	hub := NewHub(nil, nil)
+	go hub.Run()
+	books := make(chan models.BookUpdate)
+	hub.SetBookChannel(books)
+
+	c := &Client{hub: hub, queue: newSendQueue(16, Conflate), codec: common.JSON, userID: "u1"}
+	hub.register <- c
+	hub.subscribe(c, "users", true)
+	hub.subscribe(c, BookTopic("BTC"), true)
+
+	hub.broadcast <- BroadcastMessage{Entity: "users", Type: "create", Message: []byte(`{"user_id":"x"}`)}
+	hub.broadcast <- BroadcastMessage{Entity: "users", Type: "update", Message: []byte(`{"user_id":"x"}`)}
+	books <- models.BookUpdate{ID: "BTC-1-B1", AssetID: "BTC"}
+	books <- models.BookUpdate{ID: "ETH-1-B1", AssetID: "ETH"}
+	books <- models.BookUpdate{ID: "BTC-1-B2", AssetID: "BTC"}
+	require.Eventually(t, func() bool { return hub.Metrics().Conflated == 1 }, time.Second, time.Millisecond)
+	hub.sync()
+
+	msgs := queued(c.queue)
+	require.Len(t, msgs, 3)
+	assert.Contains(t, msgs[0], `"type":"create"`)
+	assert.Contains(t, msgs[1], `"type":"update"`)
+	assert.Contains(t, msgs[2], `"id":"BTC-1-B2"`)
+	assert.Contains(t, msgs[2], `"topic":"book:BTC"`)
+}
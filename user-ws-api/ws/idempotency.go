@@ -0,0 +1,84 @@
+// user-ws/ws/idempotency.go
+package ws
+
+import (
+	"errors"
+	"sync"
+	"time"
+	"user-ws-api/models"
+)
+
+const defaultClientOrderIDRetention = 24 * time.Hour
+
+var errClientOrderIDReused = errors.New("ClientOrderID was used for a different order")
+
+// orderAck acknowledges an order submitted with a ClientOrderID.
+type orderAck struct {
+	Status        string `json:"status"`
+	OrderID       string `json:"order_id"`
+	ClientOrderID string `json:"client_order_id"`
+}
+
+type submittedOrder struct {
+	order   models.Order
+	ack     orderAck
+	expires time.Time
+}
+
+// clientOrderIDs remembers the orders submitted with a ClientOrderID for a
+// retention window, so that a retried submission gets the acknowledgement of
+// the first one instead of entering a second order. It is shared by every
+// connection, so a retry on a new connection is recognised too.
+type clientOrderIDs struct {
+	mu        sync.Mutex
+	retention time.Duration
+	orders    map[string]submittedOrder // user ID + ClientOrderID
+	lastSweep time.Time
+}
+
+func newClientOrderIDs(retention time.Duration) *clientOrderIDs {
+	return &clientOrderIDs{retention: retention, orders: make(map[string]submittedOrder)}
+}
+
+// claim records order for userID. If the ClientOrderID was used before within
+// the retention window it returns the earlier acknowledgement and dup, or
+// errClientOrderIDReused if the earlier order was a different one.
+func (o *clientOrderIDs) claim(userID string, order models.Order, now time.Time) (ack orderAck, dup bool, err error) {
+	key := userID + "\x00" + order.ClientOrderID
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sweep(now)
+	if prev, ok := o.orders[key]; ok && now.Before(prev.expires) {
+		if !sameOrder(prev.order, order) {
+			return orderAck{}, false, errClientOrderIDReused
+		}
+		return prev.ack, true, nil
+	}
+	ack = orderAck{Status: "accepted", OrderID: order.ID, ClientOrderID: order.ClientOrderID}
+	o.orders[key] = submittedOrder{order: order, ack: ack, expires: now.Add(o.retention)}
+	return ack, false, nil
+}
+
+// sweep drops expired entries, at most once a minute.
+func (o *clientOrderIDs) sweep(now time.Time) {
+	if now.Sub(o.lastSweep) < time.Minute {
+		return
+	}
+	o.lastSweep = now
+	for key, prev := range o.orders {
+		if !now.Before(prev.expires) {
+			delete(o.orders, key)
+		}
+	}
+}
+
+// sameOrder compares orders ignoring CreatedAt, which a client may refresh
+// when it retries.
+func sameOrder(a, b models.Order) bool {
+	if !a.ExpiresAt.Equal(b.ExpiresAt) {
+		return false
+	}
+	a.CreatedAt, b.CreatedAt = time.Time{}, time.Time{}
+	a.ExpiresAt, b.ExpiresAt = time.Time{}, time.Time{}
+	return a == b
+}
@@ -0,0 +1,57 @@
+package ws
+
+import (
+	"bytes"
+	"testing"
+	"time"
+	"user-ws-api/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateOrder_ClientOrderIDIsIdempotent(t *testing.T) {
+	hub, router := newTradingHub(t)
+	handler := &CreateOrderHandler{router: router}
+	order := []byte(`{"ClientOrderID":"c1","UserID":"u1","AssetID":"BTC","Quantity":1,"Price":98,"Side":"BUY"}`)
+
+	// The retry comes on a new connection after the first one timed out.
+	first, retry := newTestClient(hub, "u1"), newTestClient(hub, "u1")
+	handler.HandleMessage(first, nil, WSMessage{Type: "order", Entity: "orders", Payload: order})
+	handler.HandleMessage(retry, nil, WSMessage{Type: "order", Entity: "orders", Payload: order})
+
+	ack := `"data":{"status":"accepted","order_id":"u1-c1","client_order_id":"c1"}`
+	assert.Contains(t, string(bytes.Join(first.queue.drain(), nil)), ack)
+	assert.Contains(t, string(bytes.Join(retry.queue.drain(), nil)), ack)
+	require.Eventually(t, func() bool {
+		return router.GetAsset("BTC").GetBookDepth().BuyDepth == 3
+	}, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 3, router.GetAsset("BTC").GetBookDepth().BuyDepth, "the retry must not enter a second order")
+
+	other := []byte(`{"ClientOrderID":"c1","UserID":"u1","AssetID":"BTC","Quantity":5,"Price":98,"Side":"BUY"}`)
+	handler.HandleMessage(retry, nil, WSMessage{Type: "order", Entity: "orders", Payload: other})
+	assert.Contains(t, string(bytes.Join(retry.queue.drain(), nil)), errClientOrderIDReused.Error())
+}
+
+func TestClientOrderIDs_Expire(t *testing.T) {
+	ids := newClientOrderIDs(time.Hour)
+	now := time.Now()
+	order := models.Order{ID: "u1-c1", ClientOrderID: "c1", CreatedAt: now}
+
+	_, dup, err := ids.claim("u1", order, now)
+	require.NoError(t, err)
+	assert.False(t, dup)
+
+	// Another user may use the same ClientOrderID.
+	_, dup, _ = ids.claim("u2", order, now)
+	assert.False(t, dup)
+
+	order.CreatedAt = now.Add(time.Second)
+	_, dup, _ = ids.claim("u1", order, now.Add(59*time.Minute))
+	assert.True(t, dup)
+
+	_, dup, _ = ids.claim("u1", order, now.Add(61*time.Minute))
+	assert.False(t, dup)
+	assert.Len(t, ids.orders, 1, "the expired order of u2 is swept")
+}
@@ -0,0 +1,126 @@
+package ws
+
+import (
+	"testing"
+	"time"
+	"user-ws-api/engine"
+	"user-ws-api/matcher"
+	"user-ws-api/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newEventRouter() (*engine.OrderRouter, chan models.Trade, chan models.OrderEvent) {
+	tradeCh := make(chan models.Trade, 10)
+	eventCh := make(chan models.OrderEvent, 10)
+	router := engine.NewOrderRouter(&matcher.SimpleMatcher{}, tradeCh)
+	router.SetEventChannel(eventCh)
+	return router, tradeCh, eventCh
+}
+
+// nextEvent returns the next order event other than an acknowledgement.
+func nextEvent(t *testing.T, eventCh chan models.OrderEvent) models.OrderEvent {
+	for {
+		select {
+		case event := <-eventCh:
+			if event.Status != models.New {
+				return event
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no order event")
+			return models.OrderEvent{}
+		}
+	}
+}
+
+func TestEngine_GTDOrderExpires(t *testing.T) {
+	router, _, eventCh := newEventRouter()
+	router.Submit(models.Order{ID: "o1", UserID: "u1", AssetID: "BTC", Quantity: 1, Price: 100, Side: models.Buy,
+		TimeInForce: models.GTD, ExpiresAt: time.Now().Add(30 * time.Millisecond)})
+
+	event := nextEvent(t, eventCh)
+	assert.Equal(t, "o1", event.OrderID)
+	assert.Equal(t, models.Canceled, event.Status)
+	assert.Equal(t, "expired", event.Reason)
+	assert.Equal(t, 0, router.GetAsset("BTC").GetBookDepth().BuyDepth)
+}
+
+func TestEngine_PostOnly(t *testing.T) {
+	router, _, eventCh := newEventRouter()
+	router.Submit(models.Order{ID: "s1", UserID: "u2", AssetID: "BTC", Quantity: 1, Price: 100, Side: models.Sell})
+
+	router.Submit(models.Order{ID: "b1", UserID: "u1", AssetID: "BTC", Quantity: 1, Price: 101, Side: models.Buy,
+		PostOnly: models.PostOnlyReject})
+	event := nextEvent(t, eventCh)
+	assert.Equal(t, models.Rejected, event.Status)
+	assert.Equal(t, "post_only", event.Reason)
+
+	router.Submit(models.Order{ID: "b2", UserID: "u1", AssetID: "BTC", Quantity: 1, Price: 101, Side: models.Buy,
+		PostOnly: models.PostOnlyReprice})
+	event = nextEvent(t, eventCh)
+	assert.Equal(t, models.Repriced, event.Status)
+	assert.Equal(t, 99.99, event.Price)
+
+	depth := router.GetAsset("BTC").GetBookDepth()
+	assert.Equal(t, 1, depth.BuyDepth)
+	assert.Equal(t, 1, depth.SellDepth)
+}
+
+func TestEngine_PostOnlyRepricesToTheTickGrid(t *testing.T) {
+	router, _, eventCh := newEventRouter()
+	// Resting prices that are not on the grid.
+	router.Submit(models.Order{ID: "s1", UserID: "u2", AssetID: "BTC", Quantity: 1, Price: 100.005, Side: models.Sell})
+	router.Submit(models.Order{ID: "b1", UserID: "u2", AssetID: "ETH", Quantity: 1, Price: 99.995, Side: models.Buy})
+
+	router.Submit(models.Order{ID: "b2", UserID: "u1", AssetID: "BTC", Quantity: 1, Price: 101, Side: models.Buy,
+		PostOnly: models.PostOnlyReprice})
+	event := nextEvent(t, eventCh)
+	assert.Equal(t, "b2", event.OrderID)
+	assert.Equal(t, models.Repriced, event.Status)
+	assert.Equal(t, 100.0, event.Price)
+
+	router.Submit(models.Order{ID: "s2", UserID: "u1", AssetID: "ETH", Quantity: 1, Price: 99, Side: models.Sell,
+		PostOnly: models.PostOnlyReprice})
+	event = nextEvent(t, eventCh)
+	assert.Equal(t, "s2", event.OrderID)
+	assert.Equal(t, models.Repriced, event.Status)
+	assert.Equal(t, 100.0, event.Price)
+
+	// From a price on the grid, the next tick.
+	router.Submit(models.Order{ID: "b3", UserID: "u2", AssetID: "SOL", Quantity: 1, Price: 0.2, Side: models.Buy})
+	router.Submit(models.Order{ID: "s3", UserID: "u1", AssetID: "SOL", Quantity: 1, Price: 0.1, Side: models.Sell,
+		PostOnly: models.PostOnlyReprice})
+	event = nextEvent(t, eventCh)
+	assert.Equal(t, "s3", event.OrderID)
+	assert.Equal(t, models.Repriced, event.Status)
+	assert.Equal(t, 0.21, event.Price, "not 0.2 + 0.01")
+}
+
+func TestEngine_ReduceOnly(t *testing.T) {
+	router, tradeCh, eventCh := newEventRouter()
+	router.Submit(models.Order{ID: "r1", UserID: "u1", AssetID: "BTC", Quantity: 1, Price: 100, Side: models.Sell, ReduceOnly: true})
+	event := nextEvent(t, eventCh)
+	assert.Equal(t, models.Rejected, event.Status)
+	assert.Equal(t, "reduce_only", event.Reason)
+
+	// u1 buys 2, then may sell at most 2 reduce-only.
+	router.Submit(models.Order{ID: "s1", UserID: "u2", AssetID: "BTC", Quantity: 2, Price: 100, Side: models.Sell})
+	router.Submit(models.Order{ID: "b1", UserID: "u1", AssetID: "BTC", Quantity: 2, Price: 100, Side: models.Buy})
+	<-tradeCh
+
+	router.Submit(models.Order{ID: "r2", UserID: "u1", AssetID: "BTC", Quantity: 5, Price: 120, Side: models.Sell, ReduceOnly: true})
+	require.Eventually(t, func() bool {
+		return router.GetAsset("BTC").GetBookDepth().SellDepth == 1
+	}, time.Second, 5*time.Millisecond)
+	// The resting reduce-only order already closes the position.
+	router.Submit(models.Order{ID: "r3", UserID: "u1", AssetID: "BTC", Quantity: 1, Price: 130, Side: models.Sell, ReduceOnly: true})
+	event = nextEvent(t, eventCh)
+	assert.Equal(t, "r3", event.OrderID)
+	assert.Equal(t, models.Rejected, event.Status)
+	assert.Equal(t, "reduce_only", event.Reason)
+
+	canceled := router.CancelAll("u1", "test")
+	require.Len(t, canceled, 1)
+	assert.Equal(t, 2.0, canceled[0].Quantity)
+}
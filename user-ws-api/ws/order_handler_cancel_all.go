@@ -0,0 +1,42 @@
+package ws
+
+import (
+	"context"
+	"log/slog"
+)
+
+// CancelAllOrdersHandler is the kill switch: it cancels every resting order of
+// a user across all assets. Users may cancel their own orders; admins may
+// name any user.
+type CancelAllOrdersHandler struct{}
+
+func (h *CancelAllOrdersHandler) HandleMessage(c *Client, ctx context.Context, msg WSMessage) {
+	var payload struct {
+		UserID string `json:"user_id"`
+	}
+	if len(msg.Payload) > 0 {
+		if err := c.codec.Unmarshal(msg.Payload, &payload); err != nil {
+			slog.Error("Invalid cancel_all payload:", "Error", err)
+			errMsg := map[string]string{"error": "Invalid cancel_all payload"}
+			c.respond("error", "orders", msg.Type, errMsg)
+			return
+		}
+	}
+	target := payload.UserID
+	if target == "" {
+		target = c.userID
+	}
+	if target != c.userID && !c.hub.isAdmin(c) {
+		slog.Warn("Kill switch denied", "UserID", c.userID, "Target", target)
+		errMsg := map[string]string{"error": "Not allowed to cancel orders of another user"}
+		c.respond("error", "orders", msg.Type, errMsg)
+		return
+	}
+	canceled, ok := c.hub.cancelAll(target, "kill_switch")
+	if !ok {
+		errMsg := map[string]string{"error": "Order cancellation not supported"}
+		c.respond("error", "orders", msg.Type, errMsg)
+		return
+	}
+	c.respond("ok", "orders", msg.Type, map[string]any{"user_id": target, "canceled": len(canceled)})
+}
@@ -2,26 +2,67 @@ package ws
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"log/slog"
-	"user-ws-api/common"
+	"time"
+	"user-ws-api/gateway"
 	"user-ws-api/interfaces"
 
 	"user-ws-api/models"
 )
 
+// userNotActiveCode is the "code" of order rejections for users that may not
+// trade.
+const userNotActiveCode = "user_not_active"
+
 type CreateOrderHandler struct {
 	router interfaces.OrderSubmitter
 }
 
 func (h *CreateOrderHandler) HandleMessage(c *Client, ctx context.Context, msg WSMessage) {
 	var order models.Order
-	if err := json.Unmarshal(msg.Payload, &order); err != nil {
+	if err := c.codec.Unmarshal(msg.Payload, &order); err != nil {
 		slog.Error("Invalid order payload:", "Error", err)
 		errMsg := map[string]string{"error": "Invalid order payload"}
-		c.send <- common.MakeWSResponse("error", "orders", "create", errMsg)
+		c.respond("error", "orders", "create", errMsg)
+		return
+	}
+	// Orders belong to the user of the connection, which is the one checked,
+	// canceled on disconnect and scoping ClientOrderIDs.
+	if order.UserID != "" && order.UserID != c.userID {
+		slog.Warn("Rejecting order of another user", "UserID", c.userID, "OrderUserID", order.UserID)
+		errMsg := map[string]string{"error": "Order belongs to another user"}
+		c.respond("error", "orders", msg.Type, errMsg)
 		return
 	}
+	order.UserID = c.userID
+	if err := c.hub.gate.Check(ctx, c.userID, order); err != nil {
+		slog.Warn("Rejecting order", "UserID", c.userID, "Error", err)
+		errMsg := map[string]string{"error": err.Error()}
+		if errors.Is(err, gateway.ErrCannotTrade) {
+			errMsg["code"] = userNotActiveCode
+		}
+		c.respond("error", "orders", msg.Type, errMsg)
+		return
+	}
+	if order.ClientOrderID != "" {
+		// Orders with a ClientOrderID are acknowledged, and a retry gets the
+		// same acknowledgement without entering the order again.
+		if order.ID == "" {
+			order.ID = c.userID + "-" + order.ClientOrderID
+		}
+		ack, dup, err := c.hub.clientOrderIDs.claim(c.userID, order, time.Now())
+		if err != nil {
+			slog.Warn("Rejecting order", "UserID", c.userID, "ClientOrderID", order.ClientOrderID, "Error", err)
+			c.respond("error", "orders", msg.Type, map[string]string{"error": err.Error()})
+			return
+		}
+		c.respond("ok", "orders", msg.Type, ack)
+		if dup {
+			slog.Info("Acknowledged duplicate order", "UserID", c.userID, "ClientOrderID", order.ClientOrderID)
+			return
+		}
+	}
 	slog.Info("CreateOrderHandler.HandleMessage", "order", order)
 	h.router.Submit(order)
 }
@@ -0,0 +1,66 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+	"user-ws-api/gateway"
+
+	"github.com/google/uuid"
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/userservice"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeUsers serves GetUser from a map; other methods are not implemented.
+type fakeUsers struct {
+	userservice.UserService
+	users   map[uuid.UUID]userservice.User
+	lookups int
+}
+
+func (f *fakeUsers) GetUser(_ context.Context, id uuid.UUID) (userservice.User, error) {
+	f.lookups++
+	user, ok := f.users[id]
+	if !ok {
+		return userservice.User{}, userservice.ErrUserNotFound
+	}
+	return user, nil
+}
+
+func TestCreateOrder_RejectsUsersThatCannotTrade(t *testing.T) {
+	hub, router := newTradingHub(t)
+	active, suspended := uuid.New(), uuid.New()
+	hub.gate = gateway.NewGate(&fakeUsers{users: map[uuid.UUID]userservice.User{
+		active:    {UserID: active, Status: userservice.StatusActive},
+		suspended: {UserID: suspended, Status: userservice.StatusSuspended},
+	}}, time.Minute)
+	handler := &CreateOrderHandler{router: router}
+	order := func(clientOrderID string) []byte {
+		return []byte(`{"ClientOrderID":"` + clientOrderID + `","AssetID":"BTC","Quantity":1,"Price":98,"Side":"BUY"}`)
+	}
+
+	c := newTestClient(hub, suspended.String())
+	handler.HandleMessage(c, context.Background(), WSMessage{Type: "order", Entity: "orders", Payload: order("s1")})
+	out := string(bytes.Join(c.queue.drain(), nil))
+	assert.Contains(t, out, "user is suspended and cannot submit orders")
+	assert.Contains(t, out, `"code":"`+userNotActiveCode+`"`)
+
+	// Naming another user does not get around the check.
+	c = newTestClient(hub, suspended.String())
+	handler.HandleMessage(c, context.Background(), WSMessage{Type: "order", Entity: "orders", Payload: []byte(
+		`{"UserID":"` + active.String() + `","ClientOrderID":"s2","AssetID":"BTC","Quantity":1,"Price":98,"Side":"BUY"}`)})
+	assert.Contains(t, string(bytes.Join(c.queue.drain(), nil)), "Order belongs to another user")
+
+	c = newTestClient(hub, uuid.NewString())
+	handler.HandleMessage(c, context.Background(), WSMessage{Type: "order", Entity: "orders", Payload: order("u1")})
+	assert.Contains(t, string(bytes.Join(c.queue.drain(), nil)), "user does not exist")
+
+	c = newTestClient(hub, active.String())
+	handler.HandleMessage(c, context.Background(), WSMessage{Type: "order", Entity: "orders", Payload: order("a1")})
+	assert.Contains(t, string(bytes.Join(c.queue.drain(), nil)), `"status":"accepted"`)
+	require.Eventually(t, func() bool {
+		return router.GetAsset("BTC").GetBookDepth().BuyDepth == 3
+	}, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,197 @@
+// user-ws/ws/outbound.go
+package ws
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// SlowConsumerPolicy decides what happens when a client's outbound queue is full.
+type SlowConsumerPolicy string
+
+const (
+	// DropOldest evicts the oldest queued message to make room for the new one.
+	DropOldest SlowConsumerPolicy = "drop_oldest"
+	// Conflate replaces queued messages that share a conflation key (e.g. market
+	// data for the same asset) and evicts the oldest conflatable message when full.
+	// A full queue with nothing left to conflate disconnects the client.
+	Conflate SlowConsumerPolicy = "conflate"
+	// Disconnect closes the connection with a "slow consumer" close reason.
+	Disconnect SlowConsumerPolicy = "disconnect"
+)
+
+const defaultSendQueueSize = 256
+
+func ParseSlowConsumerPolicy(s string) (SlowConsumerPolicy, error) {
+	switch p := SlowConsumerPolicy(s); p {
+	case DropOldest, Conflate, Disconnect:
+		return p, nil
+	case "":
+		return Disconnect, nil
+	default:
+		return "", fmt.Errorf("unknown slow consumer policy %q", s)
+	}
+}
+
+// Metrics counts delivery and rate limiting problems across all clients of a hub.
+type Metrics struct {
+	Dropped              atomic.Uint64
+	Conflated            atomic.Uint64
+	SlowDisconnects      atomic.Uint64
+	RateLimited          atomic.Uint64
+	RateLimitDisconnects atomic.Uint64
+}
+
+type MetricsSnapshot struct {
+	Dropped              uint64 `json:"dropped"`
+	Conflated            uint64 `json:"conflated"`
+	SlowDisconnects      uint64 `json:"slow_disconnects"`
+	RateLimited          uint64 `json:"rate_limited"`
+	RateLimitDisconnects uint64 `json:"rate_limit_disconnects"`
+}
+
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	return MetricsSnapshot{
+		Dropped:              m.Dropped.Load(),
+		Conflated:            m.Conflated.Load(),
+		SlowDisconnects:      m.SlowDisconnects.Load(),
+		RateLimited:          m.RateLimited.Load(),
+		RateLimitDisconnects: m.RateLimitDisconnects.Load(),
+	}
+}
+
+type outboundMessage struct {
+	data []byte
+	key  string // conflation key, empty if the message must never be conflated
+}
+
+type pushResult int
+
+const (
+	pushQueued pushResult = iota
+	pushConflated
+	pushDropped
+	pushOverflow
+	pushClosed
+)
+
+// sendQueue is a bounded per-client outbound queue. push never blocks, so
+// neither the hub nor the matching engine can be stalled by a slow reader.
+type sendQueue struct {
+	mu      sync.Mutex
+	items   []outboundMessage
+	size    int
+	policy  SlowConsumerPolicy
+	closed  bool
+	dropped uint64
+	// closeCode and closeReason are sent to the peer when the queue is
+	// closed by disconnect rather than by a normal unregister.
+	closeCode   int
+	closeReason string
+	notify      chan struct{}
+	done        chan struct{}
+}
+
+func newSendQueue(size int, policy SlowConsumerPolicy) *sendQueue {
+	if size <= 0 {
+		size = defaultSendQueueSize
+	}
+	return &sendQueue{
+		items:  make([]outboundMessage, 0, size),
+		size:   size,
+		policy: policy,
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+func (q *sendQueue) push(msg outboundMessage) pushResult {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return pushClosed
+	}
+
+	result := pushQueued
+	if q.policy == Conflate && msg.key != "" {
+		for i := range q.items {
+			if q.items[i].key == msg.key {
+				q.items[i] = msg
+				return pushConflated
+			}
+		}
+	}
+	if len(q.items) >= q.size {
+		switch q.policy {
+		case DropOldest:
+			q.items = q.items[1:]
+		case Conflate:
+			i := q.oldestConflatable()
+			if i < 0 {
+				return pushOverflow
+			}
+			q.items = append(q.items[:i], q.items[i+1:]...)
+		default:
+			return pushOverflow
+		}
+		q.dropped++
+		result = pushDropped
+	}
+	q.items = append(q.items, msg)
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return result
+}
+
+func (q *sendQueue) oldestConflatable() int {
+	for i := range q.items {
+		if q.items[i].key != "" {
+			return i
+		}
+	}
+	return -1
+}
+
+// drain removes and returns everything currently queued.
+func (q *sendQueue) drain() [][]byte {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make([][]byte, len(q.items))
+	for i, item := range q.items {
+		out[i] = item.data
+	}
+	q.items = q.items[:0]
+	return out
+}
+
+func (q *sendQueue) close() {
+	q.disconnect(0, "")
+}
+
+func (q *sendQueue) disconnect(code int, reason string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.closeCode = code
+	q.closeReason = reason
+	close(q.done)
+}
+
+func (q *sendQueue) closeStatus() (int, string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closeCode, q.closeReason
+}
+
+func (q *sendQueue) droppedCount() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
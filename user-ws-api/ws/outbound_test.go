@@ -0,0 +1,81 @@
+package ws
+
+import (
+	"testing"
+	"user-ws-api/common"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"user-ws-api/models"
+)
+
+func queued(q *sendQueue) []string {
+	var out []string
+	for _, m := range q.drain() {
+		out = append(out, string(m))
+	}
+	return out
+}
+
+func TestSendQueue_DropOldest(t *testing.T) {
+	q := newSendQueue(2, DropOldest)
+	assert.Equal(t, pushQueued, q.push(outboundMessage{data: []byte("a")}))
+	assert.Equal(t, pushQueued, q.push(outboundMessage{data: []byte("b")}))
+	assert.Equal(t, pushDropped, q.push(outboundMessage{data: []byte("c")}))
+
+	assert.Equal(t, []string{"b", "c"}, queued(q))
+	assert.Equal(t, uint64(1), q.droppedCount())
+}
+
+func TestSendQueue_ConflateReplacesSameKey(t *testing.T) {
+	q := newSendQueue(3, Conflate)
+	q.push(outboundMessage{data: []byte("btc-1"), key: "book:BTC"})
+	q.push(outboundMessage{data: []byte("trade")})
+	assert.Equal(t, pushConflated, q.push(outboundMessage{data: []byte("btc-2"), key: "book:BTC"}))
+
+	assert.Equal(t, []string{"btc-2", "trade"}, queued(q))
+}
+
+func TestSendQueue_ConflateEvictsKeyedBeforeOverflowing(t *testing.T) {
+	q := newSendQueue(2, Conflate)
+	q.push(outboundMessage{data: []byte("trade")})
+	q.push(outboundMessage{data: []byte("btc"), key: "book:BTC"})
+	assert.Equal(t, pushDropped, q.push(outboundMessage{data: []byte("eth"), key: "book:ETH"}))
+	assert.Equal(t, []string{"trade", "eth"}, queued(q))
+
+	q.push(outboundMessage{data: []byte("t1")})
+	q.push(outboundMessage{data: []byte("t2")})
+	assert.Equal(t, pushOverflow, q.push(outboundMessage{data: []byte("t3")}))
+}
+
+func TestSendQueue_DisconnectPolicy(t *testing.T) {
+	q := newSendQueue(1, Disconnect)
+	q.push(outboundMessage{data: []byte("a")})
+	assert.Equal(t, pushOverflow, q.push(outboundMessage{data: []byte("b")}))
+
+	q.disconnect(websocket.ClosePolicyViolation, "slow consumer")
+	code, reason := q.closeStatus()
+	assert.Equal(t, websocket.ClosePolicyViolation, code)
+	assert.Equal(t, "slow consumer", reason)
+	assert.Equal(t, pushClosed, q.push(outboundMessage{data: []byte("c")}))
+}
+
+func TestHub_SlowConsumerDoesNotBlockTrades(t *testing.T) {
+	hub := NewHub(nil, nil)
+	hub.SetSendQueue(1, Disconnect)
+	go hub.Run()
+
+	slow := &Client{hub: hub, queue: newSendQueue(1, Disconnect), codec: common.JSON, userID: "u1"}
+	hub.register <- slow
+
+	for i := 0; i < 10; i++ {
+		hub.sendTrade <- tradeFor("u1", "u2")
+	}
+
+	<-slow.queue.done
+	assert.Equal(t, uint64(1), hub.Metrics().SlowDisconnects)
+}
+
+func tradeFor(buyer, seller string) models.Trade {
+	return models.Trade{BuyerID: buyer, SellerID: seller, Quantity: 1, Price: 100}
+}
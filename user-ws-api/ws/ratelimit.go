@@ -0,0 +1,178 @@
+// user-ws/ws/ratelimit.go
+package ws
+
+import (
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+	"user-ws-api/config"
+
+	"github.com/gorilla/websocket"
+)
+
+// Message classes that are rate limited separately.
+const (
+	classOrders  = "orders"
+	classQueries = "queries"
+)
+
+func messageClass(msg WSMessage) string {
+	if msg.Entity == "orders" {
+		return classOrders
+	}
+	return classQueries
+}
+
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit config.RateLimit, now time.Time) *tokenBucket {
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = math.Max(1, limit.Rate)
+	}
+	return &tokenBucket{rate: limit.Rate, burst: burst, tokens: burst, last: now}
+}
+
+// allow takes a token if one is available. On refusal it reports how long
+// until the next token.
+func (b *tokenBucket) allow(now time.Time) (bool, time.Duration) {
+	if b.rate <= 0 {
+		return true, 0
+	}
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func limitFor(limits config.RateLimits, class string) config.ClassLimits {
+	if class == classOrders {
+		return limits.Orders
+	}
+	return limits.Queries
+}
+
+// connLimiter holds a connection's own buckets. It is only used by the
+// connection's read pump, so it needs no locking.
+type connLimiter struct {
+	limits     config.RateLimits
+	buckets    map[string]*tokenBucket
+	violations []time.Time
+}
+
+func newConnLimiter(limits config.RateLimits) *connLimiter {
+	return &connLimiter{limits: limits, buckets: make(map[string]*tokenBucket)}
+}
+
+func (l *connLimiter) allow(class string, now time.Time) (bool, time.Duration) {
+	b, ok := l.buckets[class]
+	if !ok {
+		b = newTokenBucket(limitFor(l.limits, class).PerConnection, now)
+		l.buckets[class] = b
+	}
+	return b.allow(now)
+}
+
+// violate records a rejected message and reports whether the connection has
+// exceeded the allowed number of violations within the window.
+func (l *connLimiter) violate(now time.Time) bool {
+	if l.limits.MaxViolations <= 0 {
+		return false
+	}
+	cutoff := now.Add(-l.limits.ViolationWindow)
+	kept := l.violations[:0]
+	for _, t := range l.violations {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	l.violations = append(kept, now)
+	return len(l.violations) > l.limits.MaxViolations
+}
+
+// userLimiter holds buckets shared by every connection of the same user.
+type userLimiter struct {
+	mu        sync.Mutex
+	limits    config.RateLimits
+	buckets   map[string]map[string]*tokenBucket // user ID -> class -> bucket
+	lastSweep time.Time
+}
+
+const userBucketIdle = 10 * time.Minute
+
+func newUserLimiter(limits config.RateLimits) *userLimiter {
+	return &userLimiter{limits: limits, buckets: make(map[string]map[string]*tokenBucket)}
+}
+
+func (l *userLimiter) allow(userID, class string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > userBucketIdle {
+		l.sweep(now)
+	}
+	classes, ok := l.buckets[userID]
+	if !ok {
+		classes = make(map[string]*tokenBucket)
+		l.buckets[userID] = classes
+	}
+	b, ok := classes[class]
+	if !ok {
+		b = newTokenBucket(limitFor(l.limits, class).PerUser, now)
+		classes[class] = b
+	}
+	return b.allow(now)
+}
+
+// sweep forgets users that have been idle for a while; a new bucket starts full,
+// which is what their old one would have refilled to.
+func (l *userLimiter) sweep(now time.Time) {
+	for userID, classes := range l.buckets {
+		idle := true
+		for _, b := range classes {
+			if now.Sub(b.last) < userBucketIdle {
+				idle = false
+				break
+			}
+		}
+		if idle {
+			delete(l.buckets, userID)
+		}
+	}
+	l.lastSweep = now
+}
+
+// checkRate applies the connection and user limits to msg. A refused message
+// gets a rate limit error, and repeat offenders are disconnected.
+func (c *Client) checkRate(msg WSMessage) bool {
+	now := time.Now()
+	class := messageClass(msg)
+	ok, retryAfter := c.limiter.allow(class, now)
+	if ok {
+		ok, retryAfter = c.hub.userLimits.allow(c.userID, class, now)
+	}
+	if ok {
+		return true
+	}
+
+	c.hub.metrics.RateLimited.Add(1)
+	c.respond("error", msg.Entity, msg.Type, map[string]any{
+		"error":          "rate limit exceeded",
+		"class":          class,
+		"retry_after_ms": retryAfter.Milliseconds(),
+	})
+	if c.limiter.violate(now) {
+		slog.Warn("Disconnecting client for repeated rate limit violations", "UserID", c.userID, "Class", class)
+		c.hub.metrics.RateLimitDisconnects.Add(1)
+		c.queue.disconnect(websocket.ClosePolicyViolation, "rate limit exceeded")
+	}
+	return false
+}
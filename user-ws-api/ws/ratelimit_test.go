@@ -0,0 +1,85 @@
+package ws
+
+import (
+	"testing"
+	"time"
+	"user-ws-api/common"
+	"user-ws-api/config"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(config.RateLimit{Rate: 2, Burst: 2}, now)
+
+	ok, _ := b.allow(now)
+	assert.True(t, ok)
+	ok, _ = b.allow(now)
+	assert.True(t, ok)
+	ok, retry := b.allow(now)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, retry)
+
+	ok, _ = b.allow(now.Add(500 * time.Millisecond))
+	assert.True(t, ok, "refilled one token after 1/rate seconds")
+}
+
+func TestTokenBucket_ZeroRateIsUnlimited(t *testing.T) {
+	b := newTokenBucket(config.RateLimit{}, time.Now())
+	for i := 0; i < 1000; i++ {
+		ok, _ := b.allow(time.Now())
+		assert.True(t, ok)
+	}
+}
+
+func TestCheckRate_PerClassAndDisconnect(t *testing.T) {
+	limits := config.RateLimits{
+		Orders:          config.ClassLimits{PerConnection: config.RateLimit{Rate: 0.001, Burst: 2}},
+		MaxViolations:   2,
+		ViolationWindow: time.Minute,
+	}
+	hub := NewHub(nil, nil)
+	hub.SetRateLimits(limits)
+	c := &Client{hub: hub, queue: newSendQueue(16, Disconnect), codec: common.JSON, limiter: newConnLimiter(limits), userID: "u1"}
+
+	order := WSMessage{Entity: "orders", Type: "order"}
+	query := WSMessage{Entity: "users", Type: "get"}
+
+	assert.True(t, c.checkRate(order))
+	assert.True(t, c.checkRate(order))
+	assert.False(t, c.checkRate(order))
+	assert.True(t, c.checkRate(query), "queries have their own, unlimited, bucket")
+	assert.False(t, c.checkRate(order))
+	assert.Len(t, queued(c.queue), 2, "one error response per refused message")
+
+	assert.False(t, c.checkRate(order))
+	code, reason := c.queue.closeStatus()
+	assert.NotZero(t, code)
+	assert.Equal(t, "rate limit exceeded", reason)
+	assert.Equal(t, uint64(3), hub.Metrics().RateLimited)
+	assert.Equal(t, uint64(1), hub.Metrics().RateLimitDisconnects)
+}
+
+func TestCheckRate_PerUserSharedAcrossConnections(t *testing.T) {
+	limits := config.RateLimits{
+		Orders: config.ClassLimits{PerUser: config.RateLimit{Rate: 0.001, Burst: 1}},
+	}
+	hub := NewHub(nil, nil)
+	hub.SetRateLimits(limits)
+	first := &Client{hub: hub, queue: newSendQueue(16, Disconnect), codec: common.JSON, limiter: newConnLimiter(limits), userID: "u1"}
+	second := &Client{hub: hub, queue: newSendQueue(16, Disconnect), codec: common.JSON, limiter: newConnLimiter(limits), userID: "u1"}
+
+	order := WSMessage{Entity: "orders", Type: "order"}
+	assert.True(t, first.checkRate(order))
+	assert.False(t, second.checkRate(order))
+}
+
+func TestRateLimitsConfigParsesDurations(t *testing.T) {
+	var limits config.RateLimits
+	err := yaml.Unmarshal([]byte("max_violations: 3\nviolation_window: 10s\norders:\n  per_user: { rate: 5, burst: 10 }\n"), &limits)
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Second, limits.ViolationWindow)
+	assert.Equal(t, 5.0, limits.Orders.PerUser.Rate)
+}
@@ -0,0 +1,64 @@
+// user-ws/ws/replay.go
+package ws
+
+import "time"
+
+const defaultReplayBufferSize = 1024
+
+// replayIdleTimeout is how long the buffer of a user's topic is kept once the
+// user has no connections and nothing is published to it. A client resuming
+// later resnapshots.
+const replayIdleTimeout = 10 * time.Minute
+
+// replayBuffer keeps the last messages published on a topic so a client that
+// reconnects can ask for everything after the last sequence number it saw.
+// The ring grows as messages arrive, so that quiet topics stay small.
+type replayBuffer struct {
+	seq   uint64 // sequence number of the most recent message
+	size  int    // most messages kept
+	ring  []*topicMessage
+	start int
+	last  time.Time // when the most recent message was published
+}
+
+func newReplayBuffer(size int) *replayBuffer {
+	if size <= 0 {
+		size = defaultReplayBufferSize
+	}
+	return &replayBuffer{size: size}
+}
+
+// next assigns the following sequence number to msg and retains it.
+func (b *replayBuffer) next(msg *topicMessage) {
+	b.seq++
+	msg.seq = b.seq
+	b.last = time.Now()
+	if len(b.ring) < b.size {
+		b.ring = append(b.ring, msg)
+		return
+	}
+	b.ring[b.start] = msg
+	b.start = (b.start + 1) % len(b.ring)
+}
+
+// since returns the retained messages after lastSeq. ok is false when some of
+// those messages have already been evicted, or lastSeq is from the future
+// (e.g. the server restarted), in which case the client must resnapshot.
+func (b *replayBuffer) since(lastSeq uint64) (msgs []*topicMessage, ok bool) {
+	if lastSeq > b.seq {
+		return nil, false
+	}
+	if lastSeq == b.seq {
+		return nil, true
+	}
+	if len(b.ring) == 0 || lastSeq+1 < b.ring[b.start].seq {
+		return nil, false
+	}
+	for i := 0; i < len(b.ring); i++ {
+		msg := b.ring[(b.start+i)%len(b.ring)]
+		if msg.seq > lastSeq {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, true
+}
@@ -0,0 +1,108 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func seqs(msgs []*topicMessage) []uint64 {
+	var out []uint64
+	for _, m := range msgs {
+		out = append(out, m.seq)
+	}
+	return out
+}
+
+func TestReplayBuffer_Since(t *testing.T) {
+	buf := newReplayBuffer(3)
+	for i := 0; i < 5; i++ {
+		buf.next(&topicMessage{topic: "users"})
+	}
+	assert.Equal(t, uint64(5), buf.seq)
+
+	msgs, ok := buf.since(3)
+	assert.True(t, ok)
+	assert.Equal(t, []uint64{4, 5}, seqs(msgs))
+
+	msgs, ok = buf.since(2)
+	assert.True(t, ok)
+	assert.Equal(t, []uint64{3, 4, 5}, seqs(msgs))
+
+	msgs, ok = buf.since(5)
+	assert.True(t, ok)
+	assert.Empty(t, msgs)
+
+	_, ok = buf.since(1)
+	assert.False(t, ok, "seq 2 was evicted")
+
+	_, ok = buf.since(9)
+	assert.False(t, ok, "seq from the future")
+}
+
+func decodeAll(t *testing.T, q *sendQueue) []WSMessage {
+	var out []WSMessage
+	for _, raw := range q.drain() {
+		var msg WSMessage
+		require.NoError(t, json.Unmarshal(raw, &msg))
+		out = append(out, msg)
+	}
+	return out
+}
+
+func TestHub_ResumeReplaysMissedMessages(t *testing.T) {
+	hub := NewHub(nil, nil)
+	hub.SetReplayBufferSize(2)
+	go hub.Run()
+
+	for i := 0; i < 3; i++ {
+		hub.sendTrade <- tradeFor("u1", "u2")
+	}
+
+	c := newTestClient(hub, "u1")
+	hub.resume <- resumeRequest{client: c, topic: UserTopic("u1"), lastSeq: 1}
+	hub.sync()
+
+	msgs := decodeAll(t, c.queue)
+	require.Len(t, msgs, 2)
+	assert.Equal(t, uint64(2), msgs[0].Seq)
+	assert.Equal(t, uint64(3), msgs[1].Seq)
+	assert.Equal(t, UserTopic("u1"), msgs[0].Topic)
+
+	hub.resume <- resumeRequest{client: c, topic: UserTopic("u1"), lastSeq: 0}
+	hub.sync()
+
+	msgs = decodeAll(t, c.queue)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, "resnapshot", msgs[0].Type)
+}
+
+func TestReplayBuffer_GrowsAsNeeded(t *testing.T) {
+	buf := newReplayBuffer(1024)
+	assert.Zero(t, cap(buf.ring))
+	buf.next(&topicMessage{topic: "users"})
+	buf.next(&topicMessage{topic: "users"})
+	assert.Len(t, buf.ring, 2)
+	msgs, ok := buf.since(0)
+	assert.True(t, ok)
+	assert.Equal(t, []uint64{1, 2}, seqs(msgs))
+}
+
+func TestHub_EvictsIdleUserTopics(t *testing.T) {
+	hub := NewHub(nil, nil) // not running, so the test may touch Run's state
+	hub.conns["u1"] = 1
+	for _, topic := range []string{UserTopic("u1"), UserTopic("u2"), DropCopyTopic} {
+		hub.sequence(topic, WSMessage{Type: "trade", Entity: "orders"}, nil)
+	}
+
+	hub.evictReplay(time.Now().Add(time.Minute))
+	assert.Len(t, hub.replay, 3, "buffers are kept while recently used")
+
+	hub.evictReplay(time.Now().Add(replayIdleTimeout))
+	assert.Contains(t, hub.replay, UserTopic("u1"), "u1 is connected")
+	assert.NotContains(t, hub.replay, UserTopic("u2"))
+	assert.Contains(t, hub.replay, DropCopyTopic, "only user topics are evicted")
+}
@@ -0,0 +1,24 @@
+package ws
+
+import (
+	"context"
+	"log/slog"
+)
+
+// CancelOnDisconnectHandler toggles cancel-on-disconnect for the session. It
+// can also be enabled when connecting with ?cancel_on_disconnect=true.
+type CancelOnDisconnectHandler struct{}
+
+func (h *CancelOnDisconnectHandler) HandleMessage(c *Client, ctx context.Context, msg WSMessage) {
+	var payload struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := c.codec.Unmarshal(msg.Payload, &payload); err != nil {
+		slog.Error("Invalid cancel_on_disconnect payload:", "Error", err)
+		errMsg := map[string]string{"error": "Invalid cancel_on_disconnect payload"}
+		c.respond("error", "session", msg.Type, errMsg)
+		return
+	}
+	c.cancelOnDisconnect.Store(payload.Enabled)
+	c.respond("ok", "session", msg.Type, map[string]bool{"enabled": payload.Enabled})
+}
@@ -0,0 +1,29 @@
+package ws
+
+import (
+	"context"
+	"log/slog"
+)
+
+// ResumeHandler lets a reconnecting client recover the messages it missed on
+// a topic by sending the last sequence number it processed.
+type ResumeHandler struct{}
+
+func (h *ResumeHandler) HandleMessage(c *Client, ctx context.Context, msg WSMessage) {
+	var payload struct {
+		Topic   string `json:"topic"`
+		LastSeq uint64 `json:"last_seq"`
+	}
+	if err := c.codec.Unmarshal(msg.Payload, &payload); err != nil || payload.Topic == "" {
+		slog.Error("Invalid resume payload:", "Error", err)
+		errMsg := map[string]string{"error": "Invalid resume payload"}
+		c.respond("error", "session", "resume", errMsg)
+		return
+	}
+	if !c.hub.canSubscribe(c, payload.Topic) {
+		errMsg := map[string]string{"error": "Topic not allowed"}
+		c.respond("error", "session", "resume", errMsg)
+		return
+	}
+	c.hub.resume <- resumeRequest{client: c, topic: payload.Topic, lastSeq: payload.LastSeq}
+}
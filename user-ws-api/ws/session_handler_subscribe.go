@@ -0,0 +1,29 @@
+package ws
+
+import (
+	"context"
+	"log/slog"
+)
+
+type SubscribeHandler struct {
+	subscribe bool
+}
+
+func (h *SubscribeHandler) HandleMessage(c *Client, ctx context.Context, msg WSMessage) {
+	var payload struct {
+		Topic string `json:"topic"`
+	}
+	if err := c.codec.Unmarshal(msg.Payload, &payload); err != nil || payload.Topic == "" {
+		slog.Error("Invalid subscription payload:", "Error", err)
+		errMsg := map[string]string{"error": "Invalid subscription payload"}
+		c.respond("error", "session", msg.Type, errMsg)
+		return
+	}
+	if !c.hub.canSubscribe(c, payload.Topic) {
+		errMsg := map[string]string{"error": "Topic not allowed"}
+		c.respond("error", "session", msg.Type, errMsg)
+		return
+	}
+	c.hub.subscribe(c, payload.Topic, h.subscribe)
+	c.respond("ok", "session", msg.Type, map[string]string{"topic": payload.Topic})
+}
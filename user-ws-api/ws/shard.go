@@ -0,0 +1,175 @@
+// user-ws/ws/shard.go
+package ws
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"user-ws-api/common"
+)
+
+// UserTopic is the private topic every client is subscribed to for its own
+// trades and order events.
+const userTopicPrefix = "user:"
+
+func UserTopic(userID string) string {
+	return userTopicPrefix + userID
+}
+
+// topicMessage is a broadcast payload serialised once per codec and shared by
+// every subscriber it is fanned out to.
+type topicMessage struct {
+	topic string
+	seq   uint64
+	key   string
+	env   WSMessage // payload is JSON
+	data  []byte    // JSON encoding of env
+	// value is the payload before encoding, if known. Binary codecs encode it
+	// directly rather than transcoding the JSON payload.
+	value any
+
+	binaryOnce sync.Once
+	binary     []byte
+}
+
+// encoded returns the message in codec's wire format. The binary encoding is
+// produced lazily, at most once, by the first shard that needs it.
+func (m *topicMessage) encoded(codec common.Codec) []byte {
+	if codec == common.JSON {
+		return m.data
+	}
+	m.binaryOnce.Do(func() {
+		env := m.env
+		var payload common.RawPayload
+		var err error
+		if m.value != nil {
+			payload, err = codec.Marshal(m.value)
+		} else {
+			payload, err = common.Transcode(codec, json.RawMessage(env.Payload))
+		}
+		if err != nil {
+			slog.Error("Cannot transcode topic message", "Topic", m.topic, "Codec", codec.Name(), "Error", err)
+			return
+		}
+		env.Payload = payload
+		m.binary, _ = codec.Marshal(env)
+	})
+	return m.binary
+}
+
+type shardOpKind int
+
+const (
+	opRegister shardOpKind = iota
+	opUnregister
+	opSubscribe
+	opUnsubscribe
+	opPublish
+	opReplay
+	opSync
+)
+
+type shardOp struct {
+	kind   shardOpKind
+	client *Client
+	topic  string
+	msg    *topicMessage
+	replay []*topicMessage
+	done   chan struct{}
+}
+
+// shard owns a subset of the hub's clients, partitioned by user ID, together
+// with the topic index for those clients. All state is confined to the shard's
+// goroutine, so fan-out for different shards runs in parallel. Operations are
+// applied in the order they were sent.
+type shard struct {
+	clients map[*Client]map[string]struct{} // client -> subscribed topics
+	topics  map[string]map[*Client]struct{} // topic -> subscribers
+	ops     chan shardOp
+}
+
+const shardQueueSize = 1024
+
+func newShard() *shard {
+	return &shard{
+		clients: make(map[*Client]map[string]struct{}),
+		topics:  make(map[string]map[*Client]struct{}),
+		ops:     make(chan shardOp, shardQueueSize),
+	}
+}
+
+func (s *shard) run() {
+	for op := range s.ops {
+		switch op.kind {
+		case opRegister:
+			s.clients[op.client] = make(map[string]struct{})
+			s.subscribe(op.client, UserTopic(op.client.userID))
+		case opUnregister:
+			s.remove(op.client)
+		case opSubscribe:
+			if _, ok := s.clients[op.client]; ok {
+				s.subscribe(op.client, op.topic)
+			}
+		case opUnsubscribe:
+			if _, ok := s.clients[op.client]; ok {
+				s.unsubscribe(op.client, op.topic)
+			}
+		case opPublish:
+			for client := range s.topics[op.msg.topic] {
+				client.enqueueKeyed(op.msg.encoded(client.codec), op.msg.key)
+			}
+		case opReplay:
+			if _, ok := s.clients[op.client]; !ok {
+				continue
+			}
+			for _, msg := range op.replay {
+				op.client.enqueue(msg.encoded(op.client.codec))
+			}
+			s.subscribe(op.client, op.topic)
+		case opSync:
+			close(op.done)
+		}
+	}
+}
+
+func (s *shard) subscribe(client *Client, topic string) {
+	subscribers, ok := s.topics[topic]
+	if !ok {
+		subscribers = make(map[*Client]struct{})
+		s.topics[topic] = subscribers
+	}
+	subscribers[client] = struct{}{}
+	s.clients[client][topic] = struct{}{}
+}
+
+func (s *shard) unsubscribe(client *Client, topic string) {
+	delete(s.clients[client], topic)
+	if subscribers, ok := s.topics[topic]; ok {
+		delete(subscribers, client)
+		if len(subscribers) == 0 {
+			delete(s.topics, topic)
+		}
+	}
+}
+
+func (s *shard) remove(client *Client) {
+	topics, ok := s.clients[client]
+	if !ok {
+		return
+	}
+	for topic := range topics {
+		s.unsubscribe(client, topic)
+	}
+	delete(s.clients, client)
+	client.queue.close()
+	if dropped := client.queue.droppedCount(); dropped > 0 {
+		slog.Info("Client unregistered with dropped messages", "UserID", client.userID, "Dropped", dropped)
+	}
+}
+
+func shardIndex(userID string, n int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(userID))
+	return int(h.Sum32() % uint32(n))
+}
@@ -4,9 +4,17 @@ package ws
 import (
 	"log/slog"
 
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/events"
 	nats "github.com/nats-io/nats.go"
 )
 
+// userEventTypes maps user event types to broadcast message types.
+var userEventTypes = map[string]string{
+	events.TypeUserCreated: "create",
+	events.TypeUserUpdated: "update",
+	events.TypeUserDeleted: "delete",
+}
+
 func StartNATSListener(hub *Hub, natsURL string) {
 	nc, err := nats.Connect(natsURL)
 	if err != nil {
@@ -15,14 +23,33 @@ func StartNATSListener(hub *Hub, natsURL string) {
 	}
 	slog.Info("[INFO] Connected to NATS for external REST updates")
 
-	_, err = nc.Subscribe("users.updated", func(m *nats.Msg) {
-		slog.Info("NATS message received:", "Message", string(m.Data))
-		hub.broadcast <- BroadcastMessage{
-			Entity:  "users",
-			Message: m.Data,
+	// The REST API publishes users.created, users.updated and users.deleted
+	// from its outbox.
+	_, err = nc.Subscribe("users.*", func(m *nats.Msg) {
+		slog.Info("NATS message received:", "Subject", m.Subject, "Message", string(m.Data))
+		if event, err := events.Parse(m.Data); err == nil {
+			hub.gate.Observe(event)
+		}
+		if msg, ok := userEventBroadcast(m.Data); ok {
+			hub.broadcast <- msg
 		}
 	})
 	if err != nil {
-		slog.Error("[ERROR] Failed to subscribe to users.updated:", "Error", err)
+		slog.Error("[ERROR] Failed to subscribe to users.*:", "Error", err)
+	}
+}
+
+// userEventBroadcast turns a user event into a broadcast of the whole event.
+// Changes made through this service were broadcast by the user handlers
+// already and are skipped.
+func userEventBroadcast(data []byte) (BroadcastMessage, bool) {
+	event, err := events.Parse(data)
+	if err != nil {
+		slog.Warn("Ignoring user event", "Error", err)
+		return BroadcastMessage{}, false
+	}
+	if event.Source == events.SourceWSAPI {
+		return BroadcastMessage{}, false
 	}
+	return BroadcastMessage{Entity: "users", Type: userEventTypes[event.Type], Message: data}, true
 }
@@ -0,0 +1,47 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func userEvent(t *testing.T, eventType, source string) []byte {
+	t.Helper()
+	var before, after any
+	if eventType != events.TypeUserCreated {
+		before = map[string]string{"user_id": "u1", "email": "a@b.c"}
+	}
+	if eventType != events.TypeUserDeleted {
+		after = map[string]string{"user_id": "u1", "email": "x@b.c"}
+	}
+	e, err := events.NewUserEvent(context.Background(), eventType, source, "u1", before, after)
+	require.NoError(t, err)
+	data, err := json.Marshal(e)
+	require.NoError(t, err)
+	return data
+}
+
+func TestUserEventBroadcast(t *testing.T) {
+	for eventType, want := range userEventTypes {
+		data := userEvent(t, eventType, events.SourceRESTAPI)
+		msg, ok := userEventBroadcast(data)
+		require.True(t, ok, eventType)
+		assert.Equal(t, "users", msg.Entity)
+		assert.Equal(t, want, msg.Type)
+		assert.Equal(t, data, msg.Message)
+		// A conflated update would hide the creation of the user.
+		assert.Empty(t, msg.Key)
+	}
+}
+
+func TestUserEventBroadcast_SkipsOwnAndUnknownEvents(t *testing.T) {
+	_, ok := userEventBroadcast(userEvent(t, events.TypeUserUpdated, events.SourceWSAPI))
+	assert.False(t, ok)
+	_, ok = userEventBroadcast([]byte(`{"user_id":"u1"}`))
+	assert.False(t, ok)
+}
@@ -2,11 +2,9 @@ package ws
 
 import (
 	"context"
-	"encoding/json"
-	"github.com/google/uuid"
+	"errors"
 	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/userservice"
 	"log/slog"
-	"user-ws-api/common"
 )
 
 type DeleteUserHandler struct {
@@ -14,22 +12,24 @@ type DeleteUserHandler struct {
 }
 
 func (h *DeleteUserHandler) HandleMessage(c *Client, ctx context.Context, msg WSMessage) {
-	var payload struct {
-		UserID uuid.UUID `json:"user_id"`
-	}
-	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+	// expected_version, if given, must be the user's current version.
+	var payload userservice.DeleteUserParams
+	if err := c.codec.Unmarshal(msg.Payload, &payload); err != nil {
 		slog.Error("Invalid delete payload:", "Error", err)
 		errMsg := map[string]string{"error": "Invalid user payload"}
-		c.send <- common.MakeWSResponse("error", "users", "delete", errMsg)
+		c.respond("error", "users", "delete", errMsg)
 		return
 	}
-	if err := h.service.DeleteUser(ctx, payload.UserID); err != nil {
+	if err := h.service.DeleteUser(ctx, payload); err != nil {
 		slog.Error("Delete error:", "Error", err)
 		errMsg := map[string]string{"error": err.Error()}
-		c.send <- common.MakeWSResponse("error", "users", "delete", errMsg)
+		if errors.Is(err, userservice.ErrVersionMismatch) {
+			errMsg["code"] = versionMismatchCode
+		}
+		c.respond("error", "users", "delete", errMsg)
 		return
 	}
 	msgResp := []byte(`{"status":"deleted"}`)
-	c.send <- msgResp
-	c.hub.broadcast <- BroadcastMessage{"users", msgResp}
+	c.reply(map[string]string{"status": "deleted"})
+	c.hub.broadcast <- BroadcastMessage{Entity: "users", Type: "delete", Message: msgResp}
 }
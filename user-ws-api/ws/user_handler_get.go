@@ -2,24 +2,32 @@ package ws
 
 import (
 	"context"
-	"encoding/json"
 	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/userservice"
 	"log/slog"
-	"user-ws-api/common"
 )
 
 type GetUsersHandler struct {
 	service userservice.UserService
 }
 
+// HandleMessage replies with one page of users. The payload is optional and
+// takes the fields of userservice.ListUsersParams; the reply's next_cursor
+// goes into the cursor of the next request.
 func (h *GetUsersHandler) HandleMessage(c *Client, ctx context.Context, msg WSMessage) {
-	users, err := h.service.GetAllUsers(ctx)
+	var params userservice.ListUsersParams
+	if len(msg.Payload) > 0 {
+		if err := c.codec.Unmarshal(msg.Payload, &params); err != nil {
+			slog.Error("Invalid list payload:", "Error", err)
+			c.respond("error", "users", "get", map[string]string{"error": "Invalid list payload"})
+			return
+		}
+	}
+	page, err := h.service.ListUsers(ctx, params)
 	if err != nil {
-		slog.Error("GetAllUsers error:", "Error", err)
+		slog.Error("ListUsers error:", "Error", err)
 		errMsg := map[string]string{"error": err.Error()}
-		c.send <- common.MakeWSResponse("error", "users", "get", errMsg)
+		c.respond("error", "users", "get", errMsg)
 		return
 	}
-	resp, _ := json.Marshal(users)
-	c.send <- resp
+	c.reply(page)
 }
@@ -2,11 +2,9 @@ package ws
 
 import (
 	"context"
-	"encoding/json"
 	"github.com/google/uuid"
 	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/userservice"
 	"log/slog"
-	"user-ws-api/common"
 )
 
 type GetUserByIDHandler struct {
@@ -17,10 +15,10 @@ func (h *GetUserByIDHandler) HandleMessage(c *Client, ctx context.Context, msg W
 	var payload struct {
 		UserID uuid.UUID `json:"user_id"`
 	}
-	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+	if err := c.codec.Unmarshal(msg.Payload, &payload); err != nil {
 		slog.Error("Invalid get_by_id payload:", "Error", err)
 		errMsg := map[string]string{"error": "Invalid user get_by_id payload"}
-		c.send <- common.MakeWSResponse("error", "users", "get_by_id", errMsg)
+		c.respond("error", "users", "get_by_id", errMsg)
 		return
 	}
 	user, err := h.service.GetUser(ctx, payload.UserID)
@@ -28,6 +26,5 @@ func (h *GetUserByIDHandler) HandleMessage(c *Client, ctx context.Context, msg W
 		slog.Error("GetUser error:", "Error", err)
 		return
 	}
-	resp, _ := json.Marshal(user)
-	c.send <- resp
+	c.reply(user)
 }
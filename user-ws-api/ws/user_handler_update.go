@@ -3,31 +3,43 @@ package ws
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"github.com/laki88/yaalalabs-user-api/user-rest-api/pkg/userservice"
 	"log/slog"
 	"user-ws-api/common"
 )
 
+// versionMismatchCode is the error code of an update or delete whose
+// expected_version is not the user's current version. The client should get
+// the user again and retry.
+const versionMismatchCode = "version_mismatch"
+
 type UpdateUserHandler struct {
 	service userservice.UserService
 }
 
 func (h *UpdateUserHandler) HandleMessage(c *Client, ctx context.Context, msg WSMessage) {
+	// The payload is a merge patch with user_id: absent fields are left
+	// unchanged and null clears a field. expected_version, if given, must be
+	// the user's current version.
 	var user userservice.UpdateUserParams
-	if err := json.Unmarshal(msg.Payload, &user); err != nil {
+	if err := common.UnmarshalViaJSON(c.codec, msg.Payload, &user); err != nil {
 		slog.Error("Invalid update payload:", "Error", err)
 		errMsg := map[string]string{"error": "Invalid update payload"}
-		c.send <- common.MakeWSResponse("error", "users", "update", errMsg)
+		c.respond("error", "users", "update", errMsg)
 		return
 	}
 	updated, err := h.service.UpdateUser(ctx, user)
 	if err != nil {
 		slog.Error("Update error:", "Error", err)
 		errMsg := map[string]string{"error": err.Error()}
-		c.send <- common.MakeWSResponse("error", "users", "update", errMsg)
+		if errors.Is(err, userservice.ErrVersionMismatch) {
+			errMsg["code"] = versionMismatchCode
+		}
+		c.respond("error", "users", "update", errMsg)
 		return
 	}
 	resp, _ := json.Marshal(updated)
-	c.send <- resp
-	c.hub.broadcast <- BroadcastMessage{"users", resp}
+	c.reply(updated)
+	c.hub.broadcast <- BroadcastMessage{Entity: "users", Type: "update", Message: resp}
 }
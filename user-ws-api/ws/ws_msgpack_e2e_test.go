@@ -0,0 +1,60 @@
+package ws_test
+
+import (
+	"net/url"
+	"testing"
+	"time"
+	"user-ws-api/common"
+	"user-ws-api/config"
+	"user-ws-api/models"
+	"user-ws-api/ws"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func dialMsgPack(t *testing.T, userID string) *websocket.Conn {
+	u := url.URL{Scheme: "ws", Host: "localhost:" + config.AppConfig.Server.Port, Path: "/ws", RawQuery: "user_id=" + userID}
+	dialer := websocket.Dialer{Subprotocols: []string{"msgpack"}}
+	conn, _, err := dialer.Dial(u.String(), nil)
+	require.NoError(t, err)
+	require.Equal(t, "msgpack", conn.Subprotocol())
+	return conn
+}
+
+func TestMsgPackSubprotocol_OrderAndTrade(t *testing.T) {
+	_, users, cleanup, _ := SetupTestServer(t)
+	defer cleanup()
+
+	conn := dialMsgPack(t, "u5")
+	defer conn.Close()
+
+	buy := models.Order{ID: "b1", UserID: "u5", AssetID: "BTC", Side: models.Buy, Price: 100, Quantity: 1, CreatedAt: time.Now()}
+	payload, err := common.MsgPack.Marshal(buy)
+	require.NoError(t, err)
+	raw, err := common.MsgPack.Marshal(ws.WSMessage{Type: "order", Entity: "orders", Payload: payload})
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, raw))
+	time.Sleep(200 * time.Millisecond)
+
+	sell := models.Order{ID: "s1", UserID: "u2", AssetID: "BTC", Side: models.Sell, Price: 100, Quantity: 1, CreatedAt: time.Now()}
+	SendOrders(t, users["u2"], []models.Order{sell})
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	frameType, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, websocket.BinaryMessage, frameType)
+
+	var msg ws.WSMessage
+	require.NoError(t, common.MsgPack.Unmarshal(data, &msg))
+	assert.Equal(t, "trade", msg.Type)
+	assert.Equal(t, ws.UserTopic("u5"), msg.Topic)
+	assert.Equal(t, uint64(1), msg.Seq)
+
+	var trade models.Trade
+	require.NoError(t, common.MsgPack.Unmarshal(msg.Payload, &trade))
+	assert.Equal(t, "u5", trade.BuyerID)
+	assert.Equal(t, "u2", trade.SellerID)
+	assert.Equal(t, 1.0, trade.Quantity)
+}